@@ -2,6 +2,7 @@ package app
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -25,11 +26,82 @@ type ServerConfig struct {
 	// Required - Defaults to 15m - Interval to reap expired clusters
 	ReapInterval string `mapstructure:"reap_interval"`
 
+	// Optional - Defaults to 4 - Number of expired clusters reaped at once
+	ReapConcurrency int `mapstructure:"reap_concurrency"`
+
+	// Optional - Defaults to 24h - How long an Idempotency-Key is remembered
+	IdempotencyRetention string `mapstructure:"idempotency_retention"`
+
+	// Optional - No Default - Bearer token with admin rights, used to create
+	// the first tokens. Requests are refused without a token.
+	AdminToken string `mapstructure:"admin_token"`
+
 	// Logrus Configuration
 	Logging LoggingConfig
 
 	// Cloud Project Configuration
 	Clouds map[string]CloudProjectConfig
+
+	// Outbound Webhook Configuration
+	Webhooks WebhookConfig
+
+	// Terraform Job Queue Configuration
+	Jobs JobConfig
+
+	// Automatic Retries of Failed Destroys
+	DestroyRetries DestroyRetryConfig `mapstructure:"destroy_retries"`
+
+	// Warnings to owners of clusters about to expire
+	ExpirationWarnings ExpirationWarningConfig `mapstructure:"expiration_warnings"`
+
+	// Quotas of the clusters of each owner
+	OwnerQuotas OwnerQuotaConfig `mapstructure:"owner_quotas"`
+
+	// Storage of clusters and their jobs, logs and history
+	ClusterStore ClusterStoreConfig `mapstructure:"cluster_store"`
+
+	// Keys encrypting the terraform config, state, outputs and variables of
+	// clusters
+	Encryption EncryptionConfig `mapstructure:"encryption"`
+
+	// Terraform http backend storing the state of clusters in taos
+	StateBackend StateBackendConfig `mapstructure:"state_backend"`
+}
+
+type StateBackendConfig struct {
+	// Optional - No Default - URL terraform reaches taos at, such as
+	// http://localhost:8080. Terraform writes the state of a cluster as it
+	// changes and locks it during operations through /cluster/{id}/state.
+	// The state is kept in a local file until the operation ends when not set.
+	Address string `mapstructure:"address"`
+
+	// Required with an address - No Default - Key the credentials of the
+	// state of each cluster are derived from with HMAC-SHA256
+	Secret string `mapstructure:"secret"`
+}
+
+type EncryptionConfig struct {
+	// Optional - Id of the key encrypting clusters stored from now on, one
+	// of keys. Clusters are stored in plaintext when no keys are configured.
+	Key string `mapstructure:"key"`
+
+	// Optional - Keys by lowercase id, each 32 random bytes encoded as
+	// base64. A retired key is kept until taos rekey has encrypted every
+	// cluster with the active key.
+	Keys map[string]string `mapstructure:"keys"`
+}
+
+type ClusterStoreConfig struct {
+	// Optional - Defaults to postgres - Where clusters are stored, one of
+	// postgres, sqlite or memory. Other resources, such as tokens, roles,
+	// templates, quotas and reaper runs, are always stored in postgres, so
+	// conn_str is required whatever the backend. Clusters stored in memory
+	// are lost when the server stops, and neither sqlite nor memory may be
+	// shared between instances.
+	Backend string `mapstructure:"backend"`
+
+	// Optional - Defaults to taos.db - Database file of the sqlite backend
+	Path string `mapstructure:"path"`
 }
 
 type LoggingConfig struct {
@@ -41,6 +113,87 @@ type LoggingConfig struct {
 	Level string `mapstructure:"log_level"`
 }
 
+type WebhookConfig struct {
+	// Optional - No Default - URLs notified of lifecycle events of every cluster
+	URLs []string `mapstructure:"urls"`
+
+	// Optional - No Default - Key used to sign payloads with HMAC-SHA256
+	Secret string `mapstructure:"secret"`
+
+	// Optional - Defaults to 5 - Deliveries are given up after this many attempts
+	MaxAttempts int `mapstructure:"max_attempts"`
+
+	// Optional - Defaults to 10s - Interval to dispatch pending deliveries
+	// and the base of the backoff between attempts of a delivery
+	Interval string `mapstructure:"interval"`
+}
+
+type JobConfig struct {
+	// Optional - Defaults to 4 - Number of terraform operations run at once
+	Workers int `mapstructure:"workers"`
+
+	// Optional - Defaults to 5s - Interval an idle worker polls for queued jobs
+	// and a busy worker reports its job alive
+	Interval string `mapstructure:"interval"`
+
+	// Optional - Defaults to 2m - Running jobs not reported alive for this long
+	// are abandoned, and the interval clusters left without a job are reconciled
+	StaleAfter string `mapstructure:"stale_after"`
+}
+
+type DestroyRetryConfig struct {
+	// Optional - Defaults to 5 - Destroying a cluster is given up after this
+	// many failed attempts, leaving the cluster for a human to look at
+	MaxAttempts int `mapstructure:"max_attempts"`
+
+	// Optional - Defaults to 1m - Delay before the first retry, doubled for
+	// every further failed attempt
+	Backoff string `mapstructure:"backoff"`
+
+	// Optional - Defaults to 1h - Upper bound of the delay between retries
+	MaxBackoff string `mapstructure:"max_backoff"`
+}
+
+type ExpirationWarningConfig struct {
+	// Optional - No Default - How long before a cluster expires its owner is
+	// warned, such as ["1h", "10m"]. No warnings are sent when not set.
+	Thresholds []string `mapstructure:"thresholds"`
+
+	// Optional - Defaults to [log] - Sinks notified of each warning, any of
+	// log, webhook and smtp
+	Sinks []string `mapstructure:"sinks"`
+
+	// Optional - Defaults to 1m - Interval to check for clusters to warn about
+	Interval string `mapstructure:"interval"`
+
+	// Optional - No Default - URL taos is reached at, warnings link to the
+	// extend endpoint of the cluster below it
+	BaseURL string `mapstructure:"base_url"`
+
+	// Optional - Mail server of the smtp sink
+	SMTP SMTPConfig `mapstructure:"smtp"`
+}
+
+type SMTPConfig struct {
+	// Required by the smtp sink - No Default - Mail server host
+	Host string `mapstructure:"host"`
+
+	// Optional - Defaults to 25 - Mail server port
+	Port int `mapstructure:"port"`
+
+	// Optional - No Default - Credentials of the mail server, sent without
+	// authentication when not set
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+
+	// Required by the smtp sink - No Default - Sender of warnings
+	From string `mapstructure:"from"`
+
+	// Optional - No Default - Warnings are mailed to owners which are not
+	// themselves an email address at this domain
+	Domain string `mapstructure:"domain"`
+}
+
 type CloudProjectConfig struct {
 	// Required - No Default - Project to provision within
 	Project string `mapstructure:"project"`
@@ -50,6 +203,34 @@ type CloudProjectConfig struct {
 
 	// Required - No Default - Default cloud region to set when performing Terraform actions
 	Region string `mapstructure:"region"`
+
+	// Optional - No Default - Maximum lifetime of a cluster measured from its creation
+	// Extending a cluster beyond this lifetime is refused. Unlimited when not set.
+	MaxLifetime string `mapstructure:"max_lifetime"`
+
+	// Optional - Quota of the clusters within the project
+	QuotaConfig `mapstructure:",squash"`
+}
+
+// Limits checked when a cluster is created, unlimited when not set
+type QuotaConfig struct {
+	// Optional - No Default - Maximum number of clusters not yet destroyed
+	MaxClusters int `mapstructure:"max_clusters"`
+
+	// Optional - No Default - Maximum hours of cluster lifetime requested
+	// by the clusters created within the last 24 hours
+	MaxClusterHours float64 `mapstructure:"max_cluster_hours"`
+
+	// Optional - No Default - Maximum timeout of a cluster
+	MaxTimeout string `mapstructure:"max_timeout"`
+}
+
+type OwnerQuotaConfig struct {
+	// Optional - Quota of owners without a quota of their own
+	Default QuotaConfig `mapstructure:"default"`
+
+	// Optional - Quota of each owner
+	Owners map[string]QuotaConfig `mapstructure:"owners"`
 }
 
 // Load the server configuration from ConfigPath/Name.Type or from the ENV with TAOS_[var]
@@ -68,6 +249,21 @@ func LoadServerConfig(config *ServerConfig, path string) error {
 	// Set Defaults
 	v.SetDefault("server_port", 8080)
 	v.SetDefault("reap_interval", "15m")
+	v.SetDefault("reap_concurrency", 4)
+	v.SetDefault("idempotency_retention", "24h")
+	v.SetDefault("webhooks.max_attempts", 5)
+	v.SetDefault("webhooks.interval", "10s")
+	v.SetDefault("jobs.workers", 4)
+	v.SetDefault("jobs.interval", "5s")
+	v.SetDefault("jobs.stale_after", "2m")
+	v.SetDefault("destroy_retries.max_attempts", 5)
+	v.SetDefault("destroy_retries.backoff", "1m")
+	v.SetDefault("destroy_retries.max_backoff", "1h")
+	v.SetDefault("expiration_warnings.sinks", []string{"log"})
+	v.SetDefault("expiration_warnings.interval", "1m")
+	v.SetDefault("expiration_warnings.smtp.port", 25)
+	v.SetDefault("cluster_store.backend", "postgres")
+	v.SetDefault("cluster_store.path", "taos.db")
 
 	if err := v.ReadInConfig(); err != nil {
 		return fmt.Errorf("Failed to read the configuration file: %s", err)
@@ -90,3 +286,53 @@ func (config *ServerConfig) Credentials(project string) string {
 
 	return val.Credentials
 }
+
+// Maximum lifetime of a cluster within the given project. A zero duration
+// is returned when no maximum lifetime is configured.
+func (config *ServerConfig) MaxLifetime(project string) (time.Duration, error) {
+	val, exists := config.Clouds[project]
+	if !exists || len(val.MaxLifetime) == 0 {
+		return 0, nil
+	}
+
+	return time.ParseDuration(val.MaxLifetime)
+}
+
+// Delay before retrying to destroy a cluster after the given number of
+// failed attempts
+func (config *ServerConfig) DestroyBackoff(attempts int) (time.Duration, error) {
+	backoff, err := time.ParseDuration(config.DestroyRetries.Backoff)
+	if err != nil {
+		return 0, err
+	}
+
+	max_backoff, err := time.ParseDuration(config.DestroyRetries.MaxBackoff)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 1; i < attempts && backoff < max_backoff; i++ {
+		backoff *= 2
+	}
+	if backoff > max_backoff {
+		backoff = max_backoff
+	}
+
+	return backoff, nil
+}
+
+// Quota of the clusters within the given project
+func (config *ServerConfig) ProjectQuota(project string) QuotaConfig {
+	return config.Clouds[project].QuotaConfig
+}
+
+// Quota of the clusters of the given owner, the default quota when the
+// owner has no quota of their own
+func (config *ServerConfig) OwnerQuota(owner string) QuotaConfig {
+	quota, exists := config.OwnerQuotas.Owners[owner]
+	if !exists {
+		return config.OwnerQuotas.Default
+	}
+
+	return quota
+}
@@ -37,6 +37,9 @@ type RequestContext struct {
 	rollback        bool
 	requestTime     time.Time
 	requestID       string
+	owner           string
+	tokenId         string
+	admin           bool
 }
 
 func NewRequestContext(ctx context.Context, req *http.Request) RequestContext {
@@ -85,3 +88,25 @@ func (rs *RequestContext) RequestTime() time.Time {
 func (rs *RequestContext) RequestId() string {
 	return rs.requestID
 }
+
+// Owner of the token the request was authenticated with
+func (rs *RequestContext) Owner() string {
+	return rs.owner
+}
+
+// Id of the token the request was authenticated with, empty for the
+// configured admin token
+func (rs *RequestContext) TokenId() string {
+	return rs.tokenId
+}
+
+// Whether the request was authenticated with an admin token
+func (rs *RequestContext) Admin() bool {
+	return rs.admin
+}
+
+func (rs *RequestContext) SetPrincipal(owner string, token_id string, admin bool) {
+	rs.owner = owner
+	rs.tokenId = token_id
+	rs.admin = admin
+}
@@ -0,0 +1,22 @@
+package app
+
+import (
+	"net/http"
+	"time"
+)
+
+// Time allowed for a handler to write its response. Streaming handlers
+// are long-lived and are not wrapped with this timeout.
+const RequestTimeout = 10 * time.Second
+
+// Time allowed to plan a cluster, which includes initializing providers
+const PlanTimeout = 5 * time.Minute
+
+// Middleware to bound the time a handler may take to respond
+// The server itself has no write timeout so that streaming responses
+// are not cut off, this replaces it for all other requests
+func WithTimeout(timeout time.Duration) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.TimeoutHandler(h, timeout, "request timed out")
+	}
+}
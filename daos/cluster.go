@@ -1,8 +1,10 @@
 package daos
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	sillyname "github.com/Pallinder/sillyname-go"
@@ -11,44 +13,60 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-type ClusterDao struct{}
+// Clusters stored in a SQL database, Postgres or the embedded SQLite. The
+// same queries serve both, differing only where noted.
+type ClusterDao struct {
+	db *sqlx.DB
 
-func NewClusterDao() *ClusterDao {
-	return &ClusterDao{}
+	// Locks of clusters held within the process, when the database is not
+	// shared with other instances
+	locks *localLocks
+
+	// Keys the secrets of clusters are encrypted with, stored in plaintext
+	// when nil
+	keyring *Keyring
+
+	// URLs every status change of a cluster is delivered to
+	webhooks []string
 }
 
-func (dao *ClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string, requestId string, project string, region string) (*models.Cluster, error) {
-	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_cluster", "request": requestId})
+func NewClusterDao(db *sqlx.DB) *ClusterDao {
+	return &ClusterDao{db: db}
+}
+
+// Encrypt the terraform config, state, outputs and variables of clusters
+// stored from now on with the keyring, which decrypts those already stored
+func (dao *ClusterDao) WithKeyring(keyring *Keyring) *ClusterDao {
+	dao.keyring = keyring
+	return dao
+}
+
+// A cluster requested with the spec, before it is stored
+func newCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error) {
+	config := spec.TerraformConfig
+	timeout := spec.Timeout
+	project := spec.Project
+	region := spec.Region
 
 	if len(config) == 0 {
-		err := errors.New(models.ErrorMissingConfig)
-		logger.Error(err)
-		return nil, err
+		return nil, errors.New(models.ErrorMissingConfig)
 	}
 
 	if len(timeout) == 0 {
-		err := errors.New(models.ErrorMissingTimeout)
-		logger.Error(err)
-		return nil, err
+		return nil, errors.New(models.ErrorMissingTimeout)
 	}
 
 	if len(project) == 0 {
-		err := errors.New(models.ErrorMissingProject)
-		logger.Error(err)
-		return nil, err
+		return nil, errors.New(models.ErrorMissingProject)
 	}
 
 	if len(region) == 0 {
-		err := errors.New(models.ErrorMissingRegion)
-		logger.Error(err)
-		return nil, err
+		return nil, errors.New(models.ErrorMissingRegion)
 	}
 
 	timeout_duration, err := time.ParseDuration(timeout)
 	if err != nil {
-		err := errors.New(models.ErrorInvalidTimeout)
-		logger.Error(err)
-		return nil, err
+		return nil, errors.New(models.ErrorInvalidTimeout)
 	}
 
 	creation_time := time.Now()
@@ -64,10 +82,49 @@ func (dao *ClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string,
 		Timeout:         timeout,
 		Project:         project,
 		Region:          region,
+		TemplateName:    spec.TemplateName,
+		TemplateVersion: spec.TemplateVersion,
+		Variables:       spec.Variables,
+		Owner:           spec.Owner,
 	}
 
-	tx, err := db.Beginx()
+	return &cluster, nil
+}
+
+func (dao *ClusterDao) CreateCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_cluster", "request": requestId})
+
+	cluster, err := newCluster(spec, requestId)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	// Creations counted against the same quota are serialized until the
+	//  transaction ends, so concurrent requests cannot both take the last
+	//  of a quota
+	err = checkQuotas(spec.Quotas, cluster, func(quota models.Quota) (*models.QuotaUsage, error) {
+		if err := lockQuota(tx, quota); err != nil {
+			return nil, err
+		}
+		return quotaUsage(tx, quota)
+	})
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	// The cluster is returned in plaintext, the copy stored is encrypted
+	stored := *cluster
+	err = dao.keyring.encryptCluster(&stored)
 	if err != nil {
+		tx.Rollback()
 		logger.Error(err.Error())
 		return nil, err
 	}
@@ -84,7 +141,13 @@ func (dao *ClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string,
 		expiration,
 		timeout,
 		project,
-		region
+		region,
+		template_name,
+		template_version,
+		variables,
+		owner,
+		encryption_key_id,
+		data_key
 	) VALUES (
 			:id,
 			:name,
@@ -95,9 +158,62 @@ func (dao *ClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string,
 			:expiration,
 			:timeout,
 			:project,
-			:region
+			:region,
+			:template_name,
+			:template_version,
+			:variables,
+			:owner,
+			:encryption_key_id,
+			:data_key
 		)`
-	_, err = tx.NamedQuery(sql, cluster)
+	_, err = tx.NamedExec(sql, &stored)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	// The key is claimed with the cluster, so a concurrent request with the
+	//  same key cannot create a second cluster
+	if len(spec.IdempotencyKey) > 0 {
+		sql := `INSERT INTO idempotency_keys (owner, key, request_hash, cluster_id, timestamp) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (owner, key) DO NOTHING`
+		result, err := tx.Exec(sql, spec.Owner, spec.IdempotencyKey, spec.RequestHash, cluster.Id, cluster.Timestamp)
+		if err != nil {
+			tx.Rollback()
+			logger.Error(err.Error())
+			return nil, err
+		}
+		claimed, err := result.RowsAffected()
+		if err != nil || claimed == 0 {
+			tx.Rollback()
+			err := errors.New(models.ErrorIdempotencyKeyInUse)
+			logger.Error(err)
+			return nil, err
+		}
+	}
+
+	for _, url := range spec.Webhooks {
+		_, err = tx.Exec(`INSERT INTO cluster_webhooks (cluster_id, url) VALUES ($1, $2)`, cluster.Id, url)
+		if err != nil {
+			tx.Rollback()
+			logger.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	err = recordClusterEvent(tx, cluster.Id, models.ClusterHistoryAction, models.ClusterActionCreate, "", cluster.Owner, requestId)
+	if err == nil {
+		err = recordClusterEvent(tx, cluster.Id, models.ClusterHistoryStatus, cluster.Status, "", cluster.Owner, requestId)
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	// Provisioning is queued with the cluster, so a cluster is never
+	//  persisted without the job that provisions it
+	_, err = enqueueClusterJob(tx, cluster.Id, models.ClusterJobProvision, requestId)
 	if err != nil {
 		tx.Rollback()
 		logger.Error(err.Error())
@@ -106,10 +222,10 @@ func (dao *ClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string,
 
 	tx.Commit()
 
-	return &cluster, nil
+	return cluster, nil
 }
 
-func (dao *ClusterDao) GetCluster(db *sqlx.DB, id string, requestId string) (*models.Cluster, error) {
+func (dao *ClusterDao) GetCluster(id string, requestId string) (*models.Cluster, error) {
 	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_cluster", "request": requestId})
 
 	cluster := models.Cluster{}
@@ -128,14 +244,14 @@ func (dao *ClusterDao) GetCluster(db *sqlx.DB, id string, requestId string) (*mo
 
 	logger.Info(fmt.Sprintf("fetching cluster '%v' from database", id))
 
-	tx, err := db.Beginx()
+	tx, err := dao.db.Beginx()
 	if err != nil {
 		logger.Error(err.Error())
 		return nil, err
 	}
 
-	sql := `SELECT * FROM clusters WHERE id=$1`
-	err = tx.Get(&cluster, sql, id)
+	sql := `SELECT clusters.*, ` + fmt.Sprintf(clusterQueuePosition, "$1") + ` FROM clusters WHERE id=$2`
+	err = tx.Get(&cluster, sql, models.ClusterJobQueued, id)
 	if err != nil {
 		tx.Rollback()
 		logger.Error(err.Error())
@@ -144,32 +260,141 @@ func (dao *ClusterDao) GetCluster(db *sqlx.DB, id string, requestId string) (*mo
 
 	tx.Commit()
 
+	err = dao.keyring.decryptCluster(&cluster)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
 	logger.Info(fmt.Sprintf("returning cluster '%v' from database", id))
 
 	return &cluster, nil
 }
 
-func (dao *ClusterDao) GetClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error) {
+// Queued jobs up to and including the first queued job of the cluster, 0
+// without a queued job, selected with the queued status as the argument
+const clusterQueuePosition = `(
+		SELECT COUNT(*) FROM cluster_jobs
+		WHERE status = %[1]s AND id <= (SELECT MIN(id) FROM cluster_jobs WHERE cluster_id = clusters.id AND status = %[1]s)
+	) AS queue_position`
+
+// Retrieve a page of clusters matching the filter, ordered as requested.
+// The returned cursor retrieves the following page and is empty on the last page.
+func (dao *ClusterDao) GetClusters(filter *models.ClusterFilter, requestId string) ([]models.Cluster, string, error) {
 	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_clusters", "request": requestId})
 
 	if len(requestId) == 0 {
 		err := errors.New(models.ErrorMissingRequestId)
 		logger.Error(err)
-		return nil, err
+		return nil, "", err
+	}
+
+	if filter == nil {
+		filter = &models.ClusterFilter{}
+	}
+
+	if !models.ValidClusterSort(filter.Sort) {
+		err := errors.New(models.ErrorInvalidClusterSort)
+		logger.Error(err)
+		return nil, "", err
 	}
 
-	tx, err := db.Beginx()
+	limit := filter.Limit
+	if limit == 0 {
+		limit = models.DefaultClusterPageSize
+	}
+	if limit < 0 || limit > models.MaxClusterPageSize {
+		err := errors.New(models.ErrorInvalidClusterLimit)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	sort_key, descending := models.ParseClusterSort(filter.Sort)
+	sort_column := clusterSortColumns[sort_key]
+
+	conditions := []string{}
+	args := []interface{}{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// The queue position is selected before the conditions, so takes the
+	//  first placeholder
+	queue_position := fmt.Sprintf(clusterQueuePosition, arg(models.ClusterJobQueued))
+
+	if len(filter.Statuses) > 0 {
+		statuses := []string{}
+		for _, status := range filter.Statuses {
+			statuses = append(statuses, arg(status))
+		}
+		conditions = append(conditions, fmt.Sprintf("status IN (%s)", strings.Join(statuses, ", ")))
+	}
+	if len(filter.Owner) > 0 {
+		conditions = append(conditions, fmt.Sprintf("owner = %s", arg(filter.Owner)))
+	}
+	if len(filter.Project) > 0 {
+		conditions = append(conditions, fmt.Sprintf("project = %s", arg(filter.Project)))
+	}
+	if len(filter.Region) > 0 {
+		conditions = append(conditions, fmt.Sprintf("region = %s", arg(filter.Region)))
+	}
+	if len(filter.NamePrefix) > 0 {
+		// Comparing the leading characters avoids escaping LIKE wildcards
+		conditions = append(conditions, fmt.Sprintf("substr(name, 1, length(%[1]s)) = %[1]s", arg(filter.NamePrefix)))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("timestamp < %s", arg(filter.CreatedBefore)))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("timestamp > %s", arg(filter.CreatedAfter)))
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("expiration < %s", arg(filter.ExpiresBefore)))
+	}
+	if !filter.ExpiresAfter.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("expiration > %s", arg(filter.ExpiresAfter)))
+	}
+
+	// Keyset pagination continues after the last cluster of the previous page,
+	//  using the id to break ties between equal sort values
+	if len(filter.Cursor) > 0 {
+		cursor, err := decodeClusterCursor(filter.Cursor, sort_key)
+		if err != nil {
+			logger.Error(err)
+			return nil, "", err
+		}
+
+		comparison := ">"
+		if descending {
+			comparison = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", sort_column, comparison, arg(cursor.value), arg(cursor.Id)))
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	sql := `SELECT clusters.*, ` + queue_position + ` FROM clusters`
+	if len(conditions) > 0 {
+		sql = sql + ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	// One more cluster than the page size tells whether there is a following page
+	sql = sql + fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s LIMIT %[3]s`, sort_column, direction, arg(limit+1))
+
+	tx, err := dao.db.Beginx()
 	if err != nil {
 		logger.Error(err.Error())
-		return nil, err
+		return nil, "", err
 	}
 
-	sql := `SELECT * FROM clusters`
-	rows, err := tx.Queryx(sql)
+	rows, err := tx.Queryx(sql, args...)
 	if err != nil {
 		tx.Rollback()
 		logger.Error(err.Error())
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
@@ -180,17 +405,29 @@ func (dao *ClusterDao) GetClusters(db *sqlx.DB, requestId string) ([]models.Clus
 		err := rows.StructScan(&cluster)
 		if err != nil {
 			logger.Error(err)
-			return nil, err
+			return nil, "", err
 		}
 		clusters = append(clusters, cluster)
 	}
 
 	tx.Commit()
 
-	return clusters, nil
+	err = dao.keyring.decryptClusters(clusters)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, "", err
+	}
+
+	next := ""
+	if len(clusters) > limit {
+		clusters = clusters[:limit]
+		next = encodeClusterCursor(clusters[limit-1], sort_key)
+	}
+
+	return clusters, next, nil
 }
 
-func (dao *ClusterDao) GetExpiredClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error) {
+func (dao *ClusterDao) GetExpiredClusters(requestId string) ([]models.Cluster, error) {
 	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_expired_clusters", "request": requestId})
 
 	if len(requestId) == 0 {
@@ -199,14 +436,15 @@ func (dao *ClusterDao) GetExpiredClusters(db *sqlx.DB, requestId string) ([]mode
 		return nil, err
 	}
 
-	tx, err := db.Beginx()
+	tx, err := dao.db.Beginx()
 	if err != nil {
 		logger.Error(err.Error())
 		return nil, err
 	}
 
-	sql := `SELECT * FROM clusters WHERE expiration < $1 AND status NOT IN ('destroyed','destroying')`
-	rows, err := tx.Queryx(sql, time.Now())
+	// Failed destroys are retried with backoff rather than reaped again
+	sql := `SELECT * FROM clusters WHERE expiration < $1 AND status NOT IN ($2, $3, $4, $5)`
+	rows, err := tx.Queryx(sql, time.Now(), models.ClusterStatusDestroyed, models.ClusterStatusDestroying, models.ClusterStatusDestroyFailed, models.ClusterStatusDestroyRetriesExhausted)
 	if err != nil {
 		tx.Rollback()
 		logger.Error(err.Error())
@@ -228,14 +466,196 @@ func (dao *ClusterDao) GetExpiredClusters(db *sqlx.DB, requestId string) ([]mode
 
 	tx.Commit()
 
+	err = dao.keyring.decryptClusters(clusters)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
 	return clusters, nil
 }
 
-func (dao *ClusterDao) UpdateClusterField(db *sqlx.DB, id string, field string, value interface{}, requestId string) error {
+// Move the cluster from the status it was read with to another status. The
+// status is only changed when the transition is allowed and the cluster is
+// still in the status it was read with, so a cluster changed concurrently,
+// such as one deleted while provisioning, is not overwritten.
+func (dao *ClusterDao) UpdateClusterStatus(id string, from string, to string, requestId string) error {
+	return dao.updateClusterStatus(nil, id, from, to, requestId)
+}
+
+// Move the cluster of the job like UpdateClusterStatus, only while the job
+// is still claimed by its worker
+func (dao *ClusterDao) UpdateJobClusterStatus(job *models.ClusterJob, from string, to string, requestId string) error {
+	return dao.updateClusterStatus(job, job.ClusterId, from, to, requestId)
+}
+
+func (dao *ClusterDao) updateClusterStatus(job *models.ClusterJob, id string, from string, to string, requestId string) error {
 	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_status", "request": requestId})
 
-	tx, err := db.Beginx()
+	if !models.ClusterStatusTransitionAllowed(from, to) {
+		err := errors.New(models.ErrorInvalidStatusTransition)
+		logger.Error(fmt.Sprintf("cluster '%v' cannot move from '%v' to '%v'", id, from, to))
+		return err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	err = fenceClusterJob(tx, job)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	result, err := tx.Exec(`UPDATE clusters SET status = $1 WHERE id = $2 AND status = $3`, to, id, from)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	if rows == 0 {
+		tx.Rollback()
+		logger.Error(fmt.Sprintf("cluster '%v' is no longer '%v', not moving it to '%v'", id, from, to))
+		return errors.New(models.ErrorClusterStatusChanged)
+	}
+
+	err = recordClusterEvent(tx, id, models.ClusterHistoryStatus, to, "", "", requestId)
+	if err == nil {
+		err = dao.enqueueStatusWebhooks(tx, id, to)
+	}
 	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Live clusters which have not yet expired but will have by before
+func (dao *ClusterDao) GetExpiringClusters(before time.Time, requestId string) ([]models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_expiring_clusters", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	clusters := []models.Cluster{}
+	sql := `SELECT * FROM clusters WHERE status = $1 AND expiration > $2 AND expiration <= $3 ORDER BY expiration`
+	err = tx.Select(&clusters, sql, models.ClusterStatusProvisionSuccess, time.Now(), before)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	err = dao.keyring.decryptClusters(clusters)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return clusters, nil
+}
+
+// Record the expiration warnings sent for the cluster. Nothing is recorded
+// when the expiration has changed since the cluster was read, the warnings
+// were of the previous expiration.
+func (dao *ClusterDao) RecordExpirationWarnings(cluster *models.Cluster, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "record_expiration_warnings", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	sql := `UPDATE clusters SET expiration_warnings = $1 WHERE id = $2 AND expiration = $3`
+	_, err = tx.Exec(sql, cluster.ExpirationWarnings, cluster.Id, cluster.Expiration)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Clusters which failed to be destroyed and are due to be retried by
+// before, without a queued or running job
+func (dao *ClusterDao) GetDestroyRetryClusters(before time.Time, requestId string) ([]models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_destroy_retry_clusters", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	clusters := []models.Cluster{}
+	sql := `SELECT * FROM clusters WHERE status = $1 AND next_destroy_attempt <= $2 AND NOT EXISTS (
+		SELECT 1 FROM cluster_jobs WHERE cluster_jobs.cluster_id = clusters.id AND cluster_jobs.status IN ($3, $4)
+	)`
+	err = tx.Select(&clusters, sql, models.ClusterStatusDestroyFailed, before, models.ClusterJobQueued, models.ClusterJobRunning)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	err = dao.keyring.decryptClusters(clusters)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return clusters, nil
+}
+
+func (dao *ClusterDao) UpdateClusterField(id string, field string, value interface{}, requestId string) error {
+	return dao.updateClusterField(nil, id, field, value, requestId)
+}
+
+// Change a field of the cluster of the job like UpdateClusterField, only
+// while the job is still claimed by its worker
+func (dao *ClusterDao) UpdateJobClusterField(job *models.ClusterJob, field string, value interface{}, requestId string) error {
+	return dao.updateClusterField(job, job.ClusterId, field, value, requestId)
+}
+
+func (dao *ClusterDao) updateClusterField(job *models.ClusterJob, id string, field string, value interface{}, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_status", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	err = fenceClusterJob(tx, job)
+	if err != nil {
+		tx.Rollback()
 		logger.Error(err.Error())
 		return err
 	}
@@ -243,30 +663,50 @@ func (dao *ClusterDao) UpdateClusterField(db *sqlx.DB, id string, field string,
 	sql := ``
 	switch field {
 	case "status":
-		sql = `UPDATE clusters SET status = $2 WHERE id = $1 `
+		tx.Rollback()
+		return errors.New("cannot update status field, use UpdateClusterStatus")
 	case "message":
-		sql = `UPDATE clusters SET message = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET message = $1 WHERE id = $2 `
 	case "outputs":
-		sql = `UPDATE clusters SET outputs = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET outputs = $1 WHERE id = $2 `
 	case "terraform_config":
-		sql = `UPDATE clusters SET terraform_config = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET terraform_config = $1 WHERE id = $2 `
 	case "terraform_state":
-		sql = `UPDATE clusters SET terraform_state = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET terraform_state = $1 WHERE id = $2 `
 	case "timeout":
-		sql = `UPDATE clusters SET timeout = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET timeout = $1 WHERE id = $2 `
+	case "expiration":
+		// Warnings sent ahead of the previous expiration are sent again
+		sql = `UPDATE clusters SET expiration = $1, expiration_warnings = '{}' WHERE id = $2 `
 	case "timestamp":
 		tx.Rollback()
 		return errors.New("cannot update timestamp field")
 	case "project":
-		sql = `UPDATE clusters SET project = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET project = $1 WHERE id = $2 `
 	case "region":
-		sql = `UPDATE clusters SET region = $2 WHERE id = $1 `
+		sql = `UPDATE clusters SET region = $1 WHERE id = $2 `
+	case "destroy_attempts":
+		sql = `UPDATE clusters SET destroy_attempts = $1 WHERE id = $2 `
+	case "next_destroy_attempt":
+		sql = `UPDATE clusters SET next_destroy_attempt = $1 WHERE id = $2 `
 	default:
 		tx.Rollback()
 		return errors.New(fmt.Sprintf("field '%s' does not exist", field))
 	}
 
-	result, err := tx.Exec(sql, id, value)
+	// Secrets are encrypted with the data key the cluster is stored with
+	for _, column := range encryptedClusterColumns {
+		if field == column {
+			value, err = dao.encryptClusterField(tx, id, field, value)
+			if err != nil {
+				tx.Rollback()
+				logger.Error(err.Error())
+				return err
+			}
+		}
+	}
+
+	result, err := tx.Exec(sql, value, id)
 	if err != nil {
 		tx.Rollback()
 		logger.Error(err.Error())
@@ -289,3 +729,139 @@ func (dao *ClusterDao) UpdateClusterField(db *sqlx.DB, id string, field string,
 
 	return nil
 }
+
+// Change when the cluster expires, refusing to extend it past its quotas.
+// Extensions counted against the same quota are serialized like creations.
+func (dao *ClusterDao) UpdateClusterExpiration(id string, expiration time.Time, quotas []models.Quota, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_expiration", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	cluster := models.Cluster{}
+	err = tx.Get(&cluster, `SELECT id, timestamp, expiration FROM clusters WHERE id = $1`+lockRows(tx, ` FOR UPDATE`), id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	err = checkQuotaExtension(quotas, &cluster, expiration, func(quota models.Quota) (*models.QuotaUsage, error) {
+		if err := lockQuota(tx, quota); err != nil {
+			return nil, err
+		}
+		return quotaUsage(tx, quota)
+	})
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	// Warnings sent ahead of the previous expiration are sent again
+	_, err = tx.Exec(`UPDATE clusters SET expiration = $1, expiration_warnings = '{}' WHERE id = $2`, expiration, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Encrypt the value of a field with the data key of the cluster, locking
+// the cluster until the transaction ends. The value of a cluster which does
+// not exist is left as it is, updating it updates no cluster.
+func (dao *ClusterDao) encryptClusterField(tx *sqlx.Tx, id string, field string, value interface{}) (interface{}, error) {
+	plaintext, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid value of type %T for field '%s'", value, field)
+	}
+
+	cluster := models.Cluster{}
+	err := tx.Get(&cluster, `SELECT encryption_key_id, data_key FROM clusters WHERE id = $1`+lockRows(tx, ` FOR UPDATE`), id)
+	if err == sql.ErrNoRows {
+		return value, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return dao.keyring.encryptColumn(id, cluster.EncryptionKeyId, cluster.DataKey, field, plaintext)
+}
+
+// Encrypt every cluster not yet encrypted with the active key with a new
+// data key, returning the number of clusters rekeyed. Each cluster is
+// rekeyed in its own transaction, an interrupted rekey is resumed by
+// running it again.
+func (dao *ClusterDao) RekeyClusters(requestId string) (int, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "rekey_clusters", "request": requestId})
+
+	if dao.keyring == nil {
+		err := errors.New(models.ErrorEncryptionNotConfigured)
+		logger.Error(err)
+		return 0, err
+	}
+
+	ids := []string{}
+	err := dao.db.Select(&ids, `SELECT id FROM clusters WHERE encryption_key_id IS NULL OR encryption_key_id <> $1`, dao.keyring.ActiveKeyId())
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	rekeyed := 0
+	for _, id := range ids {
+		changed, err := dao.rekeyCluster(id)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to rekey cluster '%v': %v", id, err))
+			return rekeyed, err
+		}
+		if changed {
+			rekeyed++
+		}
+	}
+
+	logger.Info(fmt.Sprintf("rekeyed %d clusters with key '%v'", rekeyed, dao.keyring.ActiveKeyId()))
+
+	return rekeyed, nil
+}
+
+// Decrypt the cluster and encrypt it again with a new data key, unless it
+// has been rekeyed or deleted meanwhile
+func (dao *ClusterDao) rekeyCluster(id string) (bool, error) {
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+
+	cluster := models.Cluster{}
+	err = tx.Get(&cluster, `SELECT * FROM clusters WHERE id = $1`+lockRows(tx, ` FOR UPDATE`), id)
+	if err == sql.ErrNoRows || (err == nil && cluster.EncryptionKeyId == dao.keyring.ActiveKeyId()) {
+		tx.Rollback()
+		return false, nil
+	}
+	if err == nil {
+		err = dao.keyring.decryptCluster(&cluster)
+	}
+	if err == nil {
+		err = dao.keyring.encryptCluster(&cluster)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE clusters SET terraform_config = $1, terraform_state = $2, outputs = $3, variables = $4, encryption_key_id = $5, data_key = $6 WHERE id = $7`,
+			cluster.TerraformConfig, cluster.TerraformState, cluster.Outputs, cluster.StoredVariables, cluster.EncryptionKeyId, cluster.DataKey, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	tx.Commit()
+
+	return true, nil
+}
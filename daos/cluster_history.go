@@ -0,0 +1,75 @@
+package daos
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Append an event to the history of a cluster within the transaction that
+// makes the change it records, so the history never misses a change
+func recordClusterEvent(tx *sqlx.Tx, id string, kind string, name string, message string, actor string, requestId string) error {
+	sql := `INSERT INTO cluster_events (cluster_id, kind, name, message, request_id, actor, timestamp) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.Exec(sql, id, kind, name, message, requestId, actor, time.Now())
+	return err
+}
+
+// Append an event to the history of a cluster
+func (dao *ClusterDao) CreateClusterHistoryEvent(event *models.ClusterHistoryEvent, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_cluster_history_event", "request": requestId})
+
+	if len(event.ClusterId) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	err = recordClusterEvent(tx, event.ClusterId, event.Kind, event.Name, event.Message, event.Actor, event.RequestId)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Every event in the history of a cluster, oldest first
+func (dao *ClusterDao) GetClusterHistory(id string, requestId string) ([]models.ClusterHistoryEvent, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_cluster_history", "request": requestId})
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	events := []models.ClusterHistoryEvent{}
+	err = tx.Select(&events, `SELECT * FROM cluster_events WHERE cluster_id = $1 ORDER BY id`, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return events, nil
+}
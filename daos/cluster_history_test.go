@@ -0,0 +1,106 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Cluster History", func() {
+
+	var (
+		dao              *ClusterDao
+		valid_request_id string
+		other_request_id string
+		events           []models.ClusterHistoryEvent
+		err              error
+	)
+
+	kinds := func(events []models.ClusterHistoryEvent) []string {
+		names := []string{}
+		for _, event := range events {
+			names = append(names, event.Kind+":"+event.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		dao = NewClusterDao(valid_db)
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		other_request_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+
+		spec := &models.ClusterSpec{TerraformConfig: []byte(`{}`), Timeout: "10m", Project: "project_name", Region: "region_name", Owner: "alice"}
+		_, err = dao.CreateCluster(spec, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Creating a cluster", func() {
+		It("Should record who created the cluster and its first status", func() {
+			events, err = dao.GetClusterHistory(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kinds(events)).To(Equal([]string{"action:create", "status:requested"}))
+			Expect(events[0].Actor).To(Equal("alice"))
+			Expect(events[0].RequestId).To(Equal(valid_request_id))
+		})
+	})
+
+	Describe("Running a job", func() {
+		It("Should record the operation starting, the status changes and the operation finishing", func() {
+			job, err := dao.ClaimClusterJob("worker", other_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			err = dao.UpdateClusterStatus(valid_request_id, models.ClusterStatusRequested, models.ClusterStatusProvisionStart, other_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			job.Status = models.ClusterJobDone
+			Expect(dao.FinishClusterJob(job, other_request_id)).To(Succeed())
+
+			events, err = dao.GetClusterHistory(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kinds(events)).To(Equal([]string{
+				"action:create",
+				"status:requested",
+				"operation_started:provision",
+				"status:provisioning",
+				"operation_finished:provision",
+			}))
+			Expect(events[2].Actor).To(Equal("worker"))
+			Expect(events[4].Message).To(Equal(models.ClusterJobDone))
+		})
+	})
+
+	Describe("Rejecting a status change", func() {
+		It("Should not record the status", func() {
+			err = dao.UpdateClusterStatus(valid_request_id, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess, valid_request_id)
+			Expect(err).To(HaveOccurred())
+
+			events, err = dao.GetClusterHistory(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(2))
+		})
+	})
+
+	Describe("Recording an action", func() {
+		It("Should append the action to the history", func() {
+			event := &models.ClusterHistoryEvent{ClusterId: valid_request_id, Kind: models.ClusterHistoryAction, Name: models.ClusterActionDelete, RequestId: other_request_id, Actor: "bob"}
+			Expect(dao.CreateClusterHistoryEvent(event, other_request_id)).To(Succeed())
+
+			events, err = dao.GetClusterHistory(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(events).To(HaveLen(3))
+			Expect(events[2].Name).To(Equal(models.ClusterActionDelete))
+			Expect(events[2].Actor).To(Equal("bob"))
+		})
+
+		Context("When the cluster id is missing", func() {
+			It("Should error", func() {
+				err = dao.CreateClusterHistoryEvent(&models.ClusterHistoryEvent{Kind: models.ClusterHistoryAction}, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorMissingId))
+			})
+		})
+	})
+})
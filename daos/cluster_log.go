@@ -0,0 +1,84 @@
+package daos
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Append lines of terraform output to the log of a cluster operation
+func (dao *ClusterDao) AppendClusterLog(id string, operation string, lines []string, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "append_cluster_log", "request": requestId})
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	sql := `INSERT INTO cluster_logs (cluster_id, operation, line, timestamp) VALUES ($1, $2, $3, $4)`
+	now := time.Now()
+	for _, line := range lines {
+		_, err = tx.Exec(sql, id, operation, line, now)
+		if err != nil {
+			tx.Rollback()
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Lines of the log of a cluster following the line with the id after, of
+// every operation when operation is empty, oldest first
+func (dao *ClusterDao) GetClusterLogs(id string, operation string, after int64, requestId string) ([]models.ClusterLog, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_cluster_logs", "request": requestId})
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	sql := `SELECT * FROM cluster_logs WHERE cluster_id = $1 AND ($2 = '' OR operation = $2) AND id > $3 ORDER BY id LIMIT $4`
+	rows, err := tx.Queryx(sql, id, operation, after, models.MaxClusterLogLines)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []models.ClusterLog{}
+
+	for rows.Next() {
+		line := models.ClusterLog{}
+		err := rows.StructScan(&line)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		logs = append(logs, line)
+	}
+
+	tx.Commit()
+
+	return logs, nil
+}
@@ -0,0 +1,76 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Cluster Log", func() {
+
+	var (
+		dao              *ClusterDao
+		valid_request_id string
+		valid_cluster_id string
+		logs             []models.ClusterLog
+		err              error
+	)
+
+	BeforeEach(func() {
+		dao = NewClusterDao(valid_db)
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		valid_cluster_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+
+		err = dao.AppendClusterLog(valid_cluster_id, models.ClusterLogOperationProvision, []string{"$ terraform init", "Terraform has been successfully initialized!"}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+		err = dao.AppendClusterLog(valid_cluster_id, models.ClusterLogOperationDestroy, []string{"$ terraform destroy"}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Getting the logs of a cluster", func() {
+		Context("When every operation is requested", func() {
+			BeforeEach(func() {
+				logs, err = dao.GetClusterLogs(valid_cluster_id, "", 0, valid_request_id)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return every line in order", func() {
+				Expect(logs).To(HaveLen(3))
+				Expect(logs[0].Line).To(Equal("$ terraform init"))
+				Expect(logs[2].Operation).To(Equal(models.ClusterLogOperationDestroy))
+			})
+		})
+
+		Context("When a single operation is requested", func() {
+			It("Should return only lines of the operation", func() {
+				logs, err = dao.GetClusterLogs(valid_cluster_id, models.ClusterLogOperationDestroy, 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logs).To(HaveLen(1))
+			})
+		})
+
+		Context("When lines after a line are requested", func() {
+			It("Should return only the following lines", func() {
+				logs, err = dao.GetClusterLogs(valid_cluster_id, "", 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				logs, err = dao.GetClusterLogs(valid_cluster_id, "", logs[0].Id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logs).To(HaveLen(2))
+			})
+		})
+
+		Context("Without a cluster id", func() {
+			It("Should error", func() {
+				_, err = dao.GetClusterLogs("", "", 0, valid_request_id)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
@@ -26,16 +26,137 @@ var (
 				name 							text,
 				status 						text,
 				message 					text,
-				outputs 					json,
-				terraform_state 	json,
-				terraform_config 	text,
+				outputs 					bytea,
+				terraform_state 	bytea,
+				terraform_config 	bytea,
 				timestamp 				timestamp,
 				expiration 				timestamp,
 				timeout           text,
 				project           text,
-				region            text
+				region            text,
+				template_name     text DEFAULT '',
+				template_version  integer DEFAULT 0,
+				variables         json DEFAULT '{}',
+				owner             text DEFAULT '',
+				destroy_attempts  integer DEFAULT 0,
+				next_destroy_attempt timestamp DEFAULT 'epoch',
+				expiration_warnings  text[] DEFAULT '{}',
+				encryption_key_id text DEFAULT '',
+				data_key          bytea,
+				state_lock        text DEFAULT ''
 		)`
-	truncate_clusters = `TRUNCATE TABLE clusters`
+	webhooks_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.cluster_webhooks (
+				cluster_id        text,
+				url               text
+		);
+		CREATE TABLE IF NOT EXISTS cluster_test.webhook_deliveries (
+				id                bigserial PRIMARY KEY,
+				cluster_id        text,
+				url               text,
+				event             text,
+				payload           bytea,
+				status            text,
+				attempts          integer,
+				next_attempt      timestamp,
+				timestamp         timestamp
+		);
+		CREATE TABLE IF NOT EXISTS cluster_test.webhook_attempts (
+				delivery_id       bigint,
+				attempt           integer,
+				timestamp         timestamp,
+				response_code     integer,
+				error             text
+		)`
+	cluster_logs_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.cluster_logs (
+				id                bigserial PRIMARY KEY,
+				cluster_id        text,
+				operation         text,
+				line              text,
+				timestamp         timestamp
+		)`
+	cluster_events_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.cluster_events (
+				id                bigserial PRIMARY KEY,
+				cluster_id        text,
+				kind              text,
+				name              text,
+				message           text DEFAULT '',
+				request_id        text,
+				actor             text DEFAULT '',
+				timestamp         timestamp
+		)`
+	idempotency_keys_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.idempotency_keys (
+				owner             text DEFAULT '',
+				key               text,
+				request_hash      text,
+				cluster_id        text,
+				timestamp         timestamp,
+				PRIMARY KEY (owner, key)
+		)`
+	templates_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.templates (
+				name              text,
+				version           integer,
+				description       text,
+				config            text,
+				variables         json,
+				timestamp         timestamp,
+				PRIMARY KEY (name, version)
+		)`
+	tokens_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.tokens (
+				id                text PRIMARY KEY,
+				owner             text,
+				description       text,
+				hash              text UNIQUE,
+				admin             boolean DEFAULT false,
+				timestamp         timestamp
+		)`
+	role_bindings_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.role_bindings (
+				project           text,
+				subject_type      text,
+				subject           text,
+				role              text,
+				timestamp         timestamp,
+				PRIMARY KEY (project, subject_type, subject)
+		)`
+	cluster_jobs_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.cluster_jobs (
+				id                bigserial PRIMARY KEY,
+				cluster_id        text,
+				operation         text,
+				status            text,
+				request_id        text,
+				message           text DEFAULT '',
+				timestamp         timestamp,
+				updated           timestamp,
+				worker            text DEFAULT '',
+				heartbeat         timestamp,
+				cancelled         boolean DEFAULT false
+		)`
+	cluster_jobs_active_ddl = `
+		CREATE UNIQUE INDEX IF NOT EXISTS cluster_jobs_active ON cluster_test.cluster_jobs (cluster_id) WHERE status IN ('queued', 'running')`
+	reap_runs_ddl = `
+		CREATE TABLE IF NOT EXISTS cluster_test.reap_runs (
+				id                bigserial PRIMARY KEY,
+				request_id        text,
+				started           timestamp,
+				finished          timestamp,
+				reaped            integer,
+				skipped           integer,
+				failed            integer
+		);
+		CREATE TABLE IF NOT EXISTS cluster_test.reap_outcomes (
+				run_id            bigint,
+				cluster_id        text,
+				outcome           text,
+				message           text DEFAULT ''
+		)`
+	truncate_clusters = `TRUNCATE TABLE clusters, cluster_webhooks, webhook_deliveries, webhook_attempts, cluster_logs, cluster_events, idempotency_keys, templates, tokens, role_bindings, cluster_jobs, reap_runs, reap_outcomes`
 	drop_clusters_ddl = `DROP TABLE IF EXISTS cluster_test.clusters CASCADE`
 	create_pgcrypto   = `CREATE EXTENSION pgcrypto`
 )
@@ -62,6 +183,16 @@ var _ = BeforeSuite(func() {
 	valid_db.MustExec(drop_cluster_test_schema)
 	valid_db.MustExec(cluster_test_schema)
 	valid_db.MustExec(clusters_ddl)
+	valid_db.MustExec(webhooks_ddl)
+	valid_db.MustExec(cluster_logs_ddl)
+	valid_db.MustExec(cluster_events_ddl)
+	valid_db.MustExec(idempotency_keys_ddl)
+	valid_db.MustExec(templates_ddl)
+	valid_db.MustExec(tokens_ddl)
+	valid_db.MustExec(role_bindings_ddl)
+	valid_db.MustExec(cluster_jobs_ddl)
+	valid_db.MustExec(cluster_jobs_active_ddl)
+	valid_db.MustExec(reap_runs_ddl)
 	valid_db.MustExec(cluster_test_searchpath)
 
 })
@@ -83,17 +214,18 @@ var _ = Describe("Cluster", func() {
 		valid_project          string
 		valid_region           string
 		new_timestamp          time.Time
+		new_expiration         time.Time
 		new_project            string
 		new_region             string
 		clusters               []models.Cluster
 		err                    error
-		dao                    ClusterDao
+		dao                    *ClusterDao
 		tx                     *sqlx.Tx
 		valid_terraform_config []byte
 	)
 
 	BeforeEach(func() {
-		dao = ClusterDao{}
+		dao = NewClusterDao(valid_db)
 
 		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
 		valid_timeout = "10m"
@@ -103,7 +235,7 @@ var _ = Describe("Cluster", func() {
 		cluster_1 = &models.Cluster{
 			Id:              "a19e2758-0ec5-11e8-ba89-0ed5f89f718b",
 			Name:            "cluster_1",
-			Status:          "provisioned",
+			Status:          models.ClusterStatusProvisionSuccess,
 			Message:         "This is a message",
 			Outputs:         []byte(`{}`),
 			TerraformState:  []byte(`{}`),
@@ -117,7 +249,7 @@ var _ = Describe("Cluster", func() {
 		cluster_2 = &models.Cluster{
 			Id:              "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b",
 			Name:            "cluster_2",
-			Status:          "provisioned",
+			Status:          models.ClusterStatusProvisionSuccess,
 			Message:         "This is a message",
 			Outputs:         []byte(`{}`),
 			TerraformState:  []byte(`{}`),
@@ -169,7 +301,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When everything goes ok", func() {
 			BeforeEach(func() {
-				cluster, err = dao.CreateCluster(valid_db, valid_terraform_config, valid_timeout, valid_request_id, valid_project, valid_region)
+				cluster, err = dao.CreateCluster(&models.ClusterSpec{TerraformConfig: valid_terraform_config, Timeout: valid_timeout, Project: valid_project, Region: valid_region}, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -212,9 +344,21 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 
+		Context("With variables", func() {
+			It("Should persist the variables with the cluster", func() {
+				variables := models.ClusterVariables{"nodes": {Value: float64(3)}, "password": {Value: "secret", Sensitive: true}}
+				_, err = dao.CreateCluster(&models.ClusterSpec{TerraformConfig: valid_terraform_config, Timeout: valid_timeout, Project: valid_project, Region: valid_region, Variables: variables}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Variables).To(Equal(variables))
+			})
+		})
+
 		Context("Without terraform configuration", func() {
 			BeforeEach(func() {
-				cluster, err = dao.CreateCluster(valid_db, nil, valid_timeout, valid_request_id, valid_project, valid_region)
+				cluster, err = dao.CreateCluster(&models.ClusterSpec{TerraformConfig: nil, Timeout: valid_timeout, Project: valid_project, Region: valid_region}, valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -226,7 +370,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("Without a timeout", func() {
 			BeforeEach(func() {
-				cluster, err = dao.CreateCluster(valid_db, valid_terraform_config, "", valid_request_id, valid_project, valid_region)
+				cluster, err = dao.CreateCluster(&models.ClusterSpec{TerraformConfig: valid_terraform_config, Timeout: "", Project: valid_project, Region: valid_region}, valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -238,7 +382,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("Without a request id", func() {
 			BeforeEach(func() {
-				cluster, err = dao.CreateCluster(valid_db, valid_terraform_config, valid_timeout, "", valid_project, valid_region)
+				cluster, err = dao.CreateCluster(&models.ClusterSpec{TerraformConfig: valid_terraform_config, Timeout: valid_timeout, Project: valid_project, Region: valid_region}, "")
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -250,7 +394,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When then database transaction cannot be created", func() {
 			BeforeEach(func() {
-				cluster, err = dao.CreateCluster(invalid_db, nil, valid_timeout, valid_request_id, valid_project, valid_region)
+				cluster, err = NewClusterDao(invalid_db).CreateCluster(&models.ClusterSpec{TerraformConfig: nil, Timeout: valid_timeout, Project: valid_project, Region: valid_region}, valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -278,7 +422,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				cluster, err = dao.GetCluster(valid_db, cluster_1.Id, valid_request_id)
+				cluster, err = dao.GetCluster(cluster_1.Id, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -294,7 +438,7 @@ var _ = Describe("Cluster", func() {
 		Context("When the cluster does not exist", func() {
 			BeforeEach(func() {
 				// Without inserting any clusters into database
-				cluster, err = dao.GetCluster(valid_db, cluster_1.Id, valid_request_id)
+				cluster, err = dao.GetCluster(cluster_1.Id, valid_request_id)
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -306,7 +450,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("Without a cluster id", func() {
 			BeforeEach(func() {
-				cluster, err = dao.GetCluster(valid_db, "", valid_request_id)
+				cluster, err = dao.GetCluster("", valid_request_id)
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -318,7 +462,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("Without a request id", func() {
 			BeforeEach(func() {
-				cluster, err = dao.GetCluster(valid_db, cluster_1.Id, "")
+				cluster, err = dao.GetCluster(cluster_1.Id, "")
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -330,7 +474,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When then database transaction cannot be created", func() {
 			BeforeEach(func() {
-				cluster, err = dao.GetCluster(invalid_db, cluster_1.Id, valid_request_id)
+				cluster, err = NewClusterDao(invalid_db).GetCluster(cluster_1.Id, valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -359,7 +503,7 @@ var _ = Describe("Cluster", func() {
 				Expect(seed_err).NotTo(HaveOccurred())
 				seed_err = seedDatabaseWithCluster(cluster_2)
 				Expect(seed_err).NotTo(HaveOccurred())
-				clusters, err = dao.GetClusters(valid_db, valid_request_id)
+				clusters, _, err = dao.GetClusters(nil, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -374,9 +518,138 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 
+		Context("When filtering by status", func() {
+			BeforeEach(func() {
+				cluster_2.Status = models.ClusterStatusDestroyed
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				seed_err = seedDatabaseWithCluster(cluster_2)
+				Expect(seed_err).NotTo(HaveOccurred())
+				filter := &models.ClusterFilter{Statuses: []string{cluster_1.Status}}
+				clusters, _, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should not error", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("Should return only clusters with the status", func() {
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(cluster_1.Id))
+			})
+		})
+
+		Context("When filtering by owner", func() {
+			BeforeEach(func() {
+				cluster_1.Owner = "alice"
+				cluster_2.Owner = "bob"
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				seed_err = seedDatabaseWithCluster(cluster_2)
+				Expect(seed_err).NotTo(HaveOccurred())
+				filter := &models.ClusterFilter{Owner: "bob"}
+				clusters, _, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should not error", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("Should return only the clusters of the owner", func() {
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(cluster_2.Id))
+				Expect(clusters[0].Owner).To(Equal("bob"))
+			})
+		})
+
+		Context("When filtering by name prefix and creation time", func() {
+			BeforeEach(func() {
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				seed_err = seedDatabaseWithCluster(cluster_2)
+				Expect(seed_err).NotTo(HaveOccurred())
+				filter := &models.ClusterFilter{NamePrefix: "cluster_", CreatedBefore: time.Now().Add(-5 * time.Minute)}
+				clusters, _, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should not error", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("Should return only the matching clusters", func() {
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(cluster_2.Id))
+			})
+		})
+
+		Context("When sorting by name", func() {
+			BeforeEach(func() {
+				seed_err := seedDatabaseWithCluster(cluster_2)
+				Expect(seed_err).NotTo(HaveOccurred())
+				seed_err = seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				filter := &models.ClusterFilter{Sort: models.ClusterSortName}
+				clusters, _, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should return the clusters in order", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(clusters).To(HaveLen(2))
+				Expect(clusters[0].Id).To(Equal(cluster_1.Id))
+				Expect(clusters[1].Id).To(Equal(cluster_2.Id))
+			})
+		})
+
+		Context("When paginating", func() {
+			var (
+				next_cursor string
+				next_page   []models.Cluster
+			)
+			BeforeEach(func() {
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				seed_err = seedDatabaseWithCluster(cluster_2)
+				Expect(seed_err).NotTo(HaveOccurred())
+				filter := &models.ClusterFilter{Limit: 1}
+				clusters, next_cursor, err = dao.GetClusters(filter, valid_request_id)
+				Expect(err).ShouldNot(HaveOccurred())
+				filter.Cursor = next_cursor
+				next_page, next_cursor, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should not error", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("Should return one cluster per page", func() {
+				Expect(clusters).To(HaveLen(1))
+				Expect(next_page).To(HaveLen(1))
+			})
+			It("Should continue where the previous page ended", func() {
+				Expect(clusters[0].Id).To(Equal(cluster_1.Id))
+				Expect(next_page[0].Id).To(Equal(cluster_2.Id))
+			})
+			It("Should not return a cursor for the last page", func() {
+				Expect(next_cursor).To(BeEmpty())
+			})
+		})
+
+		Context("With an invalid cursor", func() {
+			BeforeEach(func() {
+				filter := &models.ClusterFilter{Cursor: "not-a-cursor"}
+				clusters, _, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal(models.ErrorInvalidCursor))
+			})
+		})
+
+		Context("With an invalid sort order", func() {
+			BeforeEach(func() {
+				filter := &models.ClusterFilter{Sort: "status"}
+				clusters, _, err = dao.GetClusters(filter, valid_request_id)
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal(models.ErrorInvalidClusterSort))
+			})
+		})
+
 		Context("When no clusters exist", func() {
 			BeforeEach(func() {
-				clusters, err = dao.GetClusters(valid_db, valid_request_id)
+				clusters, _, err = dao.GetClusters(nil, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -388,7 +661,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("Without a request id", func() {
 			BeforeEach(func() {
-				clusters, err = dao.GetClusters(valid_db, "")
+				clusters, _, err = dao.GetClusters(nil, "")
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -400,7 +673,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When then database transaction cannot be created", func() {
 			BeforeEach(func() {
-				clusters, err = dao.GetClusters(invalid_db, valid_request_id)
+				clusters, _, err = NewClusterDao(invalid_db).GetClusters(nil, valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -427,7 +700,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "status", "different_status", valid_request_id)
+				err = dao.UpdateClusterStatus(cluster_1.Id, cluster_1.Status, models.ClusterStatusDestroying, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -437,25 +710,60 @@ var _ = Describe("Cluster", func() {
 				cluster := models.Cluster{}
 				err := valid_db.Get(&cluster, "SELECT * FROM clusters WHERE id=$1", cluster_1.Id)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(cluster.Status).To(Equal("different_status"))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
 			})
 		})
 
-		Context("When updating the status field", func() {
+		Context("When the transition is not allowed", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "status", "different_status", valid_request_id)
+				err = dao.UpdateClusterStatus(cluster_1.Id, cluster_1.Status, models.ClusterStatusProvisionStart, valid_request_id)
 			})
-			It("Should not error", func() {
+			It("Should error", func() {
+				Expect(err).To(MatchError(models.ErrorInvalidStatusTransition))
+			})
+			It("Should not change the status", func() {
+				cluster := models.Cluster{}
+				err := valid_db.Get(&cluster, "SELECT * FROM clusters WHERE id=$1", cluster_1.Id)
 				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(cluster_1.Status))
 			})
-			It("Should have been updated for the cluster saved", func() {
-				// In order to use sqlx scanning, cluster needs to be empty struct
+		})
+
+		Context("When the status changed since the cluster was read", func() {
+			BeforeEach(func() {
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				err = dao.UpdateClusterStatus(cluster_1.Id, cluster_1.Status, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				err = dao.UpdateClusterStatus(cluster_1.Id, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess, valid_request_id)
+			})
+			It("Should error", func() {
+				Expect(err).To(MatchError(models.ErrorClusterStatusChanged))
+			})
+			It("Should keep the status it was changed to", func() {
 				cluster := models.Cluster{}
 				err := valid_db.Get(&cluster, "SELECT * FROM clusters WHERE id=$1", cluster_1.Id)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(cluster.Status).To(Equal("different_status"))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+		})
+
+		Context("When updating the status field", func() {
+			BeforeEach(func() {
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				err = dao.UpdateClusterField(cluster_1.Id, "status", models.ClusterStatusDestroyed, valid_request_id)
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+			It("Should not change the status", func() {
+				cluster := models.Cluster{}
+				err := valid_db.Get(&cluster, "SELECT * FROM clusters WHERE id=$1", cluster_1.Id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(cluster_1.Status))
 			})
 		})
 
@@ -463,7 +771,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "message", "different_message", valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "message", "different_message", valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -481,7 +789,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "outputs", []byte(`{"outputs":{}}`), valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "outputs", []byte(`{"outputs":{}}`), valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -499,7 +807,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "terraform_config", []byte(`{"config":{}}`), valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "terraform_config", []byte(`{"config":{}}`), valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -517,7 +825,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "terraform_state", []byte(`{"state":{}}`), valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "terraform_state", []byte(`{"state":{}}`), valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -535,7 +843,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "timeout", "10h", valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "timeout", "10h", valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -549,12 +857,31 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 
+		Context("When updating the expiration field", func() {
+			BeforeEach(func() {
+				seed_err := seedDatabaseWithCluster(cluster_1)
+				Expect(seed_err).NotTo(HaveOccurred())
+				new_expiration = time.Now().UTC().Add(time.Hour).Round(time.Second)
+				err = dao.UpdateClusterField(cluster_1.Id, "expiration", new_expiration, valid_request_id)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should have been updated for the cluster saved", func() {
+				// In order to use sqlx scanning, cluster needs to be empty struct
+				cluster := models.Cluster{}
+				err := valid_db.Get(&cluster, "SELECT * FROM clusters WHERE id=$1", cluster_1.Id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Expiration.Equal(new_expiration)).To(BeTrue())
+			})
+		})
+
 		Context("When updating the timestamp field", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
 				new_timestamp = time.Now()
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "timestamp", new_timestamp, valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "timestamp", new_timestamp, valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -573,7 +900,7 @@ var _ = Describe("Cluster", func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
 				new_project = "new_project_name"
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "project", new_project, valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "project", new_project, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -592,7 +919,7 @@ var _ = Describe("Cluster", func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
 				new_region = "new_region_name"
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "region", new_region, valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "region", new_region, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -610,7 +937,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "not-a-field", "", valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "not-a-field", "", valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -621,7 +948,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				seed_err := seedDatabaseWithCluster(cluster_1)
 				Expect(seed_err).NotTo(HaveOccurred())
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "status", cluster_1.Status, valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "message", cluster_1.Message, valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -631,13 +958,13 @@ var _ = Describe("Cluster", func() {
 				cluster := models.Cluster{}
 				err := valid_db.Get(&cluster, "SELECT * FROM clusters WHERE id=$1", cluster_1.Id)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(cluster.Status).To(Equal(cluster_1.Status))
+				Expect(cluster.Message).To(Equal(cluster_1.Message))
 			})
 		})
 
 		Context("When the cluster does not exist", func() {
 			BeforeEach(func() {
-				err = dao.UpdateClusterField(valid_db, cluster_1.Id, "status", cluster_1.Status, valid_request_id)
+				err = dao.UpdateClusterField(cluster_1.Id, "message", cluster_1.Message, valid_request_id)
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -664,7 +991,7 @@ var _ = Describe("Cluster", func() {
 				Expect(seed_err).NotTo(HaveOccurred())
 				seed_err = seedDatabaseWithCluster(not_expired_cluster)
 				Expect(seed_err).NotTo(HaveOccurred())
-				clusters, err = dao.GetExpiredClusters(valid_db, valid_request_id)
+				clusters, err = dao.GetExpiredClusters(valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -681,7 +1008,7 @@ var _ = Describe("Cluster", func() {
 				Expect(seed_err).NotTo(HaveOccurred())
 				seed_err = seedDatabaseWithCluster(not_expired_cluster)
 				Expect(seed_err).NotTo(HaveOccurred())
-				clusters, err = dao.GetExpiredClusters(valid_db, valid_request_id)
+				clusters, err = dao.GetExpiredClusters(valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -698,7 +1025,7 @@ var _ = Describe("Cluster", func() {
 				expired_cluster.Status = "destroying"
 				seed_err = seedDatabaseWithCluster(expired_cluster)
 				Expect(seed_err).NotTo(HaveOccurred())
-				clusters, err = dao.GetExpiredClusters(valid_db, valid_request_id)
+				clusters, err = dao.GetExpiredClusters(valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -710,7 +1037,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When there are no clusters", func() {
 			BeforeEach(func() {
-				clusters, err = dao.GetExpiredClusters(valid_db, valid_request_id)
+				clusters, err = dao.GetExpiredClusters(valid_request_id)
 			})
 			It("Should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -722,7 +1049,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When then database transaction cannot be created", func() {
 			BeforeEach(func() {
-				clusters, err = dao.GetExpiredClusters(invalid_db, valid_request_id)
+				clusters, err = NewClusterDao(invalid_db).GetExpiredClusters(valid_request_id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -734,7 +1061,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("Without a request id", func() {
 			BeforeEach(func() {
-				clusters, err = dao.GetExpiredClusters(valid_db, "")
+				clusters, err = dao.GetExpiredClusters("")
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -746,6 +1073,48 @@ var _ = Describe("Cluster", func() {
 
 	})
 
+	Describe("Getting expiring clusters", func() {
+		BeforeEach(func() {
+			not_expired_cluster.Status = models.ClusterStatusProvisionSuccess
+			not_expired_cluster.Expiration = time.Now().Add(30 * time.Minute)
+			seed_err := seedDatabaseWithCluster(not_expired_cluster)
+			Expect(seed_err).NotTo(HaveOccurred())
+			expired_cluster.Status = models.ClusterStatusProvisionSuccess
+			seed_err = seedDatabaseWithCluster(expired_cluster)
+			Expect(seed_err).NotTo(HaveOccurred())
+		})
+
+		Context("When a live cluster expires by the time given", func() {
+			It("Should return only the cluster not yet expired", func() {
+				clusters, err = dao.GetExpiringClusters(time.Now().Add(time.Hour), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(not_expired_cluster.Id))
+			})
+		})
+
+		Context("When warnings of the cluster are recorded", func() {
+			BeforeEach(func() {
+				clusters, err = dao.GetExpiringClusters(time.Now().Add(time.Hour), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				clusters[0].ExpirationWarnings = append(clusters[0].ExpirationWarnings, "1h0m0s")
+				Expect(dao.RecordExpirationWarnings(&clusters[0], valid_request_id)).To(Succeed())
+			})
+			It("Should record them on the cluster", func() {
+				cluster, err := dao.GetCluster(not_expired_cluster.Id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect([]string(cluster.ExpirationWarnings)).To(Equal([]string{"1h0m0s"}))
+			})
+			It("Should clear them when the expiration changes", func() {
+				err = dao.UpdateClusterField(not_expired_cluster.Id, "expiration", time.Now().Add(2*time.Hour), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				cluster, err := dao.GetCluster(not_expired_cluster.Id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.ExpirationWarnings).To(BeEmpty())
+			})
+		})
+	})
+
 })
 
 func seedDatabaseWithCluster(cluster *models.Cluster) error {
@@ -761,7 +1130,11 @@ func seedDatabaseWithCluster(cluster *models.Cluster) error {
 		:expiration,
 		:timeout,
 		:project,
-		:region
+		:region,
+		:template_name,
+		:template_version,
+		:variables,
+		:owner
 	)`
 	_, err := valid_db.NamedExec(sql, cluster)
 	return err
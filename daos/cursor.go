@@ -0,0 +1,70 @@
+package daos
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kmacoskey/taos/models"
+)
+
+// Columns clusters can be ordered by for each models.ClusterSort* key
+var clusterSortColumns = map[string]string{
+	models.ClusterSortCreated:    "timestamp",
+	models.ClusterSortExpiration: "expiration",
+	models.ClusterSortName:       "name",
+}
+
+// Position of the last cluster of a page of results. The cursor is opaque
+// to clients and only valid for the sort key it was created with.
+type clusterCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    string `json:"id"`
+
+	// Sort value typed for comparison against the sort column
+	value interface{}
+}
+
+func encodeClusterCursor(cluster models.Cluster, sort_key string) string {
+	cursor := clusterCursor{Sort: sort_key, Id: cluster.Id}
+
+	switch sort_key {
+	case models.ClusterSortCreated:
+		cursor.Value = cluster.Timestamp.Format(time.RFC3339Nano)
+	case models.ClusterSortExpiration:
+		cursor.Value = cluster.Expiration.Format(time.RFC3339Nano)
+	case models.ClusterSortName:
+		cursor.Value = cluster.Name
+	}
+
+	js, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeClusterCursor(encoded string, sort_key string) (*clusterCursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New(models.ErrorInvalidCursor)
+	}
+
+	cursor := &clusterCursor{}
+	err = json.Unmarshal(js, cursor)
+	if err != nil || cursor.Sort != sort_key || len(cursor.Id) == 0 {
+		return nil, errors.New(models.ErrorInvalidCursor)
+	}
+
+	switch sort_key {
+	case models.ClusterSortCreated, models.ClusterSortExpiration:
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, errors.New(models.ErrorInvalidCursor)
+		}
+		cursor.value = t
+	default:
+		cursor.value = cursor.Value
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,55 @@
+package daos
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+)
+
+// Queries of the ClusterDao are shared by Postgres and SQLite. SQLite
+// numbers $N placeholders in the order they first appear in a query rather
+// than by N, so shared queries use their placeholders in increasing order.
+
+// Driver the embedded SQLite backend is opened with
+const sqliteDriver = "sqlite3"
+
+// Clause locking the rows selected until the transaction ends. SQLite has
+// no row locks, its transactions are begun immediate and lock the whole
+// database for writing instead.
+func lockRows(tx *sqlx.Tx, clause string) string {
+	if tx.DriverName() == sqliteDriver {
+		return ""
+	}
+	return clause
+}
+
+// Seconds between the creation and the expiration of a cluster
+func lifetimeSeconds(tx *sqlx.Tx) string {
+	if tx.DriverName() == sqliteDriver {
+		return `(julianday(expiration) - julianday(timestamp)) * 86400`
+	}
+	return `EXTRACT(EPOCH FROM (expiration - timestamp))`
+}
+
+// Serialize creations counted against the quota until the transaction
+// ends, as SQLite transactions already are
+func lockQuota(tx *sqlx.Tx, quota models.Quota) error {
+	if tx.DriverName() == sqliteDriver {
+		return nil
+	}
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, fmt.Sprintf("quota:%v:%v", quota.Scope, quota.Name))
+	return err
+}
+
+// Placeholders of the values, which are appended to args and numbered
+// following the args before them
+func placeholders(args *[]interface{}, values []string) string {
+	numbered := []string{}
+	for _, value := range values {
+		*args = append(*args, value)
+		numbered = append(numbered, fmt.Sprintf("$%d", len(*args)))
+	}
+	return strings.Join(numbered, ", ")
+}
@@ -0,0 +1,269 @@
+package daos
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/kmacoskey/taos/models"
+)
+
+// Keys encrypting the data keys of clusters, by id. Each cluster has its
+// own data key encrypting its terraform config, state, outputs and
+// variables, stored encrypted by the active key alongside the id of that
+// key. Retired keys are kept to decrypt the clusters not yet rekeyed.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// A keyring of the base64 encoded 256 bit keys, encrypting with the key
+// with the active id
+func NewKeyring(active string, keys map[string]string) (*Keyring, error) {
+	keyring := &Keyring{active: active, keys: make(map[string][]byte)}
+
+	for id, encoded := range keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 || len(id) == 0 {
+			return nil, errors.New(models.ErrorInvalidEncryptionKey)
+		}
+		keyring.keys[id] = key
+	}
+
+	if _, exists := keyring.keys[active]; !exists {
+		return nil, errors.New(models.ErrorMissingEncryptionKey)
+	}
+
+	return keyring, nil
+}
+
+// Id of the key new data keys are encrypted with
+func (keyring *Keyring) ActiveKeyId() string {
+	return keyring.active
+}
+
+// A new data key, and the data key encrypted by the active key
+func (keyring *Keyring) newDataKey() ([]byte, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := seal(keyring.keys[keyring.active], key, []byte(keyring.active))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, encrypted, nil
+}
+
+// The data key encrypted by the key with the id
+func (keyring *Keyring) dataKey(id string, encrypted []byte) ([]byte, error) {
+	key, exists := keyring.keys[id]
+	if !exists {
+		return nil, errors.New(models.ErrorUnknownEncryptionKey)
+	}
+
+	return open(key, encrypted, []byte(id))
+}
+
+// Encrypt the plaintext with AES-256-GCM, bound to the additional data so
+// it cannot be moved to another cluster or column. The nonce is prepended
+// to the ciphertext.
+func seal(key []byte, plaintext []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, data), nil
+}
+
+func open(key []byte, ciphertext []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New(models.ErrorDecryptionFailed)
+	}
+
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], data)
+	if err != nil {
+		return nil, errors.New(models.ErrorDecryptionFailed)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Columns of a cluster stored encrypted. The variables hold the values of
+// sensitive variables, they are encrypted json encoded.
+var encryptedClusterColumns = []string{"terraform_config", "terraform_state", "outputs", "variables"}
+
+func clusterColumn(cluster *models.Cluster, column string) *[]byte {
+	switch column {
+	case "terraform_config":
+		return &cluster.TerraformConfig
+	case "terraform_state":
+		return &cluster.TerraformState
+	case "outputs":
+		return &cluster.Outputs
+	case "variables":
+		return &cluster.StoredVariables
+	}
+	return nil
+}
+
+// Additional data binding an encrypted column to its cluster
+func columnData(id string, column string) []byte {
+	return []byte(id + "/" + column)
+}
+
+// Encrypt the columns of the cluster with a new data key, recording the
+// data key in the cluster. The cluster is left in plaintext without a
+// keyring.
+func (keyring *Keyring) encryptCluster(cluster *models.Cluster) error {
+	err := encodeClusterVariables(cluster)
+	if err != nil {
+		return err
+	}
+
+	if keyring == nil {
+		cluster.EncryptionKeyId = ""
+		cluster.DataKey = nil
+		return nil
+	}
+
+	key, encrypted, err := keyring.newDataKey()
+	if err != nil {
+		return err
+	}
+
+	for _, column := range encryptedClusterColumns {
+		value := clusterColumn(cluster, column)
+		if *value == nil {
+			continue
+		}
+		*value, err = seal(key, *value, columnData(cluster.Id, column))
+		if err != nil {
+			return err
+		}
+	}
+
+	cluster.EncryptionKeyId = keyring.active
+	cluster.DataKey = encrypted
+
+	return nil
+}
+
+// Decrypt the columns of a cluster read from the database, leaving a
+// cluster stored in plaintext as it is, then decode its variables
+func (keyring *Keyring) decryptCluster(cluster *models.Cluster) error {
+	err := keyring.decryptColumns(cluster)
+	if err != nil {
+		return err
+	}
+
+	return decodeClusterVariables(cluster)
+}
+
+func (keyring *Keyring) decryptColumns(cluster *models.Cluster) error {
+	if len(cluster.EncryptionKeyId) == 0 {
+		return nil
+	}
+
+	if keyring == nil {
+		return errors.New(models.ErrorUnknownEncryptionKey)
+	}
+
+	key, err := keyring.dataKey(cluster.EncryptionKeyId, cluster.DataKey)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range encryptedClusterColumns {
+		value := clusterColumn(cluster, column)
+		if *value == nil {
+			continue
+		}
+		*value, err = open(key, *value, columnData(cluster.Id, column))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode the variables of the cluster as they are stored
+func encodeClusterVariables(cluster *models.Cluster) error {
+	variables := cluster.Variables
+	if variables == nil {
+		variables = models.ClusterVariables{}
+	}
+
+	encoded, err := json.Marshal(variables)
+	if err != nil {
+		return err
+	}
+
+	cluster.StoredVariables = encoded
+	return nil
+}
+
+// Decode the variables of the cluster as they were stored, a cluster
+// stored without variables has none
+func decodeClusterVariables(cluster *models.Cluster) error {
+	cluster.Variables = models.ClusterVariables{}
+	if len(cluster.StoredVariables) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(cluster.StoredVariables, &cluster.Variables)
+}
+
+func (keyring *Keyring) decryptClusters(clusters []models.Cluster) error {
+	for i := range clusters {
+		if err := keyring.decryptCluster(&clusters[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encrypt a value of a column of a cluster with the data key the cluster
+// is stored with
+func (keyring *Keyring) encryptColumn(id string, keyId string, dataKey []byte, column string, value []byte) ([]byte, error) {
+	if len(keyId) == 0 || value == nil {
+		return value, nil
+	}
+
+	if keyring == nil {
+		return nil, errors.New(models.ErrorUnknownEncryptionKey)
+	}
+
+	key, err := keyring.dataKey(keyId, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(key, value, columnData(id, column))
+}
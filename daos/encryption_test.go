@@ -0,0 +1,156 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Encryption", func() {
+
+	var (
+		keyring          *Keyring
+		dao              *ClusterDao
+		valid_request_id string
+		valid_config     []byte
+		err              error
+	)
+
+	keys := map[string]string{
+		"key-1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+		"key-2": "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=",
+	}
+
+	valid_variables := models.ClusterVariables{"password": {Value: "secret", Sensitive: true}}
+
+	newSpec := func() *models.ClusterSpec {
+		return &models.ClusterSpec{
+			TerraformConfig: valid_config,
+			Timeout:         "10m",
+			Project:         "project_name",
+			Region:          "region_name",
+			Variables:       valid_variables,
+		}
+	}
+
+	// The encrypted columns of the cluster as stored
+	storedCluster := func(id string) (config []byte, variables []byte, key_id string) {
+		row := valid_db.QueryRow(`SELECT terraform_config, variables, encryption_key_id FROM clusters WHERE id = $1`, id)
+		Expect(row.Scan(&config, &variables, &key_id)).To(Succeed())
+		return config, variables, key_id
+	}
+
+	BeforeEach(func() {
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		valid_config = []byte(`{"provider":{"google":{"credentials":"secret"}}}`)
+
+		keyring, err = NewKeyring("key-1", keys)
+		Expect(err).NotTo(HaveOccurred())
+		dao = NewClusterDao(valid_db).WithKeyring(keyring)
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Creating a keyring", func() {
+		Context("When a key is not 32 bytes", func() {
+			It("Should error", func() {
+				_, err = NewKeyring("key-1", map[string]string{"key-1": "c2hvcnQ="})
+				Expect(err).To(MatchError(models.ErrorInvalidEncryptionKey))
+			})
+		})
+
+		Context("When the active key is not configured", func() {
+			It("Should error", func() {
+				_, err = NewKeyring("key-3", keys)
+				Expect(err).To(MatchError(models.ErrorMissingEncryptionKey))
+			})
+		})
+	})
+
+	Describe("Storing a cluster", func() {
+		BeforeEach(func() {
+			_, err = dao.CreateCluster(newSpec(), valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should store the secrets of the cluster encrypted", func() {
+			config, variables, key_id := storedCluster(valid_request_id)
+			Expect(key_id).To(Equal("key-1"))
+			Expect(string(config)).NotTo(ContainSubstring("secret"))
+			Expect(string(variables)).NotTo(ContainSubstring("secret"))
+		})
+
+		It("Should return the secrets of the cluster decrypted", func() {
+			Expect(dao.UpdateClusterField(valid_request_id, "terraform_state", []byte(`{"secret":"state"}`), valid_request_id)).To(Succeed())
+
+			cluster, err := dao.GetCluster(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cluster.TerraformConfig).To(Equal(valid_config))
+			Expect(cluster.TerraformState).To(MatchJSON(`{"secret":"state"}`))
+			Expect(cluster.Variables).To(Equal(valid_variables))
+		})
+
+		Context("When the key is not configured", func() {
+			It("Should error", func() {
+				_, err = NewClusterDao(valid_db).GetCluster(valid_request_id, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorUnknownEncryptionKey))
+			})
+		})
+	})
+
+	Describe("Rekeying clusters", func() {
+		Context("When the cluster is stored in plaintext", func() {
+			It("Should encrypt the cluster", func() {
+				_, err = NewClusterDao(valid_db).CreateCluster(newSpec(), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				rekeyed, err := dao.RekeyClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rekeyed).To(Equal(1))
+
+				_, variables, key_id := storedCluster(valid_request_id)
+				Expect(key_id).To(Equal("key-1"))
+				Expect(string(variables)).NotTo(ContainSubstring("secret"))
+
+				cluster, err := dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Variables).To(Equal(valid_variables))
+
+				rekeyed, err = dao.RekeyClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rekeyed).To(Equal(0))
+			})
+		})
+
+		Context("When the active key has been rotated", func() {
+			It("Should encrypt the cluster with the active key", func() {
+				_, err = dao.CreateCluster(newSpec(), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				keyring, err = NewKeyring("key-2", keys)
+				Expect(err).NotTo(HaveOccurred())
+				rekeyed, err := NewClusterDao(valid_db).WithKeyring(keyring).RekeyClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rekeyed).To(Equal(1))
+
+				keyring, err = NewKeyring("key-2", map[string]string{"key-2": keys["key-2"]})
+				Expect(err).NotTo(HaveOccurred())
+				cluster, err := NewClusterDao(valid_db).WithKeyring(keyring).GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.TerraformConfig).To(Equal(valid_config))
+				Expect(cluster.Variables).To(Equal(valid_variables))
+			})
+		})
+
+		Context("When encryption is not configured", func() {
+			It("Should error", func() {
+				_, err = NewClusterDao(valid_db).RekeyClusters(valid_request_id)
+				Expect(err).To(MatchError(models.ErrorEncryptionNotConfigured))
+			})
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package daos
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// The idempotency key the owner used with the given key, or nil when there
+// is none
+func (dao *ClusterDao) GetIdempotencyKey(owner string, key string, requestId string) (*models.IdempotencyKey, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_idempotency_key", "request": requestId})
+
+	if len(key) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	idempotency_key := models.IdempotencyKey{}
+	err = tx.Get(&idempotency_key, `SELECT * FROM idempotency_keys WHERE owner = $1 AND key = $2`, owner, key)
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &idempotency_key, nil
+}
+
+// Forget idempotency keys used before the given time
+func (dao *ClusterDao) DeleteIdempotencyKeys(before time.Time, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "delete_idempotency_keys", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM idempotency_keys WHERE timestamp < $1`, before)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
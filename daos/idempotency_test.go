@@ -0,0 +1,87 @@
+package daos_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Idempotency Key", func() {
+
+	var (
+		dao              *ClusterDao
+		spec             *models.ClusterSpec
+		valid_request_id string
+		other_request_id string
+		key              *models.IdempotencyKey
+		err              error
+	)
+
+	BeforeEach(func() {
+		dao = NewClusterDao(valid_db)
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		other_request_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+		spec = &models.ClusterSpec{
+			TerraformConfig: []byte(`{}`),
+			Timeout:         "10m",
+			Project:         "project_name",
+			Region:          "region_name",
+			IdempotencyKey:  "key",
+			RequestHash:     "hash",
+		}
+
+		_, err = dao.CreateCluster(spec, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Creating a cluster with a key", func() {
+		It("Should record the key with the cluster", func() {
+			key, err = dao.GetIdempotencyKey("", "key", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key.ClusterId).To(Equal(valid_request_id))
+			Expect(key.RequestHash).To(Equal("hash"))
+		})
+
+		Context("When the key has already been used", func() {
+			It("Should refuse to create another cluster", func() {
+				cluster, err := dao.CreateCluster(spec, other_request_id)
+				Expect(err).To(MatchError(models.ErrorIdempotencyKeyInUse))
+				Expect(cluster).To(BeNil())
+
+				_, err = dao.GetCluster(other_request_id, other_request_id)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Getting a key", func() {
+		Context("When the key has not been used", func() {
+			It("Should not return a key", func() {
+				key, err = dao.GetIdempotencyKey("", "unused", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(key).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Deleting keys", func() {
+		It("Should forget keys used before the given time", func() {
+			Expect(dao.DeleteIdempotencyKeys(time.Now().Add(-time.Hour), valid_request_id)).To(Succeed())
+			key, err = dao.GetIdempotencyKey("", "key", valid_request_id)
+			Expect(key).NotTo(BeNil())
+
+			Expect(dao.DeleteIdempotencyKeys(time.Now().Add(time.Hour), valid_request_id)).To(Succeed())
+			key, err = dao.GetIdempotencyKey("", "key", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(key).To(BeNil())
+		})
+	})
+})
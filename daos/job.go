@@ -0,0 +1,428 @@
+package daos
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Queue a terraform operation on a cluster, moving the cluster to status in
+// the same transaction. The cluster is locked while it is checked, so
+// concurrent requests cannot queue two operations on the same cluster.
+func (dao *ClusterDao) EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "enqueue_cluster_job", "request": requestId})
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	current := ""
+	err = tx.Get(&current, `SELECT status FROM clusters WHERE id = $1`+lockRows(tx, ` FOR UPDATE`), id)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		err := errors.New(models.ErrorJobClusterNotFound)
+		logger.Error(err)
+		return nil, err
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if !models.ClusterJobAllowed(operation, current) || !models.ClusterStatusTransitionAllowed(current, status) {
+		tx.Rollback()
+		err := errors.New(models.ErrorClusterJobNotAllowed)
+		logger.Error(err)
+		return nil, err
+	}
+
+	active := 0
+	err = tx.Get(&active, `SELECT COUNT(*) FROM cluster_jobs WHERE cluster_id = $1 AND status IN ($2, $3)`, id, models.ClusterJobQueued, models.ClusterJobRunning)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+	if active > 0 {
+		tx.Rollback()
+		err := errors.New(models.ErrorClusterJobActive)
+		logger.Error(err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE clusters SET status = $1 WHERE id = $2`, status, id)
+	if err == nil && current != status {
+		err = recordClusterEvent(tx, id, models.ClusterHistoryStatus, status, "", "", requestId)
+		if err == nil {
+			err = dao.enqueueStatusWebhooks(tx, id, status)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	job, err := enqueueClusterJob(tx, id, operation, requestId)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	logger.Info(fmt.Sprintf("queued job '%v' to %v cluster '%v'", job.Id, operation, id))
+
+	return job, nil
+}
+
+func enqueueClusterJob(tx *sqlx.Tx, id string, operation string, requestId string) (*models.ClusterJob, error) {
+	if operation != models.ClusterJobProvision && operation != models.ClusterJobDestroy {
+		return nil, errors.New(models.ErrorInvalidJobOperation)
+	}
+
+	now := time.Now()
+	job := models.ClusterJob{
+		ClusterId: id,
+		Operation: operation,
+		Status:    models.ClusterJobQueued,
+		RequestId: requestId,
+		Timestamp: now,
+		Updated:   now,
+		Heartbeat: now,
+	}
+
+	sql := `INSERT INTO cluster_jobs (cluster_id, operation, status, request_id, timestamp, updated, heartbeat) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := tx.Get(&job.Id, sql, job.ClusterId, job.Operation, job.Status, job.RequestId, job.Timestamp, job.Updated, job.Heartbeat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Claim the oldest queued job for the worker, marking it running. Returns
+// nil when no job is queued. Jobs locked by a concurrent claim are skipped
+// rather than waited on.
+func (dao *ClusterDao) ClaimClusterJob(worker string, requestId string) (*models.ClusterJob, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "claim_cluster_job", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	job := models.ClusterJob{}
+	now := time.Now()
+	query := `UPDATE cluster_jobs SET status = $1, worker = $2, updated = $3, heartbeat = $3
+		WHERE id = (SELECT id FROM cluster_jobs WHERE status = $4 ORDER BY id LIMIT 1` + lockRows(tx, ` FOR UPDATE SKIP LOCKED`) + `)
+		RETURNING *`
+	err = tx.Get(&job, query, models.ClusterJobRunning, worker, now, models.ClusterJobQueued)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, nil
+	}
+	if err == nil {
+		err = recordClusterEvent(tx, job.ClusterId, models.ClusterHistoryOperationStarted, job.Operation, "", worker, requestId)
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	logger.Info(fmt.Sprintf("worker '%v' claimed job '%v' to %v cluster '%v'", worker, job.Id, job.Operation, job.ClusterId))
+
+	return &job, nil
+}
+
+// The job with the id, nil when there is none
+func (dao *ClusterDao) GetClusterJob(id int64, requestId string) (*models.ClusterJob, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_cluster_job", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	job := models.ClusterJob{}
+	err = tx.Get(&job, `SELECT * FROM cluster_jobs WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &job, nil
+}
+
+// Report a running job as alive, refreshing whether cancelling the job has
+// been requested since. Errors when the job is no longer claimed by its
+// worker, having been abandoned.
+func (dao *ClusterDao) HeartbeatClusterJob(job *models.ClusterJob, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "heartbeat_cluster_job", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	job.Heartbeat = time.Now()
+	query := `UPDATE cluster_jobs SET heartbeat = $1 WHERE id = $2 AND status = $3 AND worker = $4 RETURNING cancelled`
+	err = tx.Get(&job.Cancelled, query, job.Heartbeat, job.Id, models.ClusterJobRunning, job.Worker)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		err := errors.New(models.ErrorClusterJobClaimLost)
+		logger.Error(err)
+		return err
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Lock the job while its cluster is changed within tx, erroring when the
+// job is no longer claimed by its worker. A job abandoned meanwhile may
+// already have been followed by another, whose worker now owns the cluster.
+// Nothing is checked without a job.
+func fenceClusterJob(tx *sqlx.Tx, job *models.ClusterJob) error {
+	if job == nil {
+		return nil
+	}
+
+	var id int64
+	query := `SELECT id FROM cluster_jobs WHERE id = $1 AND status = $2 AND worker = $3` + lockRows(tx, ` FOR UPDATE`)
+	err := tx.Get(&id, query, job.Id, models.ClusterJobRunning, job.Worker)
+	if err == sql.ErrNoRows {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+
+	return err
+}
+
+// Cancel the provisioning job of a cluster. A queued job is cancelled
+// before it runs and the cluster is failed, as nothing was provisioned. A
+// running job is only flagged, its worker interrupts terraform and rolls
+// back what was provisioned. Returns the job as it was cancelled.
+func (dao *ClusterDao) CancelClusterJob(id string, requestId string) (*models.ClusterJob, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "cancel_cluster_job", "request": requestId})
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	job := models.ClusterJob{}
+	query := `SELECT * FROM cluster_jobs WHERE cluster_id = $1 AND operation = $2 AND status IN ($3, $4)` + lockRows(tx, ` FOR UPDATE`)
+	err = tx.Get(&job, query, id, models.ClusterJobProvision, models.ClusterJobQueued, models.ClusterJobRunning)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		err := errors.New(models.ErrorClusterJobNotRunning)
+		logger.Error(err)
+		return nil, err
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	job.Updated = time.Now()
+	if job.Status == models.ClusterJobQueued {
+		job.Status = models.ClusterJobCancelled
+		err = dao.cancelRequestedCluster(tx, id, requestId)
+	} else {
+		job.Cancelled = true
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE cluster_jobs SET status = $1, cancelled = $2, updated = $3 WHERE id = $4`, job.Status, job.Cancelled, job.Updated, job.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	logger.Info(fmt.Sprintf("cancelled job '%v' to %v cluster '%v'", job.Id, job.Operation, id))
+
+	return &job, nil
+}
+
+// Fail a cluster whose provisioning was cancelled before it started, as
+// nothing was provisioned. A cluster which has moved on is left as it is.
+func (dao *ClusterDao) cancelRequestedCluster(tx *sqlx.Tx, id string, requestId string) error {
+	result, err := tx.Exec(`UPDATE clusters SET status = $1, message = $2 WHERE id = $3 AND status = $4`, models.ClusterStatusProvisionFailedRollbackSuccess, models.ErrorProvisionCancelled, id, models.ClusterStatusRequested)
+	if err != nil {
+		return err
+	}
+
+	if cancelled, err := result.RowsAffected(); err != nil || cancelled == 0 {
+		return err
+	}
+
+	err = recordClusterEvent(tx, id, models.ClusterHistoryStatus, models.ClusterStatusProvisionFailedRollbackSuccess, models.ErrorProvisionCancelled, "", requestId)
+	if err != nil {
+		return err
+	}
+
+	return dao.enqueueStatusWebhooks(tx, id, models.ClusterStatusProvisionFailedRollbackSuccess)
+}
+
+// Final status of a job for its history, with the message explaining it
+func jobOutcome(job *models.ClusterJob) string {
+	if len(job.Message) == 0 {
+		return job.Status
+	}
+	return job.Status + ": " + job.Message
+}
+
+// Record the final status of a job and a message explaining it. Errors
+// when the job is no longer claimed by its worker, leaving the status it
+// was abandoned with.
+func (dao *ClusterDao) FinishClusterJob(job *models.ClusterJob, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "finish_cluster_job", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	job.Updated = time.Now()
+	query := `UPDATE cluster_jobs SET status = $1, message = $2, updated = $3 WHERE id = $4 AND status = $5 AND worker = $6`
+	result, err := tx.Exec(query, job.Status, job.Message, job.Updated, job.Id, models.ClusterJobRunning, job.Worker)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	if finished, err := result.RowsAffected(); err != nil || finished == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = errors.New(models.ErrorClusterJobClaimLost)
+		}
+		logger.Error(err)
+		return err
+	}
+
+	err = recordClusterEvent(tx, job.ClusterId, models.ClusterHistoryOperationFinished, job.Operation, jobOutcome(job), job.Worker, requestId)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Fail running jobs which have not reported alive since before, returning
+// the abandoned jobs. Their workers have stopped without finishing them.
+func (dao *ClusterDao) AbandonClusterJobs(before time.Time, requestId string) ([]models.ClusterJob, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "abandon_cluster_jobs", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	jobs := []models.ClusterJob{}
+	sql := `UPDATE cluster_jobs SET status = $1, message = $2, updated = $3 WHERE status = $4 AND heartbeat < $5 RETURNING *`
+	err = tx.Select(&jobs, sql, models.ClusterJobFailed, models.ErrorJobAbandoned, time.Now(), models.ClusterJobRunning, before)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	for i := range jobs {
+		err = recordClusterEvent(tx, jobs[i].ClusterId, models.ClusterHistoryOperationFinished, jobs[i].Operation, jobOutcome(&jobs[i]), jobs[i].Worker, requestId)
+		if err != nil {
+			tx.Rollback()
+			logger.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	tx.Commit()
+
+	return jobs, nil
+}
+
+// Clusters with an operation in progress but without a queued or running
+// job to complete it
+func (dao *ClusterDao) GetOrphanedClusters(requestId string) ([]models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_orphaned_clusters", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	args := []interface{}{models.ClusterJobQueued, models.ClusterJobRunning}
+	clusters := []models.Cluster{}
+	sql := `SELECT * FROM clusters WHERE NOT EXISTS (
+		SELECT 1 FROM cluster_jobs WHERE cluster_jobs.cluster_id = clusters.id AND cluster_jobs.status IN ($1, $2)
+	) AND status IN (` + placeholders(&args, models.ClusterJobStatuses) + `)`
+	err = tx.Select(&clusters, sql, args...)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	err = dao.keyring.decryptClusters(clusters)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return clusters, nil
+}
@@ -0,0 +1,292 @@
+package daos_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Job", func() {
+
+	var (
+		dao              *ClusterDao
+		valid_request_id string
+		other_request_id string
+		job              *models.ClusterJob
+		cluster          *models.Cluster
+		err              error
+	)
+
+	newSpec := func() *models.ClusterSpec {
+		return &models.ClusterSpec{
+			TerraformConfig: []byte(`{}`),
+			Timeout:         "10m",
+			Project:         "project_name",
+			Region:          "region_name",
+		}
+	}
+
+	BeforeEach(func() {
+		dao = NewClusterDao(valid_db)
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		other_request_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+
+		_, err = dao.CreateCluster(newSpec(), valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Creating a cluster", func() {
+		It("Should queue a job to provision the cluster", func() {
+			job, err = dao.ClaimClusterJob("worker", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(job.ClusterId).To(Equal(valid_request_id))
+			Expect(job.Operation).To(Equal(models.ClusterJobProvision))
+			Expect(job.Status).To(Equal(models.ClusterJobRunning))
+		})
+	})
+
+	Describe("Enqueuing a job", func() {
+		Context("When the operation is unknown", func() {
+			It("Should error", func() {
+				_, err = dao.EnqueueClusterJob(valid_request_id, "resize", models.ClusterStatusRequested, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobNotAllowed))
+			})
+		})
+
+		Context("When the cluster already has a queued job", func() {
+			It("Should refuse the job", func() {
+				_, err = dao.EnqueueClusterJob(valid_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobActive))
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusRequested))
+			})
+		})
+
+		Context("When the previous job has finished", func() {
+			It("Should queue the job and move the cluster to the status", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				job.Status = models.ClusterJobDone
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+
+				job, err = dao.EnqueueClusterJob(valid_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.Status).To(Equal(models.ClusterJobQueued))
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+		})
+
+		Context("When the cluster is destroyed", func() {
+			It("Should refuse the job", func() {
+				_, err = valid_db.Exec(`UPDATE clusters SET status = $1 WHERE id = $2`, models.ClusterStatusDestroyed, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = dao.EnqueueClusterJob(valid_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobNotAllowed))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			It("Should error", func() {
+				_, err = dao.EnqueueClusterJob(other_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorJobClusterNotFound))
+			})
+		})
+	})
+
+	Describe("Claiming jobs", func() {
+		Context("When every job has been claimed", func() {
+			It("Should not return a job", func() {
+				_, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job).To(BeNil())
+			})
+		})
+
+		Context("When several jobs are queued", func() {
+			It("Should claim the oldest job first", func() {
+				_, err = dao.CreateCluster(newSpec(), other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.ClusterId).To(Equal(valid_request_id))
+
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.ClusterId).To(Equal(other_request_id))
+			})
+		})
+	})
+
+	Describe("Getting the queue position of a cluster", func() {
+		BeforeEach(func() {
+			_, err = dao.CreateCluster(newSpec(), other_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("When jobs are queued ahead of the cluster", func() {
+			It("Should count the queued jobs up to the job of the cluster", func() {
+				cluster, err = dao.GetCluster(other_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.QueuePosition).To(Equal(2))
+			})
+		})
+
+		Context("When the job of the cluster is running", func() {
+			It("Should not return a position", func() {
+				_, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.QueuePosition).To(Equal(0))
+
+				cluster, err = dao.GetCluster(other_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.QueuePosition).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("Abandoning jobs", func() {
+		BeforeEach(func() {
+			job, err = dao.ClaimClusterJob("worker", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("When the job has not been reported alive", func() {
+			It("Should fail the job", func() {
+				abandoned, err := dao.AbandonClusterJobs(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(abandoned).To(HaveLen(1))
+				Expect(abandoned[0].Id).To(Equal(job.Id))
+				Expect(abandoned[0].Status).To(Equal(models.ClusterJobFailed))
+				Expect(abandoned[0].Worker).To(Equal("worker"))
+			})
+		})
+
+		Context("When the job has been reported alive", func() {
+			It("Should leave the job running", func() {
+				err = dao.HeartbeatClusterJob(job, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				abandoned, err := dao.AbandonClusterJobs(time.Now().Add(-time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(abandoned).To(BeEmpty())
+			})
+		})
+
+		Context("When the worker of an abandoned job reports it", func() {
+			BeforeEach(func() {
+				_, err = dao.AbandonClusterJobs(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should refuse the heartbeat", func() {
+				err = dao.HeartbeatClusterJob(job, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobClaimLost))
+			})
+			It("Should refuse to finish the job", func() {
+				job.Status = models.ClusterJobDone
+				err = dao.FinishClusterJob(job, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobClaimLost))
+			})
+		})
+	})
+
+	Describe("Locking clusters", func() {
+		It("Should allow one job per cluster to be queued or running", func() {
+			_, err = valid_db.Exec(`INSERT INTO cluster_jobs (cluster_id, operation, status) VALUES ($1, $2, $3)`, valid_request_id, models.ClusterJobDestroy, models.ClusterJobQueued)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Should wait until the lock is released", func() {
+			unlock, err := dao.LockCluster(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+
+			locked := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				unlock_other, err := dao.LockCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				close(locked)
+				unlock_other()
+			}()
+
+			Consistently(locked, "500ms").ShouldNot(BeClosed())
+			unlock()
+			Eventually(locked, "5s").Should(BeClosed())
+		})
+	})
+
+	Describe("Getting clusters to retry destroying", func() {
+		BeforeEach(func() {
+			job, err = dao.ClaimClusterJob("worker", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			job.Status = models.ClusterJobDone
+			Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+
+			_, err = valid_db.Exec(`UPDATE clusters SET status = $1 WHERE id = $2`, models.ClusterStatusDestroyFailed, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			err = dao.UpdateClusterField(valid_request_id, "next_destroy_attempt", time.Now(), valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("When the next attempt is due", func() {
+			It("Should return the cluster", func() {
+				clusters, err := dao.GetDestroyRetryClusters(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(valid_request_id))
+			})
+		})
+
+		Context("When the next attempt is not due", func() {
+			It("Should not return the cluster", func() {
+				clusters, err := dao.GetDestroyRetryClusters(time.Now().Add(-time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Getting orphaned clusters", func() {
+		Context("When the cluster has a queued job", func() {
+			It("Should not return the cluster", func() {
+				clusters, err := dao.GetOrphanedClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(BeEmpty())
+			})
+		})
+
+		Context("When the job of the cluster was abandoned", func() {
+			It("Should return the cluster", func() {
+				_, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = dao.AbandonClusterJobs(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				clusters, err := dao.GetOrphanedClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(valid_request_id))
+			})
+		})
+	})
+})
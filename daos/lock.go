@@ -0,0 +1,148 @@
+package daos
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// Advisory locks are keyed by a namespace and the hash of a name, so
+// leadership, clusters and migrations never share a lock
+const (
+	lockNamespaceLeader    = 1
+	lockNamespaceCluster   = 2
+	lockNamespaceMigration = 3
+)
+
+// Leadership of a task among the instances sharing the database. Leadership
+// is a session advisory lock held by a dedicated connection, so it passes
+// to another instance once the leader, or its connection, is lost.
+type LeaderLock struct {
+	db    *sqlx.DB
+	name  string
+	mutex sync.Mutex
+	conn  *sql.Conn
+}
+
+func NewLeaderLock(db *sqlx.DB, name string) *LeaderLock {
+	return &LeaderLock{db: db, name: name}
+}
+
+// Whether the instance leads, becoming the leader when no other instance
+// does. Leadership is checked to still be held on every call.
+func (lock *LeaderLock) IsLeader(requestId string) (bool, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "is_leader", "request": requestId})
+
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	ctx := context.Background()
+
+	if lock.conn != nil {
+		_, err := lock.conn.ExecContext(ctx, `SELECT 1`)
+		if err == nil {
+			return true, nil
+		}
+		logger.Warn(fmt.Sprintf("lost leadership of '%v': %v", lock.name, err))
+		lock.conn.Close()
+		lock.conn = nil
+	}
+
+	conn, err := lock.db.Conn(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return false, err
+	}
+
+	acquired := false
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, lockNamespaceLeader, lock.name).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		if err != nil {
+			logger.Error(err.Error())
+		}
+		return false, err
+	}
+
+	lock.conn = conn
+	logger.Info(fmt.Sprintf("became the leader of '%v'", lock.name))
+
+	return true, nil
+}
+
+// Lock the cluster to run terraform against it, waiting while another
+// worker holds the lock, returning the function unlocking it. Postgres
+// locks are held by a dedicated connection until unlocked, or until the
+// connection is lost.
+func (dao *ClusterDao) LockCluster(id string, requestId string) (func(), error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "lock_cluster", "request": requestId})
+
+	if dao.locks != nil {
+		return dao.locks.lock(id), nil
+	}
+
+	ctx := context.Background()
+
+	conn, err := dao.db.Conn(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1, hashtext($2))`, lockNamespaceCluster, id)
+	if err != nil {
+		conn.Close()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	unlock := func() {
+		defer conn.Close()
+
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, lockNamespaceCluster, id)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	return unlock, nil
+}
+
+// Locks held within the process, for backends which are not shared
+// between instances
+type localLocks struct {
+	mutex sync.Mutex
+	held  map[string]chan struct{}
+}
+
+func newLocalLocks() *localLocks {
+	return &localLocks{held: make(map[string]chan struct{})}
+}
+
+// Lock the name, waiting while it is held, returning the function
+// unlocking it
+func (locks *localLocks) lock(name string) func() {
+	for {
+		locks.mutex.Lock()
+		held, exists := locks.held[name]
+		if !exists {
+			released := make(chan struct{})
+			locks.held[name] = released
+			locks.mutex.Unlock()
+
+			return func() {
+				locks.mutex.Lock()
+				delete(locks.held, name)
+				locks.mutex.Unlock()
+				close(released)
+			}
+		}
+		locks.mutex.Unlock()
+
+		<-held
+	}
+}
@@ -0,0 +1,44 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+)
+
+var _ = Describe("Lock", func() {
+
+	var (
+		valid_request_id string
+	)
+
+	BeforeEach(func() {
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+	})
+
+	Describe("Electing a leader", func() {
+		It("Should elect only one instance", func() {
+			first := NewLeaderLock(valid_db, "leader_test")
+			second := NewLeaderLock(valid_db, "leader_test")
+
+			leading, err := first.IsLeader(valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leading).To(BeTrue())
+
+			leading, err = second.IsLeader(valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leading).To(BeFalse())
+
+			leading, err = first.IsLeader(valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leading).To(BeTrue())
+		})
+
+		It("Should elect leaders of other tasks independently", func() {
+			leading, err := NewLeaderLock(valid_db, "other_leader_test").IsLeader(valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leading).To(BeTrue())
+		})
+	})
+})
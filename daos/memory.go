@@ -0,0 +1,1118 @@
+package daos
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kmacoskey/taos/models"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// Clusters stored in memory by a single instance, lost when it stops. Meant
+// for tests and single-user local runs, it behaves as the ClusterDao does.
+// Other resources of such a run are still stored in postgres.
+type MemoryClusterDao struct {
+	mutex      sync.Mutex
+	clusters   map[string]*models.Cluster
+	webhooks   map[string][]string
+	keys       map[idempotencyKeyId]models.IdempotencyKey
+	jobs       []*models.ClusterJob
+	logs       []models.ClusterLog
+	events     []models.ClusterHistoryEvent
+	deliveries []*models.WebhookDelivery
+	attempts   []models.WebhookAttempt
+	locks      *localLocks
+
+	// URLs every status change of a cluster is delivered to
+	statusWebhooks []string
+}
+
+// Idempotency keys are scoped to the owner using them
+type idempotencyKeyId struct {
+	owner string
+	key   string
+}
+
+func NewMemoryClusterDao() *MemoryClusterDao {
+	return &MemoryClusterDao{
+		clusters: make(map[string]*models.Cluster),
+		webhooks: make(map[string][]string),
+		keys:     make(map[idempotencyKeyId]models.IdempotencyKey),
+		locks:    newLocalLocks(),
+	}
+}
+
+// A copy of the cluster, so the stored cluster only changes through the dao
+func copyCluster(cluster *models.Cluster) models.Cluster {
+	copied := *cluster
+	copied.ExpirationWarnings = append(pq.StringArray{}, cluster.ExpirationWarnings...)
+	return copied
+}
+
+// Stored clusters matching the condition, oldest first
+func (dao *MemoryClusterDao) selectClusters(matches func(*models.Cluster) bool) []models.Cluster {
+	clusters := []models.Cluster{}
+	for _, cluster := range dao.clusters {
+		if matches(cluster) {
+			clusters = append(clusters, copyCluster(cluster))
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Timestamp.Equal(clusters[j].Timestamp) {
+			return clusters[i].Id < clusters[j].Id
+		}
+		return clusters[i].Timestamp.Before(clusters[j].Timestamp)
+	})
+
+	return clusters
+}
+
+func (dao *MemoryClusterDao) recordClusterEvent(id string, kind string, name string, message string, actor string, requestId string) {
+	dao.events = append(dao.events, models.ClusterHistoryEvent{
+		Id:        int64(len(dao.events) + 1),
+		ClusterId: id,
+		Kind:      kind,
+		Name:      name,
+		Message:   message,
+		RequestId: requestId,
+		Actor:     actor,
+		Timestamp: time.Now(),
+	})
+}
+
+func (dao *MemoryClusterDao) CreateCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_cluster", "request": requestId})
+
+	cluster, err := newCluster(spec, requestId)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	err = checkQuotas(spec.Quotas, cluster, dao.quotaUsage)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if _, exists := dao.clusters[cluster.Id]; exists {
+		err := fmt.Errorf("cluster '%v' already exists", cluster.Id)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(spec.IdempotencyKey) > 0 {
+		id := idempotencyKeyId{spec.Owner, spec.IdempotencyKey}
+		if _, claimed := dao.keys[id]; claimed {
+			err := errors.New(models.ErrorIdempotencyKeyInUse)
+			logger.Error(err)
+			return nil, err
+		}
+		dao.keys[id] = models.IdempotencyKey{Owner: spec.Owner, Key: spec.IdempotencyKey, RequestHash: spec.RequestHash, ClusterId: cluster.Id, Timestamp: cluster.Timestamp}
+	}
+
+	stored := copyCluster(cluster)
+	dao.clusters[cluster.Id] = &stored
+	dao.webhooks[cluster.Id] = append([]string{}, spec.Webhooks...)
+
+	dao.recordClusterEvent(cluster.Id, models.ClusterHistoryAction, models.ClusterActionCreate, "", cluster.Owner, requestId)
+	dao.recordClusterEvent(cluster.Id, models.ClusterHistoryStatus, cluster.Status, "", cluster.Owner, requestId)
+
+	dao.enqueueClusterJob(cluster.Id, models.ClusterJobProvision, requestId)
+
+	return cluster, nil
+}
+
+func (dao *MemoryClusterDao) GetCluster(id string, requestId string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_cluster", "request": requestId})
+
+	if len(requestId) == 0 {
+		err := errors.New(models.ErrorMissingRequestId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	stored, exists := dao.clusters[id]
+	if !exists {
+		logger.Error(sql.ErrNoRows.Error())
+		return nil, sql.ErrNoRows
+	}
+
+	cluster := copyCluster(stored)
+	cluster.QueuePosition = dao.queuePosition(id)
+
+	return &cluster, nil
+}
+
+// Queued jobs up to and including the first queued job of the cluster, 0
+// without a queued job
+func (dao *MemoryClusterDao) queuePosition(id string) int {
+	position := 0
+	for _, job := range dao.jobs {
+		if job.Status == models.ClusterJobQueued {
+			position++
+			if job.ClusterId == id {
+				return position
+			}
+		}
+	}
+	return 0
+}
+
+// Retrieve a page of clusters matching the filter, ordered as requested.
+// The returned cursor retrieves the following page and is empty on the last page.
+func (dao *MemoryClusterDao) GetClusters(filter *models.ClusterFilter, requestId string) ([]models.Cluster, string, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_clusters", "request": requestId})
+
+	if len(requestId) == 0 {
+		err := errors.New(models.ErrorMissingRequestId)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	if filter == nil {
+		filter = &models.ClusterFilter{}
+	}
+
+	if !models.ValidClusterSort(filter.Sort) {
+		err := errors.New(models.ErrorInvalidClusterSort)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = models.DefaultClusterPageSize
+	}
+	if limit < 0 || limit > models.MaxClusterPageSize {
+		err := errors.New(models.ErrorInvalidClusterLimit)
+		logger.Error(err)
+		return nil, "", err
+	}
+
+	sort_key, descending := models.ParseClusterSort(filter.Sort)
+
+	var cursor *clusterCursor
+	if len(filter.Cursor) > 0 {
+		var err error
+		cursor, err = decodeClusterCursor(filter.Cursor, sort_key)
+		if err != nil {
+			logger.Error(err)
+			return nil, "", err
+		}
+	}
+
+	// Negative when the cluster sorts before the position, positive after
+	compare := func(cluster *models.Cluster, value interface{}, id string) int {
+		order := 0
+		switch sort_key {
+		case models.ClusterSortCreated:
+			order = compareTimes(cluster.Timestamp, value.(time.Time))
+		case models.ClusterSortExpiration:
+			order = compareTimes(cluster.Expiration, value.(time.Time))
+		case models.ClusterSortName:
+			order = strings.Compare(cluster.Name, value.(string))
+		}
+		if order == 0 {
+			order = strings.Compare(cluster.Id, id)
+		}
+		if descending {
+			order = -order
+		}
+		return order
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	clusters := dao.selectClusters(func(cluster *models.Cluster) bool {
+		if len(filter.Statuses) > 0 && !containsString(filter.Statuses, cluster.Status) {
+			return false
+		}
+		if len(filter.Owner) > 0 && cluster.Owner != filter.Owner {
+			return false
+		}
+		if len(filter.Project) > 0 && cluster.Project != filter.Project {
+			return false
+		}
+		if len(filter.Region) > 0 && cluster.Region != filter.Region {
+			return false
+		}
+		if !strings.HasPrefix(cluster.Name, filter.NamePrefix) {
+			return false
+		}
+		if !filter.CreatedBefore.IsZero() && !cluster.Timestamp.Before(filter.CreatedBefore) {
+			return false
+		}
+		if !filter.CreatedAfter.IsZero() && !cluster.Timestamp.After(filter.CreatedAfter) {
+			return false
+		}
+		if !filter.ExpiresBefore.IsZero() && !cluster.Expiration.Before(filter.ExpiresBefore) {
+			return false
+		}
+		if !filter.ExpiresAfter.IsZero() && !cluster.Expiration.After(filter.ExpiresAfter) {
+			return false
+		}
+		return cursor == nil || compare(cluster, cursor.value, cursor.Id) > 0
+	})
+
+	sort.Slice(clusters, func(i, j int) bool {
+		position := encodeClusterCursor(clusters[j], sort_key)
+		decoded, _ := decodeClusterCursor(position, sort_key)
+		return compare(&clusters[i], decoded.value, decoded.Id) < 0
+	})
+
+	next := ""
+	if len(clusters) > limit {
+		clusters = clusters[:limit]
+		next = encodeClusterCursor(clusters[limit-1], sort_key)
+	}
+
+	for i := range clusters {
+		clusters[i].QueuePosition = dao.queuePosition(clusters[i].Id)
+	}
+
+	return clusters, next, nil
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	}
+	if a.After(b) {
+		return 1
+	}
+	return 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (dao *MemoryClusterDao) GetExpiredClusters(requestId string) ([]models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_expired_clusters", "request": requestId})
+
+	if len(requestId) == 0 {
+		err := errors.New(models.ErrorMissingRequestId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	// Failed destroys are retried with backoff rather than reaped again
+	reaped := []string{models.ClusterStatusDestroyed, models.ClusterStatusDestroying, models.ClusterStatusDestroyFailed, models.ClusterStatusDestroyRetriesExhausted}
+	now := time.Now()
+
+	return dao.selectClusters(func(cluster *models.Cluster) bool {
+		return cluster.Expiration.Before(now) && !containsString(reaped, cluster.Status)
+	}), nil
+}
+
+// Move the cluster from the status it was read with to another status. The
+// status is only changed when the transition is allowed and the cluster is
+// still in the status it was read with.
+func (dao *MemoryClusterDao) UpdateClusterStatus(id string, from string, to string, requestId string) error {
+	return dao.updateClusterStatus(nil, id, from, to, requestId)
+}
+
+// Move the cluster of the job like UpdateClusterStatus, only while the job
+// is still claimed by its worker
+func (dao *MemoryClusterDao) UpdateJobClusterStatus(job *models.ClusterJob, from string, to string, requestId string) error {
+	return dao.updateClusterStatus(job, job.ClusterId, from, to, requestId)
+}
+
+func (dao *MemoryClusterDao) updateClusterStatus(job *models.ClusterJob, id string, from string, to string, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_status", "request": requestId})
+
+	if !models.ClusterStatusTransitionAllowed(from, to) {
+		err := errors.New(models.ErrorInvalidStatusTransition)
+		logger.Error(fmt.Sprintf("cluster '%v' cannot move from '%v' to '%v'", id, from, to))
+		return err
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	if job != nil && dao.claimedJob(job) == nil {
+		err := errors.New(models.ErrorClusterJobClaimLost)
+		logger.Error(err)
+		return err
+	}
+
+	cluster, exists := dao.clusters[id]
+	if !exists || cluster.Status != from {
+		logger.Error(fmt.Sprintf("cluster '%v' is no longer '%v', not moving it to '%v'", id, from, to))
+		return errors.New(models.ErrorClusterStatusChanged)
+	}
+
+	err := dao.enqueueStatusWebhooks(cluster, to)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	cluster.Status = to
+	dao.recordClusterEvent(id, models.ClusterHistoryStatus, to, "", "", requestId)
+
+	return nil
+}
+
+// Live clusters which have not yet expired but will have by before
+func (dao *MemoryClusterDao) GetExpiringClusters(before time.Time, requestId string) ([]models.Cluster, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	now := time.Now()
+	clusters := dao.selectClusters(func(cluster *models.Cluster) bool {
+		return cluster.Status == models.ClusterStatusProvisionSuccess && cluster.Expiration.After(now) && !cluster.Expiration.After(before)
+	})
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].Expiration.Before(clusters[j].Expiration)
+	})
+
+	return clusters, nil
+}
+
+// Record the expiration warnings sent for the cluster. Nothing is recorded
+// when the expiration has changed since the cluster was read.
+func (dao *MemoryClusterDao) RecordExpirationWarnings(cluster *models.Cluster, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	stored, exists := dao.clusters[cluster.Id]
+	if exists && stored.Expiration.Equal(cluster.Expiration) {
+		stored.ExpirationWarnings = append(pq.StringArray{}, cluster.ExpirationWarnings...)
+	}
+
+	return nil
+}
+
+// Clusters which failed to be destroyed and are due to be retried by
+// before, without a queued or running job
+func (dao *MemoryClusterDao) GetDestroyRetryClusters(before time.Time, requestId string) ([]models.Cluster, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	return dao.selectClusters(func(cluster *models.Cluster) bool {
+		return cluster.Status == models.ClusterStatusDestroyFailed && !cluster.NextDestroyAttempt.After(before) && dao.activeJob(cluster.Id) == nil
+	}), nil
+}
+
+func (dao *MemoryClusterDao) UpdateClusterField(id string, field string, value interface{}, requestId string) error {
+	return dao.updateClusterField(nil, id, field, value, requestId)
+}
+
+// Change a field of the cluster of the job like UpdateClusterField, only
+// while the job is still claimed by its worker
+func (dao *MemoryClusterDao) UpdateJobClusterField(job *models.ClusterJob, field string, value interface{}, requestId string) error {
+	return dao.updateClusterField(job, job.ClusterId, field, value, requestId)
+}
+
+func (dao *MemoryClusterDao) updateClusterField(job *models.ClusterJob, id string, field string, value interface{}, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_status", "request": requestId})
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	if job != nil && dao.claimedJob(job) == nil {
+		err := errors.New(models.ErrorClusterJobClaimLost)
+		logger.Error(err)
+		return err
+	}
+
+	switch field {
+	case "status":
+		return errors.New("cannot update status field, use UpdateClusterStatus")
+	case "timestamp":
+		return errors.New("cannot update timestamp field")
+	}
+
+	cluster, exists := dao.clusters[id]
+	if !exists {
+		return errors.New("no clusters updated")
+	}
+
+	ok := true
+	switch field {
+	case "message":
+		cluster.Message, ok = value.(string)
+	case "outputs":
+		cluster.Outputs, ok = value.([]byte)
+	case "terraform_config":
+		cluster.TerraformConfig, ok = value.([]byte)
+	case "terraform_state":
+		cluster.TerraformState, ok = value.([]byte)
+	case "timeout":
+		cluster.Timeout, ok = value.(string)
+	case "expiration":
+		// Warnings sent ahead of the previous expiration are sent again
+		cluster.Expiration, ok = value.(time.Time)
+		cluster.ExpirationWarnings = pq.StringArray{}
+	case "project":
+		cluster.Project, ok = value.(string)
+	case "region":
+		cluster.Region, ok = value.(string)
+	case "destroy_attempts":
+		cluster.DestroyAttempts, ok = value.(int)
+	case "next_destroy_attempt":
+		cluster.NextDestroyAttempt, ok = value.(time.Time)
+	default:
+		return errors.New(fmt.Sprintf("field '%s' does not exist", field))
+	}
+
+	if !ok {
+		err := fmt.Errorf("invalid value of type %T for field '%s'", value, field)
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Change when the cluster expires, refusing to extend it past its quotas
+func (dao *MemoryClusterDao) UpdateClusterExpiration(id string, expiration time.Time, quotas []models.Quota, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_expiration", "request": requestId})
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	cluster, exists := dao.clusters[id]
+	if !exists {
+		return errors.New("no clusters updated")
+	}
+
+	err := checkQuotaExtension(quotas, cluster, expiration, dao.quotaUsage)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	// Warnings sent ahead of the previous expiration are sent again
+	cluster.Expiration = expiration
+	cluster.ExpirationWarnings = pq.StringArray{}
+
+	return nil
+}
+
+// URLs registered with the cluster when it was requested
+func (dao *MemoryClusterDao) GetClusterWebhooks(clusterId string, requestId string) ([]string, error) {
+	if len(clusterId) == 0 {
+		return nil, errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	return append([]string{}, dao.webhooks[clusterId]...), nil
+}
+
+// Deliver the status changes of every cluster to the urls, as well as to
+// the webhooks registered with each cluster
+func (dao *MemoryClusterDao) WithWebhooks(urls []string) *MemoryClusterDao {
+	dao.statusWebhooks = urls
+	return dao
+}
+
+// Enqueue deliveries of the cluster moving to status, while the cluster is
+// locked by the change
+func (dao *MemoryClusterDao) enqueueStatusWebhooks(cluster *models.Cluster, status string) error {
+	urls := append(append([]string{}, dao.statusWebhooks...), dao.webhooks[cluster.Id]...)
+
+	deliveries, err := statusWebhookDeliveries(urls, cluster, status)
+	if err != nil {
+		return err
+	}
+
+	dao.createWebhookDeliveries(deliveries)
+
+	return nil
+}
+
+// Persist pending deliveries, all of which are due immediately
+func (dao *MemoryClusterDao) CreateWebhookDeliveries(deliveries []models.WebhookDelivery, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	dao.createWebhookDeliveries(deliveries)
+
+	return nil
+}
+
+func (dao *MemoryClusterDao) createWebhookDeliveries(deliveries []models.WebhookDelivery) {
+	now := time.Now()
+	for _, delivery := range deliveries {
+		delivery := delivery
+		delivery.Id = int64(len(dao.deliveries) + 1)
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttempt = now
+		delivery.Timestamp = now
+		delivery.History = nil
+		dao.deliveries = append(dao.deliveries, &delivery)
+	}
+}
+
+// Claim the oldest pending delivery due by due, deferring its next attempt
+// until until
+func (dao *MemoryClusterDao) ClaimWebhookDelivery(due time.Time, until time.Time, requestId string) (*models.WebhookDelivery, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	for _, delivery := range dao.deliveries {
+		if delivery.Status == models.WebhookDeliveryPending && !delivery.NextAttempt.After(due) {
+			delivery.NextAttempt = until
+			claimed := *delivery
+			return &claimed, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Record an attempt to deliver and the resulting state of the delivery
+func (dao *MemoryClusterDao) RecordWebhookAttempt(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	if delivery.Id < 1 || delivery.Id > int64(len(dao.deliveries)) {
+		return errors.New("no webhook deliveries updated")
+	}
+
+	stored := dao.deliveries[delivery.Id-1]
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttempt = delivery.NextAttempt
+	dao.attempts = append(dao.attempts, *attempt)
+
+	return nil
+}
+
+// The most recent deliveries, of a single cluster when clusterId is not
+// empty, each with the history of its attempts
+func (dao *MemoryClusterDao) GetWebhookDeliveries(clusterId string, limit int, requestId string) ([]models.WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = models.DefaultWebhookDeliveryLimit
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for i := len(dao.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := *dao.deliveries[i]
+		if len(clusterId) > 0 && delivery.ClusterId != clusterId {
+			continue
+		}
+
+		delivery.History = []models.WebhookAttempt{}
+		for _, attempt := range dao.attempts {
+			if attempt.DeliveryId == delivery.Id {
+				delivery.History = append(delivery.History, attempt)
+			}
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// Append an event to the history of a cluster
+func (dao *MemoryClusterDao) CreateClusterHistoryEvent(event *models.ClusterHistoryEvent, requestId string) error {
+	if len(event.ClusterId) == 0 {
+		return errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	dao.recordClusterEvent(event.ClusterId, event.Kind, event.Name, event.Message, event.Actor, event.RequestId)
+
+	return nil
+}
+
+// Every event in the history of a cluster, oldest first
+func (dao *MemoryClusterDao) GetClusterHistory(id string, requestId string) ([]models.ClusterHistoryEvent, error) {
+	if len(id) == 0 {
+		return nil, errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	events := []models.ClusterHistoryEvent{}
+	for _, event := range dao.events {
+		if event.ClusterId == id {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// Append lines of terraform output to the log of a cluster operation
+func (dao *MemoryClusterDao) AppendClusterLog(id string, operation string, lines []string, requestId string) error {
+	if len(id) == 0 {
+		return errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	now := time.Now()
+	for _, line := range lines {
+		dao.logs = append(dao.logs, models.ClusterLog{
+			Id:        int64(len(dao.logs) + 1),
+			ClusterId: id,
+			Operation: operation,
+			Line:      line,
+			Timestamp: now,
+		})
+	}
+
+	return nil
+}
+
+// Lines of the log of a cluster following the line with the id after, of
+// every operation when operation is empty, oldest first
+func (dao *MemoryClusterDao) GetClusterLogs(id string, operation string, after int64, requestId string) ([]models.ClusterLog, error) {
+	if len(id) == 0 {
+		return nil, errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	logs := []models.ClusterLog{}
+	for _, line := range dao.logs {
+		if len(logs) == models.MaxClusterLogLines {
+			break
+		}
+		if line.ClusterId == id && (len(operation) == 0 || line.Operation == operation) && line.Id > after {
+			logs = append(logs, line)
+		}
+	}
+
+	return logs, nil
+}
+
+// The idempotency key the owner used with the given key, or nil when there
+// is none
+func (dao *MemoryClusterDao) GetIdempotencyKey(owner string, key string, requestId string) (*models.IdempotencyKey, error) {
+	if len(key) == 0 {
+		return nil, errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	idempotency_key, exists := dao.keys[idempotencyKeyId{owner, key}]
+	if !exists {
+		return nil, nil
+	}
+
+	return &idempotency_key, nil
+}
+
+// Forget idempotency keys used before the given time
+func (dao *MemoryClusterDao) DeleteIdempotencyKeys(before time.Time, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	for key, idempotency_key := range dao.keys {
+		if idempotency_key.Timestamp.Before(before) {
+			delete(dao.keys, key)
+		}
+	}
+
+	return nil
+}
+
+// Usage of the quota by the clusters currently stored
+func (dao *MemoryClusterDao) GetQuotaUsage(quota models.Quota, requestId string) (*models.QuotaUsage, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	return dao.quotaUsage(quota)
+}
+
+func (dao *MemoryClusterDao) quotaUsage(quota models.Quota) (*models.QuotaUsage, error) {
+	if _, exists := quotaColumns[quota.Scope]; !exists {
+		return nil, errors.New(models.ErrorInvalidQuotaScope)
+	}
+
+	usage := models.QuotaUsage{
+		Scope:           quota.Scope,
+		Name:            quota.Name,
+		MaxClusters:     quota.MaxClusters,
+		MaxClusterHours: quota.MaxClusterHours,
+		MaxTimeout:      quota.MaxTimeout,
+	}
+
+	// Cluster hours are the lifetimes requested, including extensions, of
+	//  the clusters created within the quota window
+	window := time.Now().Add(-models.QuotaWindow)
+	for _, cluster := range dao.clusters {
+		scope := cluster.Project
+		if quota.Scope == models.QuotaScopeOwner {
+			scope = cluster.Owner
+		}
+		if scope != quota.Name {
+			continue
+		}
+
+		if !containsString(models.ReleasedClusterStatuses, cluster.Status) {
+			usage.LiveClusters++
+		}
+		if cluster.Timestamp.After(window) {
+			usage.ClusterHours += cluster.Expiration.Sub(cluster.Timestamp).Hours()
+		}
+	}
+
+	return &usage, nil
+}
+
+// Clusters are never stored at rest, there is nothing to rekey
+func (dao *MemoryClusterDao) RekeyClusters(requestId string) (int, error) {
+	return 0, nil
+}
+
+func (dao *MemoryClusterDao) LockClusterState(id string, lock *models.StateLock, requestId string) (*models.StateLock, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	cluster, current, err := dao.stateLock(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateLockHeld(current, lock.ID) {
+		return current, errors.New(models.ErrorClusterStateLocked)
+	}
+
+	value, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	cluster.StateLock = string(value)
+
+	return lock, nil
+}
+
+func (dao *MemoryClusterDao) UnlockClusterState(id string, lockId string, requestId string) (*models.StateLock, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	cluster, current, err := dao.stateLock(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateLockHeld(current, lockId) {
+		return current, errors.New(models.ErrorClusterStateLocked)
+	}
+	cluster.StateLock = ""
+
+	return nil, nil
+}
+
+func (dao *MemoryClusterDao) UpdateClusterState(id string, lockId string, state []byte, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	cluster, current, err := dao.stateLock(id)
+	if err != nil {
+		return err
+	}
+
+	if stateLockHeld(current, lockId) {
+		return errors.New(models.ErrorClusterStateLocked)
+	}
+	cluster.TerraformState = state
+
+	return nil
+}
+
+// The cluster and the lock of its terraform state
+func (dao *MemoryClusterDao) stateLock(id string) (*models.Cluster, *models.StateLock, error) {
+	cluster, exists := dao.clusters[id]
+	if !exists {
+		return nil, nil, errors.New(models.ErrorClusterNotFound)
+	}
+
+	lock, err := models.ParseStateLock(cluster.StateLock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cluster, lock, nil
+}
+
+// The queued or running job of the cluster, nil when there is none
+func (dao *MemoryClusterDao) activeJob(id string) *models.ClusterJob {
+	for _, job := range dao.jobs {
+		if job.ClusterId == id && (job.Status == models.ClusterJobQueued || job.Status == models.ClusterJobRunning) {
+			return job
+		}
+	}
+	return nil
+}
+
+func (dao *MemoryClusterDao) enqueueClusterJob(id string, operation string, requestId string) *models.ClusterJob {
+	now := time.Now()
+	job := &models.ClusterJob{
+		Id:        int64(len(dao.jobs) + 1),
+		ClusterId: id,
+		Operation: operation,
+		Status:    models.ClusterJobQueued,
+		RequestId: requestId,
+		Timestamp: now,
+		Updated:   now,
+		Heartbeat: now,
+	}
+	dao.jobs = append(dao.jobs, job)
+
+	return job
+}
+
+// Queue a terraform operation on a cluster, moving the cluster to status
+func (dao *MemoryClusterDao) EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "enqueue_cluster_job", "request": requestId})
+
+	if len(id) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	cluster, exists := dao.clusters[id]
+	if !exists {
+		err := errors.New(models.ErrorJobClusterNotFound)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if !models.ClusterJobAllowed(operation, cluster.Status) || !models.ClusterStatusTransitionAllowed(cluster.Status, status) {
+		err := errors.New(models.ErrorClusterJobNotAllowed)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if dao.activeJob(id) != nil {
+		err := errors.New(models.ErrorClusterJobActive)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if cluster.Status != status {
+		if err := dao.enqueueStatusWebhooks(cluster, status); err != nil {
+			logger.Error(err.Error())
+			return nil, err
+		}
+		cluster.Status = status
+		dao.recordClusterEvent(id, models.ClusterHistoryStatus, status, "", "", requestId)
+	}
+
+	job := *dao.enqueueClusterJob(id, operation, requestId)
+
+	logger.Info(fmt.Sprintf("queued job '%v' to %v cluster '%v'", job.Id, operation, id))
+
+	return &job, nil
+}
+
+// Claim the oldest queued job for the worker, marking it running. Returns
+// nil when no job is queued.
+func (dao *MemoryClusterDao) ClaimClusterJob(worker string, requestId string) (*models.ClusterJob, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	for _, job := range dao.jobs {
+		if job.Status != models.ClusterJobQueued {
+			continue
+		}
+
+		now := time.Now()
+		job.Status = models.ClusterJobRunning
+		job.Worker = worker
+		job.Updated = now
+		job.Heartbeat = now
+		dao.recordClusterEvent(job.ClusterId, models.ClusterHistoryOperationStarted, job.Operation, "", worker, requestId)
+
+		claimed := *job
+		return &claimed, nil
+	}
+
+	return nil, nil
+}
+
+// The running job claimed by the worker of job, nil when the claim is lost
+func (dao *MemoryClusterDao) claimedJob(job *models.ClusterJob) *models.ClusterJob {
+	for _, claimed := range dao.jobs {
+		if claimed.Id == job.Id && claimed.Status == models.ClusterJobRunning && claimed.Worker == job.Worker {
+			return claimed
+		}
+	}
+	return nil
+}
+
+// The job with the id, nil when there is none
+func (dao *MemoryClusterDao) GetClusterJob(id int64, requestId string) (*models.ClusterJob, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	for _, job := range dao.jobs {
+		if job.Id == id {
+			found := *job
+			return &found, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Report a running job as alive, refreshing whether cancelling the job has
+// been requested since. Errors when the job is no longer claimed by its
+// worker.
+func (dao *MemoryClusterDao) HeartbeatClusterJob(job *models.ClusterJob, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	claimed := dao.claimedJob(job)
+	if claimed == nil {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+
+	job.Heartbeat = time.Now()
+	claimed.Heartbeat = job.Heartbeat
+	job.Cancelled = claimed.Cancelled
+
+	return nil
+}
+
+// Cancel the provisioning job of a cluster. A queued job is cancelled
+// before it runs and the cluster is failed, a running job is only flagged.
+// Returns the job as it was cancelled.
+func (dao *MemoryClusterDao) CancelClusterJob(id string, requestId string) (*models.ClusterJob, error) {
+	if len(id) == 0 {
+		return nil, errors.New(models.ErrorMissingId)
+	}
+
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	job := dao.activeJob(id)
+	if job == nil || job.Operation != models.ClusterJobProvision {
+		return nil, errors.New(models.ErrorClusterJobNotRunning)
+	}
+
+	job.Updated = time.Now()
+	if job.Status == models.ClusterJobQueued {
+		job.Status = models.ClusterJobCancelled
+
+		// Nothing was provisioned, a cluster which has moved on is left as it is
+		cluster := dao.clusters[id]
+		if cluster.Status == models.ClusterStatusRequested {
+			cluster.Status = models.ClusterStatusProvisionFailedRollbackSuccess
+			cluster.Message = models.ErrorProvisionCancelled
+			dao.recordClusterEvent(id, models.ClusterHistoryStatus, cluster.Status, cluster.Message, "", requestId)
+			if err := dao.enqueueStatusWebhooks(cluster, cluster.Status); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		job.Cancelled = true
+	}
+
+	cancelled := *job
+	return &cancelled, nil
+}
+
+// Record the final status of a job and a message explaining it. Errors
+// when the job is no longer claimed by its worker.
+func (dao *MemoryClusterDao) FinishClusterJob(job *models.ClusterJob, requestId string) error {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	claimed := dao.claimedJob(job)
+	if claimed == nil {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+
+	job.Updated = time.Now()
+	claimed.Status = job.Status
+	claimed.Message = job.Message
+	claimed.Updated = job.Updated
+	dao.recordClusterEvent(job.ClusterId, models.ClusterHistoryOperationFinished, job.Operation, jobOutcome(job), job.Worker, requestId)
+
+	return nil
+}
+
+// Fail running jobs which have not reported alive since before, returning
+// the abandoned jobs
+func (dao *MemoryClusterDao) AbandonClusterJobs(before time.Time, requestId string) ([]models.ClusterJob, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	abandoned := []models.ClusterJob{}
+	for _, job := range dao.jobs {
+		if job.Status != models.ClusterJobRunning || !job.Heartbeat.Before(before) {
+			continue
+		}
+
+		job.Status = models.ClusterJobFailed
+		job.Message = models.ErrorJobAbandoned
+		job.Updated = time.Now()
+		dao.recordClusterEvent(job.ClusterId, models.ClusterHistoryOperationFinished, job.Operation, jobOutcome(job), job.Worker, requestId)
+
+		abandoned = append(abandoned, *job)
+	}
+
+	return abandoned, nil
+}
+
+// Clusters with an operation in progress but without a queued or running
+// job to complete it
+func (dao *MemoryClusterDao) GetOrphanedClusters(requestId string) ([]models.Cluster, error) {
+	dao.mutex.Lock()
+	defer dao.mutex.Unlock()
+
+	return dao.selectClusters(func(cluster *models.Cluster) bool {
+		return containsString(models.ClusterJobStatuses, cluster.Status) && dao.activeJob(cluster.Id) == nil
+	}), nil
+}
+
+// Lock the cluster to run terraform against it, waiting while another
+// worker holds the lock, returning the function unlocking it
+func (dao *MemoryClusterDao) LockCluster(id string, requestId string) (func(), error) {
+	return dao.locks.lock(id), nil
+}
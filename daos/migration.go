@@ -0,0 +1,140 @@
+package daos
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type MigrationDao struct{}
+
+func NewMigrationDao() *MigrationDao {
+	return &MigrationDao{}
+}
+
+// Lock migrations, waiting while another instance migrates. The lock is
+// held by the returned connection until it is unlocked, or until the
+// connection is lost.
+func (dao *MigrationDao) LockMigrations(db *sqlx.DB, requestId string) (*sql.Conn, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "lock_migrations", "request": requestId})
+
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1, hashtext($2))`, lockNamespaceMigration, "migrations")
+	if err != nil {
+		conn.Close()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Unlock migrations locked with the connection and release the connection
+func (dao *MigrationDao) UnlockMigrations(conn *sql.Conn, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "unlock_migrations", "request": requestId})
+
+	defer conn.Close()
+
+	_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, lockNamespaceMigration, "migrations")
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Migrations applied to the database, oldest first. The table tracking them
+// is created when it does not exist yet.
+func (dao *MigrationDao) GetAppliedMigrations(db *sqlx.DB, requestId string) ([]models.AppliedMigration, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_applied_migrations", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version          integer PRIMARY KEY,
+		name             text,
+		applied          timestamp
+	)`)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	applied := []models.AppliedMigration{}
+	err = tx.Select(&applied, `SELECT * FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return applied, nil
+}
+
+// Apply a migration and record it as applied, or neither
+func (dao *MigrationDao) ApplyMigration(db *sqlx.DB, migration models.Migration, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "apply_migration", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	_, err = tx.Exec(migration.Up)
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied) VALUES ($1, $2, now())`, migration.Version, migration.Name)
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// Revert a migration and no longer record it as applied, or neither
+func (dao *MigrationDao) RevertMigration(db *sqlx.DB, migration models.Migration, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "revert_migration", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	_, err = tx.Exec(migration.Down)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
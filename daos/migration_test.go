@@ -0,0 +1,91 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Migration", func() {
+
+	var (
+		dao              *MigrationDao
+		valid_request_id string
+		migration        models.Migration
+	)
+
+	BeforeEach(func() {
+		dao = NewMigrationDao()
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		migration = models.Migration{
+			Version: 1,
+			Name:    "migration_test",
+			Up:      `CREATE TABLE migration_test (id integer)`,
+			Down:    `DROP TABLE migration_test`,
+		}
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(`DROP TABLE IF EXISTS migration_test, schema_migrations`)
+	})
+
+	Describe("Applying a migration", func() {
+		It("Should run the migration and record it as applied", func() {
+			applied, err := dao.GetAppliedMigrations(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeEmpty())
+
+			Expect(dao.ApplyMigration(valid_db, migration, valid_request_id)).To(Succeed())
+
+			applied, err = dao.GetAppliedMigrations(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+			Expect(applied[0].Version).To(Equal(1))
+			Expect(applied[0].Name).To(Equal("migration_test"))
+
+			valid_db.MustExec(`SELECT * FROM migration_test`)
+		})
+
+		Context("When the migration fails", func() {
+			It("Should not record it as applied", func() {
+				_, err := dao.GetAppliedMigrations(valid_db, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				migration.Up = `CREATE TABLE migration_test (id integer); SELECT * FROM missing_table`
+				Expect(dao.ApplyMigration(valid_db, migration, valid_request_id)).To(HaveOccurred())
+
+				applied, err := dao.GetAppliedMigrations(valid_db, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(applied).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Reverting a migration", func() {
+		It("Should revert the migration and no longer record it as applied", func() {
+			_, err := dao.GetAppliedMigrations(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dao.ApplyMigration(valid_db, migration, valid_request_id)).To(Succeed())
+
+			Expect(dao.RevertMigration(valid_db, migration, valid_request_id)).To(Succeed())
+
+			applied, err := dao.GetAppliedMigrations(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeEmpty())
+		})
+	})
+
+	Describe("Locking migrations", func() {
+		It("Should release the lock when unlocked", func() {
+			conn, err := dao.LockMigrations(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dao.UnlockMigrations(conn, valid_request_id)).To(Succeed())
+
+			conn, err = dao.LockMigrations(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dao.UnlockMigrations(conn, valid_request_id)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package daos
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Column of the clusters counted towards a quota of each scope
+var quotaColumns = map[string]string{
+	models.QuotaScopeProject: "project",
+	models.QuotaScopeOwner:   "owner",
+}
+
+// Usage of the quota by the clusters currently in the database
+func (dao *ClusterDao) GetQuotaUsage(quota models.Quota, requestId string) (*models.QuotaUsage, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_quota_usage", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	usage, err := quotaUsage(tx, quota)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return usage, nil
+}
+
+func quotaUsage(tx *sqlx.Tx, quota models.Quota) (*models.QuotaUsage, error) {
+	column, exists := quotaColumns[quota.Scope]
+	if !exists {
+		return nil, errors.New(models.ErrorInvalidQuotaScope)
+	}
+
+	usage := models.QuotaUsage{
+		Scope:           quota.Scope,
+		Name:            quota.Name,
+		MaxClusters:     quota.MaxClusters,
+		MaxClusterHours: quota.MaxClusterHours,
+		MaxTimeout:      quota.MaxTimeout,
+	}
+
+	// Cluster hours are the lifetimes requested, including extensions, of
+	//  the clusters created within the quota window
+	args := []interface{}{time.Now().Add(-models.QuotaWindow)}
+	released := placeholders(&args, models.ReleasedClusterStatuses)
+	args = append(args, quota.Name)
+	sql := fmt.Sprintf(`SELECT
+		COALESCE(SUM(%s) FILTER (WHERE timestamp > $1), 0) / 3600 AS cluster_hours,
+		COUNT(*) FILTER (WHERE status NOT IN (%s)) AS live_clusters
+		FROM clusters WHERE %s = $%d`, lifetimeSeconds(tx), released, column, len(args))
+	err := tx.Get(&usage, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// Refuse the cluster when it would exceed any of the quotas, given the
+// usage of each quota
+func checkQuotas(quotas []models.Quota, cluster *models.Cluster, quotaUsage func(models.Quota) (*models.QuotaUsage, error)) error {
+	lifetime := cluster.Expiration.Sub(cluster.Timestamp)
+
+	for _, quota := range quotas {
+		if !quota.Limited() {
+			continue
+		}
+
+		err := checkMaxTimeout(quota, lifetime, cluster.Timeout)
+		if err != nil {
+			return err
+		}
+
+		usage, err := quotaUsage(quota)
+		if err != nil {
+			return err
+		}
+
+		if quota.MaxClusters > 0 && usage.LiveClusters >= quota.MaxClusters {
+			return fmt.Errorf("%v: %v '%v' has %v of a maximum %v live clusters", models.ErrorQuotaExceeded, quota.Scope, quota.Name, usage.LiveClusters, quota.MaxClusters)
+		}
+
+		if quota.MaxClusterHours > 0 && usage.ClusterHours+lifetime.Hours() > quota.MaxClusterHours {
+			return fmt.Errorf("%v: %v '%v' has used %.2f of a maximum %v cluster hours within the last %v", models.ErrorQuotaExceeded, quota.Scope, quota.Name, usage.ClusterHours, quota.MaxClusterHours, models.QuotaWindow)
+		}
+	}
+
+	return nil
+}
+
+// Refuse extending the cluster to expiration when it would exceed any of
+// the quotas. The cluster is already counted in the usage, only the hours
+// the extension adds are checked, and shortening it is always allowed.
+func checkQuotaExtension(quotas []models.Quota, cluster *models.Cluster, expiration time.Time, quotaUsage func(models.Quota) (*models.QuotaUsage, error)) error {
+	current := cluster.Expiration.Sub(cluster.Timestamp)
+	lifetime := expiration.Sub(cluster.Timestamp)
+	if lifetime <= current {
+		return nil
+	}
+
+	// Clusters created before the quota window no longer count its hours
+	added := lifetime - current
+	if !cluster.Timestamp.After(time.Now().Add(-models.QuotaWindow)) {
+		added = 0
+	}
+
+	for _, quota := range quotas {
+		if !quota.Limited() {
+			continue
+		}
+
+		err := checkMaxTimeout(quota, lifetime, lifetime.Round(time.Second).String())
+		if err != nil {
+			return err
+		}
+
+		if quota.MaxClusterHours <= 0 || added == 0 {
+			continue
+		}
+
+		usage, err := quotaUsage(quota)
+		if err != nil {
+			return err
+		}
+
+		if usage.ClusterHours+added.Hours() > quota.MaxClusterHours {
+			return fmt.Errorf("%v: %v '%v' has used %.2f of a maximum %v cluster hours within the last %v", models.ErrorQuotaExceeded, quota.Scope, quota.Name, usage.ClusterHours, quota.MaxClusterHours, models.QuotaWindow)
+		}
+	}
+
+	return nil
+}
+
+// Refuse a lifetime, measured from the creation of the cluster, longer
+// than the maximum timeout of the quota
+func checkMaxTimeout(quota models.Quota, lifetime time.Duration, timeout string) error {
+	if len(quota.MaxTimeout) == 0 {
+		return nil
+	}
+
+	max_timeout, err := time.ParseDuration(quota.MaxTimeout)
+	if err != nil {
+		return errors.New(models.ErrorInvalidQuota)
+	}
+	if lifetime > max_timeout {
+		return fmt.Errorf("%v: timeout of %v exceeds the maximum of %v for %v '%v'", models.ErrorQuotaExceeded, timeout, quota.MaxTimeout, quota.Scope, quota.Name)
+	}
+
+	return nil
+}
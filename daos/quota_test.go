@@ -0,0 +1,109 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Quota", func() {
+
+	var (
+		dao              *ClusterDao
+		valid_request_id string
+		other_request_id string
+		usage            *models.QuotaUsage
+		err              error
+	)
+
+	newSpec := func(timeout string, quotas ...models.Quota) *models.ClusterSpec {
+		return &models.ClusterSpec{
+			TerraformConfig: []byte(`{}`),
+			Timeout:         timeout,
+			Project:         "project_name",
+			Region:          "region_name",
+			Owner:           "alice",
+			Quotas:          quotas,
+		}
+	}
+
+	BeforeEach(func() {
+		dao = NewClusterDao(valid_db)
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		other_request_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+
+		_, err = dao.CreateCluster(newSpec("2h"), valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Getting the usage of a quota", func() {
+		Context("When clusters are live", func() {
+			It("Should count the live clusters and their hours", func() {
+				usage, err = dao.GetQuotaUsage(models.Quota{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusters: 3}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage.LiveClusters).To(Equal(1))
+				Expect(usage.ClusterHours).To(BeNumerically("~", 2, 0.01))
+				Expect(usage.MaxClusters).To(Equal(3))
+			})
+		})
+
+		Context("When the clusters are destroyed", func() {
+			It("Should not count them as live", func() {
+				_, err = valid_db.Exec(`UPDATE clusters SET status = $1 WHERE id = $2`, models.ClusterStatusDestroyed, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				usage, err = dao.GetQuotaUsage(models.Quota{Scope: models.QuotaScopeProject, Name: "project_name"}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage.LiveClusters).To(Equal(0))
+				Expect(usage.ClusterHours).To(BeNumerically("~", 2, 0.01))
+			})
+		})
+
+		Context("When the scope is unknown", func() {
+			It("Should error", func() {
+				_, err = dao.GetQuotaUsage(models.Quota{Scope: "region", Name: "region_name"}, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorInvalidQuotaScope))
+			})
+		})
+	})
+
+	Describe("Creating a cluster within quotas", func() {
+		Context("When the quota allows another cluster", func() {
+			It("Should create the cluster", func() {
+				_, err = dao.CreateCluster(newSpec("2h", models.Quota{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusters: 2, MaxClusterHours: 4}), other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("When the maximum number of live clusters is reached", func() {
+			It("Should refuse the cluster", func() {
+				_, err = dao.CreateCluster(newSpec("2h", models.Quota{Scope: models.QuotaScopeProject, Name: "project_name", MaxClusters: 1}), other_request_id)
+				Expect(err).To(MatchError(HavePrefix(models.ErrorQuotaExceeded)))
+
+				usage, err = dao.GetQuotaUsage(models.Quota{Scope: models.QuotaScopeProject, Name: "project_name"}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage.LiveClusters).To(Equal(1))
+			})
+		})
+
+		Context("When the cluster hours would be exceeded", func() {
+			It("Should refuse the cluster", func() {
+				_, err = dao.CreateCluster(newSpec("2h", models.Quota{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusterHours: 3}), other_request_id)
+				Expect(err).To(MatchError(HavePrefix(models.ErrorQuotaExceeded)))
+			})
+		})
+
+		Context("When the timeout exceeds the maximum", func() {
+			It("Should refuse the cluster", func() {
+				_, err = dao.CreateCluster(newSpec("10h", models.Quota{Scope: models.QuotaScopeOwner, Name: "alice", MaxTimeout: "8h"}), other_request_id)
+				Expect(err).To(MatchError(HavePrefix(models.ErrorQuotaExceeded)))
+			})
+		})
+	})
+})
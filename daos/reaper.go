@@ -0,0 +1,94 @@
+package daos
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+type ReaperDao struct{}
+
+func NewReaperDao() *ReaperDao {
+	return &ReaperDao{}
+}
+
+// Persist a finished reap run together with the outcome of every cluster
+func (dao *ReaperDao) CreateReapRun(db *sqlx.DB, run *models.ReapRun, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_reap_run", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	sql := `INSERT INTO reap_runs (request_id, started, finished, reaped, skipped, failed) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	err = tx.Get(&run.Id, sql, run.RequestId, run.Started, run.Finished, run.Reaped, run.Skipped, run.Failed)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	for i := range run.Outcomes {
+		outcome := &run.Outcomes[i]
+		outcome.RunId = run.Id
+		sql := `INSERT INTO reap_outcomes (run_id, cluster_id, outcome, message) VALUES ($1, $2, $3, $4)`
+		_, err = tx.Exec(sql, outcome.RunId, outcome.ClusterId, outcome.Outcome, outcome.Message)
+		if err != nil {
+			tx.Rollback()
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// The most recent reap runs, newest first, with the outcome of every cluster
+func (dao *ReaperDao) GetReapRuns(db *sqlx.DB, limit int, requestId string) ([]models.ReapRun, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_reap_runs", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	runs := []models.ReapRun{}
+	err = tx.Select(&runs, `SELECT * FROM reap_runs ORDER BY id DESC LIMIT $1`, limit)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	ids := []int64{}
+	for _, run := range runs {
+		ids = append(ids, run.Id)
+	}
+
+	outcomes := []models.ReapOutcome{}
+	err = tx.Select(&outcomes, `SELECT * FROM reap_outcomes WHERE run_id = ANY($1) ORDER BY cluster_id`, pq.Array(ids))
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	for i := range runs {
+		runs[i].Outcomes = []models.ReapOutcome{}
+		for _, outcome := range outcomes {
+			if outcome.RunId == runs[i].Id {
+				runs[i].Outcomes = append(runs[i].Outcomes, outcome)
+			}
+		}
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,66 @@
+package daos_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Reaper", func() {
+
+	var (
+		dao              *ReaperDao
+		valid_request_id string
+		runs             []models.ReapRun
+		err              error
+	)
+
+	newRun := func(request_id string) *models.ReapRun {
+		run := &models.ReapRun{RequestId: request_id, Started: time.Now(), Finished: time.Now()}
+		run.Record(models.ReapOutcome{ClusterId: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Outcome: models.ReapOutcomeReaped})
+		run.Record(models.ReapOutcome{ClusterId: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Outcome: models.ReapOutcomeFailed, Message: "error"})
+		return run
+	}
+
+	BeforeEach(func() {
+		dao = NewReaperDao()
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Recording reap runs", func() {
+		BeforeEach(func() {
+			Expect(dao.CreateReapRun(valid_db, newRun("first"), valid_request_id)).To(Succeed())
+			Expect(dao.CreateReapRun(valid_db, newRun("second"), valid_request_id)).To(Succeed())
+		})
+
+		Context("When getting the runs", func() {
+			It("Should return the newest run first with its outcomes", func() {
+				runs, err = dao.GetReapRuns(valid_db, 10, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runs).To(HaveLen(2))
+				Expect(runs[0].RequestId).To(Equal("second"))
+				Expect(runs[0].Reaped).To(Equal(1))
+				Expect(runs[0].Failed).To(Equal(1))
+				Expect(runs[0].Outcomes).To(HaveLen(2))
+				Expect(runs[0].Outcomes[1].Message).To(Equal("error"))
+			})
+		})
+
+		Context("When limiting the runs", func() {
+			It("Should return only that many runs", func() {
+				runs, err = dao.GetReapRuns(valid_db, 1, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runs).To(HaveLen(1))
+				Expect(runs[0].RequestId).To(Equal("second"))
+			})
+		})
+	})
+})
@@ -0,0 +1,112 @@
+package daos
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type RoleDao struct{}
+
+func NewRoleDao() *RoleDao {
+	return &RoleDao{}
+}
+
+// Grant the role to the subject within the project, replacing any role
+// the subject was granted before
+func (dao *RoleDao) SetRoleBinding(db *sqlx.DB, binding *models.RoleBinding, requestId string) (*models.RoleBinding, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "set_role_binding", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	set := *binding
+	set.Timestamp = time.Now()
+
+	logger.Info(fmt.Sprintf("granting role '%v' on project '%v' to %v '%v'", set.Role, set.Project, set.SubjectType, set.Subject))
+
+	sql := `INSERT INTO role_bindings (
+		project,
+		subject_type,
+		subject,
+		role,
+		timestamp
+	) VALUES (
+		:project,
+		:subject_type,
+		:subject,
+		:role,
+		:timestamp
+	) ON CONFLICT (project, subject_type, subject) DO UPDATE SET
+		role = EXCLUDED.role,
+		timestamp = EXCLUDED.timestamp`
+	_, err = tx.NamedExec(sql, set)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &set, nil
+}
+
+// Every role granted within the project
+func (dao *RoleDao) GetRoleBindings(db *sqlx.DB, project string, requestId string) ([]models.RoleBinding, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_role_bindings", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	bindings := []models.RoleBinding{}
+	err = tx.Select(&bindings, `SELECT * FROM role_bindings WHERE project = $1 ORDER BY subject_type, subject`, project)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return bindings, nil
+}
+
+// Revoke the role of the subject within the project. The number of role
+// bindings deleted is returned.
+func (dao *RoleDao) DeleteRoleBinding(db *sqlx.DB, project string, subject_type string, subject string, requestId string) (int64, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "delete_role_binding", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM role_bindings WHERE project = $1 AND subject_type = $2 AND subject = $3`, project, subject_type, subject)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	tx.Commit()
+
+	return deleted, nil
+}
@@ -0,0 +1,87 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Role", func() {
+
+	var (
+		dao              *RoleDao
+		valid_request_id string
+		bindings         []models.RoleBinding
+		deleted          int64
+		err              error
+	)
+
+	BeforeEach(func() {
+		dao = NewRoleDao()
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+
+		_, err = dao.SetRoleBinding(valid_db, &models.RoleBinding{Project: "project", SubjectType: models.SubjectTypeUser, Subject: "alice", Role: models.RoleViewer}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = dao.SetRoleBinding(valid_db, &models.RoleBinding{Project: "other", SubjectType: models.SubjectTypeToken, Subject: "b7e3e0a2", Role: models.RoleAdmin}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Setting a role binding", func() {
+		Context("When the subject already has a role", func() {
+			It("Should replace the role", func() {
+				_, err = dao.SetRoleBinding(valid_db, &models.RoleBinding{Project: "project", SubjectType: models.SubjectTypeUser, Subject: "alice", Role: models.RoleOperator}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				bindings, err = dao.GetRoleBindings(valid_db, "project", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bindings).To(HaveLen(1))
+				Expect(bindings[0].Role).To(Equal(models.RoleOperator))
+			})
+		})
+
+		Context("When the database connection is unusable", func() {
+			It("Should error", func() {
+				_, err = dao.SetRoleBinding(invalid_db, &models.RoleBinding{Project: "project", SubjectType: models.SubjectTypeUser, Subject: "bob", Role: models.RoleViewer}, valid_request_id)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Getting the role bindings of a project", func() {
+		It("Should return only the role bindings of the project", func() {
+			bindings, err = dao.GetRoleBindings(valid_db, "other", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bindings).To(HaveLen(1))
+			Expect(bindings[0].SubjectType).To(Equal(models.SubjectTypeToken))
+			Expect(bindings[0].Subject).To(Equal("b7e3e0a2"))
+		})
+	})
+
+	Describe("Deleting a role binding", func() {
+		Context("When the role binding exists", func() {
+			It("Should delete the role binding", func() {
+				deleted, err = dao.DeleteRoleBinding(valid_db, "project", models.SubjectTypeUser, "alice", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(int64(1)))
+
+				bindings, err = dao.GetRoleBindings(valid_db, "project", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(bindings).To(BeEmpty())
+			})
+		})
+
+		Context("When the role binding does not exist", func() {
+			It("Should delete nothing", func() {
+				deleted, err = dao.DeleteRoleBinding(valid_db, "project", models.SubjectTypeToken, "alice", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(int64(0)))
+			})
+		})
+	})
+})
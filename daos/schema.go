@@ -0,0 +1,217 @@
+package daos
+
+import (
+	"github.com/kmacoskey/taos/models"
+)
+
+// Migrations of the schema, in the order they are applied. Once released a
+// migration is never changed, changes to the schema are new migrations.
+var Migrations = []models.Migration{
+	{
+		// The schema previously created by init.sql, databases created
+		//  with it are adopted as they are
+		Version: 1,
+		Name:    "initial_schema",
+		Up: `
+		CREATE TABLE IF NOT EXISTS clusters (
+		    id               text,
+		    name             text,
+		    status           text,
+		    message          text,
+		    outputs          bytea,
+		    terraform_config bytea,
+		    terraform_state  bytea,
+		    timestamp        timestamp,
+		    expiration       timestamp,
+		    timeout          text,
+		    project          text,
+		    region           text,
+		    template_name    text DEFAULT '',
+		    template_version integer DEFAULT 0,
+		    variables        json DEFAULT '{}',
+		    owner            text DEFAULT '',
+		    destroy_attempts integer DEFAULT 0,
+		    next_destroy_attempt timestamp DEFAULT 'epoch',
+		    expiration_warnings text[] DEFAULT '{}'
+		);
+
+		CREATE TABLE IF NOT EXISTS cluster_webhooks (
+		    cluster_id       text,
+		    url              text
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		    id               bigserial PRIMARY KEY,
+		    cluster_id       text,
+		    url              text,
+		    event            text,
+		    payload          bytea,
+		    status           text,
+		    attempts         integer,
+		    next_attempt     timestamp,
+		    timestamp        timestamp
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_attempts (
+		    delivery_id      bigint,
+		    attempt          integer,
+		    timestamp        timestamp,
+		    response_code    integer,
+		    error            text
+		);
+
+		CREATE TABLE IF NOT EXISTS cluster_logs (
+		    id               bigserial PRIMARY KEY,
+		    cluster_id       text,
+		    operation        text,
+		    line             text,
+		    timestamp        timestamp
+		);
+
+		CREATE TABLE IF NOT EXISTS cluster_events (
+		    id               bigserial PRIMARY KEY,
+		    cluster_id       text,
+		    kind             text,
+		    name             text,
+		    message          text DEFAULT '',
+		    request_id       text,
+		    actor            text DEFAULT '',
+		    timestamp        timestamp
+		);
+
+		CREATE INDEX IF NOT EXISTS cluster_events_cluster_id ON cluster_events (cluster_id, id);
+
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+		    key              text PRIMARY KEY,
+		    request_hash     text,
+		    cluster_id       text,
+		    timestamp        timestamp
+		);
+
+		CREATE TABLE IF NOT EXISTS templates (
+		    name             text,
+		    version          integer,
+		    description      text,
+		    config           text,
+		    variables        json,
+		    timestamp        timestamp,
+		    PRIMARY KEY (name, version)
+		);
+
+		CREATE TABLE IF NOT EXISTS tokens (
+		    id               text PRIMARY KEY,
+		    owner            text,
+		    description      text,
+		    hash             text UNIQUE,
+		    admin            boolean DEFAULT false,
+		    timestamp        timestamp
+		);
+
+		CREATE TABLE IF NOT EXISTS role_bindings (
+		    project          text,
+		    subject_type     text,
+		    subject          text,
+		    role             text,
+		    timestamp        timestamp,
+		    PRIMARY KEY (project, subject_type, subject)
+		);
+
+		CREATE TABLE IF NOT EXISTS cluster_jobs (
+		    id               bigserial PRIMARY KEY,
+		    cluster_id       text,
+		    operation        text,
+		    status           text,
+		    request_id       text,
+		    message          text DEFAULT '',
+		    timestamp        timestamp,
+		    updated          timestamp,
+		    worker           text DEFAULT '',
+		    heartbeat        timestamp,
+		    cancelled        boolean DEFAULT false
+		);
+
+		-- A cluster has at most one queued or running operation
+		CREATE UNIQUE INDEX IF NOT EXISTS cluster_jobs_active ON cluster_jobs (cluster_id) WHERE status IN ('queued', 'running');
+
+		CREATE TABLE IF NOT EXISTS reap_runs (
+		    id               bigserial PRIMARY KEY,
+		    request_id       text,
+		    started          timestamp,
+		    finished         timestamp,
+		    reaped           integer,
+		    skipped          integer,
+		    failed           integer
+		);
+
+		CREATE TABLE IF NOT EXISTS reap_outcomes (
+		    run_id           bigint,
+		    cluster_id       text,
+		    outcome          text,
+		    message          text DEFAULT ''
+		);`,
+		Down: `
+		DROP TABLE IF EXISTS reap_outcomes, reap_runs, cluster_jobs, role_bindings, tokens, templates, idempotency_keys, cluster_events, cluster_logs, webhook_attempts, webhook_deliveries, cluster_webhooks, clusters;`,
+	},
+	{
+		// Existing clusters stay in plaintext until taos rekey encrypts them
+		Version: 2,
+		Name:    "cluster_encryption",
+		Up: `
+		ALTER TABLE clusters
+		    ADD COLUMN encryption_key_id text DEFAULT '',
+		    ADD COLUMN data_key          bytea;`,
+		Down: `
+		ALTER TABLE clusters
+		    DROP COLUMN data_key,
+		    DROP COLUMN encryption_key_id;`,
+	},
+	{
+		Version: 3,
+		Name:    "cluster_state_lock",
+		Up: `
+		ALTER TABLE clusters
+		    ADD COLUMN state_lock text DEFAULT '';`,
+		Down: `
+		ALTER TABLE clusters
+		    DROP COLUMN state_lock;`,
+	},
+	{
+		// Keys are only kept for the retention window, going down forgets
+		//  them rather than merging the keys of different owners
+		Version: 4,
+		Name:    "idempotency_key_owner",
+		Up: `
+		ALTER TABLE idempotency_keys
+		    DROP CONSTRAINT idempotency_keys_pkey,
+		    ADD COLUMN owner text DEFAULT '';
+
+		UPDATE idempotency_keys SET owner = clusters.owner
+		    FROM clusters WHERE clusters.id = idempotency_keys.cluster_id;
+
+		ALTER TABLE idempotency_keys
+		    ADD PRIMARY KEY (owner, key);`,
+		Down: `
+		DELETE FROM idempotency_keys;
+
+		ALTER TABLE idempotency_keys
+		    DROP COLUMN owner,
+		    ADD PRIMARY KEY (key);`,
+	},
+	{
+		// Variables are encrypted with the other secrets of clusters once
+		//  stored as bytes. Going down fails while any cluster is
+		//  encrypted, as its variables are no longer json.
+		Version: 5,
+		Name:    "cluster_variables_bytes",
+		Up: `
+		ALTER TABLE clusters
+		    ALTER COLUMN variables DROP DEFAULT,
+		    ALTER COLUMN variables TYPE bytea USING convert_to(variables::text, 'UTF8'),
+		    ALTER COLUMN variables SET DEFAULT '{}';`,
+		Down: `
+		ALTER TABLE clusters
+		    ALTER COLUMN variables DROP DEFAULT,
+		    ALTER COLUMN variables TYPE json USING convert_from(variables, 'UTF8')::json,
+		    ALTER COLUMN variables SET DEFAULT '{}';`,
+	},
+}
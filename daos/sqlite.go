@@ -0,0 +1,191 @@
+package daos
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Schema of the clusters stored in SQLite, one entry per version. Unlike
+// Postgres the database belongs to a single instance, the versions applied
+// are recorded in its user_version as it is opened.
+var sqliteSchema = []string{
+	`
+	CREATE TABLE clusters (
+	    id               text PRIMARY KEY,
+	    name             text,
+	    status           text,
+	    message          text,
+	    outputs          blob,
+	    terraform_config blob,
+	    terraform_state  blob,
+	    timestamp        timestamp,
+	    expiration       timestamp,
+	    timeout          text,
+	    project          text,
+	    region           text,
+	    template_name    text DEFAULT '',
+	    template_version integer DEFAULT 0,
+	    variables        text DEFAULT '{}',
+	    owner            text DEFAULT '',
+	    destroy_attempts integer DEFAULT 0,
+	    next_destroy_attempt timestamp DEFAULT '1970-01-01 00:00:00+00:00',
+	    expiration_warnings text DEFAULT '{}'
+	);
+
+	CREATE TABLE cluster_webhooks (
+	    cluster_id       text,
+	    url              text
+	);
+
+	CREATE TABLE cluster_logs (
+	    id               integer PRIMARY KEY AUTOINCREMENT,
+	    cluster_id       text,
+	    operation        text,
+	    line             text,
+	    timestamp        timestamp
+	);
+
+	CREATE TABLE cluster_events (
+	    id               integer PRIMARY KEY AUTOINCREMENT,
+	    cluster_id       text,
+	    kind             text,
+	    name             text,
+	    message          text DEFAULT '',
+	    request_id       text,
+	    actor            text DEFAULT '',
+	    timestamp        timestamp
+	);
+
+	CREATE INDEX cluster_events_cluster_id ON cluster_events (cluster_id, id);
+
+	CREATE TABLE idempotency_keys (
+	    key              text PRIMARY KEY,
+	    request_hash     text,
+	    cluster_id       text,
+	    timestamp        timestamp
+	);
+
+	CREATE TABLE cluster_jobs (
+	    id               integer PRIMARY KEY AUTOINCREMENT,
+	    cluster_id       text,
+	    operation        text,
+	    status           text,
+	    request_id       text,
+	    message          text DEFAULT '',
+	    timestamp        timestamp,
+	    updated          timestamp,
+	    worker           text DEFAULT '',
+	    heartbeat        timestamp,
+	    cancelled        boolean DEFAULT false
+	);
+
+	CREATE UNIQUE INDEX cluster_jobs_active ON cluster_jobs (cluster_id) WHERE status IN ('queued', 'running');`,
+	`
+	ALTER TABLE clusters ADD COLUMN encryption_key_id text DEFAULT '';
+	ALTER TABLE clusters ADD COLUMN data_key blob;`,
+	`
+	ALTER TABLE clusters ADD COLUMN state_lock text DEFAULT '';`,
+	`
+	CREATE TABLE idempotency_keys_owned (
+	    owner            text DEFAULT '',
+	    key              text,
+	    request_hash     text,
+	    cluster_id       text,
+	    timestamp        timestamp,
+	    PRIMARY KEY (owner, key)
+	);
+
+	INSERT INTO idempotency_keys_owned (owner, key, request_hash, cluster_id, timestamp)
+	    SELECT COALESCE(clusters.owner, ''), idempotency_keys.key, idempotency_keys.request_hash, idempotency_keys.cluster_id, idempotency_keys.timestamp
+	    FROM idempotency_keys LEFT JOIN clusters ON clusters.id = idempotency_keys.cluster_id;
+
+	DROP TABLE idempotency_keys;
+
+	ALTER TABLE idempotency_keys_owned RENAME TO idempotency_keys;`,
+	`
+	CREATE TABLE webhook_deliveries (
+	    id               integer PRIMARY KEY AUTOINCREMENT,
+	    cluster_id       text,
+	    url              text,
+	    event            text,
+	    payload          blob,
+	    status           text,
+	    attempts         integer,
+	    next_attempt     timestamp,
+	    timestamp        timestamp
+	);
+
+	CREATE TABLE webhook_attempts (
+	    delivery_id      integer,
+	    attempt          integer,
+	    timestamp        timestamp,
+	    response_code    integer,
+	    error            text
+	);`,
+}
+
+// Clusters stored in an embedded SQLite database at path, created when it
+// does not exist. ":memory:" stores them in memory until the process ends.
+func NewSqliteClusterDao(path string) (*ClusterDao, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "new_sqlite_cluster_dao", "request": nil})
+
+	// Immediate transactions take the write lock as they begin, standing in
+	//  for the row locks taken by the queries shared with Postgres
+	db, err := sqlx.Open(sqliteDriver, fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=5000", path))
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	// A single connection serializes access, and keeps an in-memory
+	//  database alive for as long as the dao
+	db.SetMaxOpenConns(1)
+
+	err = migrateSqlite(db)
+	if err != nil {
+		db.Close()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return &ClusterDao{db: db, locks: newLocalLocks()}, nil
+}
+
+// Apply the versions of the schema the database is missing, refusing a
+// database created by a newer binary
+func migrateSqlite(db *sqlx.DB) error {
+	version := 0
+	err := db.Get(&version, `PRAGMA user_version`)
+	if err != nil {
+		return err
+	}
+
+	if version > len(sqliteSchema) {
+		return errors.New(models.ErrorDatabaseNewer)
+	}
+
+	for ; version < len(sqliteSchema); version++ {
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(sqliteSchema[version])
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		tx.Commit()
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package daos
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Lock the terraform state of the cluster. Locking it again with the lock
+// holding it succeeds, any other lock is refused with the lock holding it.
+func (dao *ClusterDao) LockClusterState(id string, lock *models.StateLock, requestId string) (*models.StateLock, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "lock_cluster_state", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	current, err := selectStateLock(tx, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if stateLockHeld(current, lock.ID) {
+		tx.Rollback()
+		return current, errors.New(models.ErrorClusterStateLocked)
+	}
+
+	value, err := json.Marshal(lock)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE clusters SET state_lock = $1 WHERE id = $2`, string(value), id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return lock, nil
+}
+
+// Unlock the terraform state of the cluster locked with the lock id. A
+// state which is not locked is left as it is, a state held by another lock
+// is refused with the lock holding it.
+func (dao *ClusterDao) UnlockClusterState(id string, lockId string, requestId string) (*models.StateLock, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "unlock_cluster_state", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	current, err := selectStateLock(tx, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if stateLockHeld(current, lockId) {
+		tx.Rollback()
+		return current, errors.New(models.ErrorClusterStateLocked)
+	}
+
+	_, err = tx.Exec(`UPDATE clusters SET state_lock = '' WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return nil, nil
+}
+
+// Replace the terraform state of the cluster, refused while the state is
+// held by a lock other than the lock id
+func (dao *ClusterDao) UpdateClusterState(id string, lockId string, state []byte, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "update_cluster_state", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	current, err := selectStateLock(tx, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	if stateLockHeld(current, lockId) {
+		tx.Rollback()
+		err := errors.New(models.ErrorClusterStateLocked)
+		logger.Error(err)
+		return err
+	}
+
+	value, err := dao.encryptClusterField(tx, id, "terraform_state", state)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE clusters SET terraform_state = $1 WHERE id = $2`, value, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// The lock of the terraform state of the cluster, locking the cluster
+// until the transaction ends
+func selectStateLock(tx *sqlx.Tx, id string) (*models.StateLock, error) {
+	value := ""
+	err := tx.Get(&value, `SELECT state_lock FROM clusters WHERE id = $1`+lockRows(tx, ` FOR UPDATE`), id)
+	if err == sql.ErrNoRows {
+		return nil, errors.New(models.ErrorClusterNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return models.ParseStateLock(value)
+}
+
+// Whether the state is held by a lock other than the lock id
+func stateLockHeld(current *models.StateLock, lockId string) bool {
+	return current != nil && current.ID != lockId
+}
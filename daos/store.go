@@ -0,0 +1,75 @@
+package daos
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+)
+
+// Storage of clusters and their jobs, logs and history, implemented by the
+// ClusterDao for Postgres and SQLite and by the MemoryClusterDao
+type ClusterStore interface {
+	CreateCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error)
+	GetCluster(id string, requestId string) (*models.Cluster, error)
+	GetClusters(filter *models.ClusterFilter, requestId string) ([]models.Cluster, string, error)
+	GetExpiredClusters(requestId string) ([]models.Cluster, error)
+	GetExpiringClusters(before time.Time, requestId string) ([]models.Cluster, error)
+	GetDestroyRetryClusters(before time.Time, requestId string) ([]models.Cluster, error)
+	GetOrphanedClusters(requestId string) ([]models.Cluster, error)
+	UpdateClusterStatus(id string, from string, to string, requestId string) error
+	UpdateClusterField(id string, field string, value interface{}, requestId string) error
+	UpdateJobClusterStatus(job *models.ClusterJob, from string, to string, requestId string) error
+	UpdateJobClusterField(job *models.ClusterJob, field string, value interface{}, requestId string) error
+	UpdateClusterExpiration(id string, expiration time.Time, quotas []models.Quota, requestId string) error
+	RecordExpirationWarnings(cluster *models.Cluster, requestId string) error
+	GetClusterWebhooks(id string, requestId string) ([]string, error)
+	CreateWebhookDeliveries(deliveries []models.WebhookDelivery, requestId string) error
+	ClaimWebhookDelivery(due time.Time, until time.Time, requestId string) (*models.WebhookDelivery, error)
+	RecordWebhookAttempt(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, requestId string) error
+	GetWebhookDeliveries(clusterId string, limit int, requestId string) ([]models.WebhookDelivery, error)
+
+	CreateClusterHistoryEvent(event *models.ClusterHistoryEvent, requestId string) error
+	GetClusterHistory(id string, requestId string) ([]models.ClusterHistoryEvent, error)
+	AppendClusterLog(id string, operation string, lines []string, requestId string) error
+	GetClusterLogs(id string, operation string, after int64, requestId string) ([]models.ClusterLog, error)
+	GetIdempotencyKey(owner string, key string, requestId string) (*models.IdempotencyKey, error)
+	DeleteIdempotencyKeys(before time.Time, requestId string) error
+	GetQuotaUsage(quota models.Quota, requestId string) (*models.QuotaUsage, error)
+	RekeyClusters(requestId string) (int, error)
+
+	LockClusterState(id string, lock *models.StateLock, requestId string) (*models.StateLock, error)
+	UnlockClusterState(id string, lockId string, requestId string) (*models.StateLock, error)
+	UpdateClusterState(id string, lockId string, state []byte, requestId string) error
+
+	EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error)
+	ClaimClusterJob(worker string, requestId string) (*models.ClusterJob, error)
+	GetClusterJob(id int64, requestId string) (*models.ClusterJob, error)
+	HeartbeatClusterJob(job *models.ClusterJob, requestId string) error
+	CancelClusterJob(id string, requestId string) (*models.ClusterJob, error)
+	FinishClusterJob(job *models.ClusterJob, requestId string) error
+	AbandonClusterJobs(before time.Time, requestId string) ([]models.ClusterJob, error)
+	LockCluster(id string, requestId string) (func(), error)
+}
+
+// The store of the backend, postgres storing clusters in db. Secrets of
+// the clusters stored in a database are encrypted with the keyring, when
+// not nil. Every status change is delivered to the webhooks. Only clusters
+// are kept in sqlite or memory, the server still requires db for every
+// other resource, so neither runs taos without postgres.
+func NewClusterStore(backend string, path string, db *sqlx.DB, keyring *Keyring, webhooks []string) (ClusterStore, error) {
+	switch backend {
+	case models.ClusterStorePostgres:
+		return NewClusterDao(db).WithKeyring(keyring).WithWebhooks(webhooks), nil
+	case models.ClusterStoreSqlite:
+		dao, err := NewSqliteClusterDao(path)
+		if err != nil {
+			return nil, err
+		}
+		return dao.WithKeyring(keyring).WithWebhooks(webhooks), nil
+	case models.ClusterStoreMemory:
+		return NewMemoryClusterDao().WithWebhooks(webhooks), nil
+	}
+	return nil, errors.New(models.ErrorInvalidClusterStore)
+}
@@ -0,0 +1,35 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/daos/storetest"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = storetest.ClusterDaoContract(models.ClusterStorePostgres, func() ClusterStore {
+	return NewClusterDao(valid_db)
+}, func() {
+	valid_db.MustExec(truncate_clusters)
+})
+
+var _ = Describe("Store", func() {
+	Describe("Creating a cluster store", func() {
+		Context("When the backend is memory", func() {
+			It("Should store clusters in memory", func() {
+				store, err := NewClusterStore(models.ClusterStoreMemory, "", nil, nil, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(store).To(BeAssignableToTypeOf(&MemoryClusterDao{}))
+			})
+		})
+
+		Context("When the backend is unknown", func() {
+			It("Should error", func() {
+				_, err := NewClusterStore("mysql", "", nil, nil, nil)
+				Expect(err).To(MatchError(models.ErrorInvalidClusterStore))
+			})
+		})
+	})
+})
@@ -0,0 +1,841 @@
+// Package storetest holds the specs every daos.ClusterStore backend is
+// expected to pass, run against each backend by the suites including them.
+package storetest
+
+import (
+	"database/sql"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+// Describe the contract of a cluster store for the backend. open returns
+// an empty store before each spec, cleanup empties it again after.
+func ClusterDaoContract(backend string, open func() daos.ClusterStore, cleanup func()) bool {
+	return Describe("Cluster store contract: "+backend, func() {
+
+		var (
+			dao              daos.ClusterStore
+			valid_request_id string
+			other_request_id string
+			cluster          *models.Cluster
+			job              *models.ClusterJob
+			err              error
+		)
+
+		newSpec := func() *models.ClusterSpec {
+			return &models.ClusterSpec{
+				TerraformConfig: []byte(`{}`),
+				Timeout:         "2h",
+				Project:         "project_name",
+				Region:          "region_name",
+				Owner:           "alice",
+			}
+		}
+
+		// Move the cluster through each of the statuses in turn
+		moveCluster := func(id string, statuses ...string) {
+			from := models.ClusterStatusRequested
+			for _, to := range statuses {
+				Expect(dao.UpdateClusterStatus(id, from, to, valid_request_id)).To(Succeed())
+				from = to
+			}
+		}
+
+		BeforeEach(func() {
+			dao = open()
+			valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+			other_request_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+
+			cluster, err = dao.CreateCluster(newSpec(), valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			cleanup()
+		})
+
+		Describe("Creating a cluster", func() {
+			It("Should store the cluster as requested", func() {
+				Expect(cluster.Id).To(Equal(valid_request_id))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusRequested))
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Project).To(Equal("project_name"))
+				Expect(cluster.Owner).To(Equal("alice"))
+				Expect(cluster.Expiration.Sub(cluster.Timestamp)).To(BeNumerically("~", 2*time.Hour, time.Second))
+				Expect(cluster.QueuePosition).To(Equal(1))
+			})
+
+			It("Should record the creation in the history of the cluster", func() {
+				events, err := dao.GetClusterHistory(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events).To(HaveLen(2))
+				Expect(events[0].Kind).To(Equal(models.ClusterHistoryAction))
+				Expect(events[0].Name).To(Equal(models.ClusterActionCreate))
+				Expect(events[1].Name).To(Equal(models.ClusterStatusRequested))
+			})
+
+			It("Should store the variables of the cluster", func() {
+				spec := newSpec()
+				spec.Variables = models.ClusterVariables{"zone": {Value: "a"}, "password": {Value: "secret", Sensitive: true}}
+				_, err = dao.CreateCluster(spec, other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				cluster, err = dao.GetCluster(other_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Variables).To(Equal(spec.Variables))
+			})
+
+			It("Should register the webhooks of the cluster", func() {
+				spec := newSpec()
+				spec.Webhooks = []string{"http://cluster.example.com"}
+				_, err = dao.CreateCluster(spec, other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				urls, err := dao.GetClusterWebhooks(other_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(urls).To(Equal([]string{"http://cluster.example.com"}))
+			})
+
+			Context("When the terraform config is missing", func() {
+				It("Should error", func() {
+					spec := newSpec()
+					spec.TerraformConfig = nil
+					_, err = dao.CreateCluster(spec, other_request_id)
+					Expect(err).To(HaveOccurred())
+
+					_, err = dao.GetCluster(other_request_id, valid_request_id)
+					Expect(err).To(MatchError(sql.ErrNoRows))
+				})
+			})
+
+			Context("When the idempotency key is already used", func() {
+				It("Should refuse the cluster", func() {
+					spec := newSpec()
+					spec.IdempotencyKey = "key"
+					spec.RequestHash = "hash"
+					_, err = dao.CreateCluster(spec, other_request_id)
+					Expect(err).NotTo(HaveOccurred())
+
+					key, err := dao.GetIdempotencyKey("alice", "key", valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(key.ClusterId).To(Equal(other_request_id))
+					Expect(key.RequestHash).To(Equal("hash"))
+
+					_, err = dao.CreateCluster(spec, "d9f3a2a4-2af0-11e8-b467-0ed5f89f718b")
+					Expect(err).To(MatchError(models.ErrorIdempotencyKeyInUse))
+				})
+
+				It("Should leave the key free for other owners", func() {
+					spec := newSpec()
+					spec.IdempotencyKey = "key"
+					_, err = dao.CreateCluster(spec, other_request_id)
+					Expect(err).NotTo(HaveOccurred())
+
+					spec.Owner = "bob"
+					_, err = dao.CreateCluster(spec, "d9f3a2a4-2af0-11e8-b467-0ed5f89f718b")
+					Expect(err).NotTo(HaveOccurred())
+
+					key, err := dao.GetIdempotencyKey("bob", "key", valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(key.ClusterId).To(Equal("d9f3a2a4-2af0-11e8-b467-0ed5f89f718b"))
+				})
+			})
+
+			Context("When the quota is exhausted", func() {
+				It("Should refuse the cluster", func() {
+					spec := newSpec()
+					spec.Quotas = []models.Quota{{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusters: 1}}
+					_, err = dao.CreateCluster(spec, other_request_id)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(HavePrefix(models.ErrorQuotaExceeded))
+				})
+			})
+		})
+
+		Describe("Getting a cluster", func() {
+			Context("When the cluster does not exist", func() {
+				It("Should error with no rows", func() {
+					_, err = dao.GetCluster(other_request_id, valid_request_id)
+					Expect(err).To(MatchError(sql.ErrNoRows))
+				})
+			})
+
+			Context("When the id is missing", func() {
+				It("Should error", func() {
+					_, err = dao.GetCluster("", valid_request_id)
+					Expect(err).To(MatchError(models.ErrorMissingId))
+				})
+			})
+
+			Context("When other clusters are queued first", func() {
+				It("Should return the position of the cluster in the queue", func() {
+					_, err = dao.CreateCluster(newSpec(), other_request_id)
+					Expect(err).NotTo(HaveOccurred())
+
+					cluster, err = dao.GetCluster(other_request_id, valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(cluster.QueuePosition).To(Equal(2))
+				})
+			})
+		})
+
+		Describe("Getting clusters", func() {
+			BeforeEach(func() {
+				spec := newSpec()
+				spec.Project = "other_project"
+				_, err = dao.CreateCluster(spec, other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("Should return the position of each cluster in the queue", func() {
+				clusters, _, err := dao.GetClusters(&models.ClusterFilter{}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(2))
+				positions := map[string]int{}
+				for _, cluster := range clusters {
+					positions[cluster.Id] = cluster.QueuePosition
+				}
+				Expect(positions).To(Equal(map[string]int{valid_request_id: 1, other_request_id: 2}))
+			})
+
+			It("Should return the clusters matching the filter", func() {
+				clusters, next, err := dao.GetClusters(&models.ClusterFilter{Project: "other_project"}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(next).To(BeEmpty())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(other_request_id))
+			})
+
+			It("Should page through the clusters in order", func() {
+				clusters, next, err := dao.GetClusters(&models.ClusterFilter{Sort: models.ClusterSortCreated, Limit: 1}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(valid_request_id))
+				Expect(next).NotTo(BeEmpty())
+
+				clusters, next, err = dao.GetClusters(&models.ClusterFilter{Sort: models.ClusterSortCreated, Limit: 1, Cursor: next}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(other_request_id))
+				Expect(next).To(BeEmpty())
+			})
+
+			It("Should filter by the prefix of the name", func() {
+				clusters, _, err := dao.GetClusters(&models.ClusterFilter{NamePrefix: cluster.Name}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).NotTo(BeEmpty())
+				for _, c := range clusters {
+					Expect(c.Name).To(HavePrefix(cluster.Name))
+				}
+			})
+
+			Context("When the sort order is unknown", func() {
+				It("Should error", func() {
+					_, _, err = dao.GetClusters(&models.ClusterFilter{Sort: "size"}, valid_request_id)
+					Expect(err).To(MatchError(models.ErrorInvalidClusterSort))
+				})
+			})
+		})
+
+		Describe("Updating the status of a cluster", func() {
+			It("Should move the cluster and record the status", func() {
+				Expect(dao.UpdateClusterStatus(valid_request_id, models.ClusterStatusRequested, models.ClusterStatusProvisionStart, valid_request_id)).To(Succeed())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionStart))
+
+				events, err := dao.GetClusterHistory(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events[len(events)-1].Name).To(Equal(models.ClusterStatusProvisionStart))
+			})
+
+			Context("When the cluster has moved on since it was read", func() {
+				It("Should error", func() {
+					err = dao.UpdateClusterStatus(valid_request_id, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess, valid_request_id)
+					Expect(err).To(MatchError(models.ErrorClusterStatusChanged))
+				})
+			})
+
+			Context("When the transition is not allowed", func() {
+				It("Should error", func() {
+					err = dao.UpdateClusterStatus(valid_request_id, models.ClusterStatusRequested, models.ClusterStatusDestroyed, valid_request_id)
+					Expect(err).To(MatchError(models.ErrorInvalidStatusTransition))
+				})
+			})
+		})
+
+		Describe("Updating a field of a cluster", func() {
+			It("Should update the field", func() {
+				Expect(dao.UpdateClusterField(valid_request_id, "message", "a message", valid_request_id)).To(Succeed())
+				Expect(dao.UpdateClusterField(valid_request_id, "terraform_state", []byte(`{"version": 3}`), valid_request_id)).To(Succeed())
+				Expect(dao.UpdateClusterField(valid_request_id, "destroy_attempts", 2, valid_request_id)).To(Succeed())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Message).To(Equal("a message"))
+				Expect(cluster.TerraformState).To(MatchJSON(`{"version": 3}`))
+				Expect(cluster.DestroyAttempts).To(Equal(2))
+			})
+
+			Context("When the field is the status", func() {
+				It("Should error", func() {
+					err = dao.UpdateClusterField(valid_request_id, "status", models.ClusterStatusDestroyed, valid_request_id)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			Context("When the field does not exist", func() {
+				It("Should error", func() {
+					err = dao.UpdateClusterField(valid_request_id, "size", "large", valid_request_id)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			Context("When the cluster does not exist", func() {
+				It("Should error", func() {
+					err = dao.UpdateClusterField(other_request_id, "message", "a message", valid_request_id)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("Updating the expiration of a cluster", func() {
+			It("Should extend the cluster within its quotas", func() {
+				quotas := []models.Quota{{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusters: 1, MaxClusterHours: 4}}
+				expiration := cluster.Timestamp.Add(3 * time.Hour)
+				Expect(dao.UpdateClusterExpiration(valid_request_id, expiration, quotas, valid_request_id)).To(Succeed())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Expiration).To(BeTemporally("~", expiration, time.Second))
+			})
+
+			Context("When the lifetime exceeds the maximum timeout", func() {
+				It("Should error", func() {
+					quotas := []models.Quota{{Scope: models.QuotaScopeOwner, Name: "alice", MaxTimeout: "2h"}}
+					err = dao.UpdateClusterExpiration(valid_request_id, cluster.Timestamp.Add(3*time.Hour), quotas, valid_request_id)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(HavePrefix(models.ErrorQuotaExceeded))
+				})
+			})
+
+			Context("When the extension exceeds the cluster hours", func() {
+				It("Should error", func() {
+					quotas := []models.Quota{{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusterHours: 2.5}}
+					err = dao.UpdateClusterExpiration(valid_request_id, cluster.Timestamp.Add(3*time.Hour), quotas, valid_request_id)
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(HavePrefix(models.ErrorQuotaExceeded))
+				})
+			})
+
+			Context("When the cluster is shortened past an exhausted quota", func() {
+				It("Should update the expiration", func() {
+					quotas := []models.Quota{{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusterHours: 1, MaxTimeout: "1h"}}
+					Expect(dao.UpdateClusterExpiration(valid_request_id, time.Now(), quotas, valid_request_id)).To(Succeed())
+				})
+			})
+
+			Context("When the cluster does not exist", func() {
+				It("Should error", func() {
+					err = dao.UpdateClusterExpiration(other_request_id, time.Now(), nil, valid_request_id)
+					Expect(err).To(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("Getting expired clusters", func() {
+			It("Should return the clusters past their expiration", func() {
+				clusters, err := dao.GetExpiredClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(BeEmpty())
+
+				Expect(dao.UpdateClusterField(valid_request_id, "expiration", time.Now().Add(-time.Minute), valid_request_id)).To(Succeed())
+
+				clusters, err = dao.GetExpiredClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(valid_request_id))
+			})
+
+			Context("When the expired cluster is destroyed", func() {
+				It("Should not return the cluster", func() {
+					Expect(dao.UpdateClusterField(valid_request_id, "expiration", time.Now().Add(-time.Minute), valid_request_id)).To(Succeed())
+					moveCluster(valid_request_id, models.ClusterStatusDestroying, models.ClusterStatusDestroyed)
+
+					clusters, err := dao.GetExpiredClusters(valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(clusters).To(BeEmpty())
+				})
+			})
+		})
+
+		Describe("Warning of expiring clusters", func() {
+			BeforeEach(func() {
+				moveCluster(valid_request_id, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess)
+			})
+
+			It("Should return live clusters expiring before the time", func() {
+				clusters, err := dao.GetExpiringClusters(time.Now().Add(3*time.Hour), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+
+				clusters, err = dao.GetExpiringClusters(time.Now().Add(time.Hour), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(BeEmpty())
+			})
+
+			It("Should record warnings until the expiration changes", func() {
+				clusters, err := dao.GetExpiringClusters(time.Now().Add(3*time.Hour), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				clusters[0].ExpirationWarnings = append(clusters[0].ExpirationWarnings, "3h0m0s")
+				Expect(dao.RecordExpirationWarnings(&clusters[0], valid_request_id)).To(Succeed())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect([]string(cluster.ExpirationWarnings)).To(Equal([]string{"3h0m0s"}))
+
+				Expect(dao.UpdateClusterField(valid_request_id, "expiration", time.Now().Add(4*time.Hour), valid_request_id)).To(Succeed())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.ExpirationWarnings).To(BeEmpty())
+			})
+		})
+
+		Describe("Running jobs", func() {
+			It("Should queue a job to provision a created cluster", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.ClusterId).To(Equal(valid_request_id))
+				Expect(job.Operation).To(Equal(models.ClusterJobProvision))
+				Expect(job.Status).To(Equal(models.ClusterJobRunning))
+				Expect(job.Worker).To(Equal("worker"))
+
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job).To(BeNil())
+			})
+
+			It("Should refuse a job while another is active", func() {
+				_, err = dao.EnqueueClusterJob(valid_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobActive))
+			})
+
+			It("Should refuse a job for a cluster which does not exist", func() {
+				_, err = dao.EnqueueClusterJob(other_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorJobClusterNotFound))
+			})
+
+			It("Should queue the next job once the job is finished", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.HeartbeatClusterJob(job, valid_request_id)).To(Succeed())
+
+				job.Status = models.ClusterJobDone
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(MatchError(models.ErrorClusterJobClaimLost))
+
+				job, err = dao.EnqueueClusterJob(valid_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.Status).To(Equal(models.ClusterJobQueued))
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+
+			It("Should refuse a job the status of the cluster does not allow", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				job.Status = models.ClusterJobDone
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+				moveCluster(valid_request_id, models.ClusterStatusDestroying, models.ClusterStatusDestroyed)
+
+				_, err = dao.EnqueueClusterJob(valid_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobNotAllowed))
+			})
+
+			It("Should abandon jobs which stopped reporting alive and orphan their clusters", func() {
+				clusters, err := dao.GetOrphanedClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(BeEmpty())
+
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				jobs, err := dao.AbandonClusterJobs(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(jobs).To(HaveLen(1))
+				Expect(jobs[0].Status).To(Equal(models.ClusterJobFailed))
+				Expect(dao.HeartbeatClusterJob(job, valid_request_id)).To(MatchError(models.ErrorClusterJobClaimLost))
+
+				clusters, err = dao.GetOrphanedClusters(valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+				Expect(clusters[0].Id).To(Equal(valid_request_id))
+			})
+
+			It("Should only change the cluster of a job while the job is claimed", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.UpdateJobClusterStatus(job, models.ClusterStatusRequested, models.ClusterStatusProvisionStart, valid_request_id)).To(Succeed())
+				Expect(dao.UpdateJobClusterField(job, "message", "applying", valid_request_id)).To(Succeed())
+
+				_, err = dao.AbandonClusterJobs(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				err = dao.UpdateJobClusterStatus(job, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobClaimLost))
+				err = dao.UpdateJobClusterField(job, "terraform_state", []byte(`{"version": 3}`), valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterJobClaimLost))
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionStart))
+				Expect(cluster.Message).To(Equal("applying"))
+				Expect(cluster.TerraformState).To(BeEmpty())
+			})
+
+			It("Should get a job by its id", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				found, err := dao.GetClusterJob(job.Id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found.ClusterId).To(Equal(valid_request_id))
+				Expect(found.Status).To(Equal(models.ClusterJobRunning))
+				Expect(found.Worker).To(Equal("worker"))
+
+				found, err = dao.GetClusterJob(job.Id+1, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found).To(BeNil())
+			})
+
+			It("Should record the operation in the history of the cluster", func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				job.Status = models.ClusterJobDone
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+
+				events, err := dao.GetClusterHistory(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events).To(HaveLen(4))
+				Expect(events[2].Kind).To(Equal(models.ClusterHistoryOperationStarted))
+				Expect(events[2].Actor).To(Equal("worker"))
+				Expect(events[3].Kind).To(Equal(models.ClusterHistoryOperationFinished))
+			})
+		})
+
+		Describe("Cancelling provisioning", func() {
+			Context("When the job is queued", func() {
+				It("Should cancel the job and fail the cluster", func() {
+					job, err = dao.CancelClusterJob(valid_request_id, valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(job.Status).To(Equal(models.ClusterJobCancelled))
+
+					cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionFailedRollbackSuccess))
+					Expect(cluster.QueuePosition).To(Equal(0))
+				})
+			})
+
+			Context("When the job is running", func() {
+				It("Should flag the job for its worker", func() {
+					job, err = dao.ClaimClusterJob("worker", valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+
+					_, err = dao.CancelClusterJob(valid_request_id, valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(dao.HeartbeatClusterJob(job, valid_request_id)).To(Succeed())
+					Expect(job.Cancelled).To(BeTrue())
+				})
+			})
+
+			Context("When no provisioning job is active", func() {
+				It("Should error", func() {
+					_, err = dao.CancelClusterJob(other_request_id, valid_request_id)
+					Expect(err).To(MatchError(models.ErrorClusterJobNotRunning))
+				})
+			})
+		})
+
+		Describe("Retrying destroys", func() {
+			BeforeEach(func() {
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				job.Status = models.ClusterJobDone
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+
+				moveCluster(valid_request_id, models.ClusterStatusDestroying, models.ClusterStatusDestroyFailed)
+				Expect(dao.UpdateClusterField(valid_request_id, "next_destroy_attempt", time.Now(), valid_request_id)).To(Succeed())
+			})
+
+			It("Should return the clusters due to be retried", func() {
+				clusters, err := dao.GetDestroyRetryClusters(time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(HaveLen(1))
+
+				clusters, err = dao.GetDestroyRetryClusters(time.Now().Add(-time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusters).To(BeEmpty())
+			})
+		})
+
+		Describe("Delivering status changes to webhooks", func() {
+			// Claim every delivery which is due, oldest first
+			claimDeliveries := func() []models.WebhookDelivery {
+				deliveries := []models.WebhookDelivery{}
+				for {
+					delivery, err := dao.ClaimWebhookDelivery(time.Now(), time.Now().Add(time.Minute), valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					if delivery == nil {
+						return deliveries
+					}
+					deliveries = append(deliveries, *delivery)
+				}
+			}
+
+			BeforeEach(func() {
+				spec := newSpec()
+				spec.Webhooks = []string{"http://cluster.example.com"}
+				_, err = dao.CreateCluster(spec, other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("Should not deliver the request of the cluster", func() {
+				deliveries := claimDeliveries()
+				Expect(deliveries).To(BeEmpty())
+			})
+
+			It("Should enqueue a delivery along with each change of status", func() {
+				moveCluster(other_request_id, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess)
+
+				deliveries := claimDeliveries()
+				Expect(deliveries).To(HaveLen(2))
+				Expect(deliveries[0].ClusterId).To(Equal(other_request_id))
+				Expect(deliveries[0].Url).To(Equal("http://cluster.example.com"))
+				Expect(deliveries[0].Event).To(Equal(models.ClusterStatusProvisionStart))
+				Expect(deliveries[0].Status).To(Equal(models.WebhookDeliveryPending))
+				Expect(string(deliveries[0].Payload)).To(ContainSubstring(`"status":"` + models.ClusterStatusProvisionStart + `"`))
+				Expect(deliveries[1].Event).To(Equal(models.ClusterStatusProvisionSuccess))
+			})
+
+			It("Should not deliver a claimed delivery again until the claim has passed", func() {
+				moveCluster(other_request_id, models.ClusterStatusProvisionStart)
+
+				delivery, err := dao.ClaimWebhookDelivery(time.Now(), time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delivery.Event).To(Equal(models.ClusterStatusProvisionStart))
+
+				Expect(claimDeliveries()).To(BeEmpty())
+
+				delivery, err = dao.ClaimWebhookDelivery(time.Now().Add(2*time.Minute), time.Now().Add(3*time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delivery).NotTo(BeNil())
+			})
+
+			It("Should enqueue a delivery when a job moves the cluster", func() {
+				// The job of the cluster created first is claimed first
+				_, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				job, err = dao.ClaimClusterJob("worker", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(job.ClusterId).To(Equal(other_request_id))
+				job.Status = models.ClusterJobDone
+				Expect(dao.FinishClusterJob(job, valid_request_id)).To(Succeed())
+				moveCluster(other_request_id, models.ClusterStatusProvisionStart, models.ClusterStatusProvisionSuccess)
+
+				_, err = dao.EnqueueClusterJob(other_request_id, models.ClusterJobDestroy, models.ClusterStatusDestroying, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				deliveries, err := dao.GetWebhookDeliveries(other_request_id, 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deliveries).To(HaveLen(3))
+				Expect(deliveries[0].Event).To(Equal(models.ClusterStatusDestroying))
+			})
+
+			It("Should enqueue a delivery when the queued provisioning is cancelled", func() {
+				_, err = dao.CancelClusterJob(other_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				deliveries := claimDeliveries()
+				Expect(deliveries).To(HaveLen(1))
+				Expect(deliveries[0].Event).To(Equal(models.ClusterStatusProvisionFailedRollbackSuccess))
+			})
+
+			It("Should not deliver the changes of clusters without webhooks", func() {
+				moveCluster(valid_request_id, models.ClusterStatusProvisionStart)
+
+				deliveries, err := dao.GetWebhookDeliveries("", 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deliveries).To(BeEmpty())
+			})
+
+			Context("When an attempt is recorded", func() {
+				BeforeEach(func() {
+					moveCluster(other_request_id, models.ClusterStatusProvisionStart)
+
+					deliveries := claimDeliveries()
+					Expect(deliveries).To(HaveLen(1))
+
+					delivery := deliveries[0]
+					delivery.Attempts = 1
+					delivery.NextAttempt = time.Now().Add(time.Hour)
+					attempt := &models.WebhookAttempt{DeliveryId: delivery.Id, Attempt: 1, Timestamp: time.Now(), ResponseCode: 500, Error: "failed"}
+					Expect(dao.RecordWebhookAttempt(&delivery, attempt, valid_request_id)).To(Succeed())
+				})
+
+				It("Should not be due until the next attempt", func() {
+					deliveries := claimDeliveries()
+					Expect(deliveries).To(BeEmpty())
+				})
+
+				It("Should list the delivery with its attempts", func() {
+					deliveries, err := dao.GetWebhookDeliveries(other_request_id, 0, valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(deliveries).To(HaveLen(1))
+					Expect(deliveries[0].Attempts).To(Equal(1))
+					Expect(deliveries[0].History).To(HaveLen(1))
+					Expect(deliveries[0].History[0].ResponseCode).To(Equal(500))
+				})
+			})
+		})
+
+		Describe("Logging terraform output", func() {
+			BeforeEach(func() {
+				Expect(dao.AppendClusterLog(valid_request_id, models.ClusterLogOperationProvision, []string{"$ terraform init", "$ terraform apply"}, valid_request_id)).To(Succeed())
+				Expect(dao.AppendClusterLog(valid_request_id, models.ClusterLogOperationDestroy, []string{"$ terraform destroy"}, valid_request_id)).To(Succeed())
+			})
+
+			It("Should return the lines in order", func() {
+				logs, err := dao.GetClusterLogs(valid_request_id, "", 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logs).To(HaveLen(3))
+				Expect(logs[0].Line).To(Equal("$ terraform init"))
+
+				logs, err = dao.GetClusterLogs(valid_request_id, "", logs[0].Id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logs).To(HaveLen(2))
+				Expect(logs[0].Line).To(Equal("$ terraform apply"))
+			})
+
+			It("Should return the lines of the operation", func() {
+				logs, err := dao.GetClusterLogs(valid_request_id, models.ClusterLogOperationDestroy, 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logs).To(HaveLen(1))
+				Expect(logs[0].Line).To(Equal("$ terraform destroy"))
+			})
+		})
+
+		Describe("Expiring idempotency keys", func() {
+			It("Should forget keys used before the time", func() {
+				spec := newSpec()
+				spec.IdempotencyKey = "key"
+				_, err = dao.CreateCluster(spec, other_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(dao.DeleteIdempotencyKeys(time.Now().Add(-time.Minute), valid_request_id)).To(Succeed())
+				key, err := dao.GetIdempotencyKey("alice", "key", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(key).NotTo(BeNil())
+
+				Expect(dao.DeleteIdempotencyKeys(time.Now().Add(time.Minute), valid_request_id)).To(Succeed())
+				key, err = dao.GetIdempotencyKey("alice", "key", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(key).To(BeNil())
+			})
+		})
+
+		Describe("Getting the usage of a quota", func() {
+			It("Should count the live clusters and their hours", func() {
+				usage, err := dao.GetQuotaUsage(models.Quota{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusters: 3}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(usage.LiveClusters).To(Equal(1))
+				Expect(usage.ClusterHours).To(BeNumerically("~", 2, 0.01))
+				Expect(usage.MaxClusters).To(Equal(3))
+			})
+
+			Context("When the scope is unknown", func() {
+				It("Should error", func() {
+					_, err = dao.GetQuotaUsage(models.Quota{Scope: "region", Name: "region_name"}, valid_request_id)
+					Expect(err).To(MatchError(models.ErrorInvalidQuotaScope))
+				})
+			})
+		})
+
+		Describe("Locking clusters", func() {
+			It("Should wait until the lock is released", func() {
+				unlock, err := dao.LockCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				locked := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					unlock_other, err := dao.LockCluster(valid_request_id, valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					close(locked)
+					unlock_other()
+				}()
+
+				Consistently(locked, "200ms").ShouldNot(BeClosed())
+				unlock()
+				Eventually(locked, "5s").Should(BeClosed())
+			})
+		})
+
+		Describe("Locking the terraform state", func() {
+			var lock *models.StateLock
+
+			BeforeEach(func() {
+				lock = &models.StateLock{ID: "lock-1", Operation: "OperationTypeApply", Who: "taos", Job: 1}
+				_, err = dao.LockClusterState(valid_request_id, lock, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("Should refuse another lock with the lock holding the state", func() {
+				current, err := dao.LockClusterState(valid_request_id, &models.StateLock{ID: "lock-2"}, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterStateLocked))
+				Expect(current.ID).To(Equal("lock-1"))
+				Expect(current.Operation).To(Equal("OperationTypeApply"))
+				Expect(current.Job).To(Equal(int64(1)))
+			})
+
+			It("Should update the state only with the lock holding it", func() {
+				Expect(dao.UpdateClusterState(valid_request_id, "lock-2", []byte(`{"serial": 1}`), valid_request_id)).To(MatchError(models.ErrorClusterStateLocked))
+				Expect(dao.UpdateClusterState(valid_request_id, "lock-1", []byte(`{"serial": 2}`), valid_request_id)).To(Succeed())
+
+				cluster, err = dao.GetCluster(valid_request_id, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.TerraformState).To(MatchJSON(`{"serial": 2}`))
+			})
+
+			It("Should allow another lock once unlocked", func() {
+				_, err = dao.UnlockClusterState(valid_request_id, "lock-2", valid_request_id)
+				Expect(err).To(MatchError(models.ErrorClusterStateLocked))
+
+				_, err = dao.UnlockClusterState(valid_request_id, "lock-1", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = dao.LockClusterState(valid_request_id, &models.StateLock{ID: "lock-2"}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			Context("When the cluster does not exist", func() {
+				It("Should error", func() {
+					_, err = dao.LockClusterState(other_request_id, lock, valid_request_id)
+					Expect(err).To(MatchError(models.ErrorClusterNotFound))
+				})
+			})
+		})
+	})
+}
@@ -0,0 +1,29 @@
+package storetest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/daos"
+	. "github.com/kmacoskey/taos/daos/storetest"
+)
+
+func TestStoretest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	log.SetLevel(log.FatalLevel)
+	RunSpecs(t, "Cluster Store Suite")
+}
+
+// Postgres runs the contract with the other daos specs, needing a database
+var _ = ClusterDaoContract("memory", func() daos.ClusterStore {
+	return daos.NewMemoryClusterDao()
+}, func() {})
+
+var _ = ClusterDaoContract("sqlite", func() daos.ClusterStore {
+	dao, err := daos.NewSqliteClusterDao(":memory:")
+	Expect(err).NotTo(HaveOccurred())
+	return dao
+}, func() {})
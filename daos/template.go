@@ -0,0 +1,204 @@
+package daos
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type TemplateDao struct{}
+
+func NewTemplateDao() *TemplateDao {
+	return &TemplateDao{}
+}
+
+// Add the template as the next version of the template with its name,
+// which is the first version when there is no template with the name
+func (dao *TemplateDao) CreateTemplate(db *sqlx.DB, template *models.Template, requestId string) (*models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_template", "request": requestId})
+
+	if len(template.Name) == 0 {
+		err := errors.New(models.ErrorMissingTemplateName)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(template.Config) == 0 {
+		err := errors.New(models.ErrorMissingTemplateConfig)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	created := *template
+	created.Timestamp = time.Now()
+
+	err = tx.Get(&created.Version, `SELECT COALESCE(MAX(version), 0) + 1 FROM templates WHERE name = $1`, created.Name)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	logger.Info(fmt.Sprintf("inserting version %v of template '%v' into database", created.Version, created.Name))
+
+	// A concurrent insert of the same version violates the primary key
+	sql := `INSERT INTO templates (
+		name,
+		version,
+		description,
+		config,
+		variables,
+		timestamp
+	) VALUES (
+		:name,
+		:version,
+		:description,
+		:config,
+		:variables,
+		:timestamp
+	)`
+	_, err = tx.NamedExec(sql, created)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &created, nil
+}
+
+// A version of a template, the latest version when version is 0. Nil is
+// returned when there is no such template.
+func (dao *TemplateDao) GetTemplate(db *sqlx.DB, name string, version int, requestId string) (*models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_template", "request": requestId})
+
+	if len(name) == 0 {
+		err := errors.New(models.ErrorMissingTemplateName)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	template := models.Template{}
+	query := `SELECT * FROM templates WHERE name = $1 AND ($2 = 0 OR version = $2) ORDER BY version DESC LIMIT 1`
+	err = tx.Get(&template, query, name, version)
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &template, nil
+}
+
+// The latest version of every template, ordered by name
+func (dao *TemplateDao) GetTemplates(db *sqlx.DB, requestId string) ([]models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_templates", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	templates := []models.Template{}
+	err = tx.Select(&templates, `SELECT DISTINCT ON (name) * FROM templates ORDER BY name, version DESC`)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return templates, nil
+}
+
+// Every version of a template, latest first
+func (dao *TemplateDao) GetTemplateVersions(db *sqlx.DB, name string, requestId string) ([]models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_template_versions", "request": requestId})
+
+	if len(name) == 0 {
+		err := errors.New(models.ErrorMissingTemplateName)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	templates := []models.Template{}
+	err = tx.Select(&templates, `SELECT * FROM templates WHERE name = $1 ORDER BY version DESC`, name)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return templates, nil
+}
+
+// Delete a version of a template, or every version when version is 0.
+// The number of versions deleted is returned.
+func (dao *TemplateDao) DeleteTemplate(db *sqlx.DB, name string, version int, requestId string) (int64, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "delete_template", "request": requestId})
+
+	if len(name) == 0 {
+		err := errors.New(models.ErrorMissingTemplateName)
+		logger.Error(err)
+		return 0, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM templates WHERE name = $1 AND ($2 = 0 OR version = $2)`, name, version)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	tx.Commit()
+
+	return deleted, nil
+}
@@ -0,0 +1,126 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Template", func() {
+
+	var (
+		dao              *TemplateDao
+		valid_request_id string
+		template         *models.Template
+		templates        []models.Template
+		deleted          int64
+		err              error
+	)
+
+	BeforeEach(func() {
+		dao = NewTemplateDao()
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+
+		for _, config := range []string{`{"v":1}`, `{"v":2}`} {
+			_, err = dao.CreateTemplate(valid_db, &models.Template{
+				Name:      "gpdb",
+				Config:    config,
+				Variables: models.TemplateVariables{{Name: "nodes", Default: float64(3)}},
+			}, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		_, err = dao.CreateTemplate(valid_db, &models.Template{Name: "another", Config: `{}`}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Creating a template", func() {
+		Context("When a template with the name exists", func() {
+			It("Should add the next version", func() {
+				template, err = dao.CreateTemplate(valid_db, &models.Template{Name: "gpdb", Config: `{"v":3}`}, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template.Version).To(Equal(3))
+			})
+		})
+
+		Context("When the config is missing", func() {
+			It("Should error", func() {
+				_, err = dao.CreateTemplate(valid_db, &models.Template{Name: "gpdb"}, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorMissingTemplateConfig))
+			})
+		})
+	})
+
+	Describe("Getting a template", func() {
+		Context("When no version is given", func() {
+			It("Should return the latest version", func() {
+				template, err = dao.GetTemplate(valid_db, "gpdb", 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template.Version).To(Equal(2))
+				Expect(template.Config).To(Equal(`{"v":2}`))
+			})
+		})
+
+		Context("When a version is given", func() {
+			It("Should return the version with its variables", func() {
+				template, err = dao.GetTemplate(valid_db, "gpdb", 1, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template.Config).To(Equal(`{"v":1}`))
+				Expect(template.Variables).To(Equal(models.TemplateVariables{{Name: "nodes", Default: float64(3)}}))
+			})
+		})
+
+		Context("When the template does not exist", func() {
+			It("Should not return a template", func() {
+				template, err = dao.GetTemplate(valid_db, "gpdb", 7, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(template).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Getting templates", func() {
+		It("Should return the latest version of each template", func() {
+			templates, err = dao.GetTemplates(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templates).To(HaveLen(2))
+			Expect(templates[0].Name).To(Equal("another"))
+			Expect(templates[1].Name).To(Equal("gpdb"))
+			Expect(templates[1].Version).To(Equal(2))
+		})
+
+		It("Should return every version of a template", func() {
+			templates, err = dao.GetTemplateVersions(valid_db, "gpdb", valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templates).To(HaveLen(2))
+			Expect(templates[0].Version).To(Equal(2))
+		})
+	})
+
+	Describe("Deleting a template", func() {
+		Context("When a version is given", func() {
+			It("Should delete only the version", func() {
+				deleted, err = dao.DeleteTemplate(valid_db, "gpdb", 2, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(int64(1)))
+
+				template, err = dao.GetTemplate(valid_db, "gpdb", 0, valid_request_id)
+				Expect(template.Version).To(Equal(1))
+			})
+		})
+
+		Context("When no version is given", func() {
+			It("Should delete every version", func() {
+				deleted, err = dao.DeleteTemplate(valid_db, "gpdb", 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(int64(2)))
+			})
+		})
+	})
+})
@@ -0,0 +1,140 @@
+package daos
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type TokenDao struct{}
+
+func NewTokenDao() *TokenDao {
+	return &TokenDao{}
+}
+
+func (dao *TokenDao) CreateToken(db *sqlx.DB, token *models.Token, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_token", "request": requestId})
+
+	if len(token.Owner) == 0 {
+		err := errors.New(models.ErrorMissingTokenOwner)
+		logger.Error(err)
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("inserting token '%v' of '%v' into database", token.Id, token.Owner))
+
+	sql := `INSERT INTO tokens (
+		id,
+		owner,
+		description,
+		hash,
+		admin,
+		timestamp
+	) VALUES (
+		:id,
+		:owner,
+		:description,
+		:hash,
+		:admin,
+		:timestamp
+	)`
+	_, err = tx.NamedExec(sql, token)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// The token with the given hash, nil when there is none
+func (dao *TokenDao) GetTokenByHash(db *sqlx.DB, hash string, requestId string) (*models.Token, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_token_by_hash", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	token := models.Token{}
+	err = tx.Get(&token, `SELECT * FROM tokens WHERE hash = $1`, hash)
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &token, nil
+}
+
+// Every token, oldest first
+func (dao *TokenDao) GetTokens(db *sqlx.DB, requestId string) ([]models.Token, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_tokens", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tokens := []models.Token{}
+	err = tx.Select(&tokens, `SELECT * FROM tokens ORDER BY timestamp, id`)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return tokens, nil
+}
+
+// Revoke a token. The number of tokens deleted is returned.
+func (dao *TokenDao) DeleteToken(db *sqlx.DB, id string, requestId string) (int64, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "delete_token", "request": requestId})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	result, err := tx.Exec(`DELETE FROM tokens WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return 0, err
+	}
+
+	tx.Commit()
+
+	return deleted, nil
+}
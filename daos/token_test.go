@@ -0,0 +1,124 @@
+package daos_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"time"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Token", func() {
+
+	var (
+		dao              *TokenDao
+		valid_request_id string
+		token            *models.Token
+		tokens           []models.Token
+		deleted          int64
+		err              error
+	)
+
+	BeforeEach(func() {
+		dao = NewTokenDao()
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+
+		err = dao.CreateToken(valid_db, &models.Token{
+			Id:          "b7e3e0a2-4f1c-11e8-9c2d-fa7ae01bbebc",
+			Owner:       "alice",
+			Description: "laptop",
+			Hash:        "alice-hash",
+			Timestamp:   time.Now(),
+		}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = dao.CreateToken(valid_db, &models.Token{
+			Id:        "b7e3e3a4-4f1c-11e8-9c2d-fa7ae01bbebc",
+			Owner:     "ops",
+			Hash:      "ops-hash",
+			Admin:     true,
+			Timestamp: time.Now().Add(time.Second),
+		}, valid_request_id)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Creating a token", func() {
+		Context("When the owner is missing", func() {
+			It("Should error", func() {
+				err = dao.CreateToken(valid_db, &models.Token{Id: "id", Hash: "hash"}, valid_request_id)
+				Expect(err).To(MatchError(models.ErrorMissingTokenOwner))
+			})
+		})
+
+		Context("When the hash already exists", func() {
+			It("Should error", func() {
+				err = dao.CreateToken(valid_db, &models.Token{Id: "id", Owner: "bob", Hash: "alice-hash"}, valid_request_id)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("When the database connection is unusable", func() {
+			It("Should error", func() {
+				err = dao.CreateToken(invalid_db, &models.Token{Id: "id", Owner: "bob", Hash: "hash"}, valid_request_id)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Getting a token by hash", func() {
+		Context("When the hash exists", func() {
+			It("Should return the token", func() {
+				token, err = dao.GetTokenByHash(valid_db, "ops-hash", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(token.Owner).To(Equal("ops"))
+				Expect(token.Admin).To(BeTrue())
+			})
+		})
+
+		Context("When the hash does not exist", func() {
+			It("Should return no token", func() {
+				token, err = dao.GetTokenByHash(valid_db, "unknown-hash", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(token).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Getting every token", func() {
+		It("Should return the tokens oldest first", func() {
+			tokens, err = dao.GetTokens(valid_db, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokens).To(HaveLen(2))
+			Expect(tokens[0].Owner).To(Equal("alice"))
+			Expect(tokens[1].Owner).To(Equal("ops"))
+		})
+	})
+
+	Describe("Deleting a token", func() {
+		Context("When the token exists", func() {
+			It("Should delete the token", func() {
+				deleted, err = dao.DeleteToken(valid_db, "b7e3e0a2-4f1c-11e8-9c2d-fa7ae01bbebc", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(int64(1)))
+
+				token, err = dao.GetTokenByHash(valid_db, "alice-hash", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(token).To(BeNil())
+			})
+		})
+
+		Context("When the token does not exist", func() {
+			It("Should delete nothing", func() {
+				deleted, err = dao.DeleteToken(valid_db, "unknown", valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deleted).To(Equal(int64(0)))
+			})
+		})
+	})
+})
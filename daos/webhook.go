@@ -0,0 +1,303 @@
+package daos
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Deliver the status changes of every cluster stored from now on to the
+// urls, as well as to the webhooks registered with each cluster
+func (dao *ClusterDao) WithWebhooks(urls []string) *ClusterDao {
+	dao.webhooks = urls
+	return dao
+}
+
+// URLs registered with the cluster when it was requested
+func (dao *ClusterDao) GetClusterWebhooks(clusterId string, requestId string) ([]string, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_cluster_webhooks", "request": requestId})
+
+	if len(clusterId) == 0 {
+		err := errors.New(models.ErrorMissingId)
+		logger.Error(err)
+		return nil, err
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	urls := []string{}
+	err = tx.Select(&urls, `SELECT url FROM cluster_webhooks WHERE cluster_id = $1`, clusterId)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return urls, nil
+}
+
+// Deliveries of the cluster moving to status, one to each of the urls. Only
+// lifecycle statuses are delivered.
+func statusWebhookDeliveries(urls []string, cluster *models.Cluster, status string) ([]models.WebhookDelivery, error) {
+	if len(urls) == 0 || status == models.ClusterStatusRequested {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(models.WebhookPayload{
+		Event:     status,
+		Timestamp: time.Now(),
+		Cluster:   models.NewWebhookCluster(cluster, status, cluster.Message),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	for _, url := range urls {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ClusterId: cluster.Id,
+			Url:       url,
+			Event:     status,
+			Payload:   body,
+		})
+	}
+
+	return deliveries, nil
+}
+
+// Enqueue deliveries of the cluster moving to status within the transaction
+// moving it, so a change of status is never stored without its deliveries
+func (dao *ClusterDao) enqueueStatusWebhooks(tx *sqlx.Tx, id string, status string) error {
+	if status == models.ClusterStatusRequested {
+		return nil
+	}
+
+	cluster_urls := []string{}
+	err := tx.Select(&cluster_urls, `SELECT url FROM cluster_webhooks WHERE cluster_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	urls := append(append([]string{}, dao.webhooks...), cluster_urls...)
+	if len(urls) == 0 {
+		return nil
+	}
+
+	cluster := models.Cluster{}
+	sql := `SELECT id, name, message, timestamp, expiration, project, region FROM clusters WHERE id = $1`
+	err = tx.Get(&cluster, sql, id)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := statusWebhookDeliveries(urls, &cluster, status)
+	if err != nil {
+		return err
+	}
+
+	return createWebhookDeliveries(tx, deliveries)
+}
+
+// Persist pending deliveries, all of which are due immediately
+func (dao *ClusterDao) CreateWebhookDeliveries(deliveries []models.WebhookDelivery, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "create_webhook_deliveries", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	err = createWebhookDeliveries(tx, deliveries)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+func createWebhookDeliveries(tx *sqlx.Tx, deliveries []models.WebhookDelivery) error {
+	sql := `INSERT INTO webhook_deliveries (
+		cluster_id,
+		url,
+		event,
+		payload,
+		status,
+		attempts,
+		next_attempt,
+		timestamp
+	) VALUES (
+		:cluster_id,
+		:url,
+		:event,
+		:payload,
+		:status,
+		:attempts,
+		:next_attempt,
+		:timestamp
+	)`
+
+	now := time.Now()
+	for _, delivery := range deliveries {
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttempt = now
+		delivery.Timestamp = now
+
+		_, err := tx.NamedExec(sql, delivery)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Claim the oldest pending delivery due by due, deferring its next attempt
+// until until, so no other instance attempts it meanwhile. Returns nil when
+// no delivery is due. Deliveries locked by a concurrent claim are skipped
+// rather than waited on.
+func (dao *ClusterDao) ClaimWebhookDelivery(due time.Time, until time.Time, requestId string) (*models.WebhookDelivery, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "claim_webhook_delivery", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	delivery := models.WebhookDelivery{}
+	query := `UPDATE webhook_deliveries SET next_attempt = $1
+		WHERE id = (SELECT id FROM webhook_deliveries WHERE status = $2 AND next_attempt <= $3 ORDER BY id LIMIT 1` + lockRows(tx, ` FOR UPDATE SKIP LOCKED`) + `)
+		RETURNING *`
+	err = tx.Get(&delivery, query, until, models.WebhookDeliveryPending, due)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	tx.Commit()
+
+	return &delivery, nil
+}
+
+// Record an attempt to deliver and the resulting state of the delivery
+// together, so the attempt count and history never disagree
+func (dao *ClusterDao) RecordWebhookAttempt(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, requestId string) error {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "record_webhook_attempt", "request": requestId})
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	sql := `INSERT INTO webhook_attempts (
+		delivery_id,
+		attempt,
+		timestamp,
+		response_code,
+		error
+	) VALUES (
+		:delivery_id,
+		:attempt,
+		:timestamp,
+		:response_code,
+		:error
+	)`
+	_, err = tx.NamedExec(sql, attempt)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	sql = `UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt = $3 WHERE id = $4`
+	_, err = tx.Exec(sql, delivery.Status, delivery.Attempts, delivery.NextAttempt, delivery.Id)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// The most recent deliveries, of a single cluster when clusterId is not
+// empty, each with the history of its attempts
+func (dao *ClusterDao) GetWebhookDeliveries(clusterId string, limit int, requestId string) ([]models.WebhookDelivery, error) {
+	logger := log.WithFields(log.Fields{"package": "daos", "event": "get_webhook_deliveries", "request": requestId})
+
+	if limit <= 0 {
+		limit = models.DefaultWebhookDeliveryLimit
+	}
+
+	tx, err := dao.db.Beginx()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	sql := `SELECT * FROM webhook_deliveries WHERE ($1 = '' OR cluster_id = $1) ORDER BY id DESC LIMIT $2`
+	err = tx.Select(&deliveries, sql, clusterId, limit)
+	if err != nil {
+		tx.Rollback()
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	ids := []interface{}{}
+	numbered := []string{}
+	index := make(map[int64]int)
+	for i := range deliveries {
+		deliveries[i].History = []models.WebhookAttempt{}
+		ids = append(ids, deliveries[i].Id)
+		numbered = append(numbered, fmt.Sprintf("$%d", len(ids)))
+		index[deliveries[i].Id] = i
+	}
+
+	attempts := []models.WebhookAttempt{}
+	if len(ids) > 0 {
+		sql = fmt.Sprintf(`SELECT * FROM webhook_attempts WHERE delivery_id IN (%s) ORDER BY delivery_id, attempt`, strings.Join(numbered, ", "))
+		err = tx.Select(&attempts, sql, ids...)
+		if err != nil {
+			tx.Rollback()
+			logger.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	tx.Commit()
+
+	for _, attempt := range attempts {
+		i := index[attempt.DeliveryId]
+		deliveries[i].History = append(deliveries[i].History, attempt)
+	}
+
+	return deliveries, nil
+}
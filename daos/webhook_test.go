@@ -0,0 +1,116 @@
+package daos_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Webhook", func() {
+
+	var (
+		dao              *ClusterDao
+		valid_request_id string
+		valid_cluster_id string
+		deliveries       []models.WebhookDelivery
+		err              error
+	)
+
+	BeforeEach(func() {
+		dao = NewClusterDao(valid_db)
+		valid_request_id = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		valid_cluster_id = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+	})
+
+	AfterEach(func() {
+		valid_db.MustExec(truncate_clusters)
+	})
+
+	Describe("Registering webhooks with a cluster", func() {
+		It("Should store the webhooks of the cluster", func() {
+			spec := &models.ClusterSpec{
+				TerraformConfig: []byte(`{}`),
+				Timeout:         "10m",
+				Project:         "project_name",
+				Region:          "region_name",
+				Webhooks:        []string{"http://a.example.com", "http://b.example.com"},
+			}
+			_, err = dao.CreateCluster(spec, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+
+			urls, err := dao.GetClusterWebhooks(valid_request_id, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(urls).To(ConsistOf("http://a.example.com", "http://b.example.com"))
+		})
+	})
+
+	Describe("Delivering webhooks", func() {
+		BeforeEach(func() {
+			err = dao.CreateWebhookDeliveries([]models.WebhookDelivery{
+				{ClusterId: valid_cluster_id, Url: "http://a.example.com", Event: models.ClusterStatusProvisionStart, Payload: []byte(`{}`)},
+				{ClusterId: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Url: "http://a.example.com", Event: models.ClusterStatusProvisionStart, Payload: []byte(`{}`)},
+			}, valid_request_id)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("When deliveries are created", func() {
+			It("Should be pending and due", func() {
+				delivery, err := dao.ClaimWebhookDelivery(time.Now(), time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delivery.Status).To(Equal(models.WebhookDeliveryPending))
+				Expect(delivery.Payload).To(Equal([]byte(`{}`)))
+			})
+			It("Should only be claimed once", func() {
+				for i := 0; i < 2; i++ {
+					delivery, err := dao.ClaimWebhookDelivery(time.Now(), time.Now().Add(time.Minute), valid_request_id)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(delivery).NotTo(BeNil())
+				}
+
+				delivery, err := dao.ClaimWebhookDelivery(time.Now(), time.Now().Add(time.Minute), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delivery).To(BeNil())
+			})
+		})
+
+		Context("When an attempt is recorded", func() {
+			BeforeEach(func() {
+				claimed, err := dao.ClaimWebhookDelivery(time.Now(), time.Now(), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+
+				delivery := *claimed
+				delivery.Attempts = 1
+				delivery.NextAttempt = time.Now().Add(time.Hour)
+				attempt := &models.WebhookAttempt{DeliveryId: delivery.Id, Attempt: 1, Timestamp: time.Now(), ResponseCode: 500, Error: "failed"}
+				err = dao.RecordWebhookAttempt(&delivery, attempt, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should not be due until the next attempt", func() {
+				delivery, err := dao.ClaimWebhookDelivery(time.Now(), time.Now(), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delivery.ClusterId).NotTo(Equal(valid_cluster_id))
+
+				delivery, err = dao.ClaimWebhookDelivery(time.Now(), time.Now(), valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(delivery).To(BeNil())
+			})
+			It("Should list the deliveries of the cluster with their attempts", func() {
+				deliveries, err = dao.GetWebhookDeliveries(valid_cluster_id, 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deliveries).To(HaveLen(1))
+				Expect(deliveries[0].Attempts).To(Equal(1))
+				Expect(deliveries[0].History).To(HaveLen(1))
+				Expect(deliveries[0].History[0].ResponseCode).To(Equal(500))
+			})
+			It("Should list the deliveries of every cluster", func() {
+				deliveries, err = dao.GetWebhookDeliveries("", 0, valid_request_id)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deliveries).To(HaveLen(2))
+			})
+		})
+	})
+})
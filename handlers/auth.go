@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type authenticator interface {
+	Authenticate(request_id string, secret string) (*models.Token, error)
+}
+
+// Middleware refusing requests without a valid bearer token. The owner of
+// the token is added to the request context, which must already exist.
+func WithAuthentication(auth authenticator) app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request_context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "authenticate", "request": request_context.RequestId()})
+
+			secret := ""
+			header := r.Header.Get("Authorization")
+			if strings.HasPrefix(header, "Bearer ") {
+				secret = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+			}
+
+			token, err := auth.Authenticate(request_context.RequestId(), secret)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorMissingToken, models.ErrorInvalidToken:
+					status = http.StatusUnauthorized
+					w.Header().Set("WWW-Authenticate", "Bearer")
+				}
+				response := ErrorResponseAttributes{Title: "authentication_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, request_context.RequestId()), status)
+				return
+			}
+
+			request_context.SetPrincipal(token.Owner, token.Id, token.Admin)
+			ctx := context.WithValue(r.Context(), app.RequestContextKey, request_context)
+
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Authenticate requests with the tokens stored in the database
+func authenticated(db *sqlx.DB) app.Adapter {
+	return WithAuthentication(services.NewTokenService(daos.NewTokenDao(), db, app.GlobalServerConfig.AdminToken))
+}
+
+// Respond with a 403 unless the request was authenticated as an admin
+func requireAdmin(w http.ResponseWriter, request_context app.RequestContext, title string) bool {
+	if request_context.Admin() {
+		return true
+	}
+
+	response := ErrorResponseAttributes{Title: title, Detail: models.ErrorAdminRequired}
+	respondWithJson(w, newErrorResponse(&response, request_context.RequestId()), http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Auth", func() {
+
+	var (
+		response *httptest.ResponseRecorder
+		resp     *http.Response
+		reached  bool
+		owner    string
+		admin    bool
+	)
+
+	serve := func(auth *StaticAuthenticator, authorization string) {
+		reached = false
+
+		// Record the principal seen by the handler behind the middleware
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request_context := app.GetRequestContext(r)
+			reached = true
+			owner = request_context.Owner()
+			admin = request_context.Admin()
+		})
+		handler := WithAuthentication(auth)(next)
+
+		request := httptest.NewRequest("GET", "/clusters", nil)
+		if len(authorization) > 0 {
+			request.Header.Set("Authorization", authorization)
+		}
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+	})
+
+	Context("When the bearer token is valid", func() {
+		BeforeEach(func() {
+			serve(&StaticAuthenticator{}, "Bearer alice-token")
+		})
+		It("Should pass the request on", func() {
+			Expect(reached).To(BeTrue())
+		})
+		It("Should add the owner of the token to the request context", func() {
+			Expect(owner).To(Equal("alice"))
+			Expect(admin).To(BeFalse())
+		})
+	})
+
+	Context("When the bearer token is an admin token", func() {
+		BeforeEach(func() {
+			serve(&StaticAuthenticator{}, "Bearer admin-token")
+		})
+		It("Should add the admin to the request context", func() {
+			Expect(reached).To(BeTrue())
+			Expect(admin).To(BeTrue())
+		})
+	})
+
+	Context("When the bearer token is missing", func() {
+		BeforeEach(func() {
+			serve(&StaticAuthenticator{}, "")
+		})
+		It("Should return a 401", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("WWW-Authenticate")).To(Equal("Bearer"))
+		})
+		It("Should not pass the request on", func() {
+			Expect(reached).To(BeFalse())
+		})
+	})
+
+	Context("When the bearer token is invalid", func() {
+		BeforeEach(func() {
+			serve(&StaticAuthenticator{}, "Bearer unknown-token")
+		})
+		It("Should return a 401", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+		It("Should not pass the request on", func() {
+			Expect(reached).To(BeFalse())
+		})
+	})
+
+	Context("When the token cannot be checked", func() {
+		BeforeEach(func() {
+			serve(&StaticAuthenticator{err: errors.New("database unavailable")}, "Bearer alice-token")
+		})
+		It("Should return a 500", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
+
+/*
+ * Static Authenticator knows a token for alice and an admin token
+ */
+type StaticAuthenticator struct {
+	err error
+}
+
+func (a *StaticAuthenticator) Authenticate(request_id string, secret string) (*models.Token, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	switch secret {
+	case "":
+		return nil, errors.New(models.ErrorMissingToken)
+	case "alice-token":
+		return &models.Token{Owner: "alice"}, nil
+	case "admin-token":
+		return &models.Token{Owner: models.AdminTokenOwner, Admin: true}, nil
+	}
+	return nil, errors.New(models.ErrorInvalidToken)
+}
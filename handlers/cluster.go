@@ -2,12 +2,21 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
@@ -21,46 +30,155 @@ import (
 
 type clusterService interface {
 	GetCluster(request_id string, id string) (*models.Cluster, error)
-	GetClusters(request_id string) ([]models.Cluster, error)
+	GetClusters(request_id string, filter *models.ClusterFilter) ([]models.Cluster, string, error)
 	GetExpiredClusters(requestId string) ([]models.Cluster, error)
-	CreateCluster(terraform_config []byte, timeout string, project string, region string, request_id string, client services.TerraformClient) (*models.Cluster, error)
-	DeleteCluster(request_id string, client services.TerraformClient, id string) (*models.Cluster, error)
+	CreateCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error)
+	PlanCluster(ctx context.Context, spec *models.ClusterSpec, request_id string, client services.TerraformClient) (*terraform.PlanSummary, error)
+	DeleteCluster(request_id string, id string) (*models.Cluster, error)
+	CancelCluster(request_id string, id string) (*models.Cluster, error)
+	RetryDestroyCluster(request_id string, id string) (*models.Cluster, error)
+	UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error)
+	SubscribeClusterEvents(id string) (<-chan models.ClusterEvent, func())
+	GetClusterLogs(request_id string, id string, operation string, after int64) ([]models.ClusterLog, error)
+	GetClusterHistory(request_id string, id string) ([]models.ClusterHistoryEvent, error)
+	RecordClusterAction(request_id string, id string, action string, actor string, message string) error
+}
+
+// Interval between comments written to otherwise idle event streams
+const eventStreamKeepalive = 15 * time.Second
+
+// Interval between checks for new lines when following cluster logs
+const clusterLogPollInterval = time.Second
+
+type templateService interface {
+	RenderTemplate(request_id string, name string, version int, variables map[string]interface{}) (*models.Template, []byte, error)
 }
 
 type ClusterHandler struct {
-	service clusterService
+	service   clusterService
+	templates templateService
+	access    authorizer
 }
 
 func NewClusterHandler(service clusterService) *ClusterHandler {
-	return &ClusterHandler{service}
+	return &ClusterHandler{service: service}
+}
+
+// Allow clusters to be requested from a template instead of a config
+func (ch *ClusterHandler) WithTemplates(templates templateService) *ClusterHandler {
+	ch.templates = templates
+	return ch
+}
+
+// Check the roles of callers within the project of a cluster before the
+// cluster is created, read, changed or deleted
+func (ch *ClusterHandler) WithAccess(access authorizer) *ClusterHandler {
+	ch.access = access
+	return ch
 }
 
-func ServeClusterResources(router *mux.Router, db *sqlx.DB) {
-	handler := NewClusterHandler(services.NewClusterService(daos.NewClusterDao(), db))
+func ServeClusterResources(router *mux.Router, db *sqlx.DB, store daos.ClusterStore) {
+	handler := NewClusterHandler(services.NewClusterService(store)).
+		WithTemplates(services.NewTemplateService(daos.NewTemplateDao(), db)).
+		WithAccess(services.NewRoleService(daos.NewRoleDao(), db))
+	auth := authenticated(db)
 
 	router.Handle("/cluster/{id}", app.Adapt(
 		router,
 		handler.GetCluster(),
+		auth,
 		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
 	)).Methods("GET")
 
 	router.Handle("/clusters", app.Adapt(
 		router,
 		handler.GetClusters(),
+		auth,
 		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
 	)).Methods("GET")
 
 	router.Handle("/cluster", app.Adapt(
 		router,
 		handler.CreateCluster(),
+		auth,
 		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
 	)).Methods("PUT")
 
+	// Planning initializes terraform providers and takes longer than
+	//  other requests
+	router.Handle("/cluster/plan", app.Adapt(
+		router,
+		handler.PlanCluster(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.PlanTimeout),
+	)).Methods("POST")
+
 	router.Handle("/cluster/{id}", app.Adapt(
 		router,
 		handler.DeleteCluster(),
+		auth,
 		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
 	)).Methods("DELETE")
+
+	router.Handle("/cluster/{id}/cancel", app.Adapt(
+		router,
+		handler.CancelCluster(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("POST")
+
+	router.Handle("/cluster/{id}/destroy/retry", app.Adapt(
+		router,
+		handler.RetryDestroyCluster(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("POST")
+
+	router.Handle("/cluster/{id}/expiration", app.Adapt(
+		router,
+		handler.UpdateClusterExpiration(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("PATCH")
+
+	// Event streams are long-lived and therefore without a timeout
+	router.Handle("/cluster/{id}/events", app.Adapt(
+		router,
+		handler.StreamClusterEvents(),
+		auth,
+		app.WithRequestContext(),
+	)).Methods("GET")
+
+	router.Handle("/clusters/events", app.Adapt(
+		router,
+		handler.StreamClusterEvents(),
+		auth,
+		app.WithRequestContext(),
+	)).Methods("GET")
+
+	// Following logs lasts as long as the terraform operation
+	router.Handle("/cluster/{id}/logs", app.Adapt(
+		router,
+		handler.GetClusterLogs(),
+		auth,
+		app.WithRequestContext(),
+	)).Methods("GET")
+
+	router.Handle("/cluster/{id}/history", app.Adapt(
+		router,
+		handler.GetClusterHistory(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
 }
 
 func getBytes(data interface{}) ([]byte, error) {
@@ -106,24 +224,119 @@ func (ch *ClusterHandler) CreateCluster() app.Adapter {
 
 			logger.Info(fmt.Sprintf("new request to create cluster '%+v'", cluster_request))
 
-			cluster, err := ch.service.CreateCluster([]byte(cluster_request.TerraformConfig), cluster_request.Timeout, cluster_request.Project, cluster_request.Region, context.RequestId(), terraform.NewTerraformClient())
+			spec := newClusterSpec(&cluster_request)
+			if status, err := ch.renderClusterTemplate(&cluster_request, spec, context.RequestId()); err != nil {
+				response := ErrorResponseAttributes{Title: "create_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+			// Requests without a project are refused by the service
+			if len(spec.Project) > 0 && !authorized(w, ch.access, context, spec.Project, models.PermissionCreateClusters, "create_cluster_error") {
+				return
+			}
+
+			spec.Owner = context.Owner()
+			spec.IdempotencyKey = r.Header.Get("Idempotency-Key")
+			digest := sha256.Sum256(body)
+			spec.RequestHash = hex.EncodeToString(digest[:])
+
+			cluster, err := ch.service.CreateCluster(spec, context.RequestId())
 
 			// Currently no expectation for the situation that
 			// err == nil && cluster == nil
 			// If a cluster is not returned, then an err has occured
 			// Eventually this may capture the situation where resources are not available
 			if err != nil || cluster == nil {
+				status := http.StatusInternalServerError
+				if err == nil {
+					err = errors.New("cluster not created")
+				}
+				switch {
+				case err.Error() == models.ErrorInvalidIdempotencyKey, strings.HasPrefix(err.Error(), models.ErrorInvalidWebhookUrl):
+					status = http.StatusBadRequest
+				case err.Error() == models.ErrorIdempotencyKeyMismatch, err.Error() == models.ErrorIdempotencyKeyInUse:
+					status = http.StatusConflict
+				case strings.HasPrefix(err.Error(), models.ErrorQuotaExceeded):
+					status = http.StatusForbidden
+				}
 				response := ErrorResponseAttributes{Title: "create_cluster_error", Detail: err.Error()}
 				logger.Error(err.Error())
-				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
 				return
 			}
 
+			// Clusters take the id of the request creating them, any other
+			//  id is a cluster created by an earlier request with the same key
+			if len(spec.IdempotencyKey) > 0 && cluster.Id != context.RequestId() {
+				w.Header().Set("Idempotent-Replayed", "true")
+			}
+
 			respondWithJson(w, newClusterResponse(cluster, context.RequestId()), http.StatusAccepted)
 		})
 	}
 }
 
+// Plan the requested cluster and respond with a summary of the changes
+// terraform would make, nothing is persisted or provisioned
+func (ch *ClusterHandler) PlanCluster() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "plan_cluster", "request": context.RequestId()})
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "plan_cluster_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			cluster_request := ClusterRequest{}
+			err = json.Unmarshal(body, &cluster_request)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "plan_cluster_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to plan cluster '%+v'", cluster_request))
+
+			spec := newClusterSpec(&cluster_request)
+			if status, err := ch.renderClusterTemplate(&cluster_request, spec, context.RequestId()); err != nil {
+				response := ErrorResponseAttributes{Title: "plan_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			// Planning uses the credentials of the project as creating does
+			if len(spec.Project) > 0 && !authorized(w, ch.access, context, spec.Project, models.PermissionCreateClusters, "plan_cluster_error") {
+				return
+			}
+
+			summary, err := ch.service.PlanCluster(r.Context(), spec, context.RequestId(), terraform.NewTerraformClient())
+			if err != nil {
+				// Terraform refusing the config is the most likely failure
+				status := http.StatusUnprocessableEntity
+				switch err.Error() {
+				case models.ErrorMissingConfig, models.ErrorMissingProject, models.ErrorMissingRegion:
+					status = http.StatusBadRequest
+				}
+				response := ErrorResponseAttributes{Title: "plan_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			respondWithJson(w, newPlanResponse(summary, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
 // Retrieve a single Cluster for a given id
 func (ch *ClusterHandler) GetCluster() app.Adapter {
 	return func(h http.Handler) http.Handler {
@@ -145,20 +358,8 @@ func (ch *ClusterHandler) GetCluster() app.Adapter {
 
 			logger.Info(fmt.Sprintf("new request to get cluster '%v'", id))
 
-			cluster, err := ch.service.GetCluster(context.RequestId(), id)
-			if err != nil {
-				response := ErrorResponseAttributes{Title: "get_cluster_error", Detail: err.Error()}
-				logger.Error(err.Error())
-				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
-				return
-			}
-
-			// Cluster does not exist
-			if cluster == nil {
-				err := errors.New("cluster not found")
-				response := ErrorResponseAttributes{Title: "get_cluster_error", Detail: err.Error()}
-				logger.Error(err)
-				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusNotFound)
+			cluster, ok := ch.readableCluster(w, context, id, "get_cluster_error")
+			if !ok {
 				return
 			}
 
@@ -169,7 +370,7 @@ func (ch *ClusterHandler) GetCluster() app.Adapter {
 	}
 }
 
-// Retrieve a ClusterList of all Clusters
+// Retrieve a ClusterList of Clusters matching the query parameters
 func (ch *ClusterHandler) GetClusters() app.Adapter {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -177,15 +378,47 @@ func (ch *ClusterHandler) GetClusters() app.Adapter {
 
 			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_clusters", "request": context.RequestId()})
 
-			clusters, err := ch.service.GetClusters(context.RequestId())
+			filter, err := newClusterFilter(r.URL.Query())
 			if err != nil {
 				response := ErrorResponseAttributes{Title: "get_clusters_error", Detail: err.Error()}
 				logger.Error(err.Error())
-				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
 				return
 			}
 
-			respondWithJson(w, newClustersResponse(clusters, context.RequestId()), http.StatusOK)
+			err = ch.scopeClusterFilter(filter, r.URL.Query(), context)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorAdminRequired || permissionDenied(err) {
+					status = http.StatusForbidden
+				}
+				response := ErrorResponseAttributes{Title: "get_clusters_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			clusters, cursor, err := ch.service.GetClusters(context.RequestId(), filter)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorInvalidClusterSort, models.ErrorInvalidClusterLimit, models.ErrorInvalidCursor:
+					status = http.StatusBadRequest
+				}
+				response := ErrorResponseAttributes{Title: "get_clusters_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			next := ""
+			if len(cursor) > 0 {
+				query := r.URL.Query()
+				query.Set("cursor", cursor)
+				next = r.URL.Path + "?" + query.Encode()
+			}
+
+			respondWithJson(w, newClustersResponse(clusters, next, context.RequestId()), http.StatusOK)
 		})
 	}
 }
@@ -210,7 +443,7 @@ func (ch *ClusterHandler) DeleteCluster() app.Adapter {
 
 			logger.Info(fmt.Sprintf("new request to delete cluster '%v'", id))
 
-			cluster, err := ch.service.DeleteCluster(context.RequestId(), terraform.NewTerraformClient(), id)
+			existing, err := ch.service.GetCluster(context.RequestId(), id)
 			if err != nil {
 				response := ErrorResponseAttributes{Title: "delete_cluster_error", Detail: err.Error()}
 				logger.Error(err.Error())
@@ -218,6 +451,36 @@ func (ch *ClusterHandler) DeleteCluster() app.Adapter {
 				return
 			}
 
+			if existing == nil {
+				err := errors.New("cluster not found")
+				response := ErrorResponseAttributes{Title: "delete_cluster_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusNotFound)
+				return
+			}
+
+			// Clusters of other owners are deleted by the project's admins
+			permission := models.PermissionDeleteClusters
+			if existing.Owner != context.Owner() {
+				permission = models.PermissionDeleteAnyCluster
+			}
+			if !authorized(w, ch.access, context, existing.Project, permission, "delete_cluster_error") {
+				return
+			}
+
+			cluster, err := ch.service.DeleteCluster(context.RequestId(), id)
+			if err != nil {
+				status := http.StatusInternalServerError
+				// Another operation on the cluster must finish first
+				if err.Error() == models.ErrorClusterJobActive || err.Error() == models.ErrorClusterJobNotAllowed {
+					status = http.StatusConflict
+				}
+				response := ErrorResponseAttributes{Title: "delete_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
 			if cluster == nil {
 				err := errors.New("cluster not found")
 				response := ErrorResponseAttributes{Title: "delete_cluster_error", Detail: err.Error()}
@@ -226,6 +489,8 @@ func (ch *ClusterHandler) DeleteCluster() app.Adapter {
 				return
 			}
 
+			ch.recordClusterAction(context, id, models.ClusterActionDelete, "")
+
 			logger.Info(fmt.Sprintf("responding to client with deleted cluster '%v'", id))
 
 			respondWithJson(w, newClusterResponse(cluster, context.RequestId()), http.StatusAccepted)
@@ -233,6 +498,650 @@ func (ch *ClusterHandler) DeleteCluster() app.Adapter {
 	}
 }
 
+// Cancel provisioning of a Cluster, rolling back whatever was provisioned
+func (ch *ClusterHandler) CancelCluster() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "cancel_cluster", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+			id := vars["id"]
+
+			if len(id) <= 0 {
+				err := errors.New("missing required cluster id")
+				response := ErrorResponseAttributes{Title: "cancel_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to cancel cluster '%v'", id))
+
+			existing, err := ch.service.GetCluster(context.RequestId(), id)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "cancel_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			if existing == nil {
+				err := errors.New("cluster not found")
+				response := ErrorResponseAttributes{Title: "cancel_cluster_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusNotFound)
+				return
+			}
+
+			// Cancelling destroys whatever was provisioned, so requires
+			//  the same permission as deleting the cluster
+			permission := models.PermissionDeleteClusters
+			if existing.Owner != context.Owner() {
+				permission = models.PermissionDeleteAnyCluster
+			}
+			if !authorized(w, ch.access, context, existing.Project, permission, "cancel_cluster_error") {
+				return
+			}
+
+			cluster, err := ch.service.CancelCluster(context.RequestId(), id)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorClusterJobNotRunning {
+					status = http.StatusConflict
+				}
+				response := ErrorResponseAttributes{Title: "cancel_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			if cluster == nil {
+				err := errors.New("cluster not found")
+				response := ErrorResponseAttributes{Title: "cancel_cluster_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusNotFound)
+				return
+			}
+
+			ch.recordClusterAction(context, id, models.ClusterActionCancel, "")
+
+			logger.Info(fmt.Sprintf("responding to client with cancelled cluster '%v'", id))
+
+			respondWithJson(w, newClusterResponse(cluster, context.RequestId()), http.StatusAccepted)
+		})
+	}
+}
+
+// Destroy a Cluster which failed to be destroyed without waiting for its
+// next automatic retry
+func (ch *ClusterHandler) RetryDestroyCluster() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "retry_destroy_cluster", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+			id := vars["id"]
+
+			if len(id) <= 0 {
+				err := errors.New("missing required cluster id")
+				response := ErrorResponseAttributes{Title: "retry_destroy_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to retry destroying cluster '%v'", id))
+
+			existing, err := ch.service.GetCluster(context.RequestId(), id)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "retry_destroy_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			if existing == nil {
+				err := errors.New("cluster not found")
+				response := ErrorResponseAttributes{Title: "retry_destroy_cluster_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusNotFound)
+				return
+			}
+
+			permission := models.PermissionDeleteClusters
+			if existing.Owner != context.Owner() {
+				permission = models.PermissionDeleteAnyCluster
+			}
+			if !authorized(w, ch.access, context, existing.Project, permission, "retry_destroy_cluster_error") {
+				return
+			}
+
+			cluster, err := ch.service.RetryDestroyCluster(context.RequestId(), id)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorClusterNotFound:
+					status = http.StatusNotFound
+				case models.ErrorClusterNotDestroyFailed, models.ErrorClusterJobActive, models.ErrorClusterJobNotAllowed:
+					status = http.StatusConflict
+				}
+				response := ErrorResponseAttributes{Title: "retry_destroy_cluster_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			ch.recordClusterAction(context, id, models.ClusterActionRetryDestroy, "")
+
+			logger.Info(fmt.Sprintf("responding to client with cluster '%v' set to destroy", id))
+
+			respondWithJson(w, newClusterResponse(cluster, context.RequestId()), http.StatusAccepted)
+		})
+	}
+}
+
+// Extend, shorten or immediately expire a live Cluster
+func (ch *ClusterHandler) UpdateClusterExpiration() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "update_cluster_expiration", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+			id := vars["id"]
+
+			if len(id) <= 0 {
+				err := errors.New("missing required cluster id")
+				response := ErrorResponseAttributes{Title: "update_cluster_expiration_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "update_cluster_expiration_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			expiration_request := ExpirationRequest{}
+			err = json.Unmarshal(body, &expiration_request)
+			if err != nil || len(expiration_request.Timeout) == 0 {
+				err := errors.New("Missing required timeout for update cluster expiration request")
+				response := ErrorResponseAttributes{Title: "update_cluster_expiration_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to update expiration of cluster '%v' to '%v' from now", id, expiration_request.Timeout))
+
+			existing, ok := ch.readableCluster(w, context, id, "update_cluster_expiration_error")
+			if !ok {
+				return
+			}
+
+			// Expiring the cluster of another owner destroys it, so requires
+			//  the same permission as deleting it
+			permission := models.PermissionUpdateClusters
+			if existing.Owner != context.Owner() {
+				permission = models.PermissionDeleteAnyCluster
+			}
+			if !authorized(w, ch.access, context, existing.Project, permission, "update_cluster_expiration_error") {
+				return
+			}
+
+			cluster, err := ch.service.UpdateClusterExpiration(context.RequestId(), id, expiration_request.Timeout)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch {
+				case err.Error() == models.ErrorInvalidTimeout, err.Error() == models.ErrorExceedsMaxLifetime:
+					status = http.StatusBadRequest
+				case err.Error() == models.ErrorClusterNotLive:
+					status = http.StatusConflict
+				case err.Error() == models.ErrorClusterNotFound:
+					status = http.StatusNotFound
+				case strings.HasPrefix(err.Error(), models.ErrorQuotaExceeded):
+					status = http.StatusForbidden
+				}
+				response := ErrorResponseAttributes{Title: "update_cluster_expiration_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			ch.recordClusterAction(context, id, models.ClusterActionExtend, fmt.Sprintf("expires at '%v'", cluster.Expiration))
+
+			logger.Info(fmt.Sprintf("responding to client with cluster '%v' expiring at '%v'", id, cluster.Expiration))
+
+			respondWithJson(w, newClusterResponse(cluster, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Stream status and message changes of a single Cluster, or of all Clusters
+// the caller may read when no id is given, as Server-Sent Events until the
+// client disconnects
+func (ch *ClusterHandler) StreamClusterEvents() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "stream_cluster_events", "request": context.RequestId()})
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				err := errors.New("streaming is not supported")
+				response := ErrorResponseAttributes{Title: "stream_cluster_events_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			vars := mux.Vars(r)
+			id := vars["id"]
+
+			// Subscribe before reading the current state so that no change
+			//  between the two is missed
+			events, unsubscribe := ch.service.SubscribeClusterEvents(id)
+			defer unsubscribe()
+
+			var current *models.Cluster
+			if len(id) > 0 {
+				cluster, ok := ch.readableCluster(w, context, id, "stream_cluster_events_error")
+				if !ok {
+					return
+				}
+				current = cluster
+			}
+
+			logger.Info(fmt.Sprintf("new request to stream events of cluster '%v'", id))
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.WriteHeader(http.StatusOK)
+
+			if current != nil {
+				writeClusterEvent(w, models.ClusterEvent{
+					ClusterId: current.Id,
+					Status:    current.Status,
+					Message:   current.Message,
+					Timestamp: time.Now(),
+				})
+			}
+			flusher.Flush()
+
+			// Comments keep idle connections from being closed by proxies
+			keepalive := time.NewTicker(eventStreamKeepalive)
+			defer keepalive.Stop()
+
+			// The events of a single cluster were authorized reading it, the
+			//  events of every cluster are only those of clusters the caller
+			//  owns or may read the project of, checked once per project
+			readable := map[string]bool{}
+
+			for {
+				select {
+				case event, ok := <-events:
+					if !ok {
+						return
+					}
+					if current == nil && event.Owner != context.Owner() {
+						allowed, checked := readable[event.Project]
+						if !checked {
+							allowed = authorize(ch.access, context, event.Project, models.PermissionReadClusters) == nil
+							readable[event.Project] = allowed
+						}
+						if !allowed {
+							continue
+						}
+					}
+					writeClusterEvent(w, event)
+					flusher.Flush()
+				case <-keepalive.C:
+					fmt.Fprint(w, ": keepalive\n\n")
+					flusher.Flush()
+				case <-r.Context().Done():
+					logger.Info(fmt.Sprintf("client closed event stream of cluster '%v'", id))
+					return
+				}
+			}
+		})
+	}
+}
+
+// Retrieve the terraform output of a cluster. With follow=true the lines
+// are streamed as plain text until the running operation has finished.
+func (ch *ClusterHandler) GetClusterLogs() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_cluster_logs", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+			id := vars["id"]
+			query := r.URL.Query()
+			operation := query.Get("operation")
+
+			after := int64(0)
+			if value := query.Get("after"); len(value) > 0 {
+				parsed, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					response := ErrorResponseAttributes{Title: "get_cluster_logs_error", Detail: models.ErrorInvalidClusterLogAfter}
+					logger.Error(err)
+					respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+					return
+				}
+				after = parsed
+			}
+
+			cluster, ok := ch.readableCluster(w, context, id, "get_cluster_logs_error")
+			if !ok {
+				return
+			}
+
+			logs, err := ch.service.GetClusterLogs(context.RequestId(), id, operation, after)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorInvalidClusterLogAfter {
+					status = http.StatusBadRequest
+				}
+				response := ErrorResponseAttributes{Title: "get_cluster_logs_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			if query.Get("follow") != "true" {
+				respondWithJson(w, newClusterLogsResponse(logs, context.RequestId()), http.StatusOK)
+				return
+			}
+
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				err := errors.New("streaming is not supported")
+				response := ErrorResponseAttributes{Title: "get_cluster_logs_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to follow logs of cluster '%v'", id))
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+
+			poll := time.NewTicker(clusterLogPollInterval)
+			defer poll.Stop()
+
+			for {
+				for _, line := range logs {
+					fmt.Fprintln(w, line.Line)
+					after = line.Id
+				}
+				flusher.Flush()
+
+				// Only stop once an idle poll finds the operation finished,
+				//  so lines written just before it finished are not missed
+				if len(logs) == 0 && !clusterOperationRunning(cluster) {
+					return
+				}
+
+				if len(logs) < models.MaxClusterLogLines {
+					select {
+					case <-poll.C:
+					case <-r.Context().Done():
+						logger.Info(fmt.Sprintf("client stopped following logs of cluster '%v'", id))
+						return
+					}
+				}
+
+				cluster, err = ch.service.GetCluster(context.RequestId(), id)
+				if err != nil || cluster == nil {
+					logger.Error(err)
+					return
+				}
+
+				logs, err = ch.service.GetClusterLogs(context.RequestId(), id, operation, after)
+				if err != nil {
+					logger.Error(err)
+					return
+				}
+			}
+		})
+	}
+}
+
+// Whether terraform may still be writing output for the cluster
+func clusterOperationRunning(cluster *models.Cluster) bool {
+	switch cluster.Status {
+	case models.ClusterStatusRequested, models.ClusterStatusProvisionStart, models.ClusterStatusDestroying:
+		return true
+	}
+	return false
+}
+
+// Timeline of everything that happened to a Cluster, with the time spent in
+// each status and taken by each operation
+func (ch *ClusterHandler) GetClusterHistory() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_cluster_history", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+			id := vars["id"]
+
+			logger.Info(fmt.Sprintf("new request to get history of cluster '%v'", id))
+
+			if _, ok := ch.readableCluster(w, context, id, "get_cluster_history_error"); !ok {
+				return
+			}
+
+			events, err := ch.service.GetClusterHistory(context.RequestId(), id)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_cluster_history_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			respondWithJson(w, newClusterHistoryResponse(events, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+type clusterReader interface {
+	GetCluster(request_id string, id string) (*models.Cluster, error)
+}
+
+// Retrieve the cluster with the id for the caller. Clusters of other owners
+// are only visible to admins, to everyone else they do not exist.
+func ownedCluster(w http.ResponseWriter, clusters clusterReader, request_context app.RequestContext, id string, title string) (*models.Cluster, bool) {
+	return findCluster(w, clusters, request_context, id, title, func(cluster *models.Cluster) bool {
+		return cluster.Owner == request_context.Owner() || request_context.Admin()
+	})
+}
+
+// Retrieve the cluster with the id for the caller. Clusters of other owners
+// are read by the viewers of their project.
+func (ch *ClusterHandler) readableCluster(w http.ResponseWriter, request_context app.RequestContext, id string, title string) (*models.Cluster, bool) {
+	cluster, ok := findCluster(w, ch.service, request_context, id, title, func(*models.Cluster) bool { return true })
+	if !ok {
+		return nil, false
+	}
+
+	if cluster.Owner != request_context.Owner() && !authorized(w, ch.access, request_context, cluster.Project, models.PermissionReadClusters, title) {
+		return nil, false
+	}
+
+	return cluster, true
+}
+
+// Retrieve the cluster with the id, responding with a 404 when it does not
+// exist or is not visible to the caller
+func findCluster(w http.ResponseWriter, clusters clusterReader, request_context app.RequestContext, id string, title string, visible func(*models.Cluster) bool) (*models.Cluster, bool) {
+	logger := log.WithFields(log.Fields{"package": "handlers", "event": "find_cluster", "request": request_context.RequestId()})
+
+	cluster, err := clusters.GetCluster(request_context.RequestId(), id)
+	if err == sql.ErrNoRows {
+		cluster, err = nil, nil
+	}
+	if err != nil {
+		response := ErrorResponseAttributes{Title: title, Detail: err.Error()}
+		logger.Error(err.Error())
+		respondWithJson(w, newErrorResponse(&response, request_context.RequestId()), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if cluster == nil || !visible(cluster) {
+		err := errors.New("cluster not found")
+		response := ErrorResponseAttributes{Title: title, Detail: err.Error()}
+		logger.Error(err)
+		respondWithJson(w, newErrorResponse(&response, request_context.RequestId()), http.StatusNotFound)
+		return nil, false
+	}
+
+	return cluster, true
+}
+
+// Record an action the caller took on a cluster in its history. The action
+// has already been taken, so failing to record it is only logged.
+func (ch *ClusterHandler) recordClusterAction(context app.RequestContext, id string, action string, message string) {
+	logger := log.WithFields(log.Fields{"package": "handlers", "event": "record_cluster_action", "request": context.RequestId()})
+
+	err := ch.service.RecordClusterAction(context.RequestId(), id, action, context.Owner(), message)
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to record '%v' of cluster '%v' by '%v': %v", action, id, context.Owner(), err))
+	}
+}
+
+func newClusterHistoryResponse(events []models.ClusterHistoryEvent, request_id string) *ClusterHistoryResponse {
+	if events == nil {
+		events = []models.ClusterHistoryEvent{}
+	}
+
+	response_data := ClusterHistoryResponseData{Type: "cluster_history", Attributes: events}
+	return &ClusterHistoryResponse{RequestId: request_id, Data: response_data}
+}
+
+func newClusterLogsResponse(logs []models.ClusterLog, request_id string) *ClusterLogsResponse {
+	if logs == nil {
+		logs = []models.ClusterLog{}
+	}
+
+	response_data := ClusterLogsResponseData{Type: "cluster_logs", Attributes: logs}
+	return &ClusterLogsResponse{RequestId: request_id, Data: response_data}
+}
+
+func writeClusterEvent(w http.ResponseWriter, event models.ClusterEvent) {
+	logger := log.WithFields(log.Fields{"package": "handlers", "event": "cluster_event", "request": event.ClusterId})
+
+	js, err := json.Marshal(event)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	fmt.Fprintf(w, "event: cluster\ndata: %s\n\n", js)
+}
+
+func newClusterSpec(cluster_request *ClusterRequest) *models.ClusterSpec {
+	return &models.ClusterSpec{
+		TerraformConfig: []byte(cluster_request.TerraformConfig),
+		Timeout:         cluster_request.Timeout,
+		Project:         cluster_request.Project,
+		Region:          cluster_request.Region,
+		Webhooks:        cluster_request.Webhooks,
+		Variables:       newClusterVariables(cluster_request),
+	}
+}
+
+func newClusterVariables(cluster_request *ClusterRequest) models.ClusterVariables {
+	sensitive := make(map[string]bool)
+	for _, name := range cluster_request.SensitiveVariables {
+		sensitive[name] = true
+	}
+
+	variables := models.ClusterVariables{}
+	for name, value := range cluster_request.Variables {
+		variables[name] = models.ClusterVariable{Value: value, Sensitive: sensitive[name]}
+	}
+
+	return variables
+}
+
+// Names of the variables whose values are withheld from responses
+func sensitiveVariableNames(variables models.ClusterVariables) []string {
+	names := []string{}
+	for name, variable := range variables {
+		if variable.Sensitive {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render the template of a cluster request into the config of its spec.
+// Requests without a template are left untouched. The status to respond
+// with is returned alongside any error.
+func (ch *ClusterHandler) renderClusterTemplate(cluster_request *ClusterRequest, spec *models.ClusterSpec, request_id string) (int, error) {
+	if len(cluster_request.Template) == 0 {
+		return http.StatusOK, nil
+	}
+
+	if len(cluster_request.TerraformConfig) > 0 {
+		return http.StatusBadRequest, errors.New(models.ErrorTemplateAndConfig)
+	}
+
+	if ch.templates == nil {
+		return http.StatusInternalServerError, errors.New(models.ErrorTemplatesNotAvailable)
+	}
+
+	template, config, err := ch.templates.RenderTemplate(request_id, cluster_request.Template, cluster_request.Version, cluster_request.Variables)
+	if err != nil {
+		switch {
+		case err.Error() == models.ErrorTemplateNotFound:
+			return http.StatusNotFound, err
+		case err.Error() == models.ErrorInvalidTemplateVersion,
+			err.Error() == models.ErrorMissingTemplateName,
+			err.Error() == models.ErrorInvalidRenderedTemplate,
+			strings.HasPrefix(err.Error(), models.ErrorMissingTemplateVariable):
+			return http.StatusBadRequest, err
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	spec.TerraformConfig = config
+	spec.TemplateName = template.Name
+	spec.TemplateVersion = template.Version
+
+	// Variables declared by the template are consumed rendering it, the
+	//  rest are passed on to terraform
+	for _, variable := range template.Variables {
+		delete(spec.Variables, variable.Name)
+	}
+
+	return http.StatusOK, nil
+}
+
+func newPlanResponse(summary *terraform.PlanSummary, request_id string) *PlanResponse {
+	response_data := PlanResponseData{Type: "plan", Attributes: summary}
+	return &PlanResponse{RequestId: request_id, Data: response_data}
+}
+
 func newClusterResponse(cluster *models.Cluster, request_id string) *ClusterResponse {
 	logger := log.WithFields(log.Fields{"package": "handlers", "event": "cluster_response", "request": request_id})
 
@@ -246,11 +1155,21 @@ func newClusterResponse(cluster *models.Cluster, request_id string) *ClusterResp
 	}
 
 	cluster_response := ClusterResponseAttributes{
-		Id:               cluster.Id,
-		Name:             cluster.Name,
-		Status:           cluster.Status,
-		Message:          cluster.Message,
-		TerraformOutputs: outputs,
+		Id:                 cluster.Id,
+		Name:               cluster.Name,
+		Status:             cluster.Status,
+		Message:            cluster.Message,
+		Expiration:         cluster.Expiration,
+		Template:           cluster.TemplateName,
+		TemplateVersion:    cluster.TemplateVersion,
+		Owner:              cluster.Owner,
+		QueuePosition:      cluster.QueuePosition,
+		DestroyAttempts:    cluster.DestroyAttempts,
+		NextDestroyAttempt: nextDestroyAttempt(cluster),
+		ExpirationWarnings: cluster.ExpirationWarnings,
+		Variables:          cluster.Variables.Public(),
+		SensitiveVariables: sensitiveVariableNames(cluster.Variables),
+		TerraformOutputs:   outputs,
 	}
 
 	response_data := ClusterResponseData{Type: "cluster", Attributes: cluster_response}
@@ -259,7 +1178,7 @@ func newClusterResponse(cluster *models.Cluster, request_id string) *ClusterResp
 	return &request_response
 }
 
-func newClustersResponse(clusters []models.Cluster, request_id string) *ClustersResponse {
+func newClustersResponse(clusters []models.Cluster, next string, request_id string) *ClustersResponse {
 	logger := log.WithFields(log.Fields{"package": "handlers", "event": "clusters_response", "request": request_id})
 
 	cluster_list := []ClusterResponseAttributes{}
@@ -275,22 +1194,106 @@ func newClustersResponse(clusters []models.Cluster, request_id string) *Clusters
 		}
 
 		cluster_response := ClusterResponseAttributes{
-			Id:               cluster.Id,
-			Name:             cluster.Name,
-			Status:           cluster.Status,
-			Message:          cluster.Message,
-			TerraformOutputs: outputs,
+			Id:                 cluster.Id,
+			Name:               cluster.Name,
+			Status:             cluster.Status,
+			Message:            cluster.Message,
+			Expiration:         cluster.Expiration,
+			Template:           cluster.TemplateName,
+			TemplateVersion:    cluster.TemplateVersion,
+			Owner:              cluster.Owner,
+			QueuePosition:      cluster.QueuePosition,
+			DestroyAttempts:    cluster.DestroyAttempts,
+			NextDestroyAttempt: nextDestroyAttempt(&cluster),
+			ExpirationWarnings: cluster.ExpirationWarnings,
+			Variables:          cluster.Variables.Public(),
+			SensitiveVariables: sensitiveVariableNames(cluster.Variables),
+			TerraformOutputs:   outputs,
 		}
 
 		cluster_list = append(cluster_list, cluster_response)
 	}
 
 	response_data := ClustersResponseData{Type: "clusters", Attributes: cluster_list}
-	request_response := ClustersResponse{RequestId: request_id, Data: response_data}
+	request_response := ClustersResponse{RequestId: request_id, Data: response_data, Links: ClustersResponseLinks{Next: next}}
 
 	return &request_response
 }
 
+// Build the cluster filter from the /clusters query parameters. Statuses
+// may be repeated or comma separated and times are RFC3339.
+func newClusterFilter(query url.Values) (*models.ClusterFilter, error) {
+	filter := &models.ClusterFilter{
+		Project:    query.Get("project"),
+		Region:     query.Get("region"),
+		NamePrefix: query.Get("name_prefix"),
+		Sort:       query.Get("sort"),
+		Cursor:     query.Get("cursor"),
+	}
+
+	for _, statuses := range query["status"] {
+		for _, status := range strings.Split(statuses, ",") {
+			if len(status) > 0 {
+				filter.Statuses = append(filter.Statuses, status)
+			}
+		}
+	}
+
+	times := map[string]*time.Time{
+		"created_before": &filter.CreatedBefore,
+		"created_after":  &filter.CreatedAfter,
+		"expires_before": &filter.ExpiresBefore,
+		"expires_after":  &filter.ExpiresAfter,
+	}
+	for param, value := range times {
+		if len(query.Get(param)) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' time, expected RFC3339: %s", param, query.Get(param))
+		}
+		*value = t
+	}
+
+	if !models.ValidClusterSort(filter.Sort) {
+		return nil, errors.New(models.ErrorInvalidClusterSort)
+	}
+
+	if len(query.Get("limit")) > 0 {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || limit > models.MaxClusterPageSize {
+			return nil, errors.New(models.ErrorInvalidClusterLimit)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// Restrict the cluster filter to the clusters of the requesting owner.
+// Admins may instead ask for the clusters of another owner, or all=true
+// for the clusters of every owner, as may the viewers of the project the
+// clusters are filtered by.
+func (ch *ClusterHandler) scopeClusterFilter(filter *models.ClusterFilter, query url.Values, request_context app.RequestContext) error {
+	filter.Owner = request_context.Owner()
+
+	owner := query.Get("owner")
+	all := query.Get("all") == "true"
+	if (len(owner) > 0 && owner != request_context.Owner()) || all {
+		if !request_context.Admin() && len(filter.Project) == 0 {
+			return errors.New(models.ErrorAdminRequired)
+		}
+		err := authorize(ch.access, request_context, filter.Project, models.PermissionReadClusters)
+		if err != nil {
+			return err
+		}
+		filter.Owner = owner
+	}
+
+	return nil
+}
+
 func newErrorResponse(response *ErrorResponseAttributes, request_id string) *ErrorResponse {
 	response_data := ErrorResponseData{Type: "error", Attributes: response}
 	request_response := ErrorResponse{RequestId: request_id, Data: response_data}
@@ -310,3 +1313,13 @@ func respondWithJson(w http.ResponseWriter, response interface{}, status int) {
 	w.WriteHeader(status)
 	w.Write(js)
 }
+
+// When destroying a cluster which failed to be destroyed is next retried,
+// nil for any other cluster
+func nextDestroyAttempt(cluster *models.Cluster) *time.Time {
+	if cluster.Status != models.ClusterStatusDestroyFailed {
+		return nil
+	}
+	next := cluster.NextDestroyAttempt
+	return &next
+}
@@ -8,12 +8,15 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -21,12 +24,14 @@ import (
 	. "github.com/kmacoskey/taos/handlers"
 	"github.com/kmacoskey/taos/models"
 	"github.com/kmacoskey/taos/services"
+	"github.com/kmacoskey/taos/terraform"
 )
 
 func emptyhandler(w http.ResponseWriter, r *http.Request) {}
 
 var (
-	outputsBlob = []byte(`{"foo":{"sensitive":true,"type":"string","value":"bar"},"bar":{"sensitive":false,"type":"string","value":"foo"}}`)
+	outputsBlob     = []byte(`{"foo":{"sensitive":true,"type":"string","value":"bar"},"bar":{"sensitive":false,"type":"string","value":"foo"}}`)
+	validExpiration = time.Date(2018, time.May, 1, 12, 0, 0, 0, time.UTC)
 )
 
 var _ = Describe("Cluster", func() {
@@ -171,6 +176,204 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 
+		Context("When an idempotency key is reused with the same request", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewValidClusterService())
+				adapter := ch.CreateCluster()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"config":"{}","timeout":"10m"}`)
+				request := httptest.NewRequest("POST", "/cluster", bytes.NewBuffer(jsonStr))
+				request.Header.Set("Content-Type", "application/json")
+				request.Header.Set("Idempotency-Key", "key")
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 202 OK", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+			})
+			It("Should mark the response as replayed", func() {
+				Expect(resp.Header.Get("Idempotent-Replayed")).To(Equal("true"))
+			})
+		})
+
+		Context("When an idempotency key is reused with a different request", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewErroringClusterService())
+				adapter := ch.CreateCluster()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"config":"{}","timeout":"10m"}`)
+				request := httptest.NewRequest("POST", "/cluster", bytes.NewBuffer(jsonStr))
+				request.Header.Set("Content-Type", "application/json")
+				request.Header.Set("Idempotency-Key", "key")
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 409", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+			})
+		})
+
+		Context("When a webhook is not an http url", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewErroringClusterService())
+				adapter := ch.CreateCluster()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"config":"{}","timeout":"10m","webhooks":["file:///etc/passwd"]}`)
+				request := httptest.NewRequest("POST", "/cluster", bytes.NewBuffer(jsonStr))
+				request.Header.Set("Content-Type", "application/json")
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When a template is requested", func() {
+			var service *ValidClusterService
+
+			serveTemplate := func(templates *ValidTemplateService, jsonStr []byte) {
+				// Unravel the middleware pattern to test only the Handler
+				service = NewValidClusterService()
+				ch := NewClusterHandler(service).WithTemplates(templates)
+				adapter := ch.CreateCluster()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("POST", "/cluster", bytes.NewBuffer(jsonStr))
+				request.Header.Set("Content-Type", "application/json")
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Context("When the template renders", func() {
+				BeforeEach(func() {
+					serveTemplate(&ValidTemplateService{}, []byte(`{"template":"gpdb-3node","version":2,"variables":{"nodes":3},"timeout":"10m"}`))
+					cluster_response_json = &ClusterResponse{}
+					json_err = json.Unmarshal(body, &cluster_response_json)
+				})
+				It("Should return a 202 OK", func() {
+					Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+				})
+				It("Should create the cluster with the rendered config", func() {
+					Expect(string(service.spec.TerraformConfig)).To(Equal(`{"nodes":3}`))
+				})
+				It("Should not pass the variables of the template to terraform", func() {
+					Expect(service.spec.Variables).To(BeEmpty())
+				})
+				It("Should return the template of the cluster", func() {
+					Expect(json_err).NotTo(HaveOccurred())
+					Expect(cluster_response_json.Data.Attributes.Template).To(Equal("gpdb-3node"))
+					Expect(cluster_response_json.Data.Attributes.TemplateVersion).To(Equal(2))
+				})
+			})
+
+			Context("When a config is also included", func() {
+				BeforeEach(func() {
+					serveTemplate(&ValidTemplateService{}, []byte(`{"template":"gpdb-3node","config":"{}"}`))
+				})
+				It("Should return a 400", func() {
+					Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+				})
+			})
+
+			Context("When the template does not exist", func() {
+				BeforeEach(func() {
+					serveTemplate(&ValidTemplateService{}, []byte(`{"template":"foo"}`))
+				})
+				It("Should return a 404", func() {
+					Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+				})
+			})
+
+			Context("When a required variable is missing", func() {
+				BeforeEach(func() {
+					serveTemplate(&ValidTemplateService{}, []byte(`{"template":"gpdb-3node"}`))
+				})
+				It("Should return a 400", func() {
+					Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+				})
+			})
+		})
+
+		Context("When terraform variables are included", func() {
+			var service *ValidClusterService
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				service = NewValidClusterService()
+				ch := NewClusterHandler(service)
+				adapter := ch.CreateCluster()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"config":"{}","timeout":"10m","variables":{"nodes":3,"password":"secret"},"sensitive_variables":["password"]}`)
+				request := httptest.NewRequest("POST", "/cluster", bytes.NewBuffer(jsonStr))
+				request.Header.Set("Content-Type", "application/json")
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				cluster_response_json = &ClusterResponse{}
+				json_err = json.Unmarshal(body, &cluster_response_json)
+			})
+			It("Should create the cluster with the variables", func() {
+				Expect(service.spec.Variables).To(Equal(models.ClusterVariables{
+					"nodes":    {Value: float64(3)},
+					"password": {Value: "secret", Sensitive: true},
+				}))
+			})
+			It("Should return only the variables which are not sensitive", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(cluster_response_json.Data.Attributes.Variables).To(Equal(map[string]interface{}{"nodes": float64(3)}))
+				Expect(cluster_response_json.Data.Attributes.SensitiveVariables).To(Equal([]string{"password"}))
+			})
+			It("Should never return the value of a sensitive variable", func() {
+				Expect(string(body)).NotTo(ContainSubstring("secret"))
+			})
+		})
+
 		Context("When no terraform config is included", func() {
 			BeforeEach(func() {
 				// Unravel the middleware pattern to test only the Handler
@@ -226,6 +429,79 @@ var _ = Describe("Cluster", func() {
 	//
 	// ======================================================================
 
+	Describe("Planning a cluster", func() {
+		var (
+			plan_response_json *PlanResponse
+		)
+
+		servePlan := func(ch *ClusterHandler, jsonStr []byte) {
+			// Unravel the middleware pattern to test only the Handler
+			adapter := ch.PlanCluster()
+			handler := adapter(http.HandlerFunc(emptyhandler))
+
+			request := httptest.NewRequest("POST", "/cluster/plan", bytes.NewBuffer(jsonStr))
+
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
+
+			// Create a server to get receive a response for the given request
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
+
+			// Read the response body
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				servePlan(NewClusterHandler(NewValidClusterService()), []byte(`{"config":"{}","project":"project","region":"region"}`))
+				plan_response_json = &PlanResponse{}
+				json_err = json.Unmarshal(body, &plan_response_json)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return json", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+			})
+			It("Should return the plan summary", func() {
+				Expect(plan_response_json.Data.Type).To(Equal("plan"))
+				Expect(plan_response_json.Data.Attributes.Add).To(Equal(1))
+				Expect(plan_response_json.Data.Attributes.Resources[0].Action).To(Equal(terraform.PlanActionCreate))
+			})
+		})
+
+		Context("When the request is not json", func() {
+			BeforeEach(func() {
+				servePlan(NewClusterHandler(NewValidClusterService()), []byte(`notjson`))
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When the config is missing", func() {
+			BeforeEach(func() {
+				servePlan(NewClusterHandler(NewEmptyClusterService()), []byte(`{}`))
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When terraform fails to plan", func() {
+			BeforeEach(func() {
+				servePlan(NewClusterHandler(NewErroringClusterService()), []byte(`{"config":"{}","project":"project","region":"region"}`))
+			})
+			It("Should return a 422", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+
 	Describe("Get a Cluster for a specific id", func() {
 		Context("When everything goes ok", func() {
 			BeforeEach(func() {
@@ -271,6 +547,9 @@ var _ = Describe("Cluster", func() {
 				cr := cluster_response_json.Data.Attributes
 				Expect(cr.Id).To(Equal(cluster1.Id))
 			})
+			It("Should return the position of the cluster in the job queue", func() {
+				Expect(cluster_response_json.Data.Attributes.QueuePosition).To(Equal(2))
+			})
 		})
 
 		Context("When the handler, service, or daos errors", func() {
@@ -357,6 +636,40 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 
+		Context("When the cluster is owned by another user", func() {
+			serve := func(admin bool) {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(&ValidRoleService{roles: map[string]string{}})
+				handler := ch.GetCluster()(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", "/cluster/id", nil)
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				requestContext.SetPrincipal("bob", "", admin)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			It("Should return a 403 without the cluster", func() {
+				serve(false)
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(string(body)).To(ContainSubstring(models.PermissionReadClusters))
+				Expect(string(body)).NotTo(ContainSubstring("a19e2758-0ec5-11e8-ba89-0ed5f89f718b"))
+			})
+			It("Should return the cluster to an admin", func() {
+				serve(true)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
 		Context("When an id was not included in the request", func() {
 			BeforeEach(func() {
 				// Unravel the middleware pattern to test only the Handler
@@ -459,14 +772,14 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 
-		Context("When there are no clusters to return", func() {
+		Context("When filtering and paginating", func() {
 			BeforeEach(func() {
 				// Unravel the middleware pattern to test only the Handler
-				ch := NewClusterHandler(NewEmptyClusterService())
+				ch := NewClusterHandler(NewValidClusterService())
 				adapter := ch.GetClusters()
 				handler := adapter(http.HandlerFunc(emptyhandler))
 
-				request := httptest.NewRequest("GET", "/clusters", nil)
+				request := httptest.NewRequest("GET", "/clusters?status=provision_success&limit=2&sort=-expiration", nil)
 
 				// Create a new request with the expected, but empty, request.Context
 				response = httptest.NewRecorder()
@@ -487,31 +800,70 @@ var _ = Describe("Cluster", func() {
 			It("Should return a 200", func() {
 				Expect(resp.StatusCode).To(Equal(http.StatusOK))
 			})
-			It("Should return json", func() {
-				Expect(json_err).NotTo(HaveOccurred())
-			})
-			It("Should return a request uuid", func() {
-				id, err := uuid.Parse(clusters_response_json.RequestId)
+			It("Should link to the next page with the same query", func() {
+				next, err := url.Parse(clusters_response_json.Links.Next)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(id).NotTo(BeNil())
+				Expect(next.Path).To(Equal("/clusters"))
+				Expect(next.Query().Get("cursor")).To(Equal("next-page"))
+				Expect(next.Query().Get("status")).To(Equal("provision_success"))
+				Expect(next.Query().Get("sort")).To(Equal("-expiration"))
 			})
-			It("Should return clusters", func() {
-				Expect(clusters_response_json.Data.Type).To(Equal("clusters"))
+		})
+
+		Context("When scoping the clusters to an owner", func() {
+			var service *ValidClusterService
+
+			serve := func(target string, owner string, admin bool) {
+				// Unravel the middleware pattern to test only the Handler
+				service = NewValidClusterService()
+				handler := NewClusterHandler(service).GetClusters()(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", target, nil)
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				requestContext.SetPrincipal(owner, "", admin)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			}
+
+			It("Should return only the clusters of the owner by default", func() {
+				serve("/clusters", "alice", false)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(service.filter.Owner).To(Equal("alice"))
 			})
-			It("Should return no clusters", func() {
-				cr := clusters_response_json.Data.Attributes
-				Expect(cr).To(BeEmpty())
+			It("Should refuse the clusters of another owner to a non-admin", func() {
+				serve("/clusters?owner=bob", "alice", false)
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(service.filter).To(BeNil())
+			})
+			It("Should refuse the clusters of every owner to a non-admin", func() {
+				serve("/clusters?all=true", "alice", false)
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+			It("Should return the clusters of another owner to an admin", func() {
+				serve("/clusters?owner=bob", "ops", true)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(service.filter.Owner).To(Equal("bob"))
+			})
+			It("Should return the clusters of every owner to an admin", func() {
+				serve("/clusters?all=true", "ops", true)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(service.filter.Owner).To(BeEmpty())
 			})
 		})
 
-		Context("When the handler, service, or daos errors", func() {
+		Context("When the query parameters are invalid", func() {
 			BeforeEach(func() {
 				// Unravel the middleware pattern to test only the Handler
-				ch := NewClusterHandler(NewErroringClusterService())
+				ch := NewClusterHandler(NewValidClusterService())
 				adapter := ch.GetClusters()
 				handler := adapter(http.HandlerFunc(emptyhandler))
 
-				request := httptest.NewRequest("GET", "/clusters", nil)
+				request := httptest.NewRequest("GET", "/clusters?created_after=yesterday", nil)
 
 				// Create a new request with the expected, but empty, request.Context
 				response = httptest.NewRecorder()
@@ -529,26 +881,107 @@ var _ = Describe("Cluster", func() {
 				error_response_json = &ErrorResponse{}
 				json_err = json.Unmarshal(body, &error_response_json)
 			})
-			It("Should return a 500", func() {
-				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
-			})
-			It("Should return json", func() {
-				Expect(json_err).NotTo(HaveOccurred())
-			})
-			It("Should return a request uuid", func() {
-				id, err := uuid.Parse(error_response_json.RequestId)
-				Expect(err).NotTo(HaveOccurred())
-				Expect(id).NotTo(BeNil())
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
 			})
 			It("Should return an error", func() {
 				Expect(error_response_json.Data.Type).To(Equal("error"))
 			})
 		})
 
-	})
+		Context("When there are no clusters to return", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewEmptyClusterService())
+				adapter := ch.GetClusters()
+				handler := adapter(http.HandlerFunc(emptyhandler))
 
-	// ======================================================================
-	//      _      _      _
+				request := httptest.NewRequest("GET", "/clusters", nil)
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				clusters_response_json = &ClustersResponse{}
+				json_err = json.Unmarshal(body, &clusters_response_json)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return json", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+			})
+			It("Should return a request uuid", func() {
+				id, err := uuid.Parse(clusters_response_json.RequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(id).NotTo(BeNil())
+			})
+			It("Should return clusters", func() {
+				Expect(clusters_response_json.Data.Type).To(Equal("clusters"))
+			})
+			It("Should return no clusters", func() {
+				cr := clusters_response_json.Data.Attributes
+				Expect(cr).To(BeEmpty())
+			})
+			It("Should not link to a next page", func() {
+				Expect(clusters_response_json.Links.Next).To(BeEmpty())
+			})
+		})
+
+		Context("When the handler, service, or daos errors", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewErroringClusterService())
+				adapter := ch.GetClusters()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", "/clusters", nil)
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+			It("Should return json", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+			})
+			It("Should return a request uuid", func() {
+				id, err := uuid.Parse(error_response_json.RequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(id).NotTo(BeNil())
+			})
+			It("Should return an error", func() {
+				Expect(error_response_json.Data.Type).To(Equal("error"))
+			})
+		})
+
+	})
+
+	// ======================================================================
+	//      _      _      _
 	//   __| | ___| | ___| |_ ___
 	//  / _` |/ _ \ |/ _ \ __/ _ \
 	// | (_| |  __/ |  __/ ||  __/
@@ -731,103 +1164,993 @@ var _ = Describe("Cluster", func() {
 		})
 	})
 
-})
+	Context("When the cluster is owned by another user", func() {
+		var cluster_deleted bool
 
-/*
- * Valid Cluster Service returns valid Clusters
- */
-type ValidClusterService struct{}
+		serve := func(admin bool) {
+			// Unravel the middleware pattern to test only the Handler
+			ch := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, &cluster_deleted})
+			handler := ch.DeleteCluster()(http.HandlerFunc(emptyhandler))
 
-func NewValidClusterService() *ValidClusterService {
-	return &ValidClusterService{}
-}
+			request := httptest.NewRequest("DELETE", "/cluster/id", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": "1"})
 
-func (cs *ValidClusterService) CreateCluster(terraform_config []byte, timeout string, project string, region string, request_id string, client services.TerraformClient) (*models.Cluster, error) {
-	cluster1 := &models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
-	return cluster1, nil
-}
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			requestContext.SetPrincipal("bob", "", admin)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
 
-func (cs *ValidClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
-	return &models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}, nil
-}
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
 
-func (cs *ValidClusterService) GetClusters(request_id string) ([]models.Cluster, error) {
-	clusters := []models.Cluster{}
-	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
-	cluster2 := models.Cluster{Id: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
-	clusters = append(clusters, cluster1)
-	clusters = append(clusters, cluster2)
-	return clusters, nil
-}
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}
 
-func (cs *ValidClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
-	clusters := []models.Cluster{}
-	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
-	cluster2 := models.Cluster{Id: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
-	clusters = append(clusters, cluster1)
-	clusters = append(clusters, cluster2)
-	return clusters, nil
-}
+		BeforeEach(func() {
+			cluster_deleted = false
+		})
 
-func (cs *ValidClusterService) DeleteCluster(request_id string, client services.TerraformClient, id string) (*models.Cluster, error) {
-	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
-	return &cluster1, nil
-}
+		It("Should refuse to delete the cluster", func() {
+			serve(false)
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			Expect(string(body)).To(ContainSubstring(models.PermissionDeleteAnyCluster))
+			Expect(cluster_deleted).To(BeFalse())
+		})
+		It("Should delete the cluster for an admin", func() {
+			serve(true)
+			Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(cluster_deleted).To(BeTrue())
+		})
+	})
 
-/*
- * Empty Cluster Service returns no Clusters
- */
-type EmptyClusterService struct{}
+	Context("When another operation on the cluster is in progress", func() {
+		BeforeEach(func() {
+			// Unravel the middleware pattern to test only the Handler
+			ch := NewClusterHandler(&BusyClusterService{})
+			handler := ch.DeleteCluster()(http.HandlerFunc(emptyhandler))
 
-func NewEmptyClusterService() *EmptyClusterService {
-	return &EmptyClusterService{}
-}
+			request := httptest.NewRequest("DELETE", "/cluster/id", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": "1"})
 
-func (cs *EmptyClusterService) CreateCluster(terraform_config []byte, timeout string, project string, region string, request_id string, client services.TerraformClient) (*models.Cluster, error) {
-	return nil, nil
-}
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
 
-func (cs *EmptyClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
-	return nil, nil
-}
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
 
-func (cs *EmptyClusterService) GetClusters(request_id string) ([]models.Cluster, error) {
-	return []models.Cluster{}, nil
-}
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Should return a 409", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+			Expect(string(body)).To(ContainSubstring(models.ErrorClusterJobActive))
+		})
+	})
 
-func (cs *EmptyClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
-	return []models.Cluster{}, nil
-}
+	Describe("Cancelling a cluster", func() {
+		serve := func(ch *ClusterHandler) {
+			// Unravel the middleware pattern to test only the Handler
+			handler := ch.CancelCluster()(http.HandlerFunc(emptyhandler))
 
-func (cs *EmptyClusterService) DeleteCluster(request_id string, client services.TerraformClient, id string) (*models.Cluster, error) {
-	return nil, nil
-}
+			request := httptest.NewRequest("POST", "/cluster/id/cancel", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": "1"})
 
-/*
- * Erroring Cluster Service returns that the Cluster Service has errored
- */
-type ErroringClusterService struct{}
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
 
-func NewErroringClusterService() *ErroringClusterService {
-	return &ErroringClusterService{}
-}
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
 
-func (cs *ErroringClusterService) CreateCluster(terraform_config []byte, timeout string, project string, region string, request_id string, client services.TerraformClient) (*models.Cluster, error) {
-	return nil, errors.New("Cluster service error")
-}
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}
 
-func (cs *ErroringClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
-	return nil, errors.New("Cluster service error")
-}
+		Context("When provisioning is in progress", func() {
+			var service *ValidClusterService
 
-func (cs *ErroringClusterService) GetClusters(request_id string) ([]models.Cluster, error) {
-	return nil, errors.New("Cluster service error")
-}
+			BeforeEach(func() {
+				service = NewValidClusterService()
+				serve(NewClusterHandler(service))
+				cluster_response_json = &ClusterResponse{}
+				json_err = json.Unmarshal(body, &cluster_response_json)
+			})
+			It("Should return a 202", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+			})
+			It("Should return the cluster", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(cluster_response_json.Data.Type).To(Equal("cluster"))
+			})
+			It("Should record the cancellation in the history of the cluster", func() {
+				Expect(service.actions).To(HaveLen(1))
+				Expect(service.actions[0].Name).To(Equal(models.ClusterActionCancel))
+				Expect(service.actions[0].ClusterId).To(Equal("1"))
+			})
+		})
 
-func (cs *ErroringClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
-	return nil, errors.New("Cluster service error")
-}
+		Context("When the cluster does not exist", func() {
+			It("Should return a 404", func() {
+				serve(NewClusterHandler(NewEmptyClusterService()))
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
 
-func (cs *ErroringClusterService) DeleteCluster(request_id string, client services.TerraformClient, id string) (*models.Cluster, error) {
-	return nil, errors.New("Cluster service error")
+		Context("When no provisioning is in progress", func() {
+			It("Should return a 409", func() {
+				serve(NewClusterHandler(&BusyClusterService{}))
+				Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+				Expect(string(body)).To(ContainSubstring(models.ErrorClusterJobNotRunning))
+			})
+		})
+	})
+
+	Describe("Retrying to destroy a cluster", func() {
+		serve := func(ch *ClusterHandler) {
+			// Unravel the middleware pattern to test only the Handler
+			handler := ch.RetryDestroyCluster()(http.HandlerFunc(emptyhandler))
+
+			request := httptest.NewRequest("POST", "/cluster/id/destroy/retry", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
+
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
+
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Context("When the cluster failed to be destroyed", func() {
+			It("Should return the cluster set to destroy", func() {
+				serve(NewClusterHandler(NewValidClusterService()))
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+
+				cluster_response_json = &ClusterResponse{}
+				Expect(json.Unmarshal(body, &cluster_response_json)).To(Succeed())
+				Expect(cluster_response_json.Data.Attributes.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+		})
+
+		Context("When the cluster has not failed to be destroyed", func() {
+			It("Should return a 409", func() {
+				serve(NewClusterHandler(&BusyClusterService{}))
+				Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+				Expect(string(body)).To(ContainSubstring(models.ErrorClusterNotDestroyFailed))
+			})
+		})
+	})
+
+	// ======================================================================
+	//                       _           _   _
+	//   _____  ___ __  _ __(_)_ __ __ _| |_(_) ___  _ __
+	//  / _ \ \/ / '_ \| '__| | '__/ _` | __| |/ _ \| '_ \
+	// |  __/>  <| |_) | |  | | | | (_| | |_| | (_) | | | |
+	//  \___/_/\_\ .__/|_|  |_|_|  \__,_|\__|_|\___/|_| |_|
+	//           |_|
+	// ======================================================================
+
+	Describe("Updating the expiration of a cluster", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewValidClusterService())
+				adapter := ch.UpdateClusterExpiration()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"timeout":"1h"}`)
+				request := httptest.NewRequest("PATCH", "/cluster/id/expiration", bytes.NewBuffer(jsonStr))
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				cluster_response_json = &ClusterResponse{}
+				json_err = json.Unmarshal(body, &cluster_response_json)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return json", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+			})
+			It("Should return a cluster", func() {
+				Expect(cluster_response_json.Data.Type).To(Equal("cluster"))
+			})
+			It("Should return the new expiration", func() {
+				Expect(cluster_response_json.Data.Attributes.Expiration).To(Equal(validExpiration))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(&MissingClusterService{ValidClusterService{}})
+				adapter := ch.UpdateClusterExpiration()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"timeout":"1h"}`)
+				request := httptest.NewRequest("PATCH", "/cluster/id/expiration", bytes.NewBuffer(jsonStr))
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("When no timeout is included", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewValidClusterService())
+				adapter := ch.UpdateClusterExpiration()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{}`)
+				request := httptest.NewRequest("PATCH", "/cluster/id/expiration", bytes.NewBuffer(jsonStr))
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("Should return an error", func() {
+				Expect(error_response_json.Data.Type).To(Equal("error"))
+			})
+		})
+
+		Context("When the expiration exceeds the maximum lifetime", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(&LimitedClusterService{})
+				adapter := ch.UpdateClusterExpiration()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"timeout":"1000h"}`)
+				request := httptest.NewRequest("PATCH", "/cluster/id/expiration", bytes.NewBuffer(jsonStr))
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("Should return the reason", func() {
+				Expect(error_response_json.Data.Attributes.Detail).To(Equal(models.ErrorExceedsMaxLifetime))
+			})
+		})
+
+		Context("When the cluster is owned by another user", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(&ValidRoleService{roles: map[string]string{}})
+				handler := ch.UpdateClusterExpiration()(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"timeout":"0s"}`)
+				request := httptest.NewRequest("PATCH", "/cluster/id/expiration", bytes.NewBuffer(jsonStr))
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				requestContext.SetPrincipal("bob", "", false)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewEmptyClusterService())
+				adapter := ch.UpdateClusterExpiration()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				var jsonStr = []byte(`{"timeout":"1h"}`)
+				request := httptest.NewRequest("PATCH", "/cluster/id/expiration", bytes.NewBuffer(jsonStr))
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	// ======================================================================
+	//                       _
+	//   _____   _____ _ __ | |_ ___
+	//  / _ \ \ / / _ \ '_ \| __/ __|
+	// |  __/\ V /  __/ | | | |_\__ \
+	//  \___| \_/ \___|_| |_|\__|___/
+	//
+	// ======================================================================
+
+	Describe("Streaming cluster events", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewValidClusterService())
+				adapter := ch.StreamClusterEvents()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", "/cluster/id/events", nil)
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return an event stream", func() {
+				Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+			})
+			It("Should begin with the current state of the cluster", func() {
+				Expect(string(body)).To(HavePrefix("event: cluster\ndata: {\"cluster_id\":\"a19e2758-0ec5-11e8-ba89-0ed5f89f718b\",\"status\":\"status\""))
+			})
+			It("Should stream the published events", func() {
+				Expect(string(body)).To(ContainSubstring(`"status":"provision_success"`))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewEmptyClusterService())
+				adapter := ch.StreamClusterEvents()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", "/cluster/id/events", nil)
+				request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("When streaming the events of all clusters", func() {
+			BeforeEach(func() {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(NewValidClusterService())
+				adapter := ch.StreamClusterEvents()
+				handler := adapter(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", "/clusters/events", nil)
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				// Create a server to get receive a response for the given request
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				// Read the response body
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should stream the published events only", func() {
+				Expect(string(body)).To(Equal("event: cluster\ndata: {\"cluster_id\":\"a19e2758-0ec5-11e8-ba89-0ed5f89f718b\",\"status\":\"provision_success\",\"message\":\"\",\"timestamp\":\"0001-01-01T00:00:00Z\"}\n\n"))
+			})
+		})
+
+		Context("When the clusters are owned by another user", func() {
+			var roles map[string]string
+
+			BeforeEach(func() {
+				roles = map[string]string{}
+			})
+
+			serve := func(target string, admin bool) {
+				// Unravel the middleware pattern to test only the Handler
+				ch := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(&ValidRoleService{roles: roles})
+				handler := ch.StreamClusterEvents()(http.HandlerFunc(emptyhandler))
+
+				request := httptest.NewRequest("GET", target, nil)
+				if target != "/clusters/events" {
+					request = mux.SetURLVars(request, map[string]string{"id": "1"})
+				}
+
+				// Create a new request with the expected, but empty, request.Context
+				response = httptest.NewRecorder()
+				requestContext := app.NewRequestContext(request.Context(), request)
+				requestContext.SetPrincipal("bob", "", admin)
+				ctx := context.WithValue(request.Context(), "request", requestContext)
+
+				handler.ServeHTTP(response, request.WithContext(ctx))
+				resp = response.Result()
+
+				body, err = ioutil.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			It("Should return a 403 for the events of a single cluster", func() {
+				serve("/cluster/1/events", false)
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+			It("Should not stream their events", func() {
+				serve("/clusters/events", false)
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(string(body)).To(BeEmpty())
+			})
+			It("Should stream their events to an admin", func() {
+				serve("/clusters/events", true)
+				Expect(string(body)).To(ContainSubstring(`"status":"provision_success"`))
+			})
+
+			Context("When the user may read the clusters of the project", func() {
+				BeforeEach(func() {
+					roles["bob"] = models.RoleViewer
+				})
+				It("Should stream the events of a single cluster", func() {
+					serve("/cluster/1/events", false)
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(string(body)).To(ContainSubstring(`"status":"provision_success"`))
+				})
+				It("Should stream their events", func() {
+					serve("/clusters/events", false)
+					Expect(string(body)).To(ContainSubstring(`"status":"provision_success"`))
+				})
+			})
+		})
+	})
+
+	Describe("Getting the logs of a cluster", func() {
+		var (
+			logs_response_json *ClusterLogsResponse
+		)
+
+		serveLogs := func(ch *ClusterHandler, target string) {
+			// Unravel the middleware pattern to test only the Handler
+			adapter := ch.GetClusterLogs()
+			handler := adapter(http.HandlerFunc(emptyhandler))
+
+			request := httptest.NewRequest("GET", target, nil)
+			request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
+
+			// Create a server to get receive a response for the given request
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
+
+			// Read the response body
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serveLogs(NewClusterHandler(NewValidClusterService()), "/cluster/1/logs")
+				logs_response_json = &ClusterLogsResponse{}
+				json_err = json.Unmarshal(body, &logs_response_json)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return json", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+			})
+			It("Should return the lines of the log", func() {
+				Expect(logs_response_json.Data.Type).To(Equal("cluster_logs"))
+				Expect(logs_response_json.Data.Attributes).To(HaveLen(2))
+				Expect(logs_response_json.Data.Attributes[0].Line).To(Equal("$ terraform apply"))
+			})
+		})
+
+		Context("When following the logs of a finished operation", func() {
+			BeforeEach(func() {
+				serveLogs(NewClusterHandler(NewValidClusterService()), "/cluster/1/logs?follow=true&after=1")
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return plain text", func() {
+				Expect(resp.Header.Get("Content-Type")).To(HavePrefix("text/plain"))
+			})
+			It("Should stream the lines following after and end", func() {
+				Expect(string(body)).To(Equal(terraform.ApplySuccess + "\n"))
+			})
+		})
+
+		Context("When after is invalid", func() {
+			BeforeEach(func() {
+				serveLogs(NewClusterHandler(NewValidClusterService()), "/cluster/1/logs?after=foo")
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			BeforeEach(func() {
+				serveLogs(NewClusterHandler(NewEmptyClusterService()), "/cluster/1/logs")
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("When the cluster is owned by another user", func() {
+			BeforeEach(func() {
+				serveLogs(NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(&ValidRoleService{roles: map[string]string{}}), "/cluster/1/logs")
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+	})
+
+	Describe("Getting the history of a cluster", func() {
+		var (
+			history_response_json *ClusterHistoryResponse
+		)
+
+		serve := func(ch *ClusterHandler) {
+			// Unravel the middleware pattern to test only the Handler
+			handler := ch.GetClusterHistory()(http.HandlerFunc(emptyhandler))
+
+			request := httptest.NewRequest("GET", "/cluster/1/history", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": "1"})
+
+			// Create a new request with the expected, but empty, request.Context
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
+
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
+
+			body, err = ioutil.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewClusterHandler(NewValidClusterService()))
+				history_response_json = &ClusterHistoryResponse{}
+				json_err = json.Unmarshal(body, &history_response_json)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the timeline with durations", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(history_response_json.Data.Type).To(Equal("cluster_history"))
+				Expect(history_response_json.Data.Attributes).To(HaveLen(2))
+				Expect(history_response_json.Data.Attributes[0].Name).To(Equal(models.ClusterStatusProvisionStart))
+				Expect(string(body)).To(ContainSubstring(`"duration_seconds":90`))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			It("Should return a 404", func() {
+				serve(NewClusterHandler(NewEmptyClusterService()))
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("When the cluster is owned by another user", func() {
+			It("Should return a 403", func() {
+				serve(NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(&ValidRoleService{roles: map[string]string{}}))
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		Context("When the history cannot be retrieved", func() {
+			It("Should return a 500", func() {
+				serve(NewClusterHandler(&ForgetfulClusterService{}))
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+})
+
+/*
+ * Valid Cluster Service returns valid Clusters
+ */
+type ValidClusterService struct {
+	spec    *models.ClusterSpec
+	filter  *models.ClusterFilter
+	actions []models.ClusterHistoryEvent
+}
+
+func NewValidClusterService() *ValidClusterService {
+	return &ValidClusterService{}
+}
+
+func (cs *ValidClusterService) CreateCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error) {
+	cs.spec = spec
+	cluster1 := &models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob, TemplateName: spec.TemplateName, TemplateVersion: spec.TemplateVersion, Variables: spec.Variables}
+	return cluster1, nil
+}
+
+func (cs *ValidClusterService) PlanCluster(ctx context.Context, spec *models.ClusterSpec, request_id string, client services.TerraformClient) (*terraform.PlanSummary, error) {
+	return &terraform.PlanSummary{Add: 1, Resources: []terraform.PlannedResource{{Address: "google_compute_instance.default", Action: terraform.PlanActionCreate}}}, nil
+}
+
+func (cs *ValidClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return &models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob, QueuePosition: 2}, nil
+}
+
+func (cs *ValidClusterService) GetClusters(request_id string, filter *models.ClusterFilter) ([]models.Cluster, string, error) {
+	cs.filter = filter
+	clusters := []models.Cluster{}
+	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
+	cluster2 := models.Cluster{Id: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
+	clusters = append(clusters, cluster1)
+	clusters = append(clusters, cluster2)
+	return clusters, "next-page", nil
+}
+
+func (cs *ValidClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
+	clusters := []models.Cluster{}
+	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
+	cluster2 := models.Cluster{Id: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
+	clusters = append(clusters, cluster1)
+	clusters = append(clusters, cluster2)
+	return clusters, nil
+}
+
+func (cs *ValidClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
+	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob}
+	return &cluster1, nil
+}
+
+func (cs *ValidClusterService) CancelCluster(request_id string, id string) (*models.Cluster, error) {
+	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: models.ClusterStatusProvisionStart, Outputs: outputsBlob}
+	return &cluster1, nil
+}
+
+func (cs *ValidClusterService) RetryDestroyCluster(request_id string, id string) (*models.Cluster, error) {
+	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: models.ClusterStatusDestroying, Outputs: outputsBlob}
+	return &cluster1, nil
+}
+
+func (cs *ValidClusterService) SubscribeClusterEvents(id string) (<-chan models.ClusterEvent, func()) {
+	// A closed channel ends the stream once the buffered event is written
+	events := make(chan models.ClusterEvent, 1)
+	events <- models.ClusterEvent{ClusterId: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Status: models.ClusterStatusProvisionSuccess}
+	close(events)
+	return events, func() {}
+}
+
+func (cs *ValidClusterService) GetClusterLogs(request_id string, id string, operation string, after int64) ([]models.ClusterLog, error) {
+	logs := []models.ClusterLog{}
+	lines := []models.ClusterLog{
+		{Id: 1, ClusterId: id, Operation: models.ClusterLogOperationProvision, Line: "$ terraform apply"},
+		{Id: 2, ClusterId: id, Operation: models.ClusterLogOperationProvision, Line: terraform.ApplySuccess},
+	}
+	for _, line := range lines {
+		if line.Id > after {
+			logs = append(logs, line)
+		}
+	}
+	return logs, nil
+}
+
+func (cs *ValidClusterService) GetClusterHistory(request_id string, id string) ([]models.ClusterHistoryEvent, error) {
+	return []models.ClusterHistoryEvent{
+		{Id: 1, ClusterId: id, Kind: models.ClusterHistoryStatus, Name: models.ClusterStatusProvisionStart, Duration: 90},
+		{Id: 2, ClusterId: id, Kind: models.ClusterHistoryStatus, Name: models.ClusterStatusProvisionSuccess},
+	}, nil
+}
+
+func (cs *ValidClusterService) RecordClusterAction(request_id string, id string, action string, actor string, message string) error {
+	cs.actions = append(cs.actions, models.ClusterHistoryEvent{ClusterId: id, Kind: models.ClusterHistoryAction, Name: action, Actor: actor, Message: message, RequestId: request_id})
+	return nil
+}
+
+func (cs *ValidClusterService) UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error) {
+	cluster1 := models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob, Expiration: validExpiration}
+	return &cluster1, nil
+}
+
+/*
+ * Owned Cluster Service returns Clusters owned by alice
+ */
+type OwnedClusterService struct {
+	ValidClusterService
+	deleted *bool
+}
+
+func (cs *OwnedClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return &models.Cluster{Id: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Name: "cluster", Status: "status", Outputs: outputsBlob, Owner: "alice"}, nil
+}
+
+func (cs *OwnedClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
+	*cs.deleted = true
+	return cs.GetCluster(request_id, id)
+}
+
+func (cs *OwnedClusterService) SubscribeClusterEvents(id string) (<-chan models.ClusterEvent, func()) {
+	events := make(chan models.ClusterEvent, 1)
+	events <- models.ClusterEvent{ClusterId: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b", Status: models.ClusterStatusProvisionSuccess, Owner: "alice"}
+	close(events)
+	return events, func() {}
+}
+
+/*
+ * Missing Cluster Service finds no Cluster, as the cluster stores do
+ */
+type MissingClusterService struct {
+	ValidClusterService
+}
+
+func (cs *MissingClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (cs *MissingClusterService) UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorClusterNotFound)
+}
+
+/*
+ * Limited Cluster Service refuses to extend Clusters past their lifetime
+ */
+type LimitedClusterService struct {
+	ValidClusterService
+}
+
+func (cs *LimitedClusterService) UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorExceedsMaxLifetime)
+}
+
+/*
+ * Forgetful Cluster Service fails to retrieve the history of Clusters
+ */
+type ForgetfulClusterService struct {
+	ValidClusterService
+}
+
+func (cs *ForgetfulClusterService) GetClusterHistory(request_id string, id string) ([]models.ClusterHistoryEvent, error) {
+	return nil, errors.New("foo")
+}
+
+/*
+ * Busy Cluster Service has an operation in progress on every Cluster
+ */
+type BusyClusterService struct {
+	ValidClusterService
+}
+
+func (cs *BusyClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorClusterJobActive)
+}
+
+func (cs *BusyClusterService) CancelCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorClusterJobNotRunning)
+}
+
+func (cs *BusyClusterService) RetryDestroyCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorClusterNotDestroyFailed)
+}
+
+/*
+ * Empty Cluster Service returns no Clusters
+ */
+type EmptyClusterService struct{}
+
+func NewEmptyClusterService() *EmptyClusterService {
+	return &EmptyClusterService{}
+}
+
+func (cs *EmptyClusterService) CreateCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error) {
+	return nil, nil
+}
+
+func (cs *EmptyClusterService) PlanCluster(ctx context.Context, spec *models.ClusterSpec, request_id string, client services.TerraformClient) (*terraform.PlanSummary, error) {
+	return nil, errors.New(models.ErrorMissingConfig)
+}
+
+func (cs *EmptyClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, nil
+}
+
+func (cs *EmptyClusterService) GetClusters(request_id string, filter *models.ClusterFilter) ([]models.Cluster, string, error) {
+	return []models.Cluster{}, "", nil
+}
+
+func (cs *EmptyClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
+	return []models.Cluster{}, nil
+}
+
+func (cs *EmptyClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, nil
+}
+
+func (cs *EmptyClusterService) CancelCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, nil
+}
+
+func (cs *EmptyClusterService) RetryDestroyCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorClusterNotFound)
+}
+
+func (cs *EmptyClusterService) SubscribeClusterEvents(id string) (<-chan models.ClusterEvent, func()) {
+	events := make(chan models.ClusterEvent)
+	close(events)
+	return events, func() {}
+}
+
+func (cs *EmptyClusterService) GetClusterLogs(request_id string, id string, operation string, after int64) ([]models.ClusterLog, error) {
+	return []models.ClusterLog{}, nil
+}
+
+func (cs *EmptyClusterService) GetClusterHistory(request_id string, id string) ([]models.ClusterHistoryEvent, error) {
+	return []models.ClusterHistoryEvent{}, nil
+}
+
+func (cs *EmptyClusterService) RecordClusterAction(request_id string, id string, action string, actor string, message string) error {
+	return nil
+}
+
+func (cs *EmptyClusterService) UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error) {
+	return nil, nil
+}
+
+/*
+ * Erroring Cluster Service returns that the Cluster Service has errored
+ */
+type ErroringClusterService struct{}
+
+func NewErroringClusterService() *ErroringClusterService {
+	return &ErroringClusterService{}
+}
+
+func (cs *ErroringClusterService) CreateCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error) {
+	if len(spec.IdempotencyKey) > 0 {
+		return nil, errors.New(models.ErrorIdempotencyKeyMismatch)
+	}
+	if len(spec.Webhooks) > 0 {
+		return nil, fmt.Errorf("%v: '%v'", models.ErrorInvalidWebhookUrl, spec.Webhooks[0])
+	}
+	return nil, errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) PlanCluster(ctx context.Context, spec *models.ClusterSpec, request_id string, client services.TerraformClient) (*terraform.PlanSummary, error) {
+	return nil, errors.New("foo")
+}
+
+func (cs *ErroringClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) GetClusters(request_id string, filter *models.ClusterFilter) ([]models.Cluster, string, error) {
+	return nil, "", errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
+	return nil, errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) CancelCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) RetryDestroyCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New("Cluster service error")
+}
+
+func (cs *ErroringClusterService) SubscribeClusterEvents(id string) (<-chan models.ClusterEvent, func()) {
+	events := make(chan models.ClusterEvent)
+	close(events)
+	return events, func() {}
+}
+
+func (cs *ErroringClusterService) GetClusterLogs(request_id string, id string, operation string, after int64) ([]models.ClusterLog, error) {
+	return nil, errors.New("foo")
+}
+
+func (cs *ErroringClusterService) GetClusterHistory(request_id string, id string) ([]models.ClusterHistoryEvent, error) {
+	return nil, errors.New("foo")
+}
+
+func (cs *ErroringClusterService) RecordClusterAction(request_id string, id string, action string, actor string, message string) error {
+	return errors.New("foo")
+}
+
+func (cs *ErroringClusterService) UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error) {
+	return nil, errors.New(models.ErrorExceedsMaxLifetime)
 }
@@ -1,10 +1,61 @@
 package handlers
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/terraform"
+)
+
 type ClusterRequest struct {
 	TerraformConfig string `json:"config"`
 	Timeout         string `json:"timeout"`
 	Project         string `json:"project"`
 	Region          string `json:"region"`
+
+	// Optional - URLs notified of lifecycle events of this cluster
+	Webhooks []string `json:"webhooks"`
+
+	// Optional - Template rendered into the config instead of a config,
+	//  the latest version unless a version is given
+	Template string `json:"template"`
+	Version  int    `json:"version"`
+
+	// Optional - Variables of the template, any variable the template does
+	//  not declare is passed to terraform as an input variable
+	Variables map[string]interface{} `json:"variables"`
+
+	// Optional - Names of variables whose values are never returned
+	SensitiveVariables []string `json:"sensitive_variables"`
+}
+
+// The request as logged, without the config or the values of variables
+// which may hold secrets
+func (r ClusterRequest) String() string {
+	return fmt.Sprintf("{Timeout:%v Project:%v Region:%v Webhooks:%v Template:%v Version:%v}", r.Timeout, r.Project, r.Region, r.Webhooks, r.Template, r.Version)
+}
+
+type TemplateRequest struct {
+	Description string                   `json:"description"`
+	Config      string                   `json:"config"`
+	Variables   models.TemplateVariables `json:"variables"`
+}
+
+type TokenRequest struct {
+	Owner       string `json:"owner"`
+	Description string `json:"description"`
+	Admin       bool   `json:"admin"`
+}
+
+type RoleRequest struct {
+	// One of viewer, operator or admin
+	Role string `json:"role"`
+}
+
+type ExpirationRequest struct {
+	// Duration from now until the cluster expires, "0s" expires immediately
+	Timeout string `json:"timeout"`
 }
 
 type ClusterResponse struct {
@@ -19,9 +70,15 @@ type ClusterResponseData struct {
 }
 
 type ClustersResponse struct {
-	RequestId string               `json:"request_id"`
-	Status    string               `json:"status"`
-	Data      ClustersResponseData `json:"data"`
+	RequestId string                `json:"request_id"`
+	Status    string                `json:"status"`
+	Data      ClustersResponseData  `json:"data"`
+	Links     ClustersResponseLinks `json:"links"`
+}
+
+type ClustersResponseLinks struct {
+	// Relative link to the following page of clusters, empty on the last page
+	Next string `json:"next,omitempty"`
 }
 
 type ClustersResponseData struct {
@@ -30,11 +87,161 @@ type ClustersResponseData struct {
 }
 
 type ClusterResponseAttributes struct {
-	Id               string `json:"id"`
-	Name             string `json:"name"`
-	Status           string `json:"status"`
-	Message          string `json:"message"`
+	Id               string    `json:"id"`
+	Name             string    `json:"name"`
+	Status           string    `json:"status"`
+	Message          string    `json:"message"`
+	Expiration       time.Time `json:"expiration"`
+	Template         string    `json:"template,omitempty"`
+	TemplateVersion  int       `json:"template_version,omitempty"`
+	Owner            string    `json:"owner,omitempty"`
 	TerraformOutputs map[string]TerraformOutput
+
+	// Position of the cluster in the terraform job queue while it waits
+	QueuePosition int `json:"queue_position,omitempty"`
+
+	// Failed attempts to destroy the cluster and when the next is due
+	DestroyAttempts    int        `json:"destroy_attempts,omitempty"`
+	NextDestroyAttempt *time.Time `json:"next_destroy_attempt,omitempty"`
+
+	// Thresholds the owner has been warned at ahead of the expiration
+	ExpirationWarnings []string `json:"expiration_warnings,omitempty"`
+
+	// Sensitive variables are listed by name only
+	Variables          map[string]interface{} `json:"variables,omitempty"`
+	SensitiveVariables []string               `json:"sensitive_variables,omitempty"`
+}
+
+type PlanResponse struct {
+	RequestId string           `json:"request_id"`
+	Status    string           `json:"status"`
+	Data      PlanResponseData `json:"data"`
+}
+
+type PlanResponseData struct {
+	Type       string `json:"type"`
+	Attributes *terraform.PlanSummary
+}
+
+type ClusterLogsResponse struct {
+	RequestId string                  `json:"request_id"`
+	Status    string                  `json:"status"`
+	Data      ClusterLogsResponseData `json:"data"`
+}
+
+type ClusterLogsResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.ClusterLog
+}
+
+type ClusterHistoryResponse struct {
+	RequestId string                     `json:"request_id"`
+	Status    string                     `json:"status"`
+	Data      ClusterHistoryResponseData `json:"data"`
+}
+
+type ClusterHistoryResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.ClusterHistoryEvent
+}
+
+type WebhookDeliveriesResponse struct {
+	RequestId string                        `json:"request_id"`
+	Status    string                        `json:"status"`
+	Data      WebhookDeliveriesResponseData `json:"data"`
+}
+
+type WebhookDeliveriesResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.WebhookDelivery
+}
+
+type TemplateResponse struct {
+	RequestId string               `json:"request_id"`
+	Status    string               `json:"status"`
+	Data      TemplateResponseData `json:"data"`
+}
+
+type TemplateResponseData struct {
+	Type       string `json:"type"`
+	Attributes *models.Template
+}
+
+type TemplatesResponse struct {
+	RequestId string                `json:"request_id"`
+	Status    string                `json:"status"`
+	Data      TemplatesResponseData `json:"data"`
+}
+
+type TemplatesResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.Template
+}
+
+type TokenResponse struct {
+	RequestId string            `json:"request_id"`
+	Status    string            `json:"status"`
+	Data      TokenResponseData `json:"data"`
+}
+
+type TokenResponseData struct {
+	Type       string `json:"type"`
+	Attributes *models.Token
+}
+
+type TokensResponse struct {
+	RequestId string             `json:"request_id"`
+	Status    string             `json:"status"`
+	Data      TokensResponseData `json:"data"`
+}
+
+type TokensResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.Token
+}
+
+type RoleBindingResponse struct {
+	RequestId string                  `json:"request_id"`
+	Status    string                  `json:"status"`
+	Data      RoleBindingResponseData `json:"data"`
+}
+
+type RoleBindingResponseData struct {
+	Type       string `json:"type"`
+	Attributes *models.RoleBinding
+}
+
+type RoleBindingsResponse struct {
+	RequestId string                   `json:"request_id"`
+	Status    string                   `json:"status"`
+	Data      RoleBindingsResponseData `json:"data"`
+}
+
+type RoleBindingsResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.RoleBinding
+}
+
+type QuotasResponse struct {
+	RequestId string             `json:"request_id"`
+	Status    string             `json:"status"`
+	Data      QuotasResponseData `json:"data"`
+}
+
+type QuotasResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.QuotaUsage
+}
+
+type ReapRunsResponse struct {
+	RequestId string               `json:"request_id"`
+	Status    string               `json:"status"`
+	Data      ReapRunsResponseData `json:"data"`
+}
+
+type ReapRunsResponseData struct {
+	Type       string `json:"type"`
+	Attributes []models.ReapRun
 }
 
 type TerraformOutput struct {
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type quotaService interface {
+	GetQuotas(request_id string, owner string) ([]models.QuotaUsage, error)
+}
+
+type QuotaHandler struct {
+	service quotaService
+}
+
+func NewQuotaHandler(service quotaService) *QuotaHandler {
+	return &QuotaHandler{service}
+}
+
+func ServeQuotaResources(router *mux.Router, db *sqlx.DB, store daos.ClusterStore) {
+	handler := NewQuotaHandler(services.NewQuotaService(store))
+	auth := authenticated(db)
+
+	router.Handle("/quotas", app.Adapt(
+		router,
+		handler.GetQuotas(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+}
+
+// Retrieve the usage against their limits of the quota of the requesting
+// owner and of every project. Admins may ask for the quota of another
+// owner.
+func (qh *QuotaHandler) GetQuotas() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_quotas", "request": context.RequestId()})
+
+			owner := context.Owner()
+			if requested := r.URL.Query().Get("owner"); len(requested) > 0 && requested != owner {
+				if !requireAdmin(w, context, "get_quotas_error") {
+					logger.Error(models.ErrorAdminRequired)
+					return
+				}
+				owner = requested
+			}
+
+			quotas, err := qh.service.GetQuotas(context.RequestId(), owner)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_quotas_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			respondWithJson(w, newQuotasResponse(quotas, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+func newQuotasResponse(quotas []models.QuotaUsage, request_id string) *QuotasResponse {
+	if quotas == nil {
+		quotas = []models.QuotaUsage{}
+	}
+
+	response_data := QuotasResponseData{Type: "quotas", Attributes: quotas}
+	return &QuotasResponse{RequestId: request_id, Data: response_data}
+}
@@ -0,0 +1,156 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Quota", func() {
+
+	var (
+		service             *ValidQuotaService
+		response            *httptest.ResponseRecorder
+		err                 error
+		json_err            error
+		resp                *http.Response
+		body                []byte
+		quotas_response     *QuotasResponse
+		error_response_json *ErrorResponse
+	)
+
+	serve := func(adapter app.Adapter, method string, target string, payload []byte, owner string, admin bool) {
+		// Unravel the middleware pattern to test only the Handler
+		handler := adapter(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest(method, target, bytes.NewBuffer(payload))
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		requestContext.SetPrincipal(owner, "", admin)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		service = &ValidQuotaService{}
+	})
+
+	Describe("Getting quotas", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewQuotaHandler(service).GetQuotas(), "GET", "/quotas", nil, "alice", false)
+				quotas_response = &QuotasResponse{}
+				json_err = json.Unmarshal(body, &quotas_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the usage against the limits", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(quotas_response.Data.Type).To(Equal("quotas"))
+				Expect(quotas_response.Data.Attributes).To(HaveLen(2))
+				Expect(quotas_response.Data.Attributes[0].Name).To(Equal("alice"))
+				Expect(quotas_response.Data.Attributes[0].LiveClusters).To(Equal(1))
+				Expect(quotas_response.Data.Attributes[0].MaxClusters).To(Equal(3))
+			})
+		})
+
+		Context("When an admin asks for the quota of another owner", func() {
+			BeforeEach(func() {
+				serve(NewQuotaHandler(service).GetQuotas(), "GET", "/quotas?owner=bob", nil, "ops", true)
+			})
+			It("Should return the quota of the owner", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(service.owner).To(Equal("bob"))
+			})
+		})
+
+		Context("When a non-admin asks for the quota of another owner", func() {
+			BeforeEach(func() {
+				serve(NewQuotaHandler(service).GetQuotas(), "GET", "/quotas?owner=bob", nil, "alice", false)
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(service.owner).To(BeEmpty())
+			})
+		})
+
+		Context("When the service errors", func() {
+			BeforeEach(func() {
+				serve(NewQuotaHandler(&ErroringQuotaService{}).GetQuotas(), "GET", "/quotas", nil, "alice", false)
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Describe("Creating a cluster beyond a quota", func() {
+		BeforeEach(func() {
+			handler := NewClusterHandler(&QuotaExceededClusterService{}).CreateCluster()
+			serve(handler, "PUT", "/cluster", []byte(`{"config":"{}","timeout":"10m","project":"project"}`), "alice", false)
+			error_response_json = &ErrorResponse{}
+			json_err = json.Unmarshal(body, &error_response_json)
+		})
+		It("Should return a 403", func() {
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+		})
+		It("Should explain which quota is exceeded", func() {
+			Expect(json_err).NotTo(HaveOccurred())
+			Expect(error_response_json.Data.Attributes.Detail).To(ContainSubstring("owner 'alice'"))
+		})
+	})
+})
+
+/*
+ * Valid Quota Service reports the quota of the owner and of one project
+ */
+type ValidQuotaService struct {
+	owner string
+}
+
+func (qs *ValidQuotaService) GetQuotas(request_id string, owner string) ([]models.QuotaUsage, error) {
+	qs.owner = owner
+	return []models.QuotaUsage{
+		{Scope: models.QuotaScopeOwner, Name: owner, LiveClusters: 1, MaxClusters: 3},
+		{Scope: models.QuotaScopeProject, Name: "project", LiveClusters: 4, MaxClusterHours: 100, ClusterHours: 12.5},
+	}, nil
+}
+
+type ErroringQuotaService struct{}
+
+func (qs *ErroringQuotaService) GetQuotas(request_id string, owner string) ([]models.QuotaUsage, error) {
+	return nil, errors.New("Quota service error")
+}
+
+/*
+ * Quota Exceeded Cluster Service refuses every cluster
+ */
+type QuotaExceededClusterService struct {
+	ErroringClusterService
+}
+
+func (cs *QuotaExceededClusterService) CreateCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error) {
+	return nil, fmt.Errorf("%v: owner '%v' has 3 of a maximum 3 live clusters", models.ErrorQuotaExceeded, spec.Owner)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type reaperService interface {
+	GetReapRuns(request_id string, limit int) ([]models.ReapRun, error)
+}
+
+type ReaperHandler struct {
+	service reaperService
+}
+
+func NewReaperHandler(service reaperService) *ReaperHandler {
+	return &ReaperHandler{service}
+}
+
+// The runs of the reaper are only visible to admins
+func ServeReaperResources(router *mux.Router, db *sqlx.DB) {
+	handler := NewReaperHandler(services.NewReaperService(daos.NewReaperDao(), db))
+	auth := authenticated(db)
+
+	router.Handle("/reaper/runs", app.Adapt(
+		router,
+		handler.GetReapRuns(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+}
+
+// Retrieve the most recent reap runs with the outcome of every cluster,
+// at most ?limit= of them
+func (rh *ReaperHandler) GetReapRuns() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_reap_runs", "request": context.RequestId()})
+
+			if !requireAdmin(w, context, "get_reap_runs_error") {
+				logger.Error(models.ErrorAdminRequired)
+				return
+			}
+
+			limit := models.DefaultReapRunsLimit
+			if requested := r.URL.Query().Get("limit"); len(requested) > 0 {
+				parsed, err := strconv.Atoi(requested)
+				if err != nil || parsed <= 0 || parsed > models.MaxReapRunsLimit {
+					response := ErrorResponseAttributes{Title: "get_reap_runs_error", Detail: models.ErrorInvalidReapRunsLimit}
+					logger.Error(models.ErrorInvalidReapRunsLimit)
+					respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+					return
+				}
+				limit = parsed
+			}
+
+			runs, err := rh.service.GetReapRuns(context.RequestId(), limit)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_reap_runs_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			respondWithJson(w, newReapRunsResponse(runs, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+func newReapRunsResponse(runs []models.ReapRun, request_id string) *ReapRunsResponse {
+	if runs == nil {
+		runs = []models.ReapRun{}
+	}
+
+	response_data := ReapRunsResponseData{Type: "reap_runs", Attributes: runs}
+	return &ReapRunsResponse{RequestId: request_id, Data: response_data}
+}
@@ -0,0 +1,142 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Reaper", func() {
+
+	var (
+		service            *ValidReaperService
+		response           *httptest.ResponseRecorder
+		err                error
+		json_err           error
+		resp               *http.Response
+		body               []byte
+		reap_runs_response *ReapRunsResponse
+	)
+
+	serve := func(adapter app.Adapter, target string, admin bool) {
+		// Unravel the middleware pattern to test only the Handler
+		handler := adapter(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest("GET", target, bytes.NewBuffer(nil))
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		requestContext.SetPrincipal("alice", "", admin)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		service = &ValidReaperService{}
+	})
+
+	Describe("Getting reap runs", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewReaperHandler(service).GetReapRuns(), "/reaper/runs", true)
+				reap_runs_response = &ReapRunsResponse{}
+				json_err = json.Unmarshal(body, &reap_runs_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the runs with the outcome of every cluster", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(reap_runs_response.Data.Type).To(Equal("reap_runs"))
+				Expect(reap_runs_response.Data.Attributes).To(HaveLen(1))
+				Expect(reap_runs_response.Data.Attributes[0].Failed).To(Equal(1))
+				Expect(reap_runs_response.Data.Attributes[0].Outcomes[0].Outcome).To(Equal(models.ReapOutcomeFailed))
+			})
+			It("Should default the number of runs", func() {
+				Expect(service.limit).To(Equal(models.DefaultReapRunsLimit))
+			})
+		})
+
+		Context("When a limit is given", func() {
+			BeforeEach(func() {
+				serve(NewReaperHandler(service).GetReapRuns(), "/reaper/runs?limit=5", true)
+			})
+			It("Should return at most that many runs", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(service.limit).To(Equal(5))
+			})
+		})
+
+		Context("When the limit is invalid", func() {
+			BeforeEach(func() {
+				serve(NewReaperHandler(service).GetReapRuns(), "/reaper/runs?limit=1000", true)
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(service.limit).To(Equal(0))
+			})
+		})
+
+		Context("When the requester is not an admin", func() {
+			BeforeEach(func() {
+				serve(NewReaperHandler(service).GetReapRuns(), "/reaper/runs", false)
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		Context("When the service errors", func() {
+			BeforeEach(func() {
+				serve(NewReaperHandler(&ErroringReaperService{}).GetReapRuns(), "/reaper/runs", true)
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+})
+
+/*
+ * Valid Reaper Service reports a single run which failed to reap a cluster
+ */
+type ValidReaperService struct {
+	limit int
+}
+
+func (rs *ValidReaperService) GetReapRuns(request_id string, limit int) ([]models.ReapRun, error) {
+	rs.limit = limit
+	return []models.ReapRun{
+		{
+			Id:        1,
+			RequestId: request_id,
+			Failed:    1,
+			Outcomes:  []models.ReapOutcome{{ClusterId: "cluster", Outcome: models.ReapOutcomeFailed, Message: "error"}},
+		},
+	}, nil
+}
+
+type ErroringReaperService struct{}
+
+func (rs *ErroringReaperService) GetReapRuns(request_id string, limit int) ([]models.ReapRun, error) {
+	return nil, errors.New("Reaper service error")
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type authorizer interface {
+	Authorize(request_id string, owner string, token_id string, project string, permission string) error
+}
+
+type roleService interface {
+	authorizer
+	SetRoleBinding(request_id string, binding *models.RoleBinding) (*models.RoleBinding, error)
+	GetRoleBindings(request_id string, project string) ([]models.RoleBinding, error)
+	DeleteRoleBinding(request_id string, project string, subject_type string, subject string) error
+}
+
+type RoleHandler struct {
+	service roleService
+}
+
+func NewRoleHandler(service roleService) *RoleHandler {
+	return &RoleHandler{service}
+}
+
+// Roles of a project are managed by admins and the project's admins
+func ServeRoleResources(router *mux.Router, db *sqlx.DB) {
+	handler := NewRoleHandler(services.NewRoleService(daos.NewRoleDao(), db))
+	auth := authenticated(db)
+
+	router.Handle("/projects/{project}/roles", app.Adapt(
+		router,
+		handler.GetRoleBindings(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/projects/{project}/roles/{subject_type}/{subject}", app.Adapt(
+		router,
+		handler.SetRoleBinding(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("PUT")
+
+	router.Handle("/projects/{project}/roles/{subject_type}/{subject}", app.Adapt(
+		router,
+		handler.DeleteRoleBinding(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("DELETE")
+}
+
+// Grant a role within the project to a user or a token, replacing the
+// role granted to them before
+func (rh *RoleHandler) SetRoleBinding() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "set_role_binding", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+
+			if !authorized(w, rh.service, context, vars["project"], models.PermissionManageRoles, "set_role_binding_error") {
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "set_role_binding_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			role_request := RoleRequest{}
+			err = json.Unmarshal(body, &role_request)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "set_role_binding_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to grant role '%v' on project '%v' to %v '%v'", role_request.Role, vars["project"], vars["subject_type"], vars["subject"]))
+
+			binding, err := rh.service.SetRoleBinding(context.RequestId(), &models.RoleBinding{
+				Project:     vars["project"],
+				SubjectType: vars["subject_type"],
+				Subject:     vars["subject"],
+				Role:        role_request.Role,
+			})
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorInvalidRole, models.ErrorInvalidSubjectType, models.ErrorMissingRoleSubject:
+					status = http.StatusBadRequest
+				case models.ErrorUnknownProject:
+					status = http.StatusNotFound
+				}
+				response := ErrorResponseAttributes{Title: "set_role_binding_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			respondWithJson(w, newRoleBindingResponse(binding, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Retrieve every role granted within the project
+func (rh *RoleHandler) GetRoleBindings() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_role_bindings", "request": context.RequestId()})
+
+			project := mux.Vars(r)["project"]
+
+			if !authorized(w, rh.service, context, project, models.PermissionManageRoles, "get_role_bindings_error") {
+				return
+			}
+
+			bindings, err := rh.service.GetRoleBindings(context.RequestId(), project)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_role_bindings_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			respondWithJson(w, newRoleBindingsResponse(bindings, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Revoke the role of a user or a token within the project
+func (rh *RoleHandler) DeleteRoleBinding() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "delete_role_binding", "request": context.RequestId()})
+
+			vars := mux.Vars(r)
+
+			if !authorized(w, rh.service, context, vars["project"], models.PermissionManageRoles, "delete_role_binding_error") {
+				return
+			}
+
+			err := rh.service.DeleteRoleBinding(context.RequestId(), vars["project"], vars["subject_type"], vars["subject"])
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorRoleBindingNotFound {
+					status = http.StatusNotFound
+				}
+				response := ErrorResponseAttributes{Title: "delete_role_binding_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// Check the permission within the project of the principal of the request.
+// Admins hold every permission. Without an authorizer every caller is an
+// operator of every project.
+func authorize(access authorizer, request_context app.RequestContext, project string, permission string) error {
+	if request_context.Admin() {
+		return nil
+	}
+
+	if access == nil {
+		if models.RoleGrants(models.RoleOperator, permission) {
+			return nil
+		}
+		return fmt.Errorf("%v '%v' on project '%v'", models.ErrorPermissionDenied, permission, project)
+	}
+
+	return access.Authorize(request_context.RequestId(), request_context.Owner(), request_context.TokenId(), project, permission)
+}
+
+// Respond with a 403 naming the missing permission, or a 500 when the
+// permission could not be checked
+func authorized(w http.ResponseWriter, access authorizer, request_context app.RequestContext, project string, permission string, title string) bool {
+	err := authorize(access, request_context, project, permission)
+	if err == nil {
+		return true
+	}
+
+	status := http.StatusInternalServerError
+	if permissionDenied(err) {
+		status = http.StatusForbidden
+	}
+
+	logger := log.WithFields(log.Fields{"package": "handlers", "event": "authorize", "request": request_context.RequestId()})
+	logger.Error(err.Error())
+
+	response := ErrorResponseAttributes{Title: title, Detail: err.Error()}
+	respondWithJson(w, newErrorResponse(&response, request_context.RequestId()), status)
+	return false
+}
+
+func permissionDenied(err error) bool {
+	return strings.HasPrefix(err.Error(), models.ErrorPermissionDenied)
+}
+
+func newRoleBindingResponse(binding *models.RoleBinding, request_id string) *RoleBindingResponse {
+	response_data := RoleBindingResponseData{Type: "role_binding", Attributes: binding}
+	return &RoleBindingResponse{RequestId: request_id, Data: response_data}
+}
+
+func newRoleBindingsResponse(bindings []models.RoleBinding, request_id string) *RoleBindingsResponse {
+	if bindings == nil {
+		bindings = []models.RoleBinding{}
+	}
+
+	response_data := RoleBindingsResponseData{Type: "role_bindings", Attributes: bindings}
+	return &RoleBindingsResponse{RequestId: request_id, Data: response_data}
+}
@@ -0,0 +1,333 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Role", func() {
+
+	var (
+		service             *ValidRoleService
+		response            *httptest.ResponseRecorder
+		err                 error
+		json_err            error
+		resp                *http.Response
+		body                []byte
+		binding_response    *RoleBindingResponse
+		bindings_response   *RoleBindingsResponse
+		error_response_json *ErrorResponse
+	)
+
+	serve := func(adapter app.Adapter, method string, target string, payload []byte, vars map[string]string, owner string) {
+		// Unravel the middleware pattern to test only the Handler
+		handler := adapter(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest(method, target, bytes.NewBuffer(payload))
+		request = mux.SetURLVars(request, vars)
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		requestContext.SetPrincipal(owner, "", false)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	binding := map[string]string{"project": "project", "subject_type": "user", "subject": "alice"}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		service = &ValidRoleService{roles: map[string]string{"ops": models.RoleAdmin, "alice": models.RoleViewer}}
+	})
+
+	Describe("Setting a role binding", func() {
+		Context("When the caller administers the project", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(service).SetRoleBinding(), "PUT", "/projects/project/roles/user/alice", []byte(`{"role":"operator"}`), binding, "ops")
+				binding_response = &RoleBindingResponse{}
+				json_err = json.Unmarshal(body, &binding_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the role binding", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(binding_response.Data.Type).To(Equal("role_binding"))
+				Expect(binding_response.Data.Attributes.Subject).To(Equal("alice"))
+				Expect(binding_response.Data.Attributes.Role).To(Equal(models.RoleOperator))
+			})
+		})
+
+		Context("When the role is invalid", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(service).SetRoleBinding(), "PUT", "/projects/project/roles/user/alice", []byte(`{"role":"owner"}`), binding, "ops")
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When the caller does not administer the project", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(service).SetRoleBinding(), "PUT", "/projects/project/roles/user/alice", []byte(`{"role":"admin"}`), binding, "alice")
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+			It("Should name the missing permission", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(error_response_json.Data.Attributes.Detail).To(Equal("missing permission 'roles:manage' on project 'project'"))
+			})
+			It("Should not set the role binding", func() {
+				Expect(service.roles["alice"]).To(Equal(models.RoleViewer))
+			})
+		})
+	})
+
+	Describe("Getting the role bindings of a project", func() {
+		Context("When the caller administers the project", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(service).GetRoleBindings(), "GET", "/projects/project/roles", nil, map[string]string{"project": "project"}, "ops")
+				bindings_response = &RoleBindingsResponse{}
+				json_err = json.Unmarshal(body, &bindings_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the role bindings", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(bindings_response.Data.Type).To(Equal("role_bindings"))
+				Expect(bindings_response.Data.Attributes).To(HaveLen(2))
+			})
+		})
+
+		Context("When the permission cannot be checked", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(&ErroringRoleService{}).GetRoleBindings(), "GET", "/projects/project/roles", nil, map[string]string{"project": "project"}, "ops")
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Describe("Deleting a role binding", func() {
+		Context("When the role binding exists", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(service).DeleteRoleBinding(), "DELETE", "/projects/project/roles/user/alice", nil, binding, "ops")
+			})
+			It("Should return a 204", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			})
+			It("Should revoke the role", func() {
+				Expect(service.roles).NotTo(HaveKey("alice"))
+			})
+		})
+
+		Context("When the role binding does not exist", func() {
+			BeforeEach(func() {
+				serve(NewRoleHandler(service).DeleteRoleBinding(), "DELETE", "/projects/project/roles/user/bob", nil, map[string]string{"project": "project", "subject_type": "user", "subject": "bob"}, "ops")
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Creating a cluster within a project", func() {
+		var cluster_service *ValidClusterService
+
+		serveCreate := func(owner string) {
+			cluster_service = NewValidClusterService()
+			handler := NewClusterHandler(cluster_service).WithAccess(service).CreateCluster()
+			serve(handler, "PUT", "/cluster", []byte(`{"config":"{}","timeout":"10m","project":"project"}`), nil, owner)
+		}
+
+		Context("When the role of the caller grants creating clusters", func() {
+			BeforeEach(func() {
+				serveCreate("ops")
+			})
+			It("Should create the cluster", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(cluster_service.spec).NotTo(BeNil())
+			})
+		})
+
+		Context("When the role of the caller does not grant creating clusters", func() {
+			BeforeEach(func() {
+				serveCreate("alice")
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+			It("Should name the missing permission", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(error_response_json.Data.Attributes.Detail).To(Equal("missing permission 'clusters:create' on project 'project'"))
+			})
+			It("Should not create the cluster", func() {
+				Expect(cluster_service.spec).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Reading a cluster within a project", func() {
+		Context("When the caller views the project", func() {
+			It("Should return the cluster of another owner", func() {
+				service.roles["carol"] = models.RoleViewer
+				handler := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(service).GetCluster()
+				serve(handler, "GET", "/cluster/id", nil, map[string]string{"id": "1"}, "carol")
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("When the caller has no role within the project", func() {
+			It("Should not return the history of the cluster", func() {
+				handler := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(service).GetClusterHistory()
+				serve(handler, "GET", "/cluster/id/history", nil, map[string]string{"id": "1"}, "carol")
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(string(body)).To(ContainSubstring(models.PermissionReadClusters))
+			})
+		})
+	})
+
+	Describe("Changing the expiration of a cluster within a project", func() {
+		serveExpiration := func(owner string) {
+			handler := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, nil}).WithAccess(service).UpdateClusterExpiration()
+			serve(handler, "PATCH", "/cluster/id/expiration", []byte(`{"timeout":"1h"}`), map[string]string{"id": "1"}, owner)
+		}
+
+		Context("When the caller operates the project", func() {
+			It("Should change the expiration of their own cluster", func() {
+				service.roles["alice"] = models.RoleOperator
+				serveExpiration("alice")
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("When the caller only views the project", func() {
+			It("Should not change the expiration of their own cluster", func() {
+				serveExpiration("alice")
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(string(body)).To(ContainSubstring(models.PermissionUpdateClusters))
+			})
+
+			It("Should not change the expiration of the cluster of another owner", func() {
+				service.roles["carol"] = models.RoleViewer
+				serveExpiration("carol")
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(string(body)).To(ContainSubstring(models.PermissionDeleteAnyCluster))
+			})
+		})
+	})
+
+	Describe("Deleting a cluster within a project", func() {
+		var deleted bool
+
+		serveDelete := func(owner string) {
+			deleted = false
+			handler := NewClusterHandler(&OwnedClusterService{ValidClusterService{}, &deleted}).WithAccess(service).DeleteCluster()
+			serve(handler, "DELETE", "/cluster/id", nil, map[string]string{"id": "1"}, owner)
+		}
+
+		Context("When the caller administers the project", func() {
+			It("Should delete the cluster of another owner", func() {
+				serveDelete("ops")
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+				Expect(deleted).To(BeTrue())
+			})
+		})
+
+		Context("When the caller only views the project", func() {
+			It("Should not delete their own cluster", func() {
+				serveDelete("alice")
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(string(body)).To(ContainSubstring(models.PermissionDeleteClusters))
+				Expect(deleted).To(BeFalse())
+			})
+		})
+	})
+})
+
+/*
+ * Valid Role Service grants each user a single role within every project
+ */
+type ValidRoleService struct {
+	roles map[string]string
+}
+
+func (rs *ValidRoleService) Authorize(request_id string, owner string, token_id string, project string, permission string) error {
+	if models.RoleGrants(rs.roles[owner], permission) {
+		return nil
+	}
+	return fmt.Errorf("%v '%v' on project '%v'", models.ErrorPermissionDenied, permission, project)
+}
+
+func (rs *ValidRoleService) SetRoleBinding(request_id string, binding *models.RoleBinding) (*models.RoleBinding, error) {
+	if !models.ValidRole(binding.Role) {
+		return nil, errors.New(models.ErrorInvalidRole)
+	}
+	rs.roles[binding.Subject] = binding.Role
+	return binding, nil
+}
+
+func (rs *ValidRoleService) GetRoleBindings(request_id string, project string) ([]models.RoleBinding, error) {
+	bindings := []models.RoleBinding{}
+	for subject, role := range rs.roles {
+		bindings = append(bindings, models.RoleBinding{Project: project, SubjectType: models.SubjectTypeUser, Subject: subject, Role: role})
+	}
+	return bindings, nil
+}
+
+func (rs *ValidRoleService) DeleteRoleBinding(request_id string, project string, subject_type string, subject string) error {
+	if _, exists := rs.roles[subject]; !exists {
+		return errors.New(models.ErrorRoleBindingNotFound)
+	}
+	delete(rs.roles, subject)
+	return nil
+}
+
+/*
+ * Erroring Role Service cannot check or change roles
+ */
+type ErroringRoleService struct{}
+
+func (rs *ErroringRoleService) Authorize(request_id string, owner string, token_id string, project string, permission string) error {
+	return errors.New("Role service error")
+}
+
+func (rs *ErroringRoleService) SetRoleBinding(request_id string, binding *models.RoleBinding) (*models.RoleBinding, error) {
+	return nil, errors.New("Role service error")
+}
+
+func (rs *ErroringRoleService) GetRoleBindings(request_id string, project string) ([]models.RoleBinding, error) {
+	return nil, errors.New("Role service error")
+}
+
+func (rs *ErroringRoleService) DeleteRoleBinding(request_id string, project string, subject_type string, subject string) error {
+	return errors.New("Role service error")
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type stateService interface {
+	AuthenticateClusterState(request_id string, id string, username string, password string) error
+	GetClusterState(request_id string, id string) ([]byte, error)
+	UpdateClusterState(request_id string, id string, lock_id string, state []byte) error
+	LockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error)
+	UnlockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error)
+}
+
+// Serves the terraform http backend protocol, terraform reads and writes
+// the state of a cluster and locks it through /cluster/{id}/state
+type StateHandler struct {
+	service stateService
+}
+
+func NewStateHandler(service stateService) *StateHandler {
+	return &StateHandler{service}
+}
+
+// Serve the state of clusters when taos is configured as the state backend
+// of terraform
+func ServeStateResources(router *mux.Router, store daos.ClusterStore) {
+	logger := log.WithFields(log.Fields{"package": "handlers", "event": "serve_state_resources", "request": nil})
+
+	if len(app.GlobalServerConfig.StateBackend.Address) == 0 {
+		return
+	}
+
+	service, err := services.NewStateService(store, app.GlobalServerConfig.StateBackend)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	handler := NewStateHandler(service)
+	auth := handler.WithStateAuthentication()
+
+	router.Handle("/cluster/{id}/state", app.Adapt(
+		router,
+		handler.GetClusterState(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/cluster/{id}/state", app.Adapt(
+		router,
+		handler.UpdateClusterState(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("POST")
+
+	router.Handle("/cluster/{id}/state", app.Adapt(
+		router,
+		handler.LockClusterState(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("LOCK")
+
+	router.Handle("/cluster/{id}/state", app.Adapt(
+		router,
+		handler.UnlockClusterState(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("UNLOCK")
+}
+
+// Middleware refusing requests without the basic auth credentials of the
+// state of the cluster, the only credentials terraform can send
+func (sh *StateHandler) WithStateAuthentication() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "authenticate_cluster_state", "request": context.RequestId()})
+
+			username, password, _ := r.BasicAuth()
+
+			err := sh.service.AuthenticateClusterState(context.RequestId(), mux.Vars(r)["id"], username, password)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="taos"`)
+				response := ErrorResponseAttributes{Title: "authentication_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusUnauthorized)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Respond with the state of the cluster as it is stored, without content
+// when terraform has not yet written a state
+func (sh *StateHandler) GetClusterState() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_cluster_state", "request": context.RequestId()})
+
+			id := mux.Vars(r)["id"]
+
+			state, err := sh.service.GetClusterState(context.RequestId(), id)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorClusterNotFound {
+					status = http.StatusNotFound
+				}
+				response := ErrorResponseAttributes{Title: "get_cluster_state_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			if len(state) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(state)
+		})
+	}
+}
+
+// Replace the state of the cluster with the body. Terraform holding a lock
+// on the state passes the id of its lock as the ID query parameter.
+func (sh *StateHandler) UpdateClusterState() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "update_cluster_state", "request": context.RequestId()})
+
+			id := mux.Vars(r)["id"]
+
+			state, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "update_cluster_state_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			err = sh.service.UpdateClusterState(context.RequestId(), id, r.URL.Query().Get("ID"), state)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorInvalidClusterState:
+					status = http.StatusBadRequest
+				case models.ErrorClusterNotFound:
+					status = http.StatusNotFound
+				case models.ErrorClusterStateLocked:
+					status = http.StatusLocked
+				}
+				response := ErrorResponseAttributes{Title: "update_cluster_state_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+}
+
+// Lock the state of the cluster with the lock in the body. A state already
+// locked is refused with the lock holding it, which terraform reports.
+// Terraform run by a job of taos passes the id of the job as the job query
+// parameter, which is recorded with the lock.
+func (sh *StateHandler) LockClusterState() app.Adapter {
+	return sh.serveStateLock("lock_cluster_state", sh.service.LockClusterState)
+}
+
+// Unlock the state of the cluster locked with the lock in the body
+func (sh *StateHandler) UnlockClusterState() app.Adapter {
+	return sh.serveStateLock("unlock_cluster_state", sh.service.UnlockClusterState)
+}
+
+func (sh *StateHandler) serveStateLock(event string, change func(string, string, *models.StateLock) (*models.StateLock, error)) app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": event, "request": context.RequestId()})
+
+			id := mux.Vars(r)["id"]
+
+			lock := &models.StateLock{}
+			body, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				err = json.Unmarshal(body, lock)
+			}
+			if err == nil {
+				lock.Job = 0
+				if job := r.URL.Query().Get("job"); len(job) > 0 {
+					lock.Job, err = strconv.ParseInt(job, 10, 64)
+				}
+			}
+			if err != nil {
+				response := ErrorResponseAttributes{Title: event + "_error", Detail: models.ErrorInvalidStateLock}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			current, err := change(context.RequestId(), id, lock)
+			if err != nil {
+				// Terraform reads the lock holding the state from the body
+				if err.Error() == models.ErrorClusterStateLocked && current != nil {
+					logger.Info(fmt.Sprintf("terraform state of cluster '%v' is held by lock '%v'", id, current.ID))
+					respondWithJson(w, current, http.StatusLocked)
+					return
+				}
+
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorInvalidStateLock:
+					status = http.StatusBadRequest
+				case models.ErrorClusterNotFound:
+					status = http.StatusNotFound
+				}
+				response := ErrorResponseAttributes{Title: event + "_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("State", func() {
+
+	var (
+		service   *ValidStateService
+		handler   *StateHandler
+		response  *httptest.ResponseRecorder
+		resp      *http.Response
+		body      []byte
+		err       error
+		clusterId string
+	)
+
+	serve := func(adapter app.Adapter, method string, target string, payload []byte) {
+		// Unravel the middleware pattern to test only the Handler
+		h := adapter(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest(method, target, bytes.NewReader(payload))
+		request = mux.SetURLVars(request, map[string]string{"id": clusterId})
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		h.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		clusterId = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+		service = &ValidStateService{state: []byte(`{"serial":1}`)}
+		handler = NewStateHandler(service)
+	})
+
+	Describe("Authenticating", func() {
+		var reached bool
+
+		serveAuthenticated := func(username string, password string) {
+			reached = false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			})
+
+			request := httptest.NewRequest("GET", "/cluster/"+clusterId+"/state", nil)
+			request = mux.SetURLVars(request, map[string]string{"id": clusterId})
+			if len(username) > 0 {
+				request.SetBasicAuth(username, password)
+			}
+
+			response = httptest.NewRecorder()
+			requestContext := app.NewRequestContext(request.Context(), request)
+			ctx := context.WithValue(request.Context(), "request", requestContext)
+
+			handler.WithStateAuthentication()(next).ServeHTTP(response, request.WithContext(ctx))
+			resp = response.Result()
+		}
+
+		Context("When the credentials are of the cluster", func() {
+			It("Should serve the request", func() {
+				serveAuthenticated(clusterId, "password")
+				Expect(reached).To(BeTrue())
+			})
+		})
+
+		Context("When there are no credentials", func() {
+			It("Should return a 401", func() {
+				serveAuthenticated("", "")
+				Expect(reached).To(BeFalse())
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Expect(resp.Header.Get("WWW-Authenticate")).To(ContainSubstring("Basic"))
+			})
+		})
+	})
+
+	Describe("Getting the state", func() {
+		It("Should return the state as it is stored", func() {
+			serve(handler.GetClusterState(), "GET", "/cluster/"+clusterId+"/state", nil)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(MatchJSON(`{"serial":1}`))
+		})
+
+		Context("When terraform has not yet written a state", func() {
+			It("Should return a 204", func() {
+				service.state = nil
+				serve(handler.GetClusterState(), "GET", "/cluster/"+clusterId+"/state", nil)
+				Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			It("Should return a 404", func() {
+				handler = NewStateHandler(&ErroringStateService{err: errors.New(models.ErrorClusterNotFound)})
+				serve(handler.GetClusterState(), "GET", "/cluster/"+clusterId+"/state", nil)
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Updating the state", func() {
+		It("Should store the state with the id of the lock", func() {
+			serve(handler.UpdateClusterState(), "POST", "/cluster/"+clusterId+"/state?ID=lock-1", []byte(`{"serial":2}`))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(service.state).To(MatchJSON(`{"serial":2}`))
+			Expect(service.lockId).To(Equal("lock-1"))
+		})
+
+		Context("When the state is locked by another lock", func() {
+			It("Should return a 423", func() {
+				handler = NewStateHandler(&ErroringStateService{err: errors.New(models.ErrorClusterStateLocked)})
+				serve(handler.UpdateClusterState(), "POST", "/cluster/"+clusterId+"/state?ID=lock-2", []byte(`{"serial":2}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusLocked))
+			})
+		})
+	})
+
+	Describe("Locking the state", func() {
+		It("Should lock the state with the lock", func() {
+			serve(handler.LockClusterState(), "LOCK", "/cluster/"+clusterId+"/state", []byte(`{"ID":"lock-1","Operation":"OperationTypeApply"}`))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(service.lock.ID).To(Equal("lock-1"))
+			Expect(service.lock.Operation).To(Equal("OperationTypeApply"))
+			Expect(service.lock.Job).To(BeZero())
+		})
+
+		Context("When terraform is run by a job", func() {
+			It("Should record the job with the lock", func() {
+				serve(handler.LockClusterState(), "LOCK", "/cluster/"+clusterId+"/state?job=3", []byte(`{"ID":"lock-1","Job":7}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(service.lock.Job).To(Equal(int64(3)))
+			})
+		})
+
+		Context("When the job is not a number", func() {
+			It("Should return a 400", func() {
+				serve(handler.LockClusterState(), "LOCK", "/cluster/"+clusterId+"/state?job=job", []byte(`{"ID":"lock-1"}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When the state is already locked", func() {
+			It("Should return a 423 with the lock holding the state", func() {
+				service.lock = &models.StateLock{ID: "lock-1", Who: "taos"}
+				serve(handler.LockClusterState(), "LOCK", "/cluster/"+clusterId+"/state", []byte(`{"ID":"lock-2"}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusLocked))
+
+				current := models.StateLock{}
+				Expect(json.Unmarshal(body, &current)).To(Succeed())
+				Expect(current.ID).To(Equal("lock-1"))
+				Expect(current.Who).To(Equal("taos"))
+			})
+		})
+
+		Context("When the lock is not json", func() {
+			It("Should return a 400", func() {
+				serve(handler.LockClusterState(), "LOCK", "/cluster/"+clusterId+"/state", []byte(`lock`))
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Describe("Unlocking the state", func() {
+		It("Should unlock the state locked with the lock", func() {
+			service.lock = &models.StateLock{ID: "lock-1"}
+			serve(handler.UnlockClusterState(), "UNLOCK", "/cluster/"+clusterId+"/state", []byte(`{"ID":"lock-1"}`))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(service.lock).To(BeNil())
+		})
+
+		Context("When the state is locked by another lock", func() {
+			It("Should return a 423", func() {
+				service.lock = &models.StateLock{ID: "lock-1"}
+				serve(handler.UnlockClusterState(), "UNLOCK", "/cluster/"+clusterId+"/state", []byte(`{"ID":"lock-2"}`))
+				Expect(resp.StatusCode).To(Equal(http.StatusLocked))
+				Expect(service.lock.ID).To(Equal("lock-1"))
+			})
+		})
+	})
+})
+
+type ValidStateService struct {
+	state  []byte
+	lockId string
+	lock   *models.StateLock
+}
+
+func (ss *ValidStateService) AuthenticateClusterState(request_id string, id string, username string, password string) error {
+	if username != id {
+		return errors.New(models.ErrorInvalidStateCredentials)
+	}
+	return nil
+}
+
+func (ss *ValidStateService) GetClusterState(request_id string, id string) ([]byte, error) {
+	return ss.state, nil
+}
+
+func (ss *ValidStateService) UpdateClusterState(request_id string, id string, lock_id string, state []byte) error {
+	ss.lockId = lock_id
+	ss.state = state
+	return nil
+}
+
+func (ss *ValidStateService) LockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error) {
+	if ss.lock != nil && ss.lock.ID != lock.ID {
+		return ss.lock, errors.New(models.ErrorClusterStateLocked)
+	}
+	ss.lock = lock
+	return lock, nil
+}
+
+func (ss *ValidStateService) UnlockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error) {
+	if ss.lock != nil && ss.lock.ID != lock.ID {
+		return ss.lock, errors.New(models.ErrorClusterStateLocked)
+	}
+	ss.lock = nil
+	return nil, nil
+}
+
+type ErroringStateService struct {
+	err error
+}
+
+func (ss *ErroringStateService) AuthenticateClusterState(request_id string, id string, username string, password string) error {
+	return ss.err
+}
+
+func (ss *ErroringStateService) GetClusterState(request_id string, id string) ([]byte, error) {
+	return nil, ss.err
+}
+
+func (ss *ErroringStateService) UpdateClusterState(request_id string, id string, lock_id string, state []byte) error {
+	return ss.err
+}
+
+func (ss *ErroringStateService) LockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error) {
+	return nil, ss.err
+}
+
+func (ss *ErroringStateService) UnlockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error) {
+	return nil, ss.err
+}
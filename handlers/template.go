@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type templateResourceService interface {
+	CreateTemplate(request_id string, template *models.Template) (*models.Template, error)
+	GetTemplate(request_id string, name string, version int) (*models.Template, error)
+	GetTemplates(request_id string) ([]models.Template, error)
+	GetTemplateVersions(request_id string, name string) ([]models.Template, error)
+	DeleteTemplate(request_id string, name string, version int) error
+}
+
+type TemplateHandler struct {
+	service templateResourceService
+}
+
+func NewTemplateHandler(service templateResourceService) *TemplateHandler {
+	return &TemplateHandler{service}
+}
+
+func ServeTemplateResources(router *mux.Router, db *sqlx.DB) {
+	handler := NewTemplateHandler(services.NewTemplateService(daos.NewTemplateDao(), db))
+	auth := authenticated(db)
+
+	router.Handle("/templates", app.Adapt(
+		router,
+		handler.GetTemplates(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/templates/{name}", app.Adapt(
+		router,
+		handler.CreateTemplate(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("PUT")
+
+	router.Handle("/templates/{name}", app.Adapt(
+		router,
+		handler.GetTemplate(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/templates/{name}/versions", app.Adapt(
+		router,
+		handler.GetTemplateVersions(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/templates/{name}", app.Adapt(
+		router,
+		handler.DeleteTemplate(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("DELETE")
+}
+
+// Register a new version of the template with the name in the path
+func (th *TemplateHandler) CreateTemplate() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "create_template", "request": context.RequestId()})
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "create_template_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			template_request := TemplateRequest{}
+			err = json.Unmarshal(body, &template_request)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "create_template_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			name := mux.Vars(r)["name"]
+
+			logger.Info(fmt.Sprintf("new request to create template '%v'", name))
+
+			template, err := th.service.CreateTemplate(context.RequestId(), &models.Template{
+				Name:        name,
+				Description: template_request.Description,
+				Config:      template_request.Config,
+				Variables:   template_request.Variables,
+			})
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch err.Error() {
+				case models.ErrorMissingTemplateName, models.ErrorInvalidTemplateName, models.ErrorMissingTemplateConfig, models.ErrorInvalidTemplate:
+					status = http.StatusBadRequest
+				}
+				response := ErrorResponseAttributes{Title: "create_template_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			respondWithJson(w, newTemplateResponse(template, context.RequestId()), http.StatusCreated)
+		})
+	}
+}
+
+// Retrieve the latest version of a template, or the version requested
+func (th *TemplateHandler) GetTemplate() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_template", "request": context.RequestId()})
+
+			version, err := templateVersion(r)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_template_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			template, err := th.service.GetTemplate(context.RequestId(), mux.Vars(r)["name"], version)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_template_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), templateErrorStatus(err))
+				return
+			}
+
+			respondWithJson(w, newTemplateResponse(template, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Retrieve the latest version of every template
+func (th *TemplateHandler) GetTemplates() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_templates", "request": context.RequestId()})
+
+			templates, err := th.service.GetTemplates(context.RequestId())
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_templates_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			respondWithJson(w, newTemplatesResponse(templates, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Retrieve every version of a template, latest first
+func (th *TemplateHandler) GetTemplateVersions() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_template_versions", "request": context.RequestId()})
+
+			templates, err := th.service.GetTemplateVersions(context.RequestId(), mux.Vars(r)["name"])
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_template_versions_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), templateErrorStatus(err))
+				return
+			}
+
+			respondWithJson(w, newTemplatesResponse(templates, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Delete every version of a template, or only the version requested
+func (th *TemplateHandler) DeleteTemplate() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "delete_template", "request": context.RequestId()})
+
+			version, err := templateVersion(r)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "delete_template_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			err = th.service.DeleteTemplate(context.RequestId(), mux.Vars(r)["name"], version)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "delete_template_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), templateErrorStatus(err))
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// The version query parameter, 0 when absent
+func templateVersion(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("version")
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, errors.New(models.ErrorInvalidTemplateVersion)
+	}
+
+	return version, nil
+}
+
+func templateErrorStatus(err error) int {
+	switch err.Error() {
+	case models.ErrorTemplateNotFound:
+		return http.StatusNotFound
+	case models.ErrorMissingTemplateName, models.ErrorInvalidTemplateVersion:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func newTemplateResponse(template *models.Template, request_id string) *TemplateResponse {
+	response_data := TemplateResponseData{Type: "template", Attributes: template}
+	return &TemplateResponse{RequestId: request_id, Data: response_data}
+}
+
+func newTemplatesResponse(templates []models.Template, request_id string) *TemplatesResponse {
+	if templates == nil {
+		templates = []models.Template{}
+	}
+
+	response_data := TemplatesResponseData{Type: "templates", Attributes: templates}
+	return &TemplatesResponse{RequestId: request_id, Data: response_data}
+}
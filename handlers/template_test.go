@@ -0,0 +1,263 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Template", func() {
+
+	var (
+		service             *ValidTemplateService
+		response            *httptest.ResponseRecorder
+		err                 error
+		json_err            error
+		resp                *http.Response
+		body                []byte
+		template_response   *TemplateResponse
+		templates_response  *TemplatesResponse
+		error_response_json *ErrorResponse
+	)
+
+	serve := func(adapter app.Adapter, method string, target string, payload []byte, vars map[string]string) {
+		// Unravel the middleware pattern to test only the Handler
+		handler := adapter(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest(method, target, bytes.NewBuffer(payload))
+		request = mux.SetURLVars(request, vars)
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		service = &ValidTemplateService{}
+	})
+
+	Describe("Creating a template", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				payload := []byte(`{"description":"three nodes","config":"{\"nodes\":{{.nodes}}}","variables":[{"name":"nodes","default":3}]}`)
+				serve(NewTemplateHandler(service).CreateTemplate(), "PUT", "/templates/gpdb-3node", payload, map[string]string{"name": "gpdb-3node"})
+				template_response = &TemplateResponse{}
+				json_err = json.Unmarshal(body, &template_response)
+			})
+			It("Should return a 201", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			})
+			It("Should return the new version of the template", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(template_response.Data.Type).To(Equal("template"))
+				Expect(template_response.Data.Attributes.Name).To(Equal("gpdb-3node"))
+				Expect(template_response.Data.Attributes.Version).To(Equal(2))
+				Expect(template_response.Data.Attributes.Variables).To(HaveLen(1))
+			})
+		})
+
+		Context("When the template is invalid", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).CreateTemplate(), "PUT", "/templates/gpdb-3node", []byte(`{"config":""}`), map[string]string{"name": "gpdb-3node"})
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("Should return an error", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(error_response_json.Data.Attributes.Detail).To(Equal(models.ErrorMissingTemplateConfig))
+			})
+		})
+	})
+
+	Describe("Getting a template", func() {
+		Context("When a version is requested", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).GetTemplate(), "GET", "/templates/gpdb-3node?version=1", nil, map[string]string{"name": "gpdb-3node"})
+				template_response = &TemplateResponse{}
+				json_err = json.Unmarshal(body, &template_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the version", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(template_response.Data.Attributes.Version).To(Equal(1))
+			})
+		})
+
+		Context("When the version is invalid", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).GetTemplate(), "GET", "/templates/gpdb-3node?version=foo", nil, map[string]string{"name": "gpdb-3node"})
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When the template does not exist", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).GetTemplate(), "GET", "/templates/foo", nil, map[string]string{"name": "foo"})
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Describe("Getting templates", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).GetTemplates(), "GET", "/templates", nil, map[string]string{})
+				templates_response = &TemplatesResponse{}
+				json_err = json.Unmarshal(body, &templates_response)
+			})
+			It("Should return the templates", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(templates_response.Data.Type).To(Equal("templates"))
+				Expect(templates_response.Data.Attributes).To(HaveLen(1))
+			})
+		})
+
+		Context("When the service errors", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(&ErroringTemplateService{}).GetTemplates(), "GET", "/templates", nil, map[string]string{})
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Describe("Deleting a template", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).DeleteTemplate(), "DELETE", "/templates/gpdb-3node?version=2", nil, map[string]string{"name": "gpdb-3node"})
+			})
+			It("Should return a 204", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			})
+			It("Should delete the version", func() {
+				Expect(service.deleted).To(Equal("gpdb-3node:2"))
+			})
+		})
+
+		Context("When the template does not exist", func() {
+			BeforeEach(func() {
+				serve(NewTemplateHandler(service).DeleteTemplate(), "DELETE", "/templates/foo", nil, map[string]string{"name": "foo"})
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+})
+
+// Knows only the template gpdb-3node, whose latest version is 2 and which
+// requires the variable nodes
+type ValidTemplateService struct {
+	deleted string
+}
+
+func (ts *ValidTemplateService) template(name string, version int) (*models.Template, error) {
+	if name != "gpdb-3node" || version > 2 {
+		return nil, errors.New(models.ErrorTemplateNotFound)
+	}
+	if version == 0 {
+		version = 2
+	}
+	return &models.Template{Name: name, Version: version, Config: `{"nodes":{{.nodes}}}`, Variables: models.TemplateVariables{{Name: "nodes"}}}, nil
+}
+
+func (ts *ValidTemplateService) CreateTemplate(request_id string, template *models.Template) (*models.Template, error) {
+	if len(template.Config) == 0 {
+		return nil, errors.New(models.ErrorMissingTemplateConfig)
+	}
+	created := *template
+	created.Version = 2
+	return &created, nil
+}
+
+func (ts *ValidTemplateService) GetTemplate(request_id string, name string, version int) (*models.Template, error) {
+	return ts.template(name, version)
+}
+
+func (ts *ValidTemplateService) GetTemplates(request_id string) ([]models.Template, error) {
+	template, _ := ts.template("gpdb-3node", 0)
+	return []models.Template{*template}, nil
+}
+
+func (ts *ValidTemplateService) GetTemplateVersions(request_id string, name string) ([]models.Template, error) {
+	template, err := ts.template(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	return []models.Template{*template}, nil
+}
+
+func (ts *ValidTemplateService) DeleteTemplate(request_id string, name string, version int) error {
+	if _, err := ts.template(name, version); err != nil {
+		return err
+	}
+	ts.deleted = fmt.Sprintf("%v:%v", name, version)
+	return nil
+}
+
+func (ts *ValidTemplateService) RenderTemplate(request_id string, name string, version int, variables map[string]interface{}) (*models.Template, []byte, error) {
+	template, err := ts.template(name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes, ok := variables["nodes"]
+	if !ok {
+		return nil, nil, fmt.Errorf("%v 'nodes'", models.ErrorMissingTemplateVariable)
+	}
+	return template, []byte(fmt.Sprintf(`{"nodes":%v}`, nodes)), nil
+}
+
+type ErroringTemplateService struct{}
+
+func (ts *ErroringTemplateService) CreateTemplate(request_id string, template *models.Template) (*models.Template, error) {
+	return nil, errors.New("foo")
+}
+
+func (ts *ErroringTemplateService) GetTemplate(request_id string, name string, version int) (*models.Template, error) {
+	return nil, errors.New("foo")
+}
+
+func (ts *ErroringTemplateService) GetTemplates(request_id string) ([]models.Template, error) {
+	return nil, errors.New("foo")
+}
+
+func (ts *ErroringTemplateService) GetTemplateVersions(request_id string, name string) ([]models.Template, error) {
+	return nil, errors.New("foo")
+}
+
+func (ts *ErroringTemplateService) DeleteTemplate(request_id string, name string, version int) error {
+	return errors.New("foo")
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type tokenService interface {
+	CreateToken(request_id string, owner string, description string, admin bool) (*models.Token, error)
+	GetTokens(request_id string) ([]models.Token, error)
+	DeleteToken(request_id string, id string) error
+}
+
+type TokenHandler struct {
+	service tokenService
+}
+
+func NewTokenHandler(service tokenService) *TokenHandler {
+	return &TokenHandler{service}
+}
+
+// Tokens are managed by admins only
+func ServeTokenResources(router *mux.Router, db *sqlx.DB) {
+	handler := NewTokenHandler(services.NewTokenService(daos.NewTokenDao(), db, app.GlobalServerConfig.AdminToken))
+	auth := authenticated(db)
+
+	router.Handle("/tokens", app.Adapt(
+		router,
+		handler.CreateToken(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("POST")
+
+	router.Handle("/tokens", app.Adapt(
+		router,
+		handler.GetTokens(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/tokens/{id}", app.Adapt(
+		router,
+		handler.DeleteToken(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("DELETE")
+}
+
+// Issue a token, the response is the only time the token is returned
+func (th *TokenHandler) CreateToken() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "create_token", "request": context.RequestId()})
+
+			if !requireAdmin(w, context, "create_token_error") {
+				logger.Error(models.ErrorAdminRequired)
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "create_token_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			token_request := TokenRequest{}
+			err = json.Unmarshal(body, &token_request)
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "create_token_error", Detail: err.Error()}
+				logger.Error(err)
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+				return
+			}
+
+			logger.Info(fmt.Sprintf("new request to create token for '%v'", token_request.Owner))
+
+			token, err := th.service.CreateToken(context.RequestId(), token_request.Owner, token_request.Description, token_request.Admin)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorMissingTokenOwner {
+					status = http.StatusBadRequest
+				}
+				response := ErrorResponseAttributes{Title: "create_token_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			respondWithJson(w, newTokenResponse(token, context.RequestId()), http.StatusCreated)
+		})
+	}
+}
+
+// Retrieve every token, without the tokens themselves
+func (th *TokenHandler) GetTokens() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_tokens", "request": context.RequestId()})
+
+			if !requireAdmin(w, context, "get_tokens_error") {
+				logger.Error(models.ErrorAdminRequired)
+				return
+			}
+
+			tokens, err := th.service.GetTokens(context.RequestId())
+			if err != nil {
+				response := ErrorResponseAttributes{Title: "get_tokens_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusInternalServerError)
+				return
+			}
+
+			respondWithJson(w, newTokensResponse(tokens, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+// Revoke a token
+func (th *TokenHandler) DeleteToken() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "delete_token", "request": context.RequestId()})
+
+			if !requireAdmin(w, context, "delete_token_error") {
+				logger.Error(models.ErrorAdminRequired)
+				return
+			}
+
+			err := th.service.DeleteToken(context.RequestId(), mux.Vars(r)["id"])
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorTokenNotFound {
+					status = http.StatusNotFound
+				}
+				response := ErrorResponseAttributes{Title: "delete_token_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func newTokenResponse(token *models.Token, request_id string) *TokenResponse {
+	response_data := TokenResponseData{Type: "token", Attributes: token}
+	return &TokenResponse{RequestId: request_id, Data: response_data}
+}
+
+func newTokensResponse(tokens []models.Token, request_id string) *TokensResponse {
+	if tokens == nil {
+		tokens = []models.Token{}
+	}
+
+	response_data := TokensResponseData{Type: "tokens", Attributes: tokens}
+	return &TokensResponse{RequestId: request_id, Data: response_data}
+}
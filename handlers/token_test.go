@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Token", func() {
+
+	var (
+		service             *ValidTokenService
+		response            *httptest.ResponseRecorder
+		err                 error
+		json_err            error
+		resp                *http.Response
+		body                []byte
+		token_response      *TokenResponse
+		tokens_response     *TokensResponse
+		error_response_json *ErrorResponse
+	)
+
+	serve := func(adapter app.Adapter, method string, target string, payload []byte, vars map[string]string, admin bool) {
+		// Unravel the middleware pattern to test only the Handler
+		handler := adapter(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest(method, target, bytes.NewBuffer(payload))
+		request = mux.SetURLVars(request, vars)
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		requestContext.SetPrincipal("ops", "", admin)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		service = &ValidTokenService{}
+	})
+
+	Describe("Creating a token", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(service).CreateToken(), "POST", "/tokens", []byte(`{"owner":"alice","description":"laptop"}`), nil, true)
+				token_response = &TokenResponse{}
+				json_err = json.Unmarshal(body, &token_response)
+			})
+			It("Should return a 201", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+			})
+			It("Should return the token", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(token_response.Data.Type).To(Equal("token"))
+				Expect(token_response.Data.Attributes.Owner).To(Equal("alice"))
+				Expect(token_response.Data.Attributes.Secret).To(Equal("secret"))
+			})
+			It("Should not return the hash of the token", func() {
+				Expect(string(body)).NotTo(ContainSubstring("hashed"))
+			})
+		})
+
+		Context("When the owner is missing", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(service).CreateToken(), "POST", "/tokens", []byte(`{}`), nil, true)
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+		})
+
+		Context("When the request is not from an admin", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(service).CreateToken(), "POST", "/tokens", []byte(`{"owner":"alice"}`), nil, false)
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+			It("Should return an error", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(error_response_json.Data.Attributes.Detail).To(Equal(models.ErrorAdminRequired))
+			})
+		})
+	})
+
+	Describe("Getting every token", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(service).GetTokens(), "GET", "/tokens", nil, nil, true)
+				tokens_response = &TokensResponse{}
+				json_err = json.Unmarshal(body, &tokens_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return the tokens", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(tokens_response.Data.Type).To(Equal("tokens"))
+				Expect(tokens_response.Data.Attributes).To(HaveLen(1))
+			})
+		})
+
+		Context("When the service errors", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(&ErroringTokenService{}).GetTokens(), "GET", "/tokens", nil, nil, true)
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Describe("Deleting a token", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(service).DeleteToken(), "DELETE", "/tokens/b7e3e0a2", nil, map[string]string{"id": "b7e3e0a2"}, true)
+			})
+			It("Should return a 204", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			})
+			It("Should revoke the token", func() {
+				Expect(service.deleted).To(Equal("b7e3e0a2"))
+			})
+		})
+
+		Context("When the token does not exist", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(&ErroringTokenService{}).DeleteToken(), "DELETE", "/tokens/foo", nil, map[string]string{"id": "foo"}, true)
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("When the request is not from an admin", func() {
+			BeforeEach(func() {
+				serve(NewTokenHandler(service).DeleteToken(), "DELETE", "/tokens/b7e3e0a2", nil, map[string]string{"id": "b7e3e0a2"}, false)
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+			It("Should not revoke the token", func() {
+				Expect(service.deleted).To(BeEmpty())
+			})
+		})
+	})
+})
+
+/*
+ * Valid Token Service issues tokens and records revocations
+ */
+type ValidTokenService struct {
+	deleted string
+}
+
+func (ts *ValidTokenService) CreateToken(request_id string, owner string, description string, admin bool) (*models.Token, error) {
+	if len(owner) == 0 {
+		return nil, errors.New(models.ErrorMissingTokenOwner)
+	}
+	return &models.Token{Id: "b7e3e0a2", Owner: owner, Description: description, Admin: admin, Hash: "hashed", Secret: "secret"}, nil
+}
+
+func (ts *ValidTokenService) GetTokens(request_id string) ([]models.Token, error) {
+	return []models.Token{{Id: "b7e3e0a2", Owner: "alice", Hash: "hashed"}}, nil
+}
+
+func (ts *ValidTokenService) DeleteToken(request_id string, id string) error {
+	ts.deleted = id
+	return nil
+}
+
+/*
+ * Erroring Token Service knows no tokens
+ */
+type ErroringTokenService struct{}
+
+func (ts *ErroringTokenService) CreateToken(request_id string, owner string, description string, admin bool) (*models.Token, error) {
+	return nil, errors.New("Token service error")
+}
+
+func (ts *ErroringTokenService) GetTokens(request_id string) ([]models.Token, error) {
+	return nil, errors.New("Token service error")
+}
+
+func (ts *ErroringTokenService) DeleteToken(request_id string, id string) error {
+	return errors.New(models.ErrorTokenNotFound)
+}
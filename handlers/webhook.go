@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/daos"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/services"
+	log "github.com/sirupsen/logrus"
+)
+
+type webhookService interface {
+	GetCluster(request_id string, id string) (*models.Cluster, error)
+	GetWebhookDeliveries(request_id string, cluster_id string, limit int) ([]models.WebhookDelivery, error)
+}
+
+type WebhookHandler struct {
+	service webhookService
+}
+
+func NewWebhookHandler(service webhookService) *WebhookHandler {
+	return &WebhookHandler{service}
+}
+
+func ServeWebhookResources(router *mux.Router, db *sqlx.DB, store daos.ClusterStore) {
+	logger := log.WithFields(log.Fields{"package": "handlers", "event": "serve_webhook_resources", "request": nil})
+
+	service, err := services.NewWebhookService(store, app.GlobalServerConfig.Webhooks)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	handler := NewWebhookHandler(service)
+	auth := authenticated(db)
+
+	router.Handle("/webhooks/deliveries", app.Adapt(
+		router,
+		handler.GetWebhookDeliveries(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+
+	router.Handle("/cluster/{id}/webhooks/deliveries", app.Adapt(
+		router,
+		handler.GetWebhookDeliveries(),
+		auth,
+		app.WithRequestContext(),
+		app.WithTimeout(app.RequestTimeout),
+	)).Methods("GET")
+}
+
+// Retrieve the most recent webhook deliveries and their attempts, of a
+// single cluster when requested through /cluster/{id}. Deliveries of every
+// cluster are only retrieved by admins.
+func (wh *WebhookHandler) GetWebhookDeliveries() app.Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			context := app.GetRequestContext(r)
+
+			logger := log.WithFields(log.Fields{"package": "handlers", "event": "get_webhook_deliveries", "request": context.RequestId()})
+
+			limit := 0
+			if value := r.URL.Query().Get("limit"); len(value) > 0 {
+				parsed, err := strconv.Atoi(value)
+				if err != nil || parsed <= 0 {
+					response := ErrorResponseAttributes{Title: "get_webhook_deliveries_error", Detail: models.ErrorInvalidWebhookLimit}
+					logger.Error(models.ErrorInvalidWebhookLimit)
+					respondWithJson(w, newErrorResponse(&response, context.RequestId()), http.StatusBadRequest)
+					return
+				}
+				limit = parsed
+			}
+
+			id := mux.Vars(r)["id"]
+			if len(id) == 0 && !requireAdmin(w, context, "get_webhook_deliveries_error") {
+				return
+			}
+			if len(id) > 0 {
+				if _, ok := ownedCluster(w, wh.service, context, id, "get_webhook_deliveries_error"); !ok {
+					return
+				}
+			}
+
+			deliveries, err := wh.service.GetWebhookDeliveries(context.RequestId(), id, limit)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if err.Error() == models.ErrorInvalidWebhookLimit {
+					status = http.StatusBadRequest
+				}
+				response := ErrorResponseAttributes{Title: "get_webhook_deliveries_error", Detail: err.Error()}
+				logger.Error(err.Error())
+				respondWithJson(w, newErrorResponse(&response, context.RequestId()), status)
+				return
+			}
+
+			respondWithJson(w, newWebhookDeliveriesResponse(deliveries, context.RequestId()), http.StatusOK)
+		})
+	}
+}
+
+func newWebhookDeliveriesResponse(deliveries []models.WebhookDelivery, request_id string) *WebhookDeliveriesResponse {
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	response_data := WebhookDeliveriesResponseData{Type: "webhook_deliveries", Attributes: deliveries}
+	return &WebhookDeliveriesResponse{RequestId: request_id, Data: response_data}
+}
@@ -0,0 +1,181 @@
+package handlers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/kmacoskey/taos/app"
+	. "github.com/kmacoskey/taos/handlers"
+	"github.com/kmacoskey/taos/models"
+)
+
+var _ = Describe("Webhook", func() {
+
+	var (
+		service             *ValidWebhookService
+		response            *httptest.ResponseRecorder
+		err                 error
+		json_err            error
+		resp                *http.Response
+		body                []byte
+		deliveries_response *WebhookDeliveriesResponse
+		error_response_json *ErrorResponse
+		admin               bool
+	)
+
+	serve := func(wh *WebhookHandler, target string, vars map[string]string) {
+		// Unravel the middleware pattern to test only the Handler
+		handler := wh.GetWebhookDeliveries()(http.HandlerFunc(emptyhandler))
+
+		request := httptest.NewRequest("GET", target, nil)
+		request = mux.SetURLVars(request, vars)
+
+		// Create a new request with the expected, but empty, request.Context
+		response = httptest.NewRecorder()
+		requestContext := app.NewRequestContext(request.Context(), request)
+		requestContext.SetPrincipal("alice", "", admin)
+		ctx := context.WithValue(request.Context(), "request", requestContext)
+
+		handler.ServeHTTP(response, request.WithContext(ctx))
+		resp = response.Result()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+		service = &ValidWebhookService{owner: "alice"}
+		admin = true
+	})
+
+	Describe("Get webhook deliveries", func() {
+		Context("When deliveries are requested for all clusters", func() {
+			BeforeEach(func() {
+				serve(NewWebhookHandler(service), "/webhooks/deliveries?limit=10", map[string]string{})
+				deliveries_response = &WebhookDeliveriesResponse{}
+				json_err = json.Unmarshal(body, &deliveries_response)
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should return json", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+			})
+			It("Should return the deliveries with their attempts", func() {
+				Expect(deliveries_response.Data.Type).To(Equal("webhook_deliveries"))
+				Expect(deliveries_response.Data.Attributes).To(HaveLen(1))
+				Expect(deliveries_response.Data.Attributes[0].History).To(HaveLen(1))
+				Expect(deliveries_response.Data.Attributes[0].History[0].ResponseCode).To(Equal(http.StatusOK))
+			})
+			It("Should pass the limit to the service", func() {
+				Expect(service.clusterId).To(BeEmpty())
+				Expect(service.limit).To(Equal(10))
+			})
+		})
+
+		Context("When deliveries are requested for a cluster", func() {
+			BeforeEach(func() {
+				serve(NewWebhookHandler(service), "/cluster/1/webhooks/deliveries", map[string]string{"id": "1"})
+			})
+			It("Should return a 200", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("Should pass the cluster id to the service", func() {
+				Expect(service.clusterId).To(Equal("1"))
+			})
+		})
+
+		Context("When deliveries of all clusters are requested by a non-admin", func() {
+			BeforeEach(func() {
+				admin = false
+				serve(NewWebhookHandler(service), "/webhooks/deliveries", map[string]string{})
+			})
+			It("Should return a 403", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Expect(string(body)).To(ContainSubstring(models.ErrorAdminRequired))
+			})
+		})
+
+		Context("When deliveries are requested for a cluster of another owner", func() {
+			BeforeEach(func() {
+				admin = false
+				service.owner = "bob"
+				serve(NewWebhookHandler(service), "/cluster/1/webhooks/deliveries", map[string]string{"id": "1"})
+			})
+			It("Should return a 404", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+				Expect(service.clusterId).To(BeEmpty())
+			})
+		})
+
+		Context("When the limit is invalid", func() {
+			BeforeEach(func() {
+				serve(NewWebhookHandler(service), "/webhooks/deliveries?limit=foo", map[string]string{})
+				error_response_json = &ErrorResponse{}
+				json_err = json.Unmarshal(body, &error_response_json)
+			})
+			It("Should return a 400", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("Should return a error", func() {
+				Expect(json_err).NotTo(HaveOccurred())
+				Expect(error_response_json.Data.Type).To(Equal("error"))
+			})
+		})
+
+		Context("When the service errors", func() {
+			BeforeEach(func() {
+				serve(NewWebhookHandler(&ErroringWebhookService{}), "/webhooks/deliveries", map[string]string{})
+			})
+			It("Should return a 500", func() {
+				Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+})
+
+type ValidWebhookService struct {
+	owner     string
+	clusterId string
+	limit     int
+}
+
+func (ws *ValidWebhookService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return &models.Cluster{Id: id, Owner: ws.owner}, nil
+}
+
+func (ws *ValidWebhookService) GetWebhookDeliveries(request_id string, cluster_id string, limit int) ([]models.WebhookDelivery, error) {
+	ws.clusterId = cluster_id
+	ws.limit = limit
+	return []models.WebhookDelivery{
+		{
+			Id:        1,
+			ClusterId: "a19e2758-0ec5-11e8-ba89-0ed5f89f718b",
+			Url:       "http://example.com",
+			Event:     models.ClusterStatusProvisionSuccess,
+			Status:    models.WebhookDeliveryDelivered,
+			Attempts:  1,
+			History:   []models.WebhookAttempt{{DeliveryId: 1, Attempt: 1, ResponseCode: http.StatusOK}},
+		},
+	}, nil
+}
+
+type ErroringWebhookService struct{}
+
+func (ws *ErroringWebhookService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return nil, errors.New("foo")
+}
+
+func (ws *ErroringWebhookService) GetWebhookDeliveries(request_id string, cluster_id string, limit int) ([]models.WebhookDelivery, error) {
+	return nil, errors.New("foo")
+}
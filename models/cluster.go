@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Cluster struct {
@@ -17,6 +19,101 @@ type Cluster struct {
 	Timeout         string    `json:"timeout" db:"timeout"`
 	Project         string    `json:"project" db:"project"`
 	Region          string    `json:"region" db:"region"`
+	TemplateName    string    `json:"template_name" db:"template_name"`
+	TemplateVersion int       `json:"template_version" db:"template_version"`
+	Owner           string    `json:"owner" db:"owner"`
+
+	// Number of queued jobs up to and including the first queued job of
+	// the cluster, zero when the cluster has no queued job
+	QueuePosition int `json:"queue_position" db:"queue_position"`
+
+	// Failed attempts to destroy the cluster and when destroying it is
+	// next retried automatically
+	DestroyAttempts    int       `json:"destroy_attempts" db:"destroy_attempts"`
+	NextDestroyAttempt time.Time `json:"next_destroy_attempt" db:"next_destroy_attempt"`
+
+	// Thresholds the owner has been warned at ahead of the expiration,
+	// cleared whenever the expiration changes
+	ExpirationWarnings pq.StringArray `json:"expiration_warnings" db:"expiration_warnings"`
+
+	// Never marshalled, the values of sensitive variables must not leave
+	// the server
+	Variables ClusterVariables `json:"-" db:"-"`
+
+	// Variables as stored, json encoded so they can be encrypted
+	StoredVariables []byte `json:"-" db:"variables"`
+
+	// Key the data key is encrypted with, and the encrypted data key the
+	// config, state, outputs and variables are encrypted with. Both are
+	// empty when the cluster is stored in plaintext.
+	EncryptionKeyId string `json:"-" db:"encryption_key_id"`
+	DataKey         []byte `json:"-" db:"data_key"`
+
+	// Lock terraform holds on the state while it runs an operation, as
+	// json, empty when the state is not locked
+	StateLock string `json:"-" db:"state_lock"`
+}
+
+// Terraform input variables of a cluster
+type ClusterVariables map[string]ClusterVariable
+
+type ClusterVariable struct {
+	Value interface{} `json:"value"`
+
+	// Sensitive values are passed to terraform but never returned
+	Sensitive bool `json:"sensitive"`
+}
+
+// Values of every variable, as written for terraform
+func (v ClusterVariables) Values() map[string]interface{} {
+	values := make(map[string]interface{})
+	for name, variable := range v {
+		values[name] = variable.Value
+	}
+	return values
+}
+
+// Values of the variables which are not sensitive
+func (v ClusterVariables) Public() map[string]interface{} {
+	values := make(map[string]interface{})
+	for name, variable := range v {
+		if !variable.Sensitive {
+			values[name] = variable.Value
+		}
+	}
+	return values
+}
+
+// Specification of a cluster requested to be created
+type ClusterSpec struct {
+	TerraformConfig []byte
+	Timeout         string
+	Project         string
+	Region          string
+
+	// URLs notified of lifecycle events of the cluster, in addition to
+	// the webhooks configured for every cluster
+	Webhooks []string
+
+	// Optional - Retries of a request with the same key return the cluster
+	// created by the first request instead of creating another
+	IdempotencyKey string
+
+	// Digest of the request, a key may only be reused with the same request
+	RequestHash string
+
+	// Template the config was rendered from, if any
+	TemplateName    string
+	TemplateVersion int
+
+	// Terraform input variables of the cluster
+	Variables ClusterVariables
+
+	// Owner of the token requesting the cluster
+	Owner string
+
+	// Quotas checked as the cluster is created
+	Quotas []Quota
 }
 
 type Output struct {
@@ -35,6 +132,7 @@ const (
 	ClusterStatusDestroying                     = "destroying"
 	ClusterStatusDestroyed                      = "destroyed"
 	ClusterStatusDestroyFailed                  = "destruction_failed"
+	ClusterStatusDestroyRetriesExhausted        = "destruction_retries_exhausted"
 	ClusterUpdateFailed                         = "failed to update cluster"
 	ClusterProvisioningFailed                   = "failed to provision cluster"
 	CredentialsNotFound                         = "credentials not found for the given project"
@@ -45,4 +143,10 @@ const (
 	ErrorMissingRequestId                       = "missing request id"
 	ErrorMissingId                              = "missing id"
 	ErrorInvalidTimeout                         = "invalid cluster timeout"
+	ErrorInvalidMaxLifetime                     = "invalid maximum cluster lifetime configured for project"
+	ErrorExceedsMaxLifetime                     = "cluster expiration exceeds the maximum lifetime allowed for the project"
+	ErrorClusterNotLive                         = "cannot change the expiration of a cluster that is destroying or destroyed"
+	ErrorClusterNotFound                        = "cluster not found"
+	ErrorClusterNotDestroyFailed                = "only a cluster which failed to be destroyed can retry destroying"
+	ErrorDestroyRetriesExhausted                = "destroying the cluster failed on every attempt, its resources may have leaked and need attention"
 )
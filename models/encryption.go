@@ -0,0 +1,9 @@
+package models
+
+const (
+	ErrorInvalidEncryptionKey    = "encryption keys must be 32 bytes encoded as base64"
+	ErrorUnknownEncryptionKey    = "unknown encryption key"
+	ErrorMissingEncryptionKey    = "the active encryption key must be one of the configured keys"
+	ErrorDecryptionFailed        = "failed to decrypt cluster"
+	ErrorEncryptionNotConfigured = "encryption is not configured"
+)
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// A change to the status or message of a cluster
+type ClusterEvent struct {
+	ClusterId string    `json:"cluster_id"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Only used to route the event to the streams of those who may read
+	//  the cluster
+	Owner   string `json:"-"`
+	Project string `json:"-"`
+}
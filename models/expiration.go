@@ -0,0 +1,42 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Notice to the owner of a cluster that the cluster is about to expire and
+// be reaped
+type ExpirationWarning struct {
+	ClusterId  string    `json:"cluster_id"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Project    string    `json:"project"`
+	Expiration time.Time `json:"expiration"`
+
+	// The cluster expires within this duration of the warning
+	Threshold string `json:"threshold"`
+
+	// PATCH a new timeout to this URL to extend the cluster
+	ExtendUrl string `json:"extend_url"`
+}
+
+func (warning *ExpirationWarning) String() string {
+	return fmt.Sprintf("cluster '%v' (%v) of project '%v' expires within %v at %v and will then be destroyed. Extend it with PATCH %v and a new timeout, such as {\"timeout\":\"1h\"}.",
+		warning.Name, warning.ClusterId, warning.Project, warning.Threshold, warning.Expiration.Format(time.RFC3339), warning.ExtendUrl)
+}
+
+// Event of the webhooks notified of expiration warnings
+const ExpirationWarningEvent = "expiration_warning"
+
+// Sinks expiration warnings can be notified through
+const (
+	ExpirationWarningSinkLog     = "log"
+	ExpirationWarningSinkWebhook = "webhook"
+	ExpirationWarningSinkSmtp    = "smtp"
+)
+
+const (
+	ErrorUnknownExpirationWarningSink = "unknown expiration warning sink"
+	ErrorOwnerWithoutEmail            = "owner has no email address"
+)
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Criteria for listing clusters. Zero values do not filter.
+type ClusterFilter struct {
+	Statuses      []string
+	Project       string
+	Region        string
+	NamePrefix    string
+	CreatedBefore time.Time
+	CreatedAfter  time.Time
+	ExpiresBefore time.Time
+	ExpiresAfter  time.Time
+
+	// Owner of the clusters, clusters of every owner when empty
+	Owner string
+
+	// One of the ClusterSort* keys, prefixed with "-" for descending order
+	Sort string
+
+	// Maximum number of clusters in a page of results
+	Limit int
+
+	// Opaque position returned with the previous page of results
+	Cursor string
+}
+
+const (
+	ClusterSortCreated       = "created"
+	ClusterSortExpiration    = "expiration"
+	ClusterSortName          = "name"
+	DefaultClusterSort       = "-" + ClusterSortCreated
+	DefaultClusterPageSize   = 100
+	MaxClusterPageSize       = 1000
+	ErrorInvalidClusterSort  = "invalid cluster sort order"
+	ErrorInvalidClusterLimit = "invalid cluster page size"
+	ErrorInvalidCursor       = "invalid cluster page cursor"
+)
+
+// Split a sort order into its key and whether it is descending
+func ParseClusterSort(sort string) (string, bool) {
+	if len(sort) == 0 {
+		sort = DefaultClusterSort
+	}
+
+	if strings.HasPrefix(sort, "-") {
+		return strings.TrimPrefix(sort, "-"), true
+	}
+
+	return sort, false
+}
+
+func ValidClusterSort(sort string) bool {
+	key, _ := ParseClusterSort(sort)
+	switch key {
+	case ClusterSortCreated, ClusterSortExpiration, ClusterSortName:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// An entry in the history of a cluster. Unlike its status and message,
+// which are overwritten, the history of a cluster is only appended to.
+type ClusterHistoryEvent struct {
+	Id        int64     `json:"id" db:"id"`
+	ClusterId string    `json:"cluster_id" db:"cluster_id"`
+	Kind      string    `json:"kind" db:"kind"`
+	Name      string    `json:"name" db:"name"`
+	Message   string    `json:"message" db:"message"`
+	RequestId string    `json:"request_id" db:"request_id"`
+	Actor     string    `json:"actor" db:"actor"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+
+	// Seconds the cluster spent in a status until it next changed status,
+	// or the operation took when it finished. Zero for the status the
+	// cluster is still in.
+	Duration float64 `json:"duration_seconds" db:"-"`
+}
+
+// Kinds of history events, named by the status entered, the operation
+// started or finished, or the action taken
+const (
+	ClusterHistoryStatus            = "status"
+	ClusterHistoryOperationStarted  = "operation_started"
+	ClusterHistoryOperationFinished = "operation_finished"
+	ClusterHistoryAction            = "action"
+)
+
+// Actions taken on clusters through the api, or by the server itself
+const (
+	ClusterActionCreate       = "create"
+	ClusterActionDelete       = "delete"
+	ClusterActionCancel       = "cancel"
+	ClusterActionRetryDestroy = "retry_destroy"
+	ClusterActionExtend       = "update_expiration"
+)
+
+// Actor of actions taken by the reaper on expired clusters
+const ClusterActorReaper = "reaper"
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// A client supplied key recording which cluster a request created, so that
+// retrying the request returns that cluster rather than creating another.
+// Keys are scoped to the owner using them.
+type IdempotencyKey struct {
+	Owner       string    `json:"owner" db:"owner"`
+	Key         string    `json:"key" db:"key"`
+	RequestHash string    `json:"request_hash" db:"request_hash"`
+	ClusterId   string    `json:"cluster_id" db:"cluster_id"`
+	Timestamp   time.Time `json:"timestamp" db:"timestamp"`
+}
+
+const (
+	MaxIdempotencyKeyLength          = 255
+	ErrorInvalidIdempotencyKey       = "idempotency key must be at most 255 characters"
+	ErrorIdempotencyKeyMismatch      = "idempotency key was already used with a different request"
+	ErrorIdempotencyKeyInUse         = "idempotency key is in use by a concurrent request"
+	ErrorInvalidIdempotencyRetention = "invalid idempotency key retention configured"
+)
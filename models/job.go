@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+)
+
+// A terraform operation on a cluster, queued until a worker runs it
+type ClusterJob struct {
+	Id        int64     `json:"id" db:"id"`
+	ClusterId string    `json:"cluster_id" db:"cluster_id"`
+	Operation string    `json:"operation" db:"operation"`
+	Status    string    `json:"status" db:"status"`
+	RequestId string    `json:"request_id" db:"request_id"`
+	Message   string    `json:"message" db:"message"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+	Updated   time.Time `json:"updated" db:"updated"`
+
+	// Worker running the job and when it last reported the job alive
+	Worker    string    `json:"worker" db:"worker"`
+	Heartbeat time.Time `json:"heartbeat" db:"heartbeat"`
+
+	// Whether cancelling the job has been requested while it runs
+	Cancelled bool `json:"cancelled" db:"cancelled"`
+}
+
+const (
+	ClusterJobProvision = "provision"
+	ClusterJobDestroy   = "destroy"
+)
+
+const (
+	ClusterJobQueued    = "queued"
+	ClusterJobRunning   = "running"
+	ClusterJobDone      = "done"
+	ClusterJobFailed    = "failed"
+	ClusterJobCancelled = "cancelled"
+)
+
+// Statuses of a cluster which has a terraform operation in progress. A
+// cluster in one of these statuses without a queued or running job has
+// lost the worker running its operation.
+var ClusterJobStatuses = []string{
+	ClusterStatusRequested,
+	ClusterStatusProvisionStart,
+	ClusterStatusDestroying,
+}
+
+// Whether the operation may be queued for a cluster with the given status.
+// Provisioning is only queued for a requested cluster, destroying is
+// queued for any cluster which may move to destroying.
+func ClusterJobAllowed(operation string, status string) bool {
+	switch operation {
+	case ClusterJobProvision:
+		return status == ClusterStatusRequested
+	case ClusterJobDestroy:
+		return ClusterStatusTransitionAllowed(status, ClusterStatusDestroying)
+	}
+	return false
+}
+
+const (
+	ErrorInvalidJobOperation  = "invalid job operation"
+	ErrorJobClusterNotFound   = "cluster of the job does not exist"
+	ErrorClusterJobActive     = "cluster already has a queued or running operation"
+	ErrorClusterJobNotAllowed = "operation is not allowed for the status of the cluster"
+	ErrorJobAbandoned         = "job abandoned by its worker"
+	ErrorClusterJobClaimLost  = "job is no longer claimed by the worker"
+	ErrorClusterJobNotRunning = "cluster has no provisioning in progress to cancel"
+	ErrorProvisionCancelled   = "provisioning was cancelled before it started"
+	ErrorProvisionInterrupted = "provisioning was interrupted before its state was persisted, resources created before the interruption are not tracked by the cluster"
+)
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// A single line of terraform output written while operating on a cluster
+type ClusterLog struct {
+	Id        int64     `json:"id" db:"id"`
+	ClusterId string    `json:"cluster_id" db:"cluster_id"`
+	Operation string    `json:"operation" db:"operation"`
+	Line      string    `json:"line" db:"line"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+}
+
+const (
+	ClusterLogOperationProvision = "provision"
+	ClusterLogOperationDestroy   = "destroy"
+	MaxClusterLogLines           = 5000
+	ErrorInvalidClusterLogAfter  = "after must be a non-negative log line id"
+)
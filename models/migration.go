@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// A versioned change to the database schema, reverted by Down
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// A migration applied to the database
+type AppliedMigration struct {
+	Version int       `json:"version" db:"version"`
+	Name    string    `json:"name" db:"name"`
+	Applied time.Time `json:"applied" db:"applied"`
+}
+
+// Whether a migration known to the binary has been applied to the database
+type MigrationStatus struct {
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	Applied bool      `json:"applied"`
+	Time    time.Time `json:"time"`
+}
+
+const (
+	MigrateUp     = "up"
+	MigrateDown   = "down"
+	MigrateStatus = "status"
+)
+
+const (
+	ErrorDatabaseNewer           = "database schema is newer than this binary, upgrade taos"
+	ErrorInvalidMigrations       = "migration versions must be positive and increasing"
+	ErrorUnknownMigrateCommand   = "migrate expects one of up, down or status"
+	ErrorNoMigrationToRevert     = "no migration has been applied"
+	ErrorUnknownAppliedMigration = "applied migration is unknown to this binary"
+)
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// Limits on the clusters of a project or an owner. Zero values are
+// unlimited.
+type Quota struct {
+	Scope           string
+	Name            string
+	MaxClusters     int
+	MaxClusterHours float64
+	MaxTimeout      string
+}
+
+// Usage of a quota against its limits
+type QuotaUsage struct {
+	Scope           string  `json:"scope"`
+	Name            string  `json:"name"`
+	LiveClusters    int     `json:"live_clusters" db:"live_clusters"`
+	MaxClusters     int     `json:"max_clusters"`
+	ClusterHours    float64 `json:"cluster_hours" db:"cluster_hours"`
+	MaxClusterHours float64 `json:"max_cluster_hours"`
+	MaxTimeout      string  `json:"max_timeout"`
+}
+
+const (
+	QuotaScopeProject = "project"
+	QuotaScopeOwner   = "owner"
+
+	// Cluster hours are counted over the clusters created within this window
+	QuotaWindow = 24 * time.Hour
+)
+
+// Statuses of clusters whose resources are released and which no longer
+// count as live towards quotas
+var ReleasedClusterStatuses = []string{
+	ClusterStatusDestroyed,
+	ClusterStatusProvisionFailedRollbackSuccess,
+}
+
+// Whether the quota has any limit
+func (q Quota) Limited() bool {
+	return q.MaxClusters > 0 || q.MaxClusterHours > 0 || len(q.MaxTimeout) > 0
+}
+
+const (
+	ErrorQuotaExceeded     = "quota exceeded"
+	ErrorInvalidQuota      = "invalid quota configured"
+	ErrorInvalidQuotaScope = "quota scope must be either 'project' or 'owner'"
+)
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+)
+
+// A run of the reaper over the clusters which had expired when it started
+type ReapRun struct {
+	Id        int64     `json:"id" db:"id"`
+	RequestId string    `json:"request_id" db:"request_id"`
+	Started   time.Time `json:"started" db:"started"`
+	Finished  time.Time `json:"finished" db:"finished"`
+	Reaped    int       `json:"reaped" db:"reaped"`
+	Skipped   int       `json:"skipped" db:"skipped"`
+	Failed    int       `json:"failed" db:"failed"`
+
+	Outcomes []ReapOutcome `json:"outcomes" db:"-"`
+}
+
+// What became of a single expired cluster during a reap run
+type ReapOutcome struct {
+	RunId     int64  `json:"-" db:"run_id"`
+	ClusterId string `json:"cluster_id" db:"cluster_id"`
+	Outcome   string `json:"outcome" db:"outcome"`
+	Message   string `json:"message" db:"message"`
+}
+
+const (
+	// A destroy job was queued for the cluster
+	ReapOutcomeReaped = "reaped"
+
+	// Another operation on the cluster must finish first, the cluster is
+	// reaped by a later run
+	ReapOutcomeSkipped = "skipped"
+
+	ReapOutcomeFailed = "failed"
+)
+
+const (
+	DefaultReapRunsLimit = 20
+	MaxReapRunsLimit     = 100
+)
+
+const (
+	ErrorInvalidReapRunsLimit = "invalid number of reap runs"
+)
+
+// Record the outcome of reaping a cluster and count it towards the run
+func (run *ReapRun) Record(outcome ReapOutcome) {
+	switch outcome.Outcome {
+	case ReapOutcomeReaped:
+		run.Reaped++
+	case ReapOutcomeSkipped:
+		run.Skipped++
+	default:
+		run.Failed++
+	}
+	run.Outcomes = append(run.Outcomes, outcome)
+}
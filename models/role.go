@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+)
+
+// A role granted within a project to either a user, the owner of tokens,
+// or to a single token
+type RoleBinding struct {
+	Project     string    `json:"project" db:"project"`
+	SubjectType string    `json:"subject_type" db:"subject_type"`
+	Subject     string    `json:"subject" db:"subject"`
+	Role        string    `json:"role" db:"role"`
+	Timestamp   time.Time `json:"timestamp" db:"timestamp"`
+}
+
+const (
+	SubjectTypeUser  = "user"
+	SubjectTypeToken = "token"
+
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+
+	PermissionReadClusters     = "clusters:read"
+	PermissionCreateClusters   = "clusters:create"
+	PermissionUpdateClusters   = "clusters:update"
+	PermissionDeleteClusters   = "clusters:delete"
+	PermissionDeleteAnyCluster = "clusters:delete_any"
+	PermissionManageRoles      = "roles:manage"
+)
+
+// Permissions granted by each role, every role grants the permissions of
+// the roles below it
+var RolePermissions = map[string][]string{
+	RoleViewer:   {PermissionReadClusters},
+	RoleOperator: {PermissionReadClusters, PermissionCreateClusters, PermissionUpdateClusters, PermissionDeleteClusters},
+	RoleAdmin:    {PermissionReadClusters, PermissionCreateClusters, PermissionUpdateClusters, PermissionDeleteClusters, PermissionDeleteAnyCluster, PermissionManageRoles},
+}
+
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func ValidSubjectType(subject_type string) bool {
+	return subject_type == SubjectTypeUser || subject_type == SubjectTypeToken
+}
+
+// Whether the role grants the permission
+func RoleGrants(role string, permission string) bool {
+	for _, granted := range RolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ErrorPermissionDenied    = "missing permission"
+	ErrorInvalidRole         = "role must be one of 'viewer', 'operator' or 'admin'"
+	ErrorInvalidSubjectType  = "subject type must be either 'user' or 'token'"
+	ErrorMissingRoleSubject  = "missing role subject"
+	ErrorUnknownProject      = "project is not configured"
+	ErrorRoleBindingNotFound = "role binding not found"
+)
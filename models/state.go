@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Lock of the terraform state of a cluster, as sent by the terraform http
+// backend before it runs an operation
+type StateLock struct {
+	ID        string    `json:"ID"`
+	Operation string    `json:"Operation"`
+	Info      string    `json:"Info"`
+	Who       string    `json:"Who"`
+	Version   string    `json:"Version"`
+	Created   time.Time `json:"Created"`
+	Path      string    `json:"Path"`
+
+	// Job whose terraform took the lock, taken from the job query
+	//  parameter of the lock address. Zero for any other terraform.
+	Job int64 `json:"Job,omitempty"`
+}
+
+// The lock stored as json with a cluster, nil when its state is not locked
+func ParseStateLock(value string) (*StateLock, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	lock := &StateLock{}
+	err := json.Unmarshal([]byte(value), lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+const (
+	ErrorClusterStateLocked        = "the terraform state of the cluster is locked"
+	ErrorInvalidStateLock          = "invalid terraform state lock"
+	ErrorInvalidClusterState       = "invalid terraform state"
+	ErrorInvalidStateCredentials   = "invalid terraform state credentials"
+	ErrorMissingStateBackendSecret = "a secret is required to serve the terraform state backend"
+)
@@ -0,0 +1,59 @@
+package models
+
+// Statuses a cluster may move to from each of its statuses. A cluster may
+// also re-enter the status it is in, operations interrupted by a restart
+// are queued again in the status they were interrupted in.
+var ClusterStatusTransitions = map[string][]string{
+	ClusterStatusRequested: {
+		ClusterStatusProvisionStart,
+		// Provisioning cancelled before it started
+		ClusterStatusProvisionFailedRollbackSuccess,
+		ClusterStatusDestroying,
+	},
+	ClusterStatusProvisionStart: {
+		ClusterStatusProvisionSuccess,
+		ClusterStatusProvisionFailed,
+		ClusterStatusDestroying,
+	},
+	ClusterStatusProvisionFailed: {
+		ClusterStatusProvisionFailedRollbackSuccess,
+		ClusterStatusProvisionFailedRollbackFailed,
+		ClusterStatusDestroying,
+	},
+	ClusterStatusProvisionSuccess:               {ClusterStatusDestroying},
+	ClusterStatusProvisionFailedRollbackSuccess: {ClusterStatusDestroying},
+	ClusterStatusProvisionFailedRollbackFailed:  {ClusterStatusDestroying},
+	ClusterStatusDestroying: {
+		ClusterStatusDestroyed,
+		ClusterStatusDestroyFailed,
+		ClusterStatusDestroyRetriesExhausted,
+	},
+	ClusterStatusDestroyFailed:           {ClusterStatusDestroying},
+	ClusterStatusDestroyRetriesExhausted: {ClusterStatusDestroying},
+	ClusterStatusDestroyed:               {},
+}
+
+// Whether a cluster may move from one status to another
+func ClusterStatusTransitionAllowed(from string, to string) bool {
+	allowed, known := ClusterStatusTransitions[from]
+	if !known {
+		return false
+	}
+
+	if from == to {
+		return true
+	}
+
+	for _, status := range allowed {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	ErrorInvalidStatusTransition = "cluster cannot move from its status to the requested status"
+	ErrorClusterStatusChanged    = "status of the cluster changed since it was read"
+)
@@ -0,0 +1,10 @@
+package models
+
+// Backends clusters can be stored in
+const (
+	ClusterStorePostgres = "postgres"
+	ClusterStoreSqlite   = "sqlite"
+	ClusterStoreMemory   = "memory"
+)
+
+const ErrorInvalidClusterStore = "cluster store must be one of postgres, sqlite or memory"
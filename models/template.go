@@ -0,0 +1,62 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// A named and versioned terraform config rendered with variables to create
+// clusters. Versions are immutable, changing a template adds a version.
+type Template struct {
+	Name        string            `json:"name" db:"name"`
+	Version     int               `json:"version" db:"version"`
+	Description string            `json:"description" db:"description"`
+	Config      string            `json:"config" db:"config"`
+	Variables   TemplateVariables `json:"variables" db:"variables"`
+	Timestamp   time.Time         `json:"timestamp" db:"timestamp"`
+}
+
+// A variable of a template, required when it has no default
+type TemplateVariable struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+}
+
+// Variables of a template, stored as a json column
+type TemplateVariables []TemplateVariable
+
+func (v TemplateVariables) Value() (driver.Value, error) {
+	if v == nil {
+		v = TemplateVariables{}
+	}
+	return json.Marshal(v)
+}
+
+func (v *TemplateVariables) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*v = TemplateVariables{}
+		return nil
+	case []byte:
+		return json.Unmarshal(src, v)
+	case string:
+		return json.Unmarshal([]byte(src), v)
+	}
+	return errors.New("unsupported type for template variables")
+}
+
+const (
+	ErrorMissingTemplateName     = "missing template name"
+	ErrorInvalidTemplateName     = "template name may only contain letters, digits, '-', '_' and '.'"
+	ErrorMissingTemplateConfig   = "missing template config"
+	ErrorInvalidTemplate         = "invalid template config"
+	ErrorInvalidTemplateVersion  = "template version must be a positive integer"
+	ErrorTemplateNotFound        = "template not found"
+	ErrorTemplateAndConfig       = "a cluster is requested with either a config or a template, not both"
+	ErrorMissingTemplateVariable = "missing required template variable"
+	ErrorInvalidRenderedTemplate = "template did not render valid json"
+	ErrorTemplatesNotAvailable   = "templates are not available"
+)
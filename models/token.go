@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// A bearer token authenticating API requests on behalf of its owner. Only
+// a hash of the token is stored, the token itself is returned once when it
+// is created.
+type Token struct {
+	Id          string    `json:"id" db:"id"`
+	Owner       string    `json:"owner" db:"owner"`
+	Description string    `json:"description" db:"description"`
+	Hash        string    `json:"-" db:"hash"`
+	Admin       bool      `json:"admin" db:"admin"`
+	Timestamp   time.Time `json:"timestamp" db:"timestamp"`
+
+	// Only set when the token is created
+	Secret string `json:"token,omitempty" db:"-"`
+}
+
+const (
+	// Owner of requests authenticated with the configured admin token
+	AdminTokenOwner = "admin"
+
+	ErrorMissingToken      = "missing bearer token"
+	ErrorInvalidToken      = "invalid bearer token"
+	ErrorAdminRequired     = "an admin token is required"
+	ErrorMissingTokenOwner = "missing token owner"
+	ErrorTokenNotFound     = "token not found"
+)
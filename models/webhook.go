@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+)
+
+// A payload to be sent to a single webhook URL and the state of its delivery
+type WebhookDelivery struct {
+	Id          int64            `json:"id" db:"id"`
+	ClusterId   string           `json:"cluster_id" db:"cluster_id"`
+	Url         string           `json:"url" db:"url"`
+	Event       string           `json:"event" db:"event"`
+	Payload     []byte           `json:"-" db:"payload"`
+	Status      string           `json:"status" db:"status"`
+	Attempts    int              `json:"attempts" db:"attempts"`
+	NextAttempt time.Time        `json:"next_attempt" db:"next_attempt"`
+	Timestamp   time.Time        `json:"timestamp" db:"timestamp"`
+	History     []WebhookAttempt `json:"history" db:"-"`
+}
+
+// The result of a single attempt to deliver a webhook payload
+type WebhookAttempt struct {
+	DeliveryId   int64     `json:"delivery_id" db:"delivery_id"`
+	Attempt      int       `json:"attempt" db:"attempt"`
+	Timestamp    time.Time `json:"timestamp" db:"timestamp"`
+	ResponseCode int       `json:"response_code" db:"response_code"`
+	Error        string    `json:"error" db:"error"`
+}
+
+// Body POSTed to webhook URLs
+type WebhookPayload struct {
+	Event     string         `json:"event"`
+	Timestamp time.Time      `json:"timestamp"`
+	Cluster   WebhookCluster `json:"cluster"`
+
+	// Only sent with expiration warnings
+	Warning *ExpirationWarning `json:"warning,omitempty"`
+}
+
+// Cluster as sent to webhooks, without terraform config, state or outputs
+type WebhookCluster struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"`
+	Expiration time.Time `json:"expiration"`
+	Project    string    `json:"project"`
+	Region     string    `json:"region"`
+}
+
+// The cluster as sent to webhooks with an event moving it to status
+func NewWebhookCluster(cluster *Cluster, status string, message string) WebhookCluster {
+	return WebhookCluster{
+		Id:         cluster.Id,
+		Name:       cluster.Name,
+		Status:     status,
+		Message:    message,
+		Timestamp:  cluster.Timestamp,
+		Expiration: cluster.Expiration,
+		Project:    cluster.Project,
+		Region:     cluster.Region,
+	}
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+const (
+	DefaultWebhookDeliveryLimit = 100
+	MaxWebhookDeliveryLimit     = 1000
+	ErrorInvalidWebhookLimit    = "limit must be between 1 and 1000"
+	ErrorInvalidWebhookUrl      = "webhook must be an absolute http or https url"
+)
@@ -8,24 +8,37 @@ import (
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/kmacoskey/taos/models"
-	"github.com/kmacoskey/taos/services"
-	"github.com/kmacoskey/taos/terraform"
 	log "github.com/sirupsen/logrus"
 )
 
 type ClusterReaper struct {
-	interval string
-	ticker   *time.Ticker
-	service  clusterService
-	db       *sqlx.DB
+	interval    string
+	ticker      *time.Ticker
+	concurrency int
+	service     clusterService
+	dao         reaperDao
+	leader      leader
+	db          *sqlx.DB
 }
 
 type clusterService interface {
-	DeleteCluster(request_id string, client services.TerraformClient, id string) (*models.Cluster, error)
+	DeleteCluster(request_id string, id string) (*models.Cluster, error)
 	GetExpiredClusters(requestId string) ([]models.Cluster, error)
+	RecordClusterAction(request_id string, id string, action string, actor string, message string) error
 }
 
-func NewClusterReaper(interval string, cluster_service clusterService, db *sqlx.DB) (*ClusterReaper, error) {
+type reaperDao interface {
+	CreateReapRun(db *sqlx.DB, run *models.ReapRun, requestId string) error
+}
+
+// Elects a single instance to reap among the instances sharing the database
+type leader interface {
+	IsLeader(requestId string) (bool, error)
+}
+
+// A reaper destroying expired clusters every interval, reaping up to
+// concurrency clusters at once. Only the instance elected leader reaps.
+func NewClusterReaper(interval string, concurrency int, cluster_service clusterService, dao reaperDao, leader leader, db *sqlx.DB) (*ClusterReaper, error) {
 	logger := log.WithFields(log.Fields{"package": "app", "event": "new_reaper", "request": nil})
 
 	duration, err := time.ParseDuration(interval)
@@ -34,11 +47,20 @@ func NewClusterReaper(interval string, cluster_service clusterService, db *sqlx.
 		return nil, err
 	}
 
+	if concurrency <= 0 {
+		err := fmt.Errorf("invalid reap concurrency '%v'", concurrency)
+		logger.Error(err)
+		return nil, err
+	}
+
 	reaper := &ClusterReaper{
-		interval: interval,
-		service:  cluster_service,
-		db:       db,
-		ticker:   time.NewTicker(duration),
+		interval:    interval,
+		service:     cluster_service,
+		concurrency: concurrency,
+		dao:         dao,
+		leader:      leader,
+		db:          db,
+		ticker:      time.NewTicker(duration),
 	}
 
 	return reaper, nil
@@ -50,7 +72,7 @@ func (reaper *ClusterReaper) StartReaping() {
 	go func() {
 		for _ = range reaper.ticker.C {
 			logger.Debug("reaping expired clusters")
-			err := reaper.ReapClusters()
+			_, err := reaper.ReapClusters()
 			if err != nil {
 				logger.Error(err)
 			}
@@ -58,26 +80,78 @@ func (reaper *ClusterReaper) StartReaping() {
 	}()
 }
 
-func (reaper *ClusterReaper) ReapClusters() error {
+// Reap every expired cluster, each independently of the others, and
+// persist the run with the outcome of every cluster. An error is only
+// returned when the run itself could not be made or recorded. No run is
+// made unless the instance is the leader.
+func (reaper *ClusterReaper) ReapClusters() (*models.ReapRun, error) {
 	request_id := uuid.Must(uuid.NewRandom()).String()
 	logger := log.WithFields(log.Fields{"package": "app", "event": "reap_clusters", "request": request_id})
 
+	leading, err := reaper.leader.IsLeader(request_id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if !leading {
+		logger.Debug("another instance is the leader, not reaping")
+		return nil, nil
+	}
+
+	run := &models.ReapRun{RequestId: request_id, Started: time.Now(), Outcomes: []models.ReapOutcome{}}
+
 	clusters, err := reaper.ExpiredClusters(request_id)
 	if err != nil {
 		logger.Error(err)
-		return err
+		return nil, err
 	}
 
-	for _, cluster := range clusters {
+	if len(clusters) > 0 {
 		logger.Info(fmt.Sprintf("reaping %v cluster(s)", len(clusters)))
-		err := reaper.ReapCluster(cluster.Id)
-		if err != nil {
-			logger.Error(err)
-			return err
+	}
+
+	outcomes := make(chan models.ReapOutcome, len(clusters))
+	slots := make(chan struct{}, reaper.concurrency)
+	for _, cluster := range clusters {
+		slots <- struct{}{}
+		go func(id string) {
+			defer func() { <-slots }()
+			outcomes <- reaper.reapOutcome(id)
+		}(cluster.Id)
+	}
+
+	for range clusters {
+		run.Record(<-outcomes)
+	}
+	run.Finished = time.Now()
+
+	logger.Info(fmt.Sprintf("reaped %v, skipped %v and failed %v cluster(s)", run.Reaped, run.Skipped, run.Failed))
+
+	if err := reaper.dao.CreateReapRun(reaper.db, run, request_id); err != nil {
+		logger.Error(err)
+		return run, err
+	}
+
+	return run, nil
+}
+
+// Reap the cluster, classifying the result. Clusters with another
+// operation in progress are skipped rather than failed.
+func (reaper *ClusterReaper) reapOutcome(id string) models.ReapOutcome {
+	outcome := models.ReapOutcome{ClusterId: id, Outcome: models.ReapOutcomeReaped}
+
+	err := reaper.ReapCluster(id)
+	if err != nil {
+		outcome.Message = err.Error()
+		switch err.Error() {
+		case models.ErrorClusterJobActive, models.ErrorClusterJobNotAllowed:
+			outcome.Outcome = models.ReapOutcomeSkipped
+		default:
+			outcome.Outcome = models.ReapOutcomeFailed
 		}
 	}
 
-	return nil
+	return outcome
 }
 
 func (reaper *ClusterReaper) ExpiredClusters(request_id string) ([]models.Cluster, error) {
@@ -101,10 +175,17 @@ func (reaper *ClusterReaper) ReapCluster(id string) error {
 		return err
 	}
 
-	_, err := reaper.service.DeleteCluster(id, terraform.NewTerraformClient(), id)
+	_, err := reaper.service.DeleteCluster(id, id)
 	if err != nil {
+		logger.Error(err)
 		return err
 	}
 
+	// The cluster is being destroyed whether or not its history records why
+	err = reaper.service.RecordClusterAction(id, id, models.ClusterActionDelete, models.ClusterActorReaper, "cluster expired")
+	if err != nil {
+		logger.Error(err)
+	}
+
 	return nil
 }
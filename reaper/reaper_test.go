@@ -2,6 +2,7 @@ package reaper_test
 
 import (
 	"errors"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 	. "github.com/onsi/ginkgo"
@@ -10,7 +11,6 @@ import (
 
 	"github.com/kmacoskey/taos/models"
 	. "github.com/kmacoskey/taos/reaper"
-	"github.com/kmacoskey/taos/services"
 )
 
 var (
@@ -30,6 +30,10 @@ var _ = Describe("Reaper", func() {
 		cluster_2_uuid       string
 		invalid_cluster_uuid string
 		clusters             []models.Cluster
+		reap_dao             *MockReaperDao
+		leader               *MockLeader
+		run                  *models.ReapRun
+		service              *ValidClusterService
 	)
 
 	BeforeEach(func() {
@@ -37,6 +41,9 @@ var _ = Describe("Reaper", func() {
 
 		valid_interval = "5s"
 
+		reap_dao = &MockReaperDao{}
+		leader = &MockLeader{leading: true}
+
 		invalid_cluster_uuid = "d1af124a-5141-11e8-9c2d-fa7ae01bbebc"
 
 		valid_request_id = "96bc71ca-518a-11e8-9c2d-fa7ae01bbebc"
@@ -63,9 +70,10 @@ var _ = Describe("Reaper", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
 				clusters_map[cluster_1.Id] = cluster_1
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				service = NewValidClusterService(clusters_map)
+				reaper, err = NewClusterReaper(valid_interval, 2, service, reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
-				err = reaper.ReapClusters()
+				run, err = reaper.ReapClusters()
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -73,14 +81,97 @@ var _ = Describe("Reaper", func() {
 			It("Should reap expired clusters", func() {
 				Expect(clusters_map).To(HaveLen(0))
 			})
+			It("Should record the reaper deleting the cluster in its history", func() {
+				Expect(service.actors).To(HaveKeyWithValue(cluster_1_uuid, models.ClusterActorReaper))
+			})
+			It("Should record the run", func() {
+				Expect(reap_dao.runs).To(HaveLen(1))
+				Expect(run.Reaped).To(Equal(1))
+				Expect(run.Outcomes).To(ConsistOf(models.ReapOutcome{ClusterId: cluster_1_uuid, Outcome: models.ReapOutcomeReaped}))
+			})
+		})
+
+		Context("When reaping some of the clusters fails", func() {
+			BeforeEach(func() {
+				cluster_3 := &models.Cluster{Id: "a19e2d5c-0ec5-11e8-ba89-0ed5f89f718b"}
+				clusters_map = make(map[string]*models.Cluster)
+				clusters_map[cluster_1.Id] = cluster_1
+				clusters_map[cluster_2.Id] = cluster_2
+				clusters_map[cluster_3.Id] = cluster_3
+				service = NewValidClusterService(clusters_map)
+				service.failures[cluster_1.Id] = "terraform is unavailable"
+				service.failures[cluster_3.Id] = models.ErrorClusterJobActive
+				reaper, err = NewClusterReaper(valid_interval, 2, service, reap_dao, leader, NewMockDB().db)
+				Expect(err).NotTo(HaveOccurred())
+				run, err = reaper.ReapClusters()
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should still reap the other clusters", func() {
+				Expect(clusters_map).To(HaveLen(2))
+				Expect(clusters_map).NotTo(HaveKey(cluster_2_uuid))
+			})
+			It("Should record the outcome of every cluster", func() {
+				Expect(reap_dao.runs).To(HaveLen(1))
+				Expect(run.Reaped).To(Equal(1))
+				Expect(run.Skipped).To(Equal(1))
+				Expect(run.Failed).To(Equal(1))
+				Expect(run.Outcomes).To(ContainElement(models.ReapOutcome{ClusterId: cluster_1_uuid, Outcome: models.ReapOutcomeFailed, Message: "terraform is unavailable"}))
+			})
+		})
+
+		Context("When another instance is the leader", func() {
+			BeforeEach(func() {
+				clusters_map = make(map[string]*models.Cluster)
+				clusters_map[cluster_1.Id] = cluster_1
+				leader.leading = false
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
+				Expect(err).NotTo(HaveOccurred())
+				run, err = reaper.ReapClusters()
+			})
+			It("Should not reap", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(run).To(BeNil())
+				Expect(clusters_map).To(HaveLen(1))
+				Expect(reap_dao.runs).To(BeEmpty())
+			})
+		})
+
+		Context("When leadership cannot be determined", func() {
+			BeforeEach(func() {
+				clusters_map = make(map[string]*models.Cluster)
+				clusters_map[cluster_1.Id] = cluster_1
+				leader.err = errors.New("database is unavailable")
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
+				Expect(err).NotTo(HaveOccurred())
+				run, err = reaper.ReapClusters()
+			})
+			It("Should error without reaping", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(clusters_map).To(HaveLen(1))
+			})
+		})
+
+		Context("When the run cannot be recorded", func() {
+			BeforeEach(func() {
+				clusters_map = make(map[string]*models.Cluster)
+				reap_dao.err = errors.New("database is unavailable")
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
+				Expect(err).NotTo(HaveOccurred())
+				run, err = reaper.ReapClusters()
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+			})
 		})
 
 		Context("When there are no clusters to reap", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
-				err = reaper.ReapClusters()
+				run, err = reaper.ReapClusters()
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -88,12 +179,21 @@ var _ = Describe("Reaper", func() {
 		})
 	})
 
+	Describe("Creating a reaper", func() {
+		Context("When the concurrency is not positive", func() {
+			It("Should error", func() {
+				_, err = NewClusterReaper(valid_interval, 0, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
 	Describe("Finding Expired Clusters", func() {
 		Context("When everything goes ok", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
 				clusters_map[cluster_1.Id] = cluster_1
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
 				clusters, err = reaper.ExpiredClusters(valid_request_id)
 			})
@@ -108,7 +208,7 @@ var _ = Describe("Reaper", func() {
 		Context("When there are no expired clusters", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
 				clusters, err = reaper.ExpiredClusters(valid_request_id)
 			})
@@ -126,7 +226,7 @@ var _ = Describe("Reaper", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
 				clusters_map[cluster_1.Id] = cluster_1
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
 				err = reaper.ReapCluster(cluster_1.Id)
 			})
@@ -139,7 +239,7 @@ var _ = Describe("Reaper", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
 				clusters_map[cluster_1.Id] = cluster_1
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
 				err = reaper.ReapCluster(invalid_cluster_uuid)
 			})
@@ -151,7 +251,7 @@ var _ = Describe("Reaper", func() {
 		Context("When no cluster id is given", func() {
 			BeforeEach(func() {
 				clusters_map = make(map[string]*models.Cluster)
-				reaper, err = NewClusterReaper(valid_interval, NewValidClusterService(clusters_map), NewMockDB().db)
+				reaper, err = NewClusterReaper(valid_interval, 2, NewValidClusterService(clusters_map), reap_dao, leader, NewMockDB().db)
 				Expect(err).NotTo(HaveOccurred())
 				err = reaper.ReapCluster("")
 			})
@@ -171,17 +271,59 @@ type MockDB struct {
 	db *sqlx.DB
 }
 
+type MockReaperDao struct {
+	runs []models.ReapRun
+	err  error
+}
+
+func (dao *MockReaperDao) CreateReapRun(db *sqlx.DB, run *models.ReapRun, requestId string) error {
+	if dao.err != nil {
+		return dao.err
+	}
+	dao.runs = append(dao.runs, *run)
+	return nil
+}
+
+type MockLeader struct {
+	leading bool
+	err     error
+}
+
+func (leader *MockLeader) IsLeader(requestId string) (bool, error) {
+	return leader.leading && leader.err == nil, leader.err
+}
+
 type ValidClusterService struct {
+	mutex       sync.Mutex
 	clustersMap map[string]*models.Cluster
+	failures    map[string]string
+	actors      map[string]string
 }
 
 func NewValidClusterService(clusters_map map[string]*models.Cluster) *ValidClusterService {
 	return &ValidClusterService{
 		clustersMap: clusters_map,
+		failures:    make(map[string]string),
+		actors:      make(map[string]string),
 	}
 }
 
-func (service *ValidClusterService) DeleteCluster(request_id string, client services.TerraformClient, id string) (*models.Cluster, error) {
+func (service *ValidClusterService) RecordClusterAction(request_id string, id string, action string, actor string, message string) error {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	service.actors[id] = actor
+	return nil
+}
+
+func (service *ValidClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if failure, ok := service.failures[id]; ok {
+		return nil, errors.New(failure)
+	}
+
 	if cluster, ok := clusters_map[id]; ok {
 		delete(clusters_map, id)
 		return cluster, nil
@@ -191,6 +333,9 @@ func (service *ValidClusterService) DeleteCluster(request_id string, client serv
 }
 
 func (service *ValidClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
 	clusters := []models.Cluster{}
 	for _, cluster := range clusters_map {
 		clusters = append(clusters, *cluster)
@@ -1,21 +1,38 @@
 package services
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/kmacoskey/taos/app"
 	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/terraform"
 	log "github.com/sirupsen/logrus"
 )
 
 type clusterDao interface {
-	GetCluster(db *sqlx.DB, id string, requestId string) (*models.Cluster, error)
-	GetClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error)
-	GetExpiredClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error)
-	CreateCluster(db *sqlx.DB, config []byte, timeout string, requestId string, project string, region string) (*models.Cluster, error)
-	UpdateClusterField(db *sqlx.DB, id string, field string, value interface{}, requestId string) error
+	GetCluster(id string, requestId string) (*models.Cluster, error)
+	GetClusters(filter *models.ClusterFilter, requestId string) ([]models.Cluster, string, error)
+	GetExpiredClusters(requestId string) ([]models.Cluster, error)
+	CreateCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error)
+	UpdateClusterField(id string, field string, value interface{}, requestId string) error
+	UpdateClusterExpiration(id string, expiration time.Time, quotas []models.Quota, requestId string) error
+	UpdateClusterStatus(id string, from string, to string, requestId string) error
+	UpdateJobClusterStatus(job *models.ClusterJob, from string, to string, requestId string) error
+	UpdateJobClusterField(job *models.ClusterJob, field string, value interface{}, requestId string) error
+	AppendClusterLog(id string, operation string, lines []string, requestId string) error
+	GetClusterLogs(id string, operation string, after int64, requestId string) ([]models.ClusterLog, error)
+	CreateClusterHistoryEvent(event *models.ClusterHistoryEvent, requestId string) error
+	GetClusterHistory(id string, requestId string) ([]models.ClusterHistoryEvent, error)
+	GetIdempotencyKey(owner string, key string, requestId string) (*models.IdempotencyKey, error)
+	DeleteIdempotencyKeys(before time.Time, requestId string) error
+	EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error)
+	CancelClusterJob(id string, requestId string) (*models.ClusterJob, error)
+	RekeyClusters(requestId string) (int, error)
 }
 
 type TerraformClient interface {
@@ -29,6 +46,9 @@ type TerraformClient interface {
 	SetRegion(string)
 	Credentials() string
 	SetCredentials(string)
+	SetVariables(map[string]interface{})
+	SetBackend(*terraform.HttpBackend)
+	SetLog(io.Writer)
 	ClientInit() error
 	ClientDestroy() error
 	Init() (string, error)
@@ -36,67 +56,372 @@ type TerraformClient interface {
 	Apply() ([]byte, string, error)
 	Destroy() ([]byte, string, error)
 	Outputs() (string, error)
+	Cancel()
+	Abort()
 }
 
 type ClusterService struct {
-	dao clusterDao
-	db  *sqlx.DB
+	dao    clusterDao
+	events *ClusterEventBroker
+
+	// Only set when running the operation of a job
+	job *models.ClusterJob
+}
+
+func NewClusterService(dao clusterDao) *ClusterService {
+	return &ClusterService{dao: dao, events: GlobalClusterEvents}
+}
+
+// The service running the terraform operation of the job. The cluster is
+// only changed while the job is still claimed by its worker, a job which
+// was abandoned meanwhile cannot overwrite the worker of the next one.
+func (s *ClusterService) forJob(job *models.ClusterJob) *ClusterService {
+	return &ClusterService{dao: s.dao, events: s.events, job: job}
+}
+
+// Encrypt the secrets of every stored cluster with the active encryption
+// key, returning the number of clusters rekeyed
+func (s *ClusterService) RekeyClusters(request_id string) (int, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "rekey_clusters", "request": request_id})
+
+	rekeyed, err := s.dao.RekeyClusters(request_id)
+	if err != nil {
+		logger.Error(err)
+		return rekeyed, err
+	}
+
+	return rekeyed, nil
+}
+
+// Subscribe to status and message changes of the cluster with the given id,
+// or of all clusters when the id is empty
+func (s *ClusterService) SubscribeClusterEvents(id string) (<-chan models.ClusterEvent, func()) {
+	return s.events.Subscribe(id)
+}
+
+// Persist a change to a field of the cluster, publishing the cluster
+// status and message to subscribers when the message has changed
+func (s *ClusterService) updateClusterField(cluster *models.Cluster, field string, value interface{}, requestId string) error {
+	var err error
+	if s.job != nil {
+		err = s.dao.UpdateJobClusterField(s.job, field, value, requestId)
+	} else {
+		err = s.dao.UpdateClusterField(cluster.Id, field, value, requestId)
+	}
+	if err != nil {
+		return err
+	}
+
+	if field == "message" {
+		s.publishClusterEvent(cluster)
+	}
+
+	return nil
+}
+
+// Move the cluster from the status it was read with to status, publishing
+// the change to subscribers. Fails without changing the cluster when the
+// transition is not allowed or the cluster has changed status since.
+func (s *ClusterService) updateClusterStatus(cluster *models.Cluster, status string, requestId string) error {
+	var err error
+	if s.job != nil {
+		err = s.dao.UpdateJobClusterStatus(s.job, cluster.Status, status, requestId)
+	} else {
+		err = s.dao.UpdateClusterStatus(cluster.Id, cluster.Status, status, requestId)
+	}
+	if err != nil {
+		return err
+	}
+
+	cluster.Status = status
+	s.publishClusterEvent(cluster)
+
+	return nil
+}
+
+// Move the cluster to status and record the message explaining it. The
+// message is not recorded when the status could not be changed.
+func (s *ClusterService) updateClusterStatusMessage(cluster *models.Cluster, status string, message string, requestId string) error {
+	err := s.updateClusterStatus(cluster, status, requestId)
+	if err != nil {
+		return err
+	}
+
+	cluster.Message = message
+	return s.updateClusterField(cluster, "message", cluster.Message, requestId)
+}
+
+// Persist the terraform state returned by an operation. Terraform with a
+// state backend has already written the state itself and returns none.
+func (s *ClusterService) updateClusterState(cluster *models.Cluster, state []byte, requestId string) error {
+	if state == nil {
+		return nil
+	}
+
+	cluster.TerraformState = state
+	return s.updateClusterField(cluster, "terraform_state", cluster.TerraformState, requestId)
 }
 
-func NewClusterService(dao clusterDao, db *sqlx.DB) *ClusterService {
-	return &ClusterService{dao, db}
+func (s *ClusterService) publishClusterEvent(cluster *models.Cluster) {
+	s.events.Publish(models.ClusterEvent{
+		ClusterId: cluster.Id,
+		Status:    cluster.Status,
+		Message:   cluster.Message,
+		Timestamp: time.Now(),
+		Owner:     cluster.Owner,
+		Project:   cluster.Project,
+	})
 }
 
 func (s *ClusterService) GetCluster(request_id string, id string) (*models.Cluster, error) {
 	logger := log.WithFields(log.Fields{"package": "services", "event": "get_cluster", "request": request_id})
 	logger.Info(fmt.Sprintf("servicing request to get cluster '%v'", id))
-	cluster, err := s.dao.GetCluster(s.db, id, request_id)
+	cluster, err := s.dao.GetCluster(id, request_id)
 	logger.Info(fmt.Sprintf("service returning cluster '%v'", id))
 	return cluster, err
 }
 
-func (s *ClusterService) GetClusters(request_id string) ([]models.Cluster, error) {
-	clusters, err := s.dao.GetClusters(s.db, request_id)
-	return clusters, err
+func (s *ClusterService) GetClusters(request_id string, filter *models.ClusterFilter) ([]models.Cluster, string, error) {
+	clusters, next, err := s.dao.GetClusters(filter, request_id)
+	return clusters, next, err
+}
+
+// Lines of terraform output of the cluster following the line with the id
+// after, of a single operation when operation is not empty
+func (s *ClusterService) GetClusterLogs(request_id string, id string, operation string, after int64) ([]models.ClusterLog, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_cluster_logs", "request": request_id})
+
+	if after < 0 {
+		err := errors.New(models.ErrorInvalidClusterLogAfter)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return s.dao.GetClusterLogs(id, operation, after, request_id)
+}
+
+// Record an action taken on the cluster by the actor in its history
+func (s *ClusterService) RecordClusterAction(request_id string, id string, action string, actor string, message string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "record_cluster_action", "request": request_id})
+
+	event := &models.ClusterHistoryEvent{
+		ClusterId: id,
+		Kind:      models.ClusterHistoryAction,
+		Name:      action,
+		Message:   message,
+		RequestId: request_id,
+		Actor:     actor,
+	}
+
+	err := s.dao.CreateClusterHistoryEvent(event, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// The history of the cluster, oldest first, with the time spent in each
+// status and taken by each operation
+func (s *ClusterService) GetClusterHistory(request_id string, id string) ([]models.ClusterHistoryEvent, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_cluster_history", "request": request_id})
+
+	events, err := s.dao.GetClusterHistory(id, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	var status *models.ClusterHistoryEvent
+	started := make(map[string]time.Time)
+	for i := range events {
+		event := &events[i]
+		switch event.Kind {
+		case models.ClusterHistoryStatus:
+			if status != nil {
+				status.Duration = event.Timestamp.Sub(status.Timestamp).Seconds()
+			}
+			status = event
+		case models.ClusterHistoryOperationStarted:
+			started[event.Name] = event.Timestamp
+		case models.ClusterHistoryOperationFinished:
+			if start, ok := started[event.Name]; ok {
+				event.Duration = event.Timestamp.Sub(start).Seconds()
+				delete(started, event.Name)
+			}
+		}
+	}
+
+	return events, nil
 }
 
 func (s *ClusterService) GetExpiredClusters(request_id string) ([]models.Cluster, error) {
-	clusters, err := s.dao.GetExpiredClusters(s.db, request_id)
+	clusters, err := s.dao.GetExpiredClusters(request_id)
 	return clusters, err
 }
 
-func (s *ClusterService) CreateCluster(terraform_config []byte, timeout string, project string, region string, request_id string, client TerraformClient) (*models.Cluster, error) {
+// Persist the requested cluster with a queued job to provision it
+func (s *ClusterService) CreateCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error) {
 	logger := log.WithFields(log.Fields{"package": "services", "event": "create_cluster", "request": request_id})
 	logger.Info("servicing request to create cluster")
-	cluster, err := s.dao.CreateCluster(s.db, terraform_config, timeout, request_id, project, region)
+
+	err := checkWebhookUrls(spec.Webhooks)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if len(spec.IdempotencyKey) > 0 {
+		cluster, err := s.idempotentCluster(spec, request_id)
+		if err != nil || cluster != nil {
+			return cluster, err
+		}
+	}
+
+	spec.Quotas = clusterQuotas(spec)
+
+	cluster, err := s.dao.CreateCluster(spec, request_id)
+
+	// Lost the key to a concurrent request, which has created the cluster
+	if err != nil && err.Error() == models.ErrorIdempotencyKeyInUse {
+		cluster, err := s.idempotentCluster(spec, request_id)
+		if err == nil && cluster == nil {
+			err = errors.New(models.ErrorIdempotencyKeyInUse)
+		}
+		return cluster, err
+	}
+
 	if err != nil {
 		return cluster, err
 	}
-	credentials := app.GlobalServerConfig.Credentials(project)
+
+	// Cluster with requested action is returned and eventual cluster status
+	//  is handled by a job worker asynchronously
+	logger.Info("service returning requested cluster")
+
+	return cluster, err
+}
+
+// The cluster created by an earlier request of the owner with the same
+// idempotency key, or nil when the owner has not used the key within the
+// retention window.
+// Reusing a key with a different request is an error.
+func (s *ClusterService) idempotentCluster(spec *models.ClusterSpec, request_id string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "idempotent_cluster", "request": request_id})
+
+	if len(spec.IdempotencyKey) > models.MaxIdempotencyKeyLength {
+		err := errors.New(models.ErrorInvalidIdempotencyKey)
+		logger.Error(err)
+		return nil, err
+	}
+
+	retention, err := time.ParseDuration(app.GlobalServerConfig.IdempotencyRetention)
+	if err != nil {
+		err := errors.New(models.ErrorInvalidIdempotencyRetention)
+		logger.Error(err)
+		return nil, err
+	}
+
+	err = s.dao.DeleteIdempotencyKeys(time.Now().Add(-retention), request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	key, err := s.dao.GetIdempotencyKey(spec.Owner, spec.IdempotencyKey, request_id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	if key.RequestHash != spec.RequestHash {
+		err := errors.New(models.ErrorIdempotencyKeyMismatch)
+		logger.Error(err)
+		return nil, err
+	}
+
+	logger.Info(fmt.Sprintf("returning cluster '%v' created with idempotency key '%v'", key.ClusterId, key.Key))
+
+	return s.dao.GetCluster(key.ClusterId, request_id)
+}
+
+// Plan the given cluster in a throwaway working directory without
+// persisting or provisioning anything
+func (s *ClusterService) PlanCluster(ctx context.Context, spec *models.ClusterSpec, request_id string, client TerraformClient) (*terraform.PlanSummary, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "plan_cluster", "request": request_id})
+	logger.Info("servicing request to plan cluster")
+
+	if len(spec.TerraformConfig) == 0 {
+		err := errors.New(models.ErrorMissingConfig)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(spec.Project) == 0 {
+		err := errors.New(models.ErrorMissingProject)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(spec.Region) == 0 {
+		err := errors.New(models.ErrorMissingRegion)
+		logger.Error(err)
+		return nil, err
+	}
+
+	credentials := app.GlobalServerConfig.Credentials(spec.Project)
 	if len(credentials) == 0 {
 		logger.Error(models.CredentialsNotFound)
 	}
 
+	client.SetConfig(spec.TerraformConfig)
+	client.SetVariables(spec.Variables.Values())
 	client.SetCredentials(credentials)
-	client.SetProject(project)
-	client.SetRegion(region)
+	client.SetProject(spec.Project)
+	client.SetRegion(spec.Region)
+
+	// Terraform is interrupted once the request for the plan has ended,
+	//  having timed out or been abandoned by the caller
+	planned := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Cancel()
+		case <-planned:
+		}
+	}()
 
-	// Cluster with requested action is returned and eventual cluster status
-	//  is handled in the terraform service asynchronously
-	go s.TerraformProvisionCluster(client, cluster, terraform_config, request_id)
+	stdout, err := client.Plan(false)
+	close(planned)
 
-	logger.Info("service returning requested cluster")
+	// Plan creates the working directory, which is removed whether or not
+	//  planning succeeded
+	removeClientDir(client, logger)
 
-	return cluster, err
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	summary, err := terraform.ParsePlanSummary(stdout)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	logger.Info(fmt.Sprintf("service returning plan to add %v, change %v and destroy %v resources", summary.Add, summary.Change, summary.Destroy))
+
+	return summary, nil
 }
 
-func (s *ClusterService) DeleteCluster(request_id string, client TerraformClient, id string) (*models.Cluster, error) {
+// Mark the cluster destroying and queue a job to destroy it
+func (s *ClusterService) DeleteCluster(request_id string, id string) (*models.Cluster, error) {
 	logger := log.WithFields(log.Fields{"package": "services", "event": "delete_cluster", "request": request_id})
 
 	logger.Info("servicing request to delete cluster")
 
 	// Retrieve the cluster to destroy
-	cluster, err := s.dao.GetCluster(s.db, id, request_id)
+	cluster, err := s.dao.GetCluster(id, request_id)
 	if err != nil {
 		logger.Error(err.Error())
 		return nil, err
@@ -113,107 +438,282 @@ func (s *ClusterService) DeleteCluster(request_id string, client TerraformClient
 		return nil, err
 	}
 
+	// The status is changed with the job queued, so a worker can never
+	//  finish destroying the cluster before it is marked destroying
+	_, err = s.dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
 	cluster.Status = models.ClusterStatusDestroying
-	err = s.dao.UpdateClusterField(s.db, cluster.Id, "status", models.ClusterStatusDestroying, request_id)
+	s.publishClusterEvent(cluster)
+
+	// Cluster with requested action is returned and eventual cluster status
+	//  is handled by a job worker asynchronously
+
+	logger.Info("servicing returning cluster set to delete")
+
+	return cluster, nil
+}
+
+// Cancel provisioning of a cluster. Provisioning which has not started is
+// cancelled immediately, running provisioning is interrupted by the worker
+// running it, which then rolls back whatever was already provisioned.
+func (s *ClusterService) CancelCluster(request_id string, id string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "cancel_cluster", "request": request_id})
+
+	logger.Info("servicing request to cancel cluster")
+
+	_, err := s.dao.CancelClusterJob(id, request_id)
 	if err != nil {
 		logger.Error(err.Error())
-		return cluster, err
+		return nil, err
 	}
 
-	credentials := app.GlobalServerConfig.Credentials(cluster.Project)
-	if len(credentials) == 0 {
-		logger.Error(models.CredentialsNotFound)
+	cluster, err := s.dao.GetCluster(id, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
 	}
 
-	client.SetCredentials(credentials)
-	client.SetProject(cluster.Project)
-	client.SetRegion(cluster.Region)
+	if cluster != nil {
+		s.publishClusterEvent(cluster)
+	}
 
-	// Cluster with requested action is returned and eventual cluster status
-	//  is handled in the terraform service asynchronously
-	go s.TerraformDestroyCluster(client, cluster, request_id)
+	return cluster, nil
+}
 
-	logger.Info("servicing returning cluster set to delete")
+// Move the expiration of a live cluster to timeout from now. A zero timeout
+// expires the cluster immediately, leaving it for the reaper to destroy.
+func (s *ClusterService) UpdateClusterExpiration(request_id string, id string, timeout string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "update_cluster_expiration", "request": request_id})
+
+	logger.Info(fmt.Sprintf("servicing request to update expiration of cluster '%v'", id))
+
+	if len(timeout) == 0 {
+		err := errors.New(models.ErrorMissingTimeout)
+		logger.Error(err)
+		return nil, err
+	}
+
+	timeout_duration, err := time.ParseDuration(timeout)
+	if err != nil || timeout_duration < 0 {
+		err := errors.New(models.ErrorInvalidTimeout)
+		logger.Error(err)
+		return nil, err
+	}
+
+	cluster, err := s.dao.GetCluster(id, request_id)
+	if err == sql.ErrNoRows || (err == nil && cluster == nil) {
+		err = errors.New(models.ErrorClusterNotFound)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if cluster.Status == models.ClusterStatusDestroying || cluster.Status == models.ClusterStatusDestroyed {
+		err := errors.New(models.ErrorClusterNotLive)
+		logger.Error(err)
+		return nil, err
+	}
+
+	max_lifetime, err := app.GlobalServerConfig.MaxLifetime(cluster.Project)
+	if err != nil {
+		err := errors.New(models.ErrorInvalidMaxLifetime)
+		logger.Error(err)
+		return nil, err
+	}
+
+	expiration := time.Now().Add(timeout_duration)
+
+	// The maximum lifetime is measured from creation, therefore repeated
+	//  extensions cannot keep a cluster alive indefinitely
+	if max_lifetime > 0 && expiration.Sub(cluster.Timestamp) > max_lifetime {
+		err := errors.New(models.ErrorExceedsMaxLifetime)
+		logger.Error(err)
+		return nil, err
+	}
+
+	// Extensions count against the quotas the cluster was created within
+	quotas := clusterQuotas(&models.ClusterSpec{Project: cluster.Project, Owner: cluster.Owner})
+	err = s.dao.UpdateClusterExpiration(cluster.Id, expiration, quotas, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+	cluster.Expiration = expiration
+
+	logger.Info(fmt.Sprintf("service returning cluster '%v' expiring at '%v'", id, expiration))
 
 	return cluster, nil
 }
 
+// Record a failed attempt to destroy the cluster and schedule the next
+// attempt with backoff. Once every attempt has failed the cluster is left
+// for a human, as its resources may have leaked.
+func (s *ClusterService) destroyFailed(cluster *models.Cluster, cause error, requestId string) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "destroy_failed", "request": requestId})
+
+	cluster.DestroyAttempts++
+	status := models.ClusterStatusDestroyFailed
+	message := cause.Error()
+
+	backoff, err := app.GlobalServerConfig.DestroyBackoff(cluster.DestroyAttempts)
+	if err != nil {
+		logger.Error(fmt.Sprintf("invalid destroy retry backoff: %v", err))
+	}
+
+	if err != nil || cluster.DestroyAttempts >= app.GlobalServerConfig.DestroyRetries.MaxAttempts {
+		status = models.ClusterStatusDestroyRetriesExhausted
+		message = models.ErrorDestroyRetriesExhausted + "\n" + message
+		logger.Error(fmt.Sprintf("giving up destroying cluster '%v' in project '%v' after %v attempt(s), its resources may have leaked", cluster.Id, cluster.Project, cluster.DestroyAttempts))
+	} else {
+		cluster.NextDestroyAttempt = time.Now().Add(backoff)
+		logger.Warn(fmt.Sprintf("retrying to destroy cluster '%v' at '%v'", cluster.Id, cluster.NextDestroyAttempt))
+	}
+
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"destroy_attempts", cluster.DestroyAttempts},
+		{"next_destroy_attempt", cluster.NextDestroyAttempt},
+	}
+	for _, field := range fields {
+		err := s.updateClusterField(cluster, field.name, field.value, requestId)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	// The status is changed last, the cluster is only retried once the
+	//  next attempt is recorded
+	err = s.updateClusterStatusMessage(cluster, status, message, requestId)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// Destroy a cluster which failed to be destroyed right away, starting a new
+// round of automatic retries should it fail again
+func (s *ClusterService) RetryDestroyCluster(request_id string, id string) (*models.Cluster, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "retry_destroy_cluster", "request": request_id})
+
+	logger.Info("servicing request to retry destroying cluster")
+
+	cluster, err := s.dao.GetCluster(id, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	if cluster == nil {
+		err := errors.New(models.ErrorClusterNotFound)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if cluster.Status != models.ClusterStatusDestroyFailed && cluster.Status != models.ClusterStatusDestroyRetriesExhausted {
+		err := errors.New(models.ErrorClusterNotDestroyFailed)
+		logger.Error(err)
+		return nil, err
+	}
+
+	// Reset before queueing, the queued destroy may fail before this returns
+	cluster.DestroyAttempts = 0
+	err = s.updateClusterField(cluster, "destroy_attempts", cluster.DestroyAttempts, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	_, err = s.dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	cluster.Status = models.ClusterStatusDestroying
+	s.publishClusterEvent(cluster)
+
+	return cluster, nil
+}
+
+// Remove the working directory of the client however its operation ended,
+// it holds the sensitive variables and backend credentials of the cluster
+func removeClientDir(client TerraformClient, logger *log.Entry) {
+	err := client.ClientDestroy()
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
+
 func (s *ClusterService) TerraformDestroyCluster(client TerraformClient, cluster *models.Cluster, requestId string) {
 	logger := log.WithFields(log.Fields{"package": "services", "event": "terraform_destroy", "request": requestId})
 
 	client.SetConfig(cluster.TerraformConfig)
 	client.SetState(cluster.TerraformState)
 
+	// Destroy with the values the cluster was applied with
+	client.SetVariables(cluster.Variables.Values())
+
+	logs := s.newClusterLogWriter(cluster, models.ClusterLogOperationDestroy, requestId)
+	defer logs.Close()
+	client.SetLog(logs)
+
+	defer removeClientDir(client, logger)
+
 	err := client.ClientInit()
 	if err != nil {
-		cluster.Status = models.ClusterStatusDestroyFailed
-		cluster.Message = err.Error()
 		logger.Error(err.Error())
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", models.ClusterStatusDestroyFailed, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
+		s.destroyFailed(cluster, err, requestId)
 		return
 	}
 
 	state, output, err := client.Destroy()
 	if err != nil {
-		cluster.Status = models.ClusterStatusDestroyFailed
-		cluster.Message = err.Error()
 		logger.Error(err.Error())
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", models.ClusterStatusDestroyFailed, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
+		s.destroyFailed(cluster, err, requestId)
 		return
 	}
 
-	err = client.ClientDestroy()
+	err = s.updateClusterState(cluster, state, requestId)
 	if err != nil {
-		cluster.Status = models.ClusterStatusDestroyFailed
-		cluster.Message = err.Error()
 		logger.Error(err.Error())
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", models.ClusterStatusDestroyFailed, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		return
 	}
 
-	if err == nil {
-		cluster.Status = models.ClusterStatusDestroyed
-		cluster.Message = output
-		cluster.TerraformState = state
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		return
+	err = s.updateClusterStatusMessage(cluster, models.ClusterStatusDestroyed, output, requestId)
+	if err != nil {
+		logger.Error(err.Error())
 	}
-
 }
 
 func (s *ClusterService) TerraformProvisionCluster(client TerraformClient, cluster *models.Cluster, config []byte, requestId string) *models.Cluster {
 	logger := log.WithFields(log.Fields{"package": "services", "event": "terraform_provision", "request": requestId})
 
 	client.SetConfig(config)
+	client.SetVariables(cluster.Variables.Values())
+
+	logs := s.newClusterLogWriter(cluster, models.ClusterLogOperationProvision, requestId)
+	defer logs.Close()
+	client.SetLog(logs)
 
-	cluster.Status = models.ClusterStatusProvisionStart
-	err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
+	// A cluster which moved on before its provisioning started, such as a
+	//  cluster deleted meanwhile, is not provisioned
+	err := s.updateClusterStatus(cluster, models.ClusterStatusProvisionStart, requestId)
 	if err != nil {
-		logger.Error(models.ClusterUpdateFailed)
+		logger.Error(err.Error())
+		return cluster
 	}
 
+	defer removeClientDir(client, logger)
+
 	err = client.ClientInit()
 	if err != nil {
 		logger.Error(models.ClusterProvisioningFailed)
-		cluster.Status = models.ClusterStatusProvisionFailed
-		cluster.Message = err.Error()
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
-		if err != nil {
-			logger.Error(models.ClusterUpdateFailed)
-		}
-		err = s.dao.UpdateClusterField(s.db, cluster.Id, "message", cluster.Message, requestId)
+		err := s.updateClusterStatusMessage(cluster, models.ClusterStatusProvisionFailed, err.Error(), requestId)
 		if err != nil {
 			logger.Error(models.ClusterUpdateFailed)
 		}
@@ -222,27 +722,45 @@ func (s *ClusterService) TerraformProvisionCluster(client TerraformClient, clust
 
 	state, stdout, err := client.Apply()
 	if err != nil {
-		cluster.Status = models.ClusterStatusProvisionFailed
-		cluster.Message = err.Error()
+		message := err.Error()
 		logger.Error(err.Error())
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
+		err := s.updateClusterStatus(cluster, models.ClusterStatusProvisionFailed, requestId)
+		if err != nil {
+			logger.Error(err.Error())
+		}
+
+		// A job which is no longer claimed leaves the cluster to be
+		//  reconciled, rolling back would run terraform alongside the
+		//  worker which now owns the cluster
+		if err != nil && err.Error() == models.ErrorClusterJobClaimLost {
+			return cluster
+		}
+
+		// A failed or cancelled apply may have provisioned some resources,
+		//  the partial state is kept in case rolling them back fails
+		err = s.updateClusterState(cluster, state, requestId)
 		if err != nil {
 			logger.Error(err.Error())
 		}
 
-		// Attempt to rollback
+		// Attempt to rollback the resources of the partial state, unless
+		//  the client reads it from a state backend
+		client.SetState(state)
+		status := models.ClusterStatusProvisionFailedRollbackSuccess
 		rollback_state, rollback_stdout, err := client.Destroy()
 		if err != nil {
-			cluster.Status = models.ClusterStatusProvisionFailedRollbackFailed
-			cluster.Message = cluster.Message + "\n" + err.Error()
+			status = models.ClusterStatusProvisionFailedRollbackFailed
+			message = message + "\n" + err.Error()
 			logger.Error(err.Error())
 		} else {
-			cluster.Status = models.ClusterStatusProvisionFailedRollbackSuccess
-			cluster.Message = cluster.Message + "\n" + rollback_stdout
-			cluster.TerraformState = rollback_state
+			message = message + "\n" + rollback_stdout
+			err = s.updateClusterState(cluster, rollback_state, requestId)
+			if err != nil {
+				logger.Error(err.Error())
+			}
 		}
 
-		err = s.dao.UpdateClusterField(s.db, cluster.Id, "message", cluster.Message, requestId)
+		err = s.updateClusterStatusMessage(cluster, status, message, requestId)
 		if err != nil {
 			logger.Error(err.Error())
 		}
@@ -250,61 +768,35 @@ func (s *ClusterService) TerraformProvisionCluster(client TerraformClient, clust
 		return cluster
 	}
 
-	// The state must be set in the client
-	//  in order to retrieve outputs
+	// The state must be set in the client in order to retrieve
+	//  outputs, unless the client reads it from a state backend
 	client.SetState(state)
 
 	outputs, err := client.Outputs()
 	if err != nil {
-		cluster.Status = models.ClusterStatusProvisionFailed
-		cluster.Message = err.Error()
 		logger.Error(err.Error())
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		err = s.dao.UpdateClusterField(s.db, cluster.Id, "message", cluster.Message, requestId)
+		err := s.updateClusterStatusMessage(cluster, models.ClusterStatusProvisionFailed, err.Error(), requestId)
 		if err != nil {
 			logger.Error(err.Error())
 		}
 		return cluster
 	}
 
-	err = client.ClientDestroy()
+	err = s.updateClusterState(cluster, state, requestId)
 	if err != nil {
-		cluster.Status = models.ClusterStatusProvisionFailed
-		cluster.Message = err.Error()
 		logger.Error(err.Error())
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		err = s.dao.UpdateClusterField(s.db, cluster.Id, "message", cluster.Message, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
+	}
 
+	err = s.updateClusterStatusMessage(cluster, models.ClusterStatusProvisionSuccess, stdout, requestId)
+	if err != nil {
+		logger.Error(err.Error())
 		return cluster
 	}
 
-	if err == nil {
-		cluster.Status = models.ClusterStatusProvisionSuccess
-		cluster.Message = stdout
-		cluster.Outputs = []byte(outputs)
-		cluster.TerraformState = state
-		err := s.dao.UpdateClusterField(s.db, cluster.Id, "status", cluster.Status, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		err = s.dao.UpdateClusterField(s.db, cluster.Id, "message", cluster.Message, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		err = s.dao.UpdateClusterField(s.db, cluster.Id, "outputs", cluster.Outputs, requestId)
-		if err != nil {
-			logger.Error(err.Error())
-		}
-		return cluster
+	cluster.Outputs = []byte(outputs)
+	err = s.updateClusterField(cluster, "outputs", cluster.Outputs, requestId)
+	if err != nil {
+		logger.Error(err.Error())
 	}
 
 	return cluster
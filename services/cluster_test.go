@@ -6,7 +6,15 @@ import (
 	. "github.com/onsi/gomega"
 	log "github.com/sirupsen/logrus"
 
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/satori/go.uuid"
 
@@ -18,6 +26,7 @@ import (
 
 var (
 	validTerraformOutputs = "{\"bar\":{\"sensitive\":false,\"type\":\"string\",\"value\":\"foo\" }"
+	validTerraformPlan    = "Terraform will perform the following actions:\n\n  + google_compute_instance.default\n\nPlan: 1 to add, 0 to change, 0 to destroy."
 	validTerraformState   = []byte(`{"version":3,"terraform_version":"0.11.3","serial":2,"lineage":"26655d4c-852a-41e4-b6f1-7b31ff2b2981","modules":[{"path":["root"],"outputs":{"foo":{"sensitive":false,"type":"string","value":"bar"}},"resources":{},"depends_on":[]}]}`)
 )
 
@@ -60,7 +69,7 @@ var _ = Describe("Cluster", func() {
 		cluster1 = &models.Cluster{
 			Id:              cluster1UUID,
 			Name:            "cluster",
-			Status:          "status",
+			Status:          models.ClusterStatusRequested,
 			TerraformConfig: []byte(`{"provider":{"google":{}}}`),
 			Project:         validProject,
 			Region:          validRegion,
@@ -70,7 +79,7 @@ var _ = Describe("Cluster", func() {
 		cluster2 = &models.Cluster{
 			Id:              cluster2UUID,
 			Name:            "cluster",
-			Status:          "status",
+			Status:          models.ClusterStatusRequested,
 			TerraformConfig: []byte(`{"provider":{"google":{}}}`),
 			Project:         validProject,
 			Region:          validRegion,
@@ -90,11 +99,12 @@ var _ = Describe("Cluster", func() {
 
 	Describe("Creating a cluster", func() {
 		Context("When everything goes ok", func() {
+			var clusterDao *ValidClusterDao
+
 			BeforeEach(func() {
-				clustersMap := make(map[string]*models.Cluster)
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				terraformClient = new(PassingClient)
-				cluster, err = cs.CreateCluster(validTerraformConfig, validTimeout, validProject, validRegion, validRequestId, terraformClient)
+				clusterDao = NewValidClusterDao(make(map[string]*models.Cluster))
+				cs = NewClusterService(clusterDao)
+				cluster, err = cs.CreateCluster(&models.ClusterSpec{TerraformConfig: validTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion}, validRequestId)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -102,19 +112,59 @@ var _ = Describe("Cluster", func() {
 			It("Should return a cluster", func() {
 				Expect(cluster).NotTo(BeNil())
 			})
-			It("Should set the project of the terraform client", func() {
-				Expect(terraformClient.Project()).To(Equal(validProject))
+			It("Should queue a job to provision the cluster", func() {
+				Expect(clusterDao.jobs).To(HaveLen(1))
+				Expect(clusterDao.jobs[0].ClusterId).To(Equal(cluster.Id))
+				Expect(clusterDao.jobs[0].Operation).To(Equal(models.ClusterJobProvision))
 			})
-			It("Should set the region of the terraform client", func() {
-				Expect(terraformClient.Region()).To(Equal(validRegion))
+		})
+
+		Context("When quotas are configured", func() {
+			var clusterDao *ValidClusterDao
+
+			BeforeEach(func() {
+				app.GlobalServerConfig.Clouds = map[string]app.CloudProjectConfig{
+					validProject: {Project: validProject, QuotaConfig: app.QuotaConfig{MaxClusters: 10}},
+				}
+				app.GlobalServerConfig.OwnerQuotas = app.OwnerQuotaConfig{
+					Default: app.QuotaConfig{MaxClusters: 2},
+					Owners:  map[string]app.QuotaConfig{"alice": {MaxClusterHours: 24, MaxTimeout: "8h"}},
+				}
+
+				clusterDao = NewValidClusterDao(make(map[string]*models.Cluster))
+				cs = NewClusterService(clusterDao)
+				cluster, err = cs.CreateCluster(&models.ClusterSpec{TerraformConfig: validTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion, Owner: "alice"}, validRequestId)
+			})
+
+			AfterEach(func() {
+				app.GlobalServerConfig.Clouds = nil
+				app.GlobalServerConfig.OwnerQuotas = app.OwnerQuotaConfig{}
+			})
+
+			It("Should create the cluster within the quota of the project and then of the owner", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusterDao.spec.Quotas).To(Equal([]models.Quota{
+					{Scope: models.QuotaScopeProject, Name: validProject, MaxClusters: 10},
+					{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusterHours: 24, MaxTimeout: "8h"},
+				}))
+			})
+		})
+
+		Context("When a webhook is not an http url", func() {
+			It("Should error", func() {
+				cs = NewClusterService(NewValidClusterDao(make(map[string]*models.Cluster)))
+				for _, webhook := range []string{"file:///etc/passwd", "example.com/hook", "http://", "::"} {
+					cluster, err = cs.CreateCluster(&models.ClusterSpec{TerraformConfig: validTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion, Webhooks: []string{"https://example.com/hook", webhook}}, validRequestId)
+					Expect(err).To(MatchError(HavePrefix(models.ErrorInvalidWebhookUrl)))
+					Expect(cluster).To(BeNil())
+				}
 			})
 		})
 
 		Context("When a cluster is not returned from the dao", func() {
 			BeforeEach(func() {
-				cs = NewClusterService(NewEmptyClusterDao(), NewMockDB().db)
-				client := new(FailingClient)
-				cluster, err = cs.CreateCluster(validTerraformConfig, validTimeout, validRequestId, validProject, validRegion, client)
+				cs = NewClusterService(NewEmptyClusterDao())
+				cluster, err = cs.CreateCluster(&models.ClusterSpec{TerraformConfig: validTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion}, validRequestId)
 			})
 			It("Should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -127,9 +177,8 @@ var _ = Describe("Cluster", func() {
 		Context("When invalid terraform config is used", func() {
 			BeforeEach(func() {
 				clustersMap := make(map[string]*models.Cluster)
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				client := new(PassingClient)
-				cluster, err = cs.CreateCluster(invalidTerraformConfig, validTimeout, validRequestId, validProject, validRegion, client)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.CreateCluster(&models.ClusterSpec{TerraformConfig: invalidTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion}, validRequestId)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -142,9 +191,8 @@ var _ = Describe("Cluster", func() {
 		Context("When there are no outputs defined in the config", func() {
 			BeforeEach(func() {
 				clustersMap := make(map[string]*models.Cluster)
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				client := new(FailingClient)
-				cluster, err = cs.CreateCluster(validNoOutputsTerraformConfig, validTimeout, validRequestId, validProject, validRegion, client)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.CreateCluster(&models.ClusterSpec{TerraformConfig: validNoOutputsTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion}, validRequestId)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -156,12 +204,159 @@ var _ = Describe("Cluster", func() {
 
 	})
 
+	Describe("Creating a cluster with an idempotency key", func() {
+		var (
+			dao      *ValidClusterDao
+			original *models.Cluster
+			spec     *models.ClusterSpec
+		)
+
+		BeforeEach(func() {
+			app.GlobalServerConfig.IdempotencyRetention = "24h"
+			dao = NewValidClusterDao(make(map[string]*models.Cluster))
+			cs = NewClusterService(dao)
+			spec = &models.ClusterSpec{TerraformConfig: validTerraformConfig, Timeout: validTimeout, Project: validProject, Region: validRegion, IdempotencyKey: "key", RequestHash: "hash"}
+			original, err = cs.CreateCluster(spec, validRequestId)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("When the request is retried", func() {
+			BeforeEach(func() {
+				cluster, err = cs.CreateCluster(spec, "c12c2d58-2af0-11e8-b467-0ed5f89f718b")
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return the original cluster", func() {
+				Expect(cluster.Id).To(Equal(original.Id))
+			})
+			It("Should not create another cluster", func() {
+				Expect(dao.clustersMap).To(HaveLen(1))
+			})
+		})
+
+		Context("When the key is reused by another owner", func() {
+			BeforeEach(func() {
+				other := *spec
+				other.Owner = "bob"
+				cluster, err = cs.CreateCluster(&other, "c12c2d58-2af0-11e8-b467-0ed5f89f718b")
+			})
+			It("Should create another cluster", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.spec.Owner).To(Equal("bob"))
+				Expect(dao.idempotencyKeys).To(HaveLen(2))
+			})
+		})
+
+		Context("When the key is reused with a different request", func() {
+			BeforeEach(func() {
+				spec.RequestHash = "other"
+				cluster, err = cs.CreateCluster(spec, "c12c2d58-2af0-11e8-b467-0ed5f89f718b")
+			})
+			It("Should error", func() {
+				Expect(err).To(MatchError(models.ErrorIdempotencyKeyMismatch))
+			})
+		})
+
+		Context("When the key is too long", func() {
+			It("Should error", func() {
+				spec.IdempotencyKey = strings.Repeat("k", models.MaxIdempotencyKeyLength+1)
+				_, err = cs.CreateCluster(spec, "c12c2d58-2af0-11e8-b467-0ed5f89f718b")
+				Expect(err).To(MatchError(models.ErrorInvalidIdempotencyKey))
+			})
+		})
+	})
+
+	Describe("Planning a cluster", func() {
+		var (
+			summary *terraform.PlanSummary
+		)
+
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				cs = NewClusterService(NewEmptyClusterDao())
+				terraformClient = new(PassingClient)
+				summary, err = cs.PlanCluster(context.Background(), &models.ClusterSpec{TerraformConfig: validTerraformConfig, Project: validProject, Region: validRegion}, validRequestId, terraformClient)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return the parsed plan", func() {
+				Expect(summary.Add).To(Equal(1))
+				Expect(summary.Resources).To(Equal([]terraform.PlannedResource{{Address: "google_compute_instance.default", Action: terraform.PlanActionCreate}}))
+			})
+			It("Should set the project of the terraform client", func() {
+				Expect(terraformClient.Project()).To(Equal(validProject))
+			})
+		})
+
+		Context("When variables are given", func() {
+			var client *PassingClient
+			BeforeEach(func() {
+				cs = NewClusterService(NewEmptyClusterDao())
+				client = new(PassingClient)
+				summary, err = cs.PlanCluster(context.Background(), &models.ClusterSpec{
+					TerraformConfig: validTerraformConfig,
+					Project:         validProject,
+					Region:          validRegion,
+					Variables:       models.ClusterVariables{"nodes": {Value: 3}, "password": {Value: "secret", Sensitive: true}},
+				}, validRequestId, client)
+			})
+			It("Should pass every variable to terraform", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client.variables).To(Equal(map[string]interface{}{"nodes": 3, "password": "secret"}))
+			})
+		})
+
+		Context("When the config is missing", func() {
+			BeforeEach(func() {
+				cs = NewClusterService(NewEmptyClusterDao())
+				summary, err = cs.PlanCluster(context.Background(), &models.ClusterSpec{Project: validProject, Region: validRegion}, validRequestId, new(PassingClient))
+			})
+			It("Should error", func() {
+				Expect(err).To(MatchError(models.ErrorMissingConfig))
+			})
+		})
+
+		Context("When the request for the plan ends while planning", func() {
+			BeforeEach(func() {
+				cs = NewClusterService(NewEmptyClusterDao())
+				client := NewCancellableClient()
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					<-client.started
+					cancel()
+				}()
+				summary, err = cs.PlanCluster(ctx, &models.ClusterSpec{TerraformConfig: validTerraformConfig, Project: validProject, Region: validRegion}, validRequestId, client)
+			})
+			It("Should interrupt terraform", func() {
+				Expect(err).To(MatchError(terraform.ErrorPlanCancelled))
+			})
+			It("Should not return a plan", func() {
+				Expect(summary).To(BeNil())
+			})
+		})
+
+		Context("When terraform fails to plan", func() {
+			BeforeEach(func() {
+				cs = NewClusterService(NewEmptyClusterDao())
+				summary, err = cs.PlanCluster(context.Background(), &models.ClusterSpec{TerraformConfig: validTerraformConfig, Project: validProject, Region: validRegion}, validRequestId, new(FailingClient))
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+			It("Should not return a plan", func() {
+				Expect(summary).To(BeNil())
+			})
+		})
+	})
+
 	Describe("Terraform Provisioning a cluster", func() {
 		Context("When everything goes ok", func() {
 			BeforeEach(func() {
 				clustersMap := make(map[string]*models.Cluster)
 				clustersMap[cluster1.Id] = cluster1
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
 				rc.SetTerraformConfig(validTerraformConfig)
 				client := new(PassingClient)
 				cluster = cs.TerraformProvisionCluster(client, cluster1, validTerraformConfig, cluster1UUID)
@@ -181,6 +376,184 @@ var _ = Describe("Cluster", func() {
 			It("Should set the cluster outputs as expected", func() {
 				Expect(cluster.Outputs).To(Equal([]byte(validTerraformOutputs)))
 			})
+			It("Should persist the terraform output to the provision log", func() {
+				logs, err := cs.GetClusterLogs(cluster1UUID, cluster1.Id, models.ClusterLogOperationProvision, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logs).To(HaveLen(2))
+				Expect(logs[0].Line).To(Equal("$ terraform apply"))
+				Expect(logs[1].Line).To(Equal(terraform.ApplySuccess))
+			})
+		})
+
+		Context("When the cluster has variables", func() {
+			It("Should apply with the variables of the cluster", func() {
+				cluster2.Variables = models.ClusterVariables{"nodes": {Value: 3}}
+				clustersMap := map[string]*models.Cluster{cluster2.Id: cluster2}
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				client := new(PassingClient)
+				cs.TerraformProvisionCluster(client, cluster2, validTerraformConfig, cluster1UUID)
+				Expect(client.variables).To(Equal(map[string]interface{}{"nodes": 3}))
+			})
+		})
+
+		Context("When the apply fails after provisioning some resources", func() {
+			It("Should roll back the resources of the partial state", func() {
+				stored := *cluster1
+				cs = NewClusterService(NewValidClusterDao(map[string]*models.Cluster{cluster1.Id: &stored}))
+				client := new(PartialClient)
+				cluster = cs.TerraformProvisionCluster(client, &stored, validTerraformConfig, cluster1UUID)
+				Expect(client.destroyed).To(Equal([]byte(`partial`)))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionFailedRollbackSuccess))
+				Expect(client.removed).To(BeTrue())
+			})
+		})
+
+		Context("When the cluster was deleted before provisioning started", func() {
+			It("Should not provision the cluster", func() {
+				deleted := *cluster1
+				deleted.Status = models.ClusterStatusDestroying
+				cs = NewClusterService(NewValidClusterDao(map[string]*models.Cluster{cluster1.Id: &deleted}))
+				client := NewDeletingClient(nil)
+				cluster = cs.TerraformProvisionCluster(client, cluster1, validTerraformConfig, cluster1UUID)
+				Expect(client.applied).To(BeFalse())
+				Expect(deleted.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+		})
+
+		Context("When the cluster is deleted while provisioning", func() {
+			It("Should not overwrite the destroying status", func() {
+				stored := *cluster1
+				cs = NewClusterService(NewValidClusterDao(map[string]*models.Cluster{cluster1.Id: &stored}))
+				client := NewDeletingClient(func() { stored.Status = models.ClusterStatusDestroying })
+				cluster = cs.TerraformProvisionCluster(client, cluster1, validTerraformConfig, cluster1UUID)
+				Expect(client.applied).To(BeTrue())
+				Expect(stored.Status).To(Equal(models.ClusterStatusDestroying))
+				Expect(stored.Outputs).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Getting the logs of a cluster", func() {
+		Context("When after is negative", func() {
+			It("Should error", func() {
+				cs = NewClusterService(NewValidClusterDao(make(map[string]*models.Cluster)))
+				_, err = cs.GetClusterLogs(validRequestId, cluster1UUID, "", -1)
+				Expect(err).To(MatchError(models.ErrorInvalidClusterLogAfter))
+			})
+		})
+	})
+
+	Describe("Getting the history of a cluster", func() {
+		var (
+			clusterDao *ValidClusterDao
+			history    []models.ClusterHistoryEvent
+			start      time.Time
+		)
+
+		BeforeEach(func() {
+			clusterDao = NewValidClusterDao(map[string]*models.Cluster{cluster1UUID: cluster1})
+			cs = NewClusterService(clusterDao)
+			start = time.Now()
+
+			record := func(kind string, name string, after time.Duration) {
+				clusterDao.CreateClusterHistoryEvent(&models.ClusterHistoryEvent{ClusterId: cluster1UUID, Kind: kind, Name: name, Timestamp: start.Add(after)}, validRequestId)
+			}
+			record(models.ClusterHistoryStatus, models.ClusterStatusRequested, 0)
+			record(models.ClusterHistoryOperationStarted, models.ClusterJobProvision, 5*time.Second)
+			record(models.ClusterHistoryStatus, models.ClusterStatusProvisionStart, 5*time.Second)
+			record(models.ClusterHistoryStatus, models.ClusterStatusProvisionSuccess, 95*time.Second)
+			record(models.ClusterHistoryOperationFinished, models.ClusterJobProvision, 96*time.Second)
+			clusterDao.CreateClusterHistoryEvent(&models.ClusterHistoryEvent{ClusterId: cluster2UUID, Kind: models.ClusterHistoryStatus, Name: models.ClusterStatusRequested}, validRequestId)
+
+			history, err = cs.GetClusterHistory(validRequestId, cluster1UUID)
+		})
+
+		It("Should return only the events of the cluster in order", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(5))
+			Expect(history[0].Name).To(Equal(models.ClusterStatusRequested))
+		})
+		It("Should time each status until the next status", func() {
+			Expect(history[0].Duration).To(Equal(5.0))
+			Expect(history[2].Duration).To(Equal(90.0))
+		})
+		It("Should not time the current status", func() {
+			Expect(history[3].Duration).To(BeZero())
+		})
+		It("Should time each finished operation", func() {
+			Expect(history[4].Duration).To(Equal(91.0))
+		})
+
+		Context("When an action is recorded", func() {
+			It("Should append the action with its actor", func() {
+				err = cs.RecordClusterAction(validRequestId, cluster1UUID, models.ClusterActionDelete, "alice", "")
+				Expect(err).NotTo(HaveOccurred())
+
+				history, err = cs.GetClusterHistory(validRequestId, cluster1UUID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(history).To(HaveLen(6))
+				Expect(history[5].Kind).To(Equal(models.ClusterHistoryAction))
+				Expect(history[5].Name).To(Equal(models.ClusterActionDelete))
+				Expect(history[5].Actor).To(Equal("alice"))
+				Expect(history[5].RequestId).To(Equal(validRequestId))
+			})
+		})
+	})
+
+	Describe("Subscribing to cluster events", func() {
+		var (
+			events      <-chan models.ClusterEvent
+			unsubscribe func()
+			received    []models.ClusterEvent
+		)
+
+		Context("When a cluster is provisioned", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				events, unsubscribe = cs.SubscribeClusterEvents(cluster1.Id)
+				cs.TerraformProvisionCluster(new(PassingClient), cluster1, validTerraformConfig, cluster1UUID)
+				unsubscribe()
+				received = []models.ClusterEvent{}
+				for event := range events {
+					received = append(received, event)
+				}
+			})
+			It("Should publish each status change", func() {
+				statuses := []string{}
+				for _, event := range received {
+					statuses = append(statuses, event.Status)
+				}
+				Expect(statuses).To(ContainElement(models.ClusterStatusProvisionStart))
+				Expect(statuses).To(ContainElement(models.ClusterStatusProvisionSuccess))
+			})
+			It("Should publish the final message", func() {
+				messages := []string{}
+				for _, event := range received {
+					messages = append(messages, event.Message)
+				}
+				Expect(messages).To(ContainElement(terraform.ApplySuccess))
+			})
+		})
+
+		Context("When a different cluster changes", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				clustersMap[cluster2.Id] = cluster2
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				events, unsubscribe = cs.SubscribeClusterEvents(cluster1.Id)
+				_, err = cs.DeleteCluster(validRequestId, cluster2.Id)
+				unsubscribe()
+				received = []models.ClusterEvent{}
+				for event := range events {
+					received = append(received, event)
+				}
+			})
+			It("Should not publish the change to the subscriber", func() {
+				Expect(received).To(BeEmpty())
+			})
 		})
 	})
 
@@ -199,7 +572,7 @@ var _ = Describe("Cluster", func() {
 			BeforeEach(func() {
 				clustersMap := make(map[string]*models.Cluster)
 				clustersMap[cluster1.Id] = cluster1
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
 				cluster, err = cs.GetCluster(validRequestId, cluster1.Id)
 			})
 			It("Should not error", func() {
@@ -215,7 +588,7 @@ var _ = Describe("Cluster", func() {
 
 		Context("When the cluster does not exist", func() {
 			BeforeEach(func() {
-				cs = NewClusterService(NewEmptyClusterDao(), NewMockDB().db)
+				cs = NewClusterService(NewEmptyClusterDao())
 				cluster, err = cs.GetCluster(validRequestId, cluster1.Id)
 			})
 			It("Should error", func() {
@@ -243,8 +616,8 @@ var _ = Describe("Cluster", func() {
 				clustersMap := make(map[string]*models.Cluster)
 				clustersMap[cluster1.Id] = cluster1
 				clustersMap[cluster2.Id] = cluster2
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				clusters, err = cs.GetClusters(validRequestId)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				clusters, _, err = cs.GetClusters(validRequestId, nil)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
@@ -260,8 +633,8 @@ var _ = Describe("Cluster", func() {
 
 		Context("When there are no clusters", func() {
 			BeforeEach(func() {
-				cs = NewClusterService(NewEmptyClusterDao(), NewMockDB().db)
-				clusters, err = cs.GetClusters(validRequestId)
+				cs = NewClusterService(NewEmptyClusterDao())
+				clusters, _, err = cs.GetClusters(validRequestId, nil)
 			})
 			It("should not error", func() {
 				Expect(err).ShouldNot(HaveOccurred())
@@ -273,6 +646,146 @@ var _ = Describe("Cluster", func() {
 
 	})
 
+	// ======================================================================
+	//                       _           _   _
+	//   _____  ___ __  _ __(_)_ __ __ _| |_(_) ___  _ __
+	//  / _ \ \/ / '_ \| '__| | '__/ _` | __| |/ _ \| '_ \
+	// |  __/>  <| |_) | |  | | | | (_| | |_| | (_) | | | |
+	//  \___/_/\_\ .__/|_|  |_|_|  \__,_|\__|_|\___/|_| |_|
+	//           |_|
+	// ======================================================================
+
+	Describe("Updating the expiration of a cluster", func() {
+		BeforeEach(func() {
+			cluster1.Timestamp = time.Now()
+			app.GlobalServerConfig.Clouds = map[string]app.CloudProjectConfig{
+				validProject: app.CloudProjectConfig{Project: validProject, MaxLifetime: "2h"},
+			}
+		})
+
+		AfterEach(func() {
+			app.GlobalServerConfig.Clouds = nil
+		})
+
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "1h")
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return the cluster with the new expiration", func() {
+				Expect(cluster.Expiration).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			})
+			It("Should save the new expiration", func() {
+				Expect(cluster1.Expiration).To(Equal(cluster.Expiration))
+			})
+		})
+
+		Context("When quotas are configured", func() {
+			var clusterDao *ValidClusterDao
+
+			BeforeEach(func() {
+				app.GlobalServerConfig.OwnerQuotas = app.OwnerQuotaConfig{
+					Owners: map[string]app.QuotaConfig{"alice": {MaxClusterHours: 24, MaxTimeout: "8h"}},
+				}
+
+				cluster1.Owner = "alice"
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				clusterDao = NewValidClusterDao(clustersMap)
+				cs = NewClusterService(clusterDao)
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "1h")
+			})
+
+			AfterEach(func() {
+				app.GlobalServerConfig.OwnerQuotas = app.OwnerQuotaConfig{}
+			})
+
+			It("Should extend the cluster within the quota of its project and then of its owner", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusterDao.quotas).To(Equal([]models.Quota{
+					{Scope: models.QuotaScopeProject, Name: validProject},
+					{Scope: models.QuotaScopeOwner, Name: "alice", MaxClusterHours: 24, MaxTimeout: "8h"},
+				}))
+			})
+		})
+
+		Context("When expiring the cluster immediately", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "0s")
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should expire the cluster now", func() {
+				Expect(cluster.Expiration).To(BeTemporally("~", time.Now(), time.Minute))
+			})
+		})
+
+		Context("When the expiration exceeds the project maximum lifetime", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "3h")
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal(models.ErrorExceedsMaxLifetime))
+			})
+			It("Should not return a cluster", func() {
+				Expect(cluster).To(BeNil())
+			})
+		})
+
+		Context("When the timeout is invalid", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				clustersMap[cluster1.Id] = cluster1
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "-1h")
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal(models.ErrorInvalidTimeout))
+			})
+		})
+
+		Context("When the cluster is already destroyed", func() {
+			BeforeEach(func() {
+				clustersMap := make(map[string]*models.Cluster)
+				cluster1.Status = models.ClusterStatusDestroyed
+				clustersMap[cluster1.Id] = cluster1
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "1h")
+			})
+			It("Should error", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal(models.ErrorClusterNotLive))
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			BeforeEach(func() {
+				cs = NewClusterService(&MissingClusterDao{NewValidClusterDao(map[string]*models.Cluster{})})
+				cluster, err = cs.UpdateClusterExpiration(validRequestId, cluster1.Id, "1h")
+			})
+			It("Should error", func() {
+				Expect(err).To(MatchError(models.ErrorClusterNotFound))
+			})
+			It("Should not return a cluster", func() {
+				Expect(cluster).To(BeNil())
+			})
+		})
+	})
+
 	// ======================================================================
 	//      _      _      _
 	//   __| | ___| | ___| |_ ___
@@ -285,21 +798,22 @@ var _ = Describe("Cluster", func() {
 	Describe("Deleting a cluster", func() {
 
 		Context("When everything goes ok", func() {
+			var clusterDao *ValidClusterDao
+
 			BeforeEach(func() {
 				clustersMap := make(map[string]*models.Cluster)
 				clustersMap[cluster1UUID] = cluster1
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				terraformClient = new(PassingClient)
-				cluster, err = cs.DeleteCluster(validRequestId, terraformClient, cluster1UUID)
+				clusterDao = NewValidClusterDao(clustersMap)
+				cs = NewClusterService(clusterDao)
+				cluster, err = cs.DeleteCluster(validRequestId, cluster1UUID)
 			})
 			It("Should not error", func() {
 				Expect(err).NotTo(HaveOccurred())
 			})
-			It("Should set the project of the terraform client", func() {
-				Expect(terraformClient.Project()).To(Equal(validProject))
-			})
-			It("Should set the region of the terraform client", func() {
-				Expect(terraformClient.Region()).To(Equal(validRegion))
+			It("Should queue a job to destroy the cluster", func() {
+				Expect(clusterDao.jobs).To(HaveLen(1))
+				Expect(clusterDao.jobs[0].ClusterId).To(Equal(cluster1UUID))
+				Expect(clusterDao.jobs[0].Operation).To(Equal(models.ClusterJobDestroy))
 			})
 			It("Should return the expected cluster", func() {
 				Expect(cluster.Id).To(Equal(cluster1.Id))
@@ -312,9 +826,8 @@ var _ = Describe("Cluster", func() {
 		Context("When it does not exist", func() {
 			BeforeEach(func() {
 				clustersMap := make(map[string]*models.Cluster)
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				client := new(PassingClient)
-				cluster, err = cs.DeleteCluster(validRequestId, client, cluster1.Id)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.DeleteCluster(validRequestId, cluster1.Id)
 			})
 			It("should error", func() {
 				Expect(err).Should(HaveOccurred())
@@ -329,9 +842,8 @@ var _ = Describe("Cluster", func() {
 				clustersMap := make(map[string]*models.Cluster)
 				cluster1.Status = "destroyed"
 				clustersMap[cluster1.Id] = cluster1
-				cs = NewClusterService(NewValidClusterDao(clustersMap), NewMockDB().db)
-				client := new(PassingClient)
-				cluster, err = cs.DeleteCluster(validRequestId, client, cluster1.Id)
+				cs = NewClusterService(NewValidClusterDao(clustersMap))
+				cluster, err = cs.DeleteCluster(validRequestId, cluster1.Id)
 			})
 			It("Should error", func() {
 				Expect(err).To(HaveOccurred())
@@ -341,6 +853,114 @@ var _ = Describe("Cluster", func() {
 			})
 		})
 	})
+
+	Describe("Retrying to destroy a cluster", func() {
+		var clusterDao *ValidClusterDao
+
+		BeforeEach(func() {
+			cluster1.Status = models.ClusterStatusDestroyRetriesExhausted
+			cluster1.DestroyAttempts = 5
+			clusterDao = NewValidClusterDao(map[string]*models.Cluster{cluster1UUID: cluster1})
+			cs = NewClusterService(clusterDao)
+		})
+
+		Context("When retries are exhausted", func() {
+			BeforeEach(func() {
+				cluster, err = cs.RetryDestroyCluster(validRequestId, cluster1UUID)
+			})
+			It("Should queue a job to destroy the cluster", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusterDao.jobs).To(HaveLen(1))
+				Expect(clusterDao.jobs[0].Operation).To(Equal(models.ClusterJobDestroy))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+			It("Should start a new round of retries", func() {
+				Expect(cluster.DestroyAttempts).To(Equal(0))
+			})
+		})
+
+		Context("When the cluster has not failed to be destroyed", func() {
+			It("Should error", func() {
+				cluster1.Status = models.ClusterStatusProvisionSuccess
+				cluster, err = cs.RetryDestroyCluster(validRequestId, cluster1UUID)
+				Expect(err).To(MatchError(models.ErrorClusterNotDestroyFailed))
+				Expect(clusterDao.jobs).To(BeEmpty())
+			})
+		})
+
+		Context("When the cluster does not exist", func() {
+			It("Should error", func() {
+				cluster, err = cs.RetryDestroyCluster(validRequestId, cluster2UUID)
+				Expect(err).To(MatchError(models.ErrorClusterNotFound))
+			})
+		})
+	})
+
+	Describe("Rekeying clusters", func() {
+		Context("When the clusters are rekeyed", func() {
+			It("Should return the number of clusters rekeyed", func() {
+				cs = NewClusterService(NewValidClusterDao(map[string]*models.Cluster{cluster1UUID: cluster1}))
+				rekeyed, err := cs.RekeyClusters(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rekeyed).To(Equal(1))
+			})
+		})
+
+		Context("When encryption is not configured", func() {
+			It("Should error", func() {
+				cs = NewClusterService(NewEmptyClusterDao())
+				_, err = cs.RekeyClusters(validRequestId)
+				Expect(err).To(MatchError(models.ErrorEncryptionNotConfigured))
+			})
+		})
+	})
+
+	Describe("Cancelling a cluster", func() {
+		var clusterDao *ValidClusterDao
+
+		BeforeEach(func() {
+			cluster1.Status = models.ClusterStatusRequested
+			clusterDao = NewValidClusterDao(map[string]*models.Cluster{cluster1UUID: cluster1})
+			cs = NewClusterService(clusterDao)
+		})
+
+		Context("When provisioning has not started", func() {
+			BeforeEach(func() {
+				clusterDao.enqueue(cluster1UUID, models.ClusterJobProvision, validRequestId)
+				cluster, err = cs.CancelCluster(validRequestId, cluster1UUID)
+			})
+			It("Should cancel the job", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusterDao.jobs[0].Status).To(Equal(models.ClusterJobCancelled))
+			})
+			It("Should fail the cluster with nothing to roll back", func() {
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionFailedRollbackSuccess))
+				Expect(cluster.Message).To(Equal(models.ErrorProvisionCancelled))
+			})
+		})
+
+		Context("When provisioning is running", func() {
+			It("Should request the job to be cancelled", func() {
+				clusterDao.enqueue(cluster1UUID, models.ClusterJobProvision, validRequestId)
+				clusterDao.ClaimClusterJob("worker", validRequestId)
+
+				cluster, err = cs.CancelCluster(validRequestId, cluster1UUID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clusterDao.jobs[0].Status).To(Equal(models.ClusterJobRunning))
+				Expect(clusterDao.jobs[0].Cancelled).To(BeTrue())
+			})
+		})
+
+		Context("When no provisioning is in progress", func() {
+			It("Should error", func() {
+				clusterDao.enqueue(cluster1UUID, models.ClusterJobDestroy, validRequestId)
+
+				cluster, err = cs.CancelCluster(validRequestId, cluster1UUID)
+				Expect(err).To(MatchError(models.ErrorClusterJobNotRunning))
+				Expect(cluster).To(BeNil())
+			})
+		})
+	})
 })
 
 func NewMockDB() *MockDB {
@@ -356,10 +976,13 @@ type PassingClient struct {
 	project     string
 	region      string
 	credentials string
+	variables   map[string]interface{}
+	output      io.Writer
+	removed     bool
 }
 
 func (client *PassingClient) ClientInit() error                 { return nil }
-func (client *PassingClient) ClientDestroy() error              { return nil }
+func (client *PassingClient) ClientDestroy() error              { client.removed = true; return nil }
 func (client *PassingClient) Config() []byte                    { return []byte(`json`) }
 func (client *PassingClient) SetConfig(config []byte)           { return }
 func (client *PassingClient) State() []byte                     { return []byte(`json`) }
@@ -371,12 +994,115 @@ func (client *PassingClient) SetRegion(region string)           { client.region
 func (client *PassingClient) Credentials() string               { return client.credentials }
 func (client *PassingClient) SetCredentials(credentials string) { client.credentials = credentials }
 func (client *PassingClient) Init() (string, error)             { return "foo", nil }
-func (client *PassingClient) Plan(destroy bool) (string, error) { return "foo", nil }
+func (client *PassingClient) Plan(destroy bool) (string, error) { return validTerraformPlan, nil }
 func (client *PassingClient) Outputs() (string, error)          { return validTerraformOutputs, nil }
+func (client *PassingClient) SetLog(output io.Writer)           { client.output = output }
+func (client *PassingClient) SetBackend(backend *terraform.HttpBackend) {
+	client.Terraform.Backend = backend
+}
+func (client *PassingClient) SetVariables(variables map[string]interface{}) {
+	client.variables = variables
+}
 func (client *PassingClient) Apply() ([]byte, string, error) {
+	if client.output != nil {
+		fmt.Fprintf(client.output, "$ terraform apply\n%s", terraform.ApplySuccess)
+	}
 	return validTerraformState, terraform.ApplySuccess, nil
 }
 func (client *PassingClient) Destroy() ([]byte, string, error) { return []byte(`json`), "foo", nil }
+func (client *PassingClient) Cancel()                          { return }
+func (client *PassingClient) Abort()                           { return }
+
+/*
+ * Cancellable Client applies, or plans, until it is cancelled
+ */
+type CancellableClient struct {
+	PassingClient
+	started   chan struct{}
+	cancelled chan struct{}
+	once      sync.Once
+}
+
+func NewCancellableClient() *CancellableClient {
+	return &CancellableClient{started: make(chan struct{}), cancelled: make(chan struct{})}
+}
+
+func (client *CancellableClient) Apply() ([]byte, string, error) {
+	close(client.started)
+	<-client.cancelled
+	return []byte(`partial`), "", errors.New(terraform.ErrorApplyCancelled)
+}
+func (client *CancellableClient) Plan(destroy bool) (string, error) {
+	close(client.started)
+	<-client.cancelled
+	return "", errors.New(terraform.ErrorPlanCancelled)
+}
+func (client *CancellableClient) Cancel() {
+	client.once.Do(func() { close(client.cancelled) })
+}
+func (client *CancellableClient) Abort() { client.Cancel() }
+
+/*
+ * Abortable Client destroys until it is aborted, cancelling it does not
+ * interrupt the destroy
+ */
+type AbortableClient struct {
+	PassingClient
+	started chan struct{}
+	aborted chan struct{}
+	once    sync.Once
+}
+
+func NewAbortableClient() *AbortableClient {
+	return &AbortableClient{started: make(chan struct{}), aborted: make(chan struct{})}
+}
+
+func (client *AbortableClient) Destroy() ([]byte, string, error) {
+	close(client.started)
+	<-client.aborted
+	return nil, "", errors.New(terraform.ErrorDestroyAborted)
+}
+func (client *AbortableClient) Abort() {
+	client.once.Do(func() { close(client.aborted) })
+}
+
+/*
+ * Partial Client fails to apply after provisioning some resources, and
+ * records the state it destroys
+ */
+type PartialClient struct {
+	PassingClient
+	state     []byte
+	destroyed []byte
+}
+
+func (client *PartialClient) SetState(state []byte) { client.state = state }
+func (client *PartialClient) Apply() ([]byte, string, error) {
+	return []byte(`partial`), "", errors.New("apply failed")
+}
+func (client *PartialClient) Destroy() ([]byte, string, error) {
+	client.destroyed = client.state
+	return []byte(`{}`), "destroyed", nil
+}
+
+/*
+ * Deleting Client deletes the cluster while it applies
+ */
+type DeletingClient struct {
+	PassingClient
+	delete  func()
+	applied bool
+}
+
+func NewDeletingClient(delete func()) *DeletingClient {
+	return &DeletingClient{delete: delete}
+}
+
+func (client *DeletingClient) Apply() ([]byte, string, error) {
+	client.applied = true
+	client.delete()
+	return client.PassingClient.Apply()
+}
 
 type FailingClient struct{}
 
@@ -391,15 +1117,35 @@ func (client *FailingClient) Plan(destroy bool) (string, error) { return "foo",
 func (client *FailingClient) Outputs() (string, error)          { return "", errors.New("foo") }
 func (client *FailingClient) Apply() ([]byte, string, error)    { return nil, "", errors.New("") }
 func (client *FailingClient) Destroy() ([]byte, string, error)  { return nil, "", errors.New("foo") }
+func (client *FailingClient) Cancel()                           { return }
+func (client *FailingClient) Abort()                            { return }
 func (client *FailingClient) Project() string                   { return "" }
 func (client *FailingClient) SetProject(project string)         { return }
 func (client *FailingClient) Region() string                    { return "" }
 func (client *FailingClient) SetRegion(region string)           { return }
 func (client *FailingClient) Credentials() string               { return "" }
 func (client *FailingClient) SetCredentials(credentials string) { return }
+func (client *FailingClient) SetLog(output io.Writer)           { return }
+func (client *FailingClient) SetVariables(variables map[string]interface{}) {
+	return
+}
+func (client *FailingClient) SetBackend(backend *terraform.HttpBackend) { return }
 
 type ValidClusterDao struct {
-	clustersMap map[string]*models.Cluster
+	clustersMap     map[string]*models.Cluster
+	logs            []models.ClusterLog
+	logsMutex       sync.Mutex
+	idempotencyKeys map[string]*models.IdempotencyKey
+	spec            *models.ClusterSpec
+	quotas          []models.Quota
+	jobs            []models.ClusterJob
+	jobsMutex       sync.Mutex
+	locked          map[string]bool
+	webhooks        map[string][]string
+	history         []models.ClusterHistoryEvent
+
+	// Returned by every heartbeat when set, as if the database were gone
+	heartbeatErr error
 }
 
 func NewValidClusterDao(cm map[string]*models.Cluster) *ValidClusterDao {
@@ -408,23 +1154,266 @@ func NewValidClusterDao(cm map[string]*models.Cluster) *ValidClusterDao {
 	}
 }
 
-func (dao *ValidClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string, requestId string, project string, region string) (*models.Cluster, error) {
+func (dao *ValidClusterDao) CreateCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error) {
+	dao.spec = spec
 	uuid := uuid.Must(uuid.NewV4()).String()
+	if len(spec.IdempotencyKey) > 0 {
+		if dao.idempotencyKeys == nil {
+			dao.idempotencyKeys = make(map[string]*models.IdempotencyKey)
+		}
+		dao.idempotencyKeys[spec.Owner+"/"+spec.IdempotencyKey] = &models.IdempotencyKey{Owner: spec.Owner, Key: spec.IdempotencyKey, RequestHash: spec.RequestHash, ClusterId: uuid, Timestamp: time.Now()}
+	}
 	dao.clustersMap[uuid] = &models.Cluster{
 		Id:              uuid,
 		Name:            "cluster",
-		Status:          "status",
-		TerraformConfig: config,
+		Status:          models.ClusterStatusRequested,
+		TerraformConfig: spec.TerraformConfig,
+		Project:         spec.Project,
+		Region:          spec.Region,
 	}
+	dao.enqueue(uuid, models.ClusterJobProvision, requestId)
 	return dao.clustersMap[uuid], nil
 }
 
-func (dao *ValidClusterDao) UpdateClusterField(db *sqlx.DB, id string, field string, value interface{}, requestId string) error {
+func (dao *ValidClusterDao) EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error) {
+	if cluster, ok := dao.clustersMap[id]; ok {
+		cluster.Status = status
+	}
+	return dao.enqueue(id, operation, requestId), nil
+}
+
+func (dao *ValidClusterDao) enqueue(id string, operation string, requestId string) *models.ClusterJob {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	job := models.ClusterJob{Id: int64(len(dao.jobs) + 1), ClusterId: id, Operation: operation, Status: models.ClusterJobQueued, RequestId: requestId, Heartbeat: time.Now()}
+	dao.jobs = append(dao.jobs, job)
+	return &job
+}
+
+func (dao *ValidClusterDao) ClaimClusterJob(worker string, requestId string) (*models.ClusterJob, error) {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	for i := range dao.jobs {
+		if dao.jobs[i].Status == models.ClusterJobQueued {
+			dao.jobs[i].Status = models.ClusterJobRunning
+			dao.jobs[i].Worker = worker
+			dao.jobs[i].Heartbeat = time.Now()
+			job := dao.jobs[i]
+			return &job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dao *ValidClusterDao) GetClusterJob(id int64, requestId string) (*models.ClusterJob, error) {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	if id < 1 || id > int64(len(dao.jobs)) {
+		return nil, nil
+	}
+	job := dao.jobs[id-1]
+	return &job, nil
+}
+
+func (dao *ValidClusterDao) HeartbeatClusterJob(job *models.ClusterJob, requestId string) error {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	if dao.heartbeatErr != nil {
+		return dao.heartbeatErr
+	}
+	if dao.jobs[job.Id-1].Status != models.ClusterJobRunning || dao.jobs[job.Id-1].Worker != job.Worker {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+	dao.jobs[job.Id-1].Heartbeat = time.Now()
+	job.Cancelled = dao.jobs[job.Id-1].Cancelled
+	return nil
+}
+
+func (dao *ValidClusterDao) LockCluster(id string, requestId string) (func(), error) {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	if dao.locked == nil {
+		dao.locked = make(map[string]bool)
+	}
+	dao.locked[id] = true
+	return func() {
+		dao.jobsMutex.Lock()
+		defer dao.jobsMutex.Unlock()
+		delete(dao.locked, id)
+	}, nil
+}
+
+func (dao *ValidClusterDao) GetClusterWebhooks(clusterId string, requestId string) ([]string, error) {
+	return dao.webhooks[clusterId], nil
+}
+
+func (dao *ValidClusterDao) RekeyClusters(requestId string) (int, error) {
+	return len(dao.clustersMap), nil
+}
+
+func (dao *ValidClusterDao) LockClusterState(id string, lock *models.StateLock, requestId string) (*models.StateLock, error) {
+	current, err := models.ParseStateLock(dao.clustersMap[id].StateLock)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID != lock.ID {
+		return current, errors.New(models.ErrorClusterStateLocked)
+	}
+	value, _ := json.Marshal(lock)
+	dao.clustersMap[id].StateLock = string(value)
+	return lock, nil
+}
+
+func (dao *ValidClusterDao) UnlockClusterState(id string, lockId string, requestId string) (*models.StateLock, error) {
+	current, err := models.ParseStateLock(dao.clustersMap[id].StateLock)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID != lockId {
+		return current, errors.New(models.ErrorClusterStateLocked)
+	}
+	dao.clustersMap[id].StateLock = ""
+	return nil, nil
+}
+
+func (dao *ValidClusterDao) UpdateClusterState(id string, lockId string, state []byte, requestId string) error {
+	dao.clustersMap[id].TerraformState = state
+	return nil
+}
+
+func (dao *ValidClusterDao) CancelClusterJob(id string, requestId string) (*models.ClusterJob, error) {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	for i := range dao.jobs {
+		job := &dao.jobs[i]
+		if job.ClusterId != id || job.Operation != models.ClusterJobProvision {
+			continue
+		}
+		switch job.Status {
+		case models.ClusterJobQueued:
+			job.Status = models.ClusterJobCancelled
+			dao.clustersMap[id].Status = models.ClusterStatusProvisionFailedRollbackSuccess
+			dao.clustersMap[id].Message = models.ErrorProvisionCancelled
+			return job, nil
+		case models.ClusterJobRunning:
+			job.Cancelled = true
+			return job, nil
+		}
+	}
+	return nil, errors.New(models.ErrorClusterJobNotRunning)
+}
+
+func (dao *ValidClusterDao) FinishClusterJob(job *models.ClusterJob, requestId string) error {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	if dao.jobs[job.Id-1].Status != models.ClusterJobRunning || dao.jobs[job.Id-1].Worker != job.Worker {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+	dao.jobs[job.Id-1] = *job
+	return nil
+}
+
+func (dao *ValidClusterDao) AbandonClusterJobs(before time.Time, requestId string) ([]models.ClusterJob, error) {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	abandoned := []models.ClusterJob{}
+	for i := range dao.jobs {
+		if dao.jobs[i].Status == models.ClusterJobRunning && dao.jobs[i].Heartbeat.Before(before) {
+			dao.jobs[i].Status = models.ClusterJobFailed
+			dao.jobs[i].Message = models.ErrorJobAbandoned
+			abandoned = append(abandoned, dao.jobs[i])
+		}
+	}
+	return abandoned, nil
+}
+
+func (dao *ValidClusterDao) GetDestroyRetryClusters(before time.Time, requestId string) ([]models.Cluster, error) {
+	clusters := []models.Cluster{}
+	for _, cluster := range dao.clustersMap {
+		if cluster.Status == models.ClusterStatusDestroyFailed && !cluster.NextDestroyAttempt.After(before) {
+			clusters = append(clusters, *cluster)
+		}
+	}
+	return clusters, nil
+}
+
+func (dao *ValidClusterDao) GetOrphanedClusters(requestId string) ([]models.Cluster, error) {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	clusters := []models.Cluster{}
+	for _, cluster := range dao.clustersMap {
+		orphaned := cluster.Status == models.ClusterStatusRequested || cluster.Status == models.ClusterStatusProvisionStart || cluster.Status == models.ClusterStatusDestroying
+		for _, job := range dao.jobs {
+			if job.ClusterId == cluster.Id && (job.Status == models.ClusterJobQueued || job.Status == models.ClusterJobRunning) {
+				orphaned = false
+			}
+		}
+		if orphaned {
+			clusters = append(clusters, *cluster)
+		}
+	}
+	return clusters, nil
+}
+
+func (dao *ValidClusterDao) AppendClusterLog(id string, operation string, lines []string, requestId string) error {
+	dao.logsMutex.Lock()
+	defer dao.logsMutex.Unlock()
+	for _, line := range lines {
+		dao.logs = append(dao.logs, models.ClusterLog{Id: int64(len(dao.logs) + 1), ClusterId: id, Operation: operation, Line: line})
+	}
+	return nil
+}
+
+func (dao *ValidClusterDao) GetClusterLogs(id string, operation string, after int64, requestId string) ([]models.ClusterLog, error) {
+	dao.logsMutex.Lock()
+	defer dao.logsMutex.Unlock()
+	logs := []models.ClusterLog{}
+	for _, line := range dao.logs {
+		if line.ClusterId == id && line.Id > after && (len(operation) == 0 || line.Operation == operation) {
+			logs = append(logs, line)
+		}
+	}
+	return logs, nil
+}
+
+func (dao *ValidClusterDao) CreateClusterHistoryEvent(event *models.ClusterHistoryEvent, requestId string) error {
+	event.Id = int64(len(dao.history) + 1)
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	dao.history = append(dao.history, *event)
+	return nil
+}
+
+func (dao *ValidClusterDao) GetClusterHistory(id string, requestId string) ([]models.ClusterHistoryEvent, error) {
+	events := []models.ClusterHistoryEvent{}
+	for _, event := range dao.history {
+		if event.ClusterId == id {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (dao *ValidClusterDao) GetIdempotencyKey(owner string, key string, requestId string) (*models.IdempotencyKey, error) {
+	return dao.idempotencyKeys[owner+"/"+key], nil
+}
+
+func (dao *ValidClusterDao) DeleteIdempotencyKeys(before time.Time, requestId string) error {
+	for key, idempotencyKey := range dao.idempotencyKeys {
+		if idempotencyKey.Timestamp.Before(before) {
+			delete(dao.idempotencyKeys, key)
+		}
+	}
+	return nil
+}
+
+func (dao *ValidClusterDao) UpdateClusterField(id string, field string, value interface{}, requestId string) error {
 	cluster := &models.Cluster{}
 	cluster = dao.clustersMap[id]
 	switch field {
 	case "status":
-		cluster.Status = value.(string)
+		return errors.New("cannot update status field, use UpdateClusterStatus")
 	case "message":
 		cluster.Message = value.(string)
 	case "outputs":
@@ -433,24 +1422,68 @@ func (dao *ValidClusterDao) UpdateClusterField(db *sqlx.DB, id string, field str
 		cluster.TerraformConfig = value.([]byte)
 	case "terraform_state":
 		cluster.TerraformState = value.([]byte)
+	case "expiration":
+		cluster.Expiration = value.(time.Time)
+		cluster.ExpirationWarnings = nil
+	case "destroy_attempts":
+		cluster.DestroyAttempts = value.(int)
+	case "next_destroy_attempt":
+		cluster.NextDestroyAttempt = value.(time.Time)
 	}
 	dao.clustersMap[id] = cluster
 	return nil
 }
 
-func (dao *ValidClusterDao) GetCluster(db *sqlx.DB, id string, requestId string) (*models.Cluster, error) {
+func (dao *ValidClusterDao) UpdateClusterExpiration(id string, expiration time.Time, quotas []models.Quota, requestId string) error {
+	dao.quotas = quotas
+	return dao.UpdateClusterField(id, "expiration", expiration, requestId)
+}
+
+func (dao *ValidClusterDao) UpdateClusterStatus(id string, from string, to string, requestId string) error {
+	if !models.ClusterStatusTransitionAllowed(from, to) {
+		return errors.New(models.ErrorInvalidStatusTransition)
+	}
+	cluster, ok := dao.clustersMap[id]
+	if !ok || cluster.Status != from {
+		return errors.New(models.ErrorClusterStatusChanged)
+	}
+	cluster.Status = to
+	return nil
+}
+
+func (dao *ValidClusterDao) claimed(job *models.ClusterJob) bool {
+	dao.jobsMutex.Lock()
+	defer dao.jobsMutex.Unlock()
+	return dao.jobs[job.Id-1].Status == models.ClusterJobRunning && dao.jobs[job.Id-1].Worker == job.Worker
+}
+
+func (dao *ValidClusterDao) UpdateJobClusterStatus(job *models.ClusterJob, from string, to string, requestId string) error {
+	if !dao.claimed(job) {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+	return dao.UpdateClusterStatus(job.ClusterId, from, to, requestId)
+}
+
+func (dao *ValidClusterDao) UpdateJobClusterField(job *models.ClusterJob, field string, value interface{}, requestId string) error {
+	if !dao.claimed(job) {
+		return errors.New(models.ErrorClusterJobClaimLost)
+	}
+	return dao.UpdateClusterField(job.ClusterId, field, value, requestId)
+}
+
+func (dao *ValidClusterDao) GetCluster(id string, requestId string) (*models.Cluster, error) {
 	return dao.clustersMap[id], nil
 }
 
-func (dao *ValidClusterDao) GetClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error) {
+func (dao *ValidClusterDao) GetClusters(filter *models.ClusterFilter, requestId string) ([]models.Cluster, string, error) {
 	clusters := []models.Cluster{}
 	for _, cluster := range dao.clustersMap {
 		clusters = append(clusters, *cluster)
 	}
-	return clusters, nil
+	return clusters, "", nil
 }
 
-func (dao *ValidClusterDao) GetExpiredClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error) {
+func (dao *ValidClusterDao) GetExpiredClusters(requestId string) ([]models.Cluster, error) {
 	clusters := []models.Cluster{}
 	for _, cluster := range dao.clustersMap {
 		clusters = append(clusters, *cluster)
@@ -458,7 +1491,7 @@ func (dao *ValidClusterDao) GetExpiredClusters(db *sqlx.DB, requestId string) ([
 	return clusters, nil
 }
 
-func (dao *ValidClusterDao) DeleteCluster(db *sqlx.DB, id string, requestId string) (*models.Cluster, error) {
+func (dao *ValidClusterDao) DeleteCluster(id string, requestId string) (*models.Cluster, error) {
 	if _, ok := dao.clustersMap[id]; !ok {
 		return nil, errors.New("foo")
 	} else {
@@ -467,6 +1500,17 @@ func (dao *ValidClusterDao) DeleteCluster(db *sqlx.DB, id string, requestId stri
 	}
 }
 
+/*
+ * Missing Cluster Dao finds no cluster, as the cluster stores do
+ */
+type MissingClusterDao struct {
+	*ValidClusterDao
+}
+
+func (dao *MissingClusterDao) GetCluster(id string, requestId string) (*models.Cluster, error) {
+	return nil, sql.ErrNoRows
+}
+
 type EmptyClusterDao struct {
 	clustersMap map[string]*models.Cluster
 }
@@ -475,28 +1519,80 @@ func NewEmptyClusterDao() *EmptyClusterDao {
 	return &EmptyClusterDao{}
 }
 
-func (dao *EmptyClusterDao) CreateCluster(db *sqlx.DB, config []byte, timeout string, requestId string, project string, region string) (*models.Cluster, error) {
+func (dao *EmptyClusterDao) CreateCluster(spec *models.ClusterSpec, requestId string) (*models.Cluster, error) {
+	return nil, errors.New("foo")
+}
+
+func (dao *EmptyClusterDao) UpdateClusterField(id string, field string, value interface{}, requestId string) error {
+	return nil
+}
+
+func (dao *EmptyClusterDao) UpdateClusterExpiration(id string, expiration time.Time, quotas []models.Quota, requestId string) error {
+	return nil
+}
+
+func (dao *EmptyClusterDao) UpdateClusterStatus(id string, from string, to string, requestId string) error {
+	return nil
+}
+
+func (dao *EmptyClusterDao) UpdateJobClusterStatus(job *models.ClusterJob, from string, to string, requestId string) error {
+	return nil
+}
+
+func (dao *EmptyClusterDao) UpdateJobClusterField(job *models.ClusterJob, field string, value interface{}, requestId string) error {
+	return nil
+}
+
+func (dao *EmptyClusterDao) CreateClusterHistoryEvent(event *models.ClusterHistoryEvent, requestId string) error {
+	return errors.New("foo")
+}
+
+func (dao *EmptyClusterDao) GetClusterHistory(id string, requestId string) ([]models.ClusterHistoryEvent, error) {
 	return nil, errors.New("foo")
 }
 
-func (dao *EmptyClusterDao) UpdateClusterField(db *sqlx.DB, id string, field string, value interface{}, requestId string) error {
+func (dao *EmptyClusterDao) GetIdempotencyKey(owner string, key string, requestId string) (*models.IdempotencyKey, error) {
+	return nil, nil
+}
+
+func (dao *EmptyClusterDao) RekeyClusters(requestId string) (int, error) {
+	return 0, errors.New(models.ErrorEncryptionNotConfigured)
+}
+
+func (dao *EmptyClusterDao) CancelClusterJob(id string, requestId string) (*models.ClusterJob, error) {
+	return nil, errors.New(models.ErrorClusterJobNotRunning)
+}
+
+func (dao *EmptyClusterDao) EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error) {
+	return nil, errors.New("foo")
+}
+
+func (dao *EmptyClusterDao) DeleteIdempotencyKeys(before time.Time, requestId string) error {
 	return nil
 }
 
-func (dao *EmptyClusterDao) GetCluster(db *sqlx.DB, id string, requestId string) (*models.Cluster, error) {
+func (dao *EmptyClusterDao) AppendClusterLog(id string, operation string, lines []string, requestId string) error {
+	return nil
+}
+
+func (dao *EmptyClusterDao) GetClusterLogs(id string, operation string, after int64, requestId string) ([]models.ClusterLog, error) {
 	return nil, errors.New("foo")
 }
 
-func (dao *EmptyClusterDao) GetClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error) {
+func (dao *EmptyClusterDao) GetCluster(id string, requestId string) (*models.Cluster, error) {
+	return nil, errors.New("foo")
+}
+
+func (dao *EmptyClusterDao) GetClusters(filter *models.ClusterFilter, requestId string) ([]models.Cluster, string, error) {
 	clusters := []models.Cluster{}
-	return clusters, nil
+	return clusters, "", nil
 }
 
-func (dao *EmptyClusterDao) GetExpiredClusters(db *sqlx.DB, requestId string) ([]models.Cluster, error) {
+func (dao *EmptyClusterDao) GetExpiredClusters(requestId string) ([]models.Cluster, error) {
 	clusters := []models.Cluster{}
 	return clusters, nil
 }
 
-func (dao *EmptyClusterDao) DeleteCluster(db *sqlx.DB, id string, requestId string) (*models.Cluster, error) {
+func (dao *EmptyClusterDao) DeleteCluster(id string, requestId string) (*models.Cluster, error) {
 	return nil, errors.New("foo")
 }
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Number of events buffered for each subscriber before further
+// events are dropped for that subscriber
+const clusterEventBuffer = 64
+
+// Broker fanning out cluster status and message changes to subscribers
+type ClusterEventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[chan models.ClusterEvent]string
+}
+
+// Events from every ClusterService are published to this broker unless
+// a service is given a different one
+var GlobalClusterEvents = NewClusterEventBroker()
+
+func NewClusterEventBroker() *ClusterEventBroker {
+	return &ClusterEventBroker{
+		subscribers: make(map[chan models.ClusterEvent]string),
+	}
+}
+
+// Subscribe to the events of the cluster with the given id, or of all
+// clusters when the id is empty. The returned function unsubscribes and
+// closes the channel.
+func (b *ClusterEventBroker) Subscribe(id string) (<-chan models.ClusterEvent, func()) {
+	events := make(chan models.ClusterEvent, clusterEventBuffer)
+
+	b.mutex.Lock()
+	b.subscribers[events] = id
+	b.mutex.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, events)
+			b.mutex.Unlock()
+			close(events)
+		})
+	}
+
+	return events, unsubscribe
+}
+
+// Publishing never blocks the caller, a subscriber that is not keeping up
+// misses events rather than holding up provisioning
+func (b *ClusterEventBroker) Publish(event models.ClusterEvent) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "publish_cluster_event", "request": event.ClusterId})
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for events, id := range b.subscribers {
+		if len(id) > 0 && id != event.ClusterId {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+			logger.Warn(fmt.Sprintf("dropped event for slow subscriber of cluster '%v'", event.ClusterId))
+		}
+	}
+}
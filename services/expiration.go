@@ -0,0 +1,265 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type expirationDao interface {
+	GetExpiringClusters(before time.Time, requestId string) ([]models.Cluster, error)
+	RecordExpirationWarnings(cluster *models.Cluster, requestId string) error
+}
+
+// Elects a single instance among the instances sharing the database
+type leader interface {
+	IsLeader(requestId string) (bool, error)
+}
+
+// Notifies the owner of a cluster of an expiration warning
+type ExpirationWarningSink interface {
+	Notify(warning *models.ExpirationWarning, request_id string) error
+}
+
+// Warns the owners of clusters about to expire, once for every configured
+// threshold ahead of the expiration. Only the instance elected leader
+// sends warnings.
+type ExpirationWarningService struct {
+	dao        expirationDao
+	sinks      []ExpirationWarningSink
+	leader     leader
+	thresholds []time.Duration
+	interval   time.Duration
+	baseUrl    string
+}
+
+func NewExpirationWarningService(dao expirationDao, config app.ExpirationWarningConfig, sinks []ExpirationWarningSink, leader leader) (*ExpirationWarningService, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "new_expiration_warning_service", "request": nil})
+
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil || interval <= 0 {
+		err := fmt.Errorf("invalid expiration warning interval '%v'", config.Interval)
+		logger.Error(err)
+		return nil, err
+	}
+
+	thresholds := []time.Duration{}
+	for _, threshold := range config.Thresholds {
+		duration, err := time.ParseDuration(threshold)
+		if err != nil || duration <= 0 {
+			err := fmt.Errorf("invalid expiration warning threshold '%v'", threshold)
+			logger.Error(err)
+			return nil, err
+		}
+		thresholds = append(thresholds, duration)
+	}
+
+	// Longest first, the order warnings fall due in
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+
+	return &ExpirationWarningService{
+		dao:        dao,
+		sinks:      sinks,
+		leader:     leader,
+		thresholds: thresholds,
+		interval:   interval,
+		baseUrl:    strings.TrimSuffix(config.BaseURL, "/"),
+	}, nil
+}
+
+// Warn about expiring clusters every interval
+func (s *ExpirationWarningService) StartWarning() {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "expiration_warning", "request": nil})
+
+	if len(s.thresholds) == 0 {
+		logger.Info("no expiration warning thresholds are configured, owners are not warned")
+		return
+	}
+
+	go func() {
+		for _ = range time.NewTicker(s.interval).C {
+			if err := s.WarnExpiringClusters(uuid.Must(uuid.NewRandom()).String()); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+}
+
+// Notify every sink of the warnings which have fallen due and record them
+// on their cluster. When several warnings of a cluster fall due at once,
+// only the closest to the expiration is sent.
+func (s *ExpirationWarningService) WarnExpiringClusters(request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "warn_expiring_clusters", "request": request_id})
+
+	if len(s.thresholds) == 0 {
+		return nil
+	}
+
+	leading, err := s.leader.IsLeader(request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if !leading {
+		return nil
+	}
+
+	now := time.Now()
+	clusters, err := s.dao.GetExpiringClusters(now.Add(s.thresholds[0]), request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+
+		due := s.dueWarnings(cluster, now)
+		if len(due) == 0 {
+			continue
+		}
+
+		warning := s.newWarning(cluster, due[len(due)-1])
+		if !s.notify(warning, request_id) {
+			continue
+		}
+
+		cluster.ExpirationWarnings = append(cluster.ExpirationWarnings, due...)
+		if err := s.dao.RecordExpirationWarnings(cluster, request_id); err != nil {
+			logger.Error(fmt.Sprintf("failed to record warnings of cluster '%v': %v", cluster.Id, err))
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("warned owner '%v' that cluster '%v' expires within %v", cluster.Owner, cluster.Id, warning.Threshold))
+	}
+
+	return nil
+}
+
+// Thresholds the cluster has come within but not yet been warned at
+func (s *ExpirationWarningService) dueWarnings(cluster *models.Cluster, now time.Time) []string {
+	due := []string{}
+	for _, threshold := range s.thresholds {
+		if cluster.Expiration.Sub(now) > threshold {
+			continue
+		}
+
+		warned := false
+		for _, sent := range cluster.ExpirationWarnings {
+			warned = warned || sent == threshold.String()
+		}
+		if !warned {
+			due = append(due, threshold.String())
+		}
+	}
+	return due
+}
+
+func (s *ExpirationWarningService) newWarning(cluster *models.Cluster, threshold string) *models.ExpirationWarning {
+	return &models.ExpirationWarning{
+		ClusterId:  cluster.Id,
+		Name:       cluster.Name,
+		Owner:      cluster.Owner,
+		Project:    cluster.Project,
+		Expiration: cluster.Expiration,
+		Threshold:  threshold,
+		ExtendUrl:  fmt.Sprintf("%v/cluster/%v/expiration", s.baseUrl, cluster.Id),
+	}
+}
+
+// Notify every sink of the warning. The warning is considered sent unless
+// every sink failed, so it is tried again rather than repeated.
+func (s *ExpirationWarningService) notify(warning *models.ExpirationWarning, request_id string) bool {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "notify_expiration_warning", "request": request_id})
+
+	sent := false
+	for _, sink := range s.sinks {
+		if err := sink.Notify(warning, request_id); err != nil {
+			logger.Error(fmt.Sprintf("failed to warn about cluster '%v': %v", warning.ClusterId, err))
+			continue
+		}
+		sent = true
+	}
+
+	return sent
+}
+
+// The sinks named by the configuration
+func NewExpirationWarningSinks(config app.ExpirationWarningConfig, webhooks *WebhookService) ([]ExpirationWarningSink, error) {
+	sinks := []ExpirationWarningSink{}
+	for _, name := range config.Sinks {
+		switch name {
+		case models.ExpirationWarningSinkLog:
+			sinks = append(sinks, &LogExpirationWarningSink{})
+		case models.ExpirationWarningSinkWebhook:
+			sinks = append(sinks, webhooks)
+		case models.ExpirationWarningSinkSmtp:
+			sink, err := NewSmtpExpirationWarningSink(config.SMTP)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("%v '%v'", models.ErrorUnknownExpirationWarningSink, name)
+		}
+	}
+	return sinks, nil
+}
+
+// Logs expiration warnings
+type LogExpirationWarningSink struct{}
+
+func (sink *LogExpirationWarningSink) Notify(warning *models.ExpirationWarning, request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "expiration_warning", "request": request_id})
+	logger.Warn(fmt.Sprintf("owner '%v': %v", warning.Owner, warning))
+	return nil
+}
+
+// Mails expiration warnings to the owner of the cluster
+type SmtpExpirationWarningSink struct {
+	config app.SMTPConfig
+}
+
+func NewSmtpExpirationWarningSink(config app.SMTPConfig) (*SmtpExpirationWarningSink, error) {
+	if len(config.Host) == 0 || len(config.From) == 0 {
+		return nil, errors.New("the smtp expiration warning sink requires a host and a from address")
+	}
+	return &SmtpExpirationWarningSink{config}, nil
+}
+
+func (sink *SmtpExpirationWarningSink) Notify(warning *models.ExpirationWarning, request_id string) error {
+	to, err := sink.Recipient(warning.Owner)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if len(sink.config.Username) > 0 {
+		auth = smtp.PlainAuth("", sink.config.Username, sink.config.Password, sink.config.Host)
+	}
+
+	message := fmt.Sprintf("To: %v\r\nFrom: %v\r\nSubject: Cluster '%v' expires within %v\r\n\r\n%v\r\n",
+		to, sink.config.From, warning.Name, warning.Threshold, warning)
+
+	return smtp.SendMail(fmt.Sprintf("%v:%v", sink.config.Host, sink.config.Port), auth, sink.config.From, []string{to}, []byte(message))
+}
+
+// Email address of the owner, either the owner itself or the owner at the
+// configured domain
+func (sink *SmtpExpirationWarningSink) Recipient(owner string) (string, error) {
+	switch {
+	case strings.Contains(owner, "@"):
+		return owner, nil
+	case len(owner) > 0 && len(sink.config.Domain) > 0:
+		return owner + "@" + sink.config.Domain, nil
+	}
+	return "", fmt.Errorf("%v: '%v'", models.ErrorOwnerWithoutEmail, owner)
+}
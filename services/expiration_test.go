@@ -0,0 +1,195 @@
+package services_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Expiration", func() {
+
+	var (
+		es             *ExpirationWarningService
+		dao            *MemoryExpirationDao
+		sink           *RecordingExpirationWarningSink
+		leader         *MockLeader
+		cluster        *models.Cluster
+		validConfig    app.ExpirationWarningConfig
+		validRequestId string
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		validConfig = app.ExpirationWarningConfig{Thresholds: []string{"10m", "1h"}, Interval: "1m", BaseURL: "https://taos.example.com/"}
+
+		cluster = &models.Cluster{
+			Id:         "a19e2758-0ec5-11e8-ba89-0ed5f89f718b",
+			Name:       "cluster",
+			Owner:      "alice",
+			Status:     models.ClusterStatusProvisionSuccess,
+			Expiration: time.Now().Add(30 * time.Minute),
+		}
+
+		dao = &MemoryExpirationDao{clusters: []*models.Cluster{cluster}}
+		sink = &RecordingExpirationWarningSink{}
+		leader = &MockLeader{leading: true}
+		es, err = NewExpirationWarningService(dao, validConfig, []ExpirationWarningSink{sink}, leader)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Creating an expiration warning service", func() {
+		Context("When a threshold is invalid", func() {
+			It("Should error", func() {
+				validConfig.Thresholds = []string{"soon"}
+				_, err = NewExpirationWarningService(dao, validConfig, nil, leader)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("When a sink is unknown", func() {
+			It("Should error", func() {
+				validConfig.Sinks = []string{"pager"}
+				_, err = NewExpirationWarningSinks(validConfig, nil)
+				Expect(err).To(MatchError(ContainSubstring(models.ErrorUnknownExpirationWarningSink)))
+			})
+		})
+	})
+
+	Describe("Warning about expiring clusters", func() {
+		Context("When a warning has fallen due", func() {
+			BeforeEach(func() {
+				err = es.WarnExpiringClusters(validRequestId)
+			})
+			It("Should warn the owner", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sink.warnings).To(HaveLen(1))
+				Expect(sink.warnings[0].Owner).To(Equal("alice"))
+				Expect(sink.warnings[0].Threshold).To(Equal("1h0m0s"))
+			})
+			It("Should link to extending the cluster", func() {
+				Expect(sink.warnings[0].ExtendUrl).To(Equal("https://taos.example.com/cluster/a19e2758-0ec5-11e8-ba89-0ed5f89f718b/expiration"))
+			})
+			It("Should record the warning on the cluster", func() {
+				Expect([]string(cluster.ExpirationWarnings)).To(Equal([]string{"1h0m0s"}))
+			})
+			It("Should not repeat the warning", func() {
+				Expect(es.WarnExpiringClusters(validRequestId)).To(Succeed())
+				Expect(sink.warnings).To(HaveLen(1))
+			})
+		})
+
+		Context("When several warnings fall due at once", func() {
+			BeforeEach(func() {
+				cluster.Expiration = time.Now().Add(5 * time.Minute)
+				err = es.WarnExpiringClusters(validRequestId)
+			})
+			It("Should send only the closest warning and record every one", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sink.warnings).To(HaveLen(1))
+				Expect(sink.warnings[0].Threshold).To(Equal("10m0s"))
+				Expect([]string(cluster.ExpirationWarnings)).To(ConsistOf("1h0m0s", "10m0s"))
+			})
+		})
+
+		Context("When no warning has fallen due", func() {
+			It("Should not warn the owner", func() {
+				cluster.Expiration = time.Now().Add(2 * time.Hour)
+				Expect(es.WarnExpiringClusters(validRequestId)).To(Succeed())
+				Expect(sink.warnings).To(BeEmpty())
+			})
+		})
+
+		Context("When every sink fails", func() {
+			It("Should not record the warning so it is sent again", func() {
+				sink.err = errors.New("mail server unavailable")
+				Expect(es.WarnExpiringClusters(validRequestId)).To(Succeed())
+				Expect(cluster.ExpirationWarnings).To(BeEmpty())
+			})
+		})
+
+		Context("When another instance is the leader", func() {
+			It("Should not warn the owner", func() {
+				leader.leading = false
+				Expect(es.WarnExpiringClusters(validRequestId)).To(Succeed())
+				Expect(sink.warnings).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Mailing warnings", func() {
+		Context("When the owner is not an email address", func() {
+			It("Should mail the owner at the configured domain", func() {
+				smtp_sink, err := NewSmtpExpirationWarningSink(app.SMTPConfig{Host: "localhost", From: "taos@example.com", Domain: "example.com"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(smtp_sink.Recipient("alice")).To(Equal("alice@example.com"))
+				Expect(smtp_sink.Recipient("bob@example.org")).To(Equal("bob@example.org"))
+			})
+		})
+
+		Context("When no domain is configured", func() {
+			It("Should error", func() {
+				smtp_sink, err := NewSmtpExpirationWarningSink(app.SMTPConfig{Host: "localhost", From: "taos@example.com"})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = smtp_sink.Recipient("alice")
+				Expect(err).To(MatchError(ContainSubstring(models.ErrorOwnerWithoutEmail)))
+			})
+		})
+	})
+})
+
+/*
+ * Memory Expiration Dao returns the clusters expiring by before
+ */
+type MemoryExpirationDao struct {
+	clusters []*models.Cluster
+}
+
+func (dao *MemoryExpirationDao) GetExpiringClusters(before time.Time, requestId string) ([]models.Cluster, error) {
+	clusters := []models.Cluster{}
+	for _, cluster := range dao.clusters {
+		if !cluster.Expiration.After(before) {
+			clusters = append(clusters, *cluster)
+		}
+	}
+	return clusters, nil
+}
+
+func (dao *MemoryExpirationDao) RecordExpirationWarnings(cluster *models.Cluster, requestId string) error {
+	for _, recorded := range dao.clusters {
+		if recorded.Id == cluster.Id && recorded.Expiration.Equal(cluster.Expiration) {
+			recorded.ExpirationWarnings = cluster.ExpirationWarnings
+		}
+	}
+	return nil
+}
+
+type RecordingExpirationWarningSink struct {
+	warnings []models.ExpirationWarning
+	err      error
+}
+
+func (sink *RecordingExpirationWarningSink) Notify(warning *models.ExpirationWarning, request_id string) error {
+	if sink.err != nil {
+		return sink.err
+	}
+	sink.warnings = append(sink.warnings, *warning)
+	return nil
+}
+
+type MockLeader struct {
+	leading bool
+}
+
+func (leader *MockLeader) IsLeader(requestId string) (bool, error) {
+	return leader.leading, nil
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type jobDao interface {
+	EnqueueClusterJob(id string, operation string, status string, requestId string) (*models.ClusterJob, error)
+	ClaimClusterJob(worker string, requestId string) (*models.ClusterJob, error)
+	GetClusterJob(id int64, requestId string) (*models.ClusterJob, error)
+	HeartbeatClusterJob(job *models.ClusterJob, requestId string) error
+	FinishClusterJob(job *models.ClusterJob, requestId string) error
+	LockCluster(id string, requestId string) (func(), error)
+	UnlockClusterState(id string, lockId string, requestId string) (*models.StateLock, error)
+	AbandonClusterJobs(before time.Time, requestId string) ([]models.ClusterJob, error)
+	GetOrphanedClusters(requestId string) ([]models.Cluster, error)
+	GetDestroyRetryClusters(before time.Time, requestId string) ([]models.Cluster, error)
+}
+
+// Runs queued terraform operations on clusters with a fixed number of
+// workers. Jobs are persisted when they are queued, and running jobs are
+// kept alive by their worker, so operations left behind by a restart are
+// found and reconciled. Any number of instances may share the queue.
+type JobService struct {
+	dao        jobDao
+	clusters   *ClusterService
+	worker     string
+	workers    int
+	interval   time.Duration
+	staleAfter time.Duration
+	newClient  func() TerraformClient
+}
+
+func NewJobService(dao jobDao, clusters *ClusterService, config app.JobConfig, new_client func() TerraformClient) (*JobService, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "new_job_service", "request": nil})
+
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil || interval <= 0 {
+		err := fmt.Errorf("invalid job interval '%v'", config.Interval)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if config.Workers <= 0 {
+		err := fmt.Errorf("invalid number of job workers '%v'", config.Workers)
+		logger.Error(err)
+		return nil, err
+	}
+
+	// A job is only considered abandoned once its worker has missed
+	//  several heartbeats
+	stale_after, err := time.ParseDuration(config.StaleAfter)
+	if err != nil || stale_after <= 2*interval {
+		err := fmt.Errorf("invalid job stale after '%v', must be more than twice the interval", config.StaleAfter)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &JobService{
+		dao:        dao,
+		clusters:   clusters,
+		worker:     uuid.Must(uuid.NewRandom()).String(),
+		workers:    config.Workers,
+		interval:   interval,
+		staleAfter: stale_after,
+		newClient:  new_client,
+	}, nil
+}
+
+// Reconcile clusters left behind by a previous run, then start the workers,
+// each running queued jobs until none remain and then polling every
+// interval. Clusters are reconciled again every stale after, and failed
+// destroys which are due are queued again every interval.
+func (s *JobService) StartWorking() error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "job_working", "request": nil})
+
+	if err := s.ReconcileClusters(uuid.Must(uuid.NewRandom()).String()); err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			for {
+				ran, err := s.RunNextJob(uuid.Must(uuid.NewRandom()).String())
+				if err != nil {
+					logger.Error(err)
+				}
+				if !ran {
+					time.Sleep(s.interval)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _ = range time.NewTicker(s.interval).C {
+			if err := s.RetryDestroys(uuid.Must(uuid.NewRandom()).String()); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+
+	go func() {
+		for _ = range time.NewTicker(s.staleAfter).C {
+			if err := s.ReconcileClusters(uuid.Must(uuid.NewRandom()).String()); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Claim and run the oldest queued job. Returns false when no job is queued.
+func (s *JobService) RunNextJob(request_id string) (bool, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "run_job", "request": request_id})
+
+	job, err := s.dao.ClaimClusterJob(s.worker, request_id)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	client := s.newClient()
+
+	// Report the job alive until it is finished, a job which stops being
+	//  reported is reconciled as abandoned. Cancelling the job is requested
+	//  through its record, so any instance can cancel it. A job which was
+	//  abandoned regardless is aborted, destroying included, as it may be
+	//  queued again, as is a job which could not be reported for nearly
+	//  stale after, such as by a worker cut off from the database, before
+	//  another worker takes over.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		reported := job.Heartbeat
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := s.dao.HeartbeatClusterJob(job, request_id)
+				if err != nil {
+					logger.Error(err)
+				} else {
+					reported = job.Heartbeat
+				}
+				lost := err != nil && (err.Error() == models.ErrorClusterJobClaimLost || time.Since(reported) > s.staleAfter-s.interval)
+				if lost {
+					client.Abort()
+				} else if job.Cancelled {
+					client.Cancel()
+				}
+			}
+		}
+	}()
+
+	job.Status = models.ClusterJobDone
+	if err := s.runLockedClusterJob(job, client, request_id); err != nil {
+		logger.Error(err)
+		job.Status = models.ClusterJobFailed
+		job.Message = err.Error()
+	}
+	close(done)
+	<-stopped
+
+	return true, s.dao.FinishClusterJob(job, request_id)
+}
+
+// Run the job holding the lock of its cluster. A worker on any instance
+// still running an abandoned job of the cluster is waited on, so terraform
+// never runs against a cluster twice at once.
+func (s *JobService) runLockedClusterJob(job *models.ClusterJob, client TerraformClient, request_id string) error {
+	unlock, err := s.dao.LockCluster(job.ClusterId, request_id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.runClusterJob(job, client)
+}
+
+// Run the terraform operation of the job with the client. The outcome of
+// the operation is recorded on the cluster, an error is only returned when
+// the operation could not be run.
+func (s *JobService) runClusterJob(job *models.ClusterJob, client TerraformClient) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "run_cluster_job", "request": job.RequestId})
+
+	cluster, err := s.clusters.GetCluster(job.RequestId, job.ClusterId)
+	if err != nil {
+		return err
+	}
+
+	if cluster == nil {
+		return errors.New(models.ErrorJobClusterNotFound)
+	}
+
+	credentials := app.GlobalServerConfig.Credentials(cluster.Project)
+	if len(credentials) == 0 {
+		logger.Error(models.CredentialsNotFound)
+	}
+
+	client.SetCredentials(credentials)
+	client.SetProject(cluster.Project)
+	client.SetRegion(cluster.Region)
+
+	backend := ClusterStateBackend(app.GlobalServerConfig.StateBackend, cluster.Id, job.Id)
+	client.SetBackend(backend)
+	if backend != nil {
+		err = s.releaseStateLock(cluster, job)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info(fmt.Sprintf("running job '%v' to %v cluster '%v'", job.Id, job.Operation, cluster.Id))
+
+	// The outcome is only recorded while the job is still claimed
+	clusters := s.clusters.forJob(job)
+
+	switch job.Operation {
+	case models.ClusterJobProvision:
+		clusters.TerraformProvisionCluster(client, cluster, cluster.TerraformConfig, job.RequestId)
+	case models.ClusterJobDestroy:
+		clusters.TerraformDestroyCluster(client, cluster, job.RequestId)
+	default:
+		return errors.New(models.ErrorInvalidJobOperation)
+	}
+
+	return nil
+}
+
+// Release a lock left on the terraform state of the cluster by an earlier
+// job of the cluster, such as one whose terraform failed to unlock, which
+// would otherwise refuse every later operation. Jobs of a cluster never run
+// at once, so a job which has finished no longer holds its lock. The
+// terraform of an abandoned job may still be running on a worker cut off
+// from the database, so its lock is kept, as is any lock held by a
+// terraform outside of taos, and fails the job instead.
+func (s *JobService) releaseStateLock(cluster *models.Cluster, job *models.ClusterJob) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "release_state_lock", "request": job.RequestId})
+
+	lock, err := models.ParseStateLock(cluster.StateLock)
+	if err != nil || lock == nil {
+		return err
+	}
+
+	previous, err := s.dao.GetClusterJob(lock.Job, job.RequestId)
+	if err != nil {
+		return err
+	}
+
+	finished := previous != nil && (previous.Status == models.ClusterJobDone ||
+		previous.Status == models.ClusterJobFailed && previous.Message != models.ErrorJobAbandoned)
+
+	if !finished || previous.ClusterId != cluster.Id || previous.Id == job.Id {
+		logger.Error(fmt.Sprintf("terraform state of cluster '%v' is held by lock '%v' of %v", cluster.Id, lock.ID, lock.Who))
+		return errors.New(models.ErrorClusterStateLocked)
+	}
+
+	_, err = s.dao.UnlockClusterState(cluster.Id, lock.ID, job.RequestId)
+	if err != nil {
+		return err
+	}
+
+	logger.Warn(fmt.Sprintf("released lock '%v' left on the terraform state of cluster '%v' by job '%v' of %v", lock.ID, cluster.Id, previous.Id, lock.Who))
+
+	return nil
+}
+
+// Abandon running jobs whose worker has stopped reporting them alive, then
+// recover every cluster left with an operation in progress but no job.
+// Operations which have not changed any resources, or which can continue
+// from the persisted terraform state, are queued again. A cluster
+// interrupted while provisioning has no state to continue from and fails.
+func (s *JobService) ReconcileClusters(request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "reconcile_clusters", "request": request_id})
+
+	abandoned, err := s.dao.AbandonClusterJobs(time.Now().Add(-s.staleAfter), request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	for _, job := range abandoned {
+		logger.Warn(fmt.Sprintf("abandoned job '%v' to %v cluster '%v' of worker '%v'", job.Id, job.Operation, job.ClusterId, job.Worker))
+	}
+
+	clusters, err := s.dao.GetOrphanedClusters(request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+
+		switch cluster.Status {
+		case models.ClusterStatusRequested:
+			_, err = s.dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, cluster.Status, request_id)
+		case models.ClusterStatusDestroying:
+			_, err = s.dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, cluster.Status, request_id)
+		case models.ClusterStatusProvisionStart:
+			err = s.clusters.updateClusterStatusMessage(cluster, models.ClusterStatusProvisionFailed, models.ErrorProvisionInterrupted, request_id)
+		}
+
+		// A cluster changed since it was found is left as it is
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to reconcile cluster '%v': %v", cluster.Id, err))
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("reconciled cluster '%v', now '%v'", cluster.Id, cluster.Status))
+	}
+
+	return nil
+}
+
+// Queue a destroy for every cluster which failed to be destroyed and whose
+// backoff has passed
+func (s *JobService) RetryDestroys(request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "retry_destroys", "request": request_id})
+
+	clusters, err := s.dao.GetDestroyRetryClusters(time.Now(), request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for _, cluster := range clusters {
+		_, err := s.dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, request_id)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to retry destroying cluster '%v': %v", cluster.Id, err))
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("retrying to destroy cluster '%v' after %v failed attempt(s)", cluster.Id, cluster.DestroyAttempts))
+	}
+
+	return nil
+}
@@ -0,0 +1,459 @@
+package services_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+	"github.com/kmacoskey/taos/terraform"
+)
+
+var _ = Describe("Job", func() {
+
+	var (
+		js             *JobService
+		dao            *ValidClusterDao
+		client         *PassingClient
+		cluster        *models.Cluster
+		validRequestId string
+		validConfig    app.JobConfig
+		ran            bool
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		validConfig = app.JobConfig{Workers: 2, Interval: "1s", StaleAfter: "1m"}
+		app.GlobalServerConfig.DestroyRetries = app.DestroyRetryConfig{MaxAttempts: 3, Backoff: "1m", MaxBackoff: "1h"}
+
+		cluster = &models.Cluster{
+			Id:              "a19e2758-0ec5-11e8-ba89-0ed5f89f718b",
+			Name:            "cluster",
+			Status:          models.ClusterStatusRequested,
+			TerraformConfig: []byte(`{"provider":{"google":{}}}`),
+			Project:         "valid-project-name",
+			Region:          "valid-region",
+		}
+
+		dao = NewValidClusterDao(map[string]*models.Cluster{cluster.Id: cluster})
+		client = new(PassingClient)
+		js, err = NewJobService(dao, NewClusterService(dao), validConfig, func() TerraformClient { return client })
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Creating a job service", func() {
+		Context("When the interval is invalid", func() {
+			It("Should error", func() {
+				_, err = NewJobService(dao, nil, app.JobConfig{Workers: 2, Interval: "never", StaleAfter: "1m"}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("When jobs would be abandoned between heartbeats", func() {
+			It("Should error", func() {
+				_, err = NewJobService(dao, nil, app.JobConfig{Workers: 2, Interval: "1m", StaleAfter: "1m"}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("When there are no workers", func() {
+			It("Should error", func() {
+				_, err = NewJobService(dao, nil, app.JobConfig{Workers: 0, Interval: "1s", StaleAfter: "1m"}, nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Running the next job", func() {
+		Context("When no job is queued", func() {
+			It("Should not run a job", func() {
+				ran, err = js.RunNextJob(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ran).To(BeFalse())
+			})
+		})
+
+		Context("When a provision job is queued", func() {
+			BeforeEach(func() {
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				ran, err = js.RunNextJob(validRequestId)
+			})
+			It("Should run the job", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ran).To(BeTrue())
+				Expect(dao.jobs[0].Status).To(Equal(models.ClusterJobDone))
+			})
+			It("Should provision the cluster", func() {
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionSuccess))
+			})
+			It("Should set the project and region of the terraform client", func() {
+				Expect(client.Project()).To(Equal(cluster.Project))
+				Expect(client.Region()).To(Equal(cluster.Region))
+			})
+			It("Should remove the working directory of the terraform client", func() {
+				Expect(client.removed).To(BeTrue())
+			})
+		})
+
+		Context("When taos serves the state of clusters", func() {
+			BeforeEach(func() {
+				app.GlobalServerConfig.StateBackend = app.StateBackendConfig{Address: "http://taos.example.com", Secret: "secret"}
+
+				// The lock is left by an earlier job of the cluster which failed
+				failed, _ := dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				dao.jobs[failed.Id-1].Status = models.ClusterJobFailed
+				cluster.StateLock = fmt.Sprintf(`{"ID":"stale-lock","Who":"failed-worker","Job":%d}`, failed.Id)
+			})
+			AfterEach(func() {
+				app.GlobalServerConfig.StateBackend = app.StateBackendConfig{}
+			})
+			It("Should store the state of the cluster in taos", func() {
+				job, _ := dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				ran, err = js.RunNextJob(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client.Terraform.Backend).To(Equal(ClusterStateBackend(app.GlobalServerConfig.StateBackend, cluster.Id, job.Id)))
+			})
+			It("Should release a lock left on the state by an earlier job", func() {
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				ran, err = js.RunNextJob(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.StateLock).To(BeEmpty())
+				Expect(dao.jobs[1].Status).To(Equal(models.ClusterJobDone))
+			})
+
+			Context("When the lock is not known to have been left by a job", func() {
+				BeforeEach(func() {
+					cluster.StateLock = `{"ID":"manual-lock","Who":"someone@laptop"}`
+					dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+					ran, err = js.RunNextJob(validRequestId)
+				})
+				It("Should fail the job and keep the lock", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dao.jobs[1].Status).To(Equal(models.ClusterJobFailed))
+					Expect(dao.jobs[1].Message).To(Equal(models.ErrorClusterStateLocked))
+					Expect(cluster.StateLock).NotTo(BeEmpty())
+					Expect(cluster.Status).To(Equal(models.ClusterStatusRequested))
+				})
+			})
+
+			Context("When the job of the lock was abandoned", func() {
+				BeforeEach(func() {
+					dao.jobs[0].Message = models.ErrorJobAbandoned
+					dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+					ran, err = js.RunNextJob(validRequestId)
+				})
+				It("Should fail the job and keep the lock", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dao.jobs[1].Status).To(Equal(models.ClusterJobFailed))
+					Expect(dao.jobs[1].Message).To(Equal(models.ErrorClusterStateLocked))
+					Expect(cluster.StateLock).NotTo(BeEmpty())
+				})
+			})
+
+			Context("When the job of the lock is still running", func() {
+				BeforeEach(func() {
+					dao.jobs[0].Status = models.ClusterJobRunning
+					dao.jobs[0].Worker = "another-worker"
+					dao.jobs = append(dao.jobs, models.ClusterJob{Id: 2, ClusterId: cluster.Id, Operation: models.ClusterJobDestroy, Status: models.ClusterJobQueued})
+					ran, err = js.RunNextJob(validRequestId)
+				})
+				It("Should fail the job and keep the lock", func() {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(dao.jobs[1].Status).To(Equal(models.ClusterJobFailed))
+					Expect(dao.jobs[1].Message).To(Equal(models.ErrorClusterStateLocked))
+					Expect(cluster.StateLock).NotTo(BeEmpty())
+				})
+			})
+		})
+
+		Context("When a destroy job is queued", func() {
+			BeforeEach(func() {
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, validRequestId)
+				ran, err = js.RunNextJob(validRequestId)
+			})
+			It("Should destroy the cluster", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroyed))
+				Expect(dao.jobs[0].Status).To(Equal(models.ClusterJobDone))
+			})
+			It("Should remove the working directory of the terraform client", func() {
+				Expect(client.removed).To(BeTrue())
+			})
+		})
+
+		Context("When a destroy job fails", func() {
+			BeforeEach(func() {
+				js, err = NewJobService(dao, NewClusterService(dao), validConfig, func() TerraformClient { return new(FailingClient) })
+				Expect(err).NotTo(HaveOccurred())
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, validRequestId)
+			})
+			It("Should retry destroying the cluster with backoff", func() {
+				cluster.DestroyAttempts = 1
+				_, err = js.RunNextJob(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroyFailed))
+				Expect(cluster.DestroyAttempts).To(Equal(2))
+				Expect(cluster.NextDestroyAttempt).To(BeTemporally("~", time.Now().Add(2*time.Minute), time.Second))
+			})
+			It("Should give up once every attempt has failed", func() {
+				cluster.DestroyAttempts = 2
+				_, err = js.RunNextJob(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroyRetriesExhausted))
+				Expect(cluster.DestroyAttempts).To(Equal(3))
+				Expect(cluster.Message).To(HavePrefix(models.ErrorDestroyRetriesExhausted))
+			})
+		})
+
+		Context("When jobs are queued for several clusters", func() {
+			It("Should run them in the order they were queued", func() {
+				other := &models.Cluster{Id: "a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", Status: models.ClusterStatusRequested}
+				dao.clustersMap[other.Id] = other
+				dao.EnqueueClusterJob(other.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+
+				_, err = js.RunNextJob(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(other.Status).To(Equal(models.ClusterStatusProvisionSuccess))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusRequested))
+			})
+		})
+
+		Context("When the provision job is cancelled while it runs", func() {
+			It("Should interrupt provisioning and roll it back", func() {
+				cancellable := NewCancellableClient()
+				js, err = NewJobService(dao, NewClusterService(dao), validConfig, func() TerraformClient { return cancellable })
+				Expect(err).NotTo(HaveOccurred())
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+
+				finished := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					ran, err = js.RunNextJob(validRequestId)
+					close(finished)
+				}()
+
+				<-cancellable.started
+				_, cancel_err := NewClusterService(dao).CancelCluster(validRequestId, cluster.Id)
+				Expect(cancel_err).NotTo(HaveOccurred())
+
+				Eventually(finished, "5s").Should(BeClosed())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionFailedRollbackSuccess))
+				Expect(cluster.Message).To(ContainSubstring(terraform.ErrorApplyCancelled))
+			})
+		})
+
+		Context("When the job is abandoned while it runs", func() {
+			It("Should interrupt the job and leave it abandoned", func() {
+				cancellable := NewCancellableClient()
+				js, err = NewJobService(dao, NewClusterService(dao), validConfig, func() TerraformClient { return cancellable })
+				Expect(err).NotTo(HaveOccurred())
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+
+				finished := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					ran, err = js.RunNextJob(validRequestId)
+					close(finished)
+				}()
+
+				<-cancellable.started
+				dao.jobsMutex.Lock()
+				Expect(dao.locked).To(HaveKey(cluster.Id))
+				dao.jobsMutex.Unlock()
+				_, abandon_err := dao.AbandonClusterJobs(time.Now().Add(time.Minute), validRequestId)
+				Expect(abandon_err).NotTo(HaveOccurred())
+
+				Eventually(finished, "5s").Should(BeClosed())
+				Expect(err).To(MatchError(models.ErrorClusterJobClaimLost))
+				Expect(dao.jobs[0].Status).To(Equal(models.ClusterJobFailed))
+				Expect(dao.jobs[0].Message).To(Equal(models.ErrorJobAbandoned))
+				Expect(dao.locked).NotTo(HaveKey(cluster.Id))
+			})
+			It("Should leave the cluster to the next worker", func() {
+				cancellable := NewCancellableClient()
+				js, err = NewJobService(dao, NewClusterService(dao), validConfig, func() TerraformClient { return cancellable })
+				Expect(err).NotTo(HaveOccurred())
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+
+				finished := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					ran, err = js.RunNextJob(validRequestId)
+					close(finished)
+				}()
+
+				<-cancellable.started
+				_, abandon_err := dao.AbandonClusterJobs(time.Now().Add(time.Minute), validRequestId)
+				Expect(abandon_err).NotTo(HaveOccurred())
+
+				Eventually(finished, "5s").Should(BeClosed())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionStart))
+				Expect(cluster.TerraformState).To(BeEmpty())
+			})
+		})
+
+		Context("When a destroy job is abandoned while it runs", func() {
+			It("Should abort the destroy", func() {
+				abortable := NewAbortableClient()
+				js, err = NewJobService(dao, NewClusterService(dao), validConfig, func() TerraformClient { return abortable })
+				Expect(err).NotTo(HaveOccurred())
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, validRequestId)
+
+				finished := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					ran, err = js.RunNextJob(validRequestId)
+					close(finished)
+				}()
+
+				<-abortable.started
+				_, abandon_err := dao.AbandonClusterJobs(time.Now().Add(time.Minute), validRequestId)
+				Expect(abandon_err).NotTo(HaveOccurred())
+
+				Eventually(finished, "5s").Should(BeClosed())
+				Expect(err).To(MatchError(models.ErrorClusterJobClaimLost))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+		})
+
+		Context("When the job cannot be reported alive", func() {
+			It("Should interrupt the job before it is abandoned", func() {
+				cancellable := NewCancellableClient()
+				config := app.JobConfig{Workers: 1, Interval: "10ms", StaleAfter: "50ms"}
+				js, err = NewJobService(dao, NewClusterService(dao), config, func() TerraformClient { return cancellable })
+				Expect(err).NotTo(HaveOccurred())
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				dao.heartbeatErr = errors.New("connection refused")
+
+				finished := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					ran, err = js.RunNextJob(validRequestId)
+					close(finished)
+				}()
+
+				Eventually(finished, "5s").Should(BeClosed())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cluster.Message).To(ContainSubstring(terraform.ErrorApplyCancelled))
+			})
+		})
+
+		Context("When the cluster of the job does not exist", func() {
+			BeforeEach(func() {
+				dao.EnqueueClusterJob("a19e2bfe-0ec5-11e8-ba89-0ed5f89f718b", models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				ran, err = js.RunNextJob(validRequestId)
+			})
+			It("Should fail the job", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ran).To(BeTrue())
+				Expect(dao.jobs[0].Status).To(Equal(models.ClusterJobFailed))
+				Expect(dao.jobs[0].Message).To(Equal(models.ErrorJobClusterNotFound))
+			})
+		})
+	})
+
+	Describe("Retrying failed destroys", func() {
+		BeforeEach(func() {
+			cluster.Status = models.ClusterStatusDestroyFailed
+			cluster.DestroyAttempts = 1
+		})
+
+		Context("When the backoff has passed", func() {
+			It("Should queue a job to destroy the cluster", func() {
+				cluster.NextDestroyAttempt = time.Now().Add(-time.Second)
+				err = js.RetryDestroys(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs).To(HaveLen(1))
+				Expect(dao.jobs[0].Operation).To(Equal(models.ClusterJobDestroy))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroying))
+			})
+		})
+
+		Context("When the backoff has not passed", func() {
+			It("Should leave the cluster", func() {
+				cluster.NextDestroyAttempt = time.Now().Add(time.Minute)
+				err = js.RetryDestroys(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs).To(BeEmpty())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusDestroyFailed))
+			})
+		})
+	})
+
+	Describe("Reconciling clusters", func() {
+		Context("When a requested cluster has no job", func() {
+			It("Should queue a job to provision the cluster", func() {
+				err = js.ReconcileClusters(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs).To(HaveLen(1))
+				Expect(dao.jobs[0].Operation).To(Equal(models.ClusterJobProvision))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusRequested))
+			})
+		})
+
+		Context("When a destroying cluster has no job", func() {
+			It("Should queue a job to resume destroying the cluster", func() {
+				cluster.Status = models.ClusterStatusDestroying
+				err = js.ReconcileClusters(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs).To(HaveLen(1))
+				Expect(dao.jobs[0].Operation).To(Equal(models.ClusterJobDestroy))
+			})
+		})
+
+		Context("When a provisioning cluster has no job", func() {
+			It("Should fail the cluster with an explanation", func() {
+				cluster.Status = models.ClusterStatusProvisionStart
+				err = js.ReconcileClusters(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs).To(BeEmpty())
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionFailed))
+				Expect(cluster.Message).To(Equal(models.ErrorProvisionInterrupted))
+			})
+		})
+
+		Context("When the worker of a running job has stopped", func() {
+			BeforeEach(func() {
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobDestroy, models.ClusterStatusDestroying, validRequestId)
+				dao.ClaimClusterJob("stopped-worker", validRequestId)
+				dao.jobs[0].Heartbeat = time.Now().Add(-time.Hour)
+				err = js.ReconcileClusters(validRequestId)
+			})
+			It("Should abandon the job", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs[0].Status).To(Equal(models.ClusterJobFailed))
+				Expect(dao.jobs[0].Message).To(Equal(models.ErrorJobAbandoned))
+			})
+			It("Should queue the operation again", func() {
+				Expect(dao.jobs).To(HaveLen(2))
+				Expect(dao.jobs[1].Operation).To(Equal(models.ClusterJobDestroy))
+				Expect(dao.jobs[1].Status).To(Equal(models.ClusterJobQueued))
+			})
+		})
+
+		Context("When a running job is alive", func() {
+			It("Should leave the job running", func() {
+				dao.EnqueueClusterJob(cluster.Id, models.ClusterJobProvision, models.ClusterStatusRequested, validRequestId)
+				dao.ClaimClusterJob("live-worker", validRequestId)
+				cluster.Status = models.ClusterStatusProvisionStart
+
+				err = js.ReconcileClusters(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(dao.jobs).To(HaveLen(1))
+				Expect(dao.jobs[0].Status).To(Equal(models.ClusterJobRunning))
+				Expect(cluster.Status).To(Equal(models.ClusterStatusProvisionStart))
+			})
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Writer persisting terraform output to the log of a cluster operation one
+// line at a time. Terraform writes stdout and stderr concurrently, so
+// writes are serialized.
+type clusterLogWriter struct {
+	service   *ClusterService
+	clusterId string
+	operation string
+	requestId string
+	mutex     sync.Mutex
+	partial   bytes.Buffer
+}
+
+func (s *ClusterService) newClusterLogWriter(cluster *models.Cluster, operation string, requestId string) *clusterLogWriter {
+	return &clusterLogWriter{
+		service:   s,
+		clusterId: cluster.Id,
+		operation: operation,
+		requestId: requestId,
+	}
+}
+
+// Failing to persist output is logged but never fails the terraform
+// command writing it
+func (w *clusterLogWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.partial.Write(p)
+
+	lines := []string{}
+	for {
+		i := bytes.IndexByte(w.partial.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.partial.Next(i + 1)
+		lines = append(lines, string(bytes.TrimRight(line, "\r\n")))
+	}
+
+	w.append(lines)
+
+	return len(p), nil
+}
+
+// Persist any trailing output not terminated by a newline
+func (w *clusterLogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.partial.Len() > 0 {
+		w.append([]string{w.partial.String()})
+		w.partial.Reset()
+	}
+
+	return nil
+}
+
+func (w *clusterLogWriter) append(lines []string) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "append_cluster_log", "request": w.requestId})
+
+	if len(lines) == 0 {
+		return
+	}
+
+	err := w.service.dao.AppendClusterLog(w.clusterId, w.operation, lines, w.requestId)
+	if err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type migrationDao interface {
+	LockMigrations(db *sqlx.DB, requestId string) (*sql.Conn, error)
+	UnlockMigrations(conn *sql.Conn, requestId string) error
+	GetAppliedMigrations(db *sqlx.DB, requestId string) ([]models.AppliedMigration, error)
+	ApplyMigration(db *sqlx.DB, migration models.Migration, requestId string) error
+	RevertMigration(db *sqlx.DB, migration models.Migration, requestId string) error
+}
+
+// Migrates the database schema. Instances sharing the database take turns
+// migrating, so replicas starting together do not apply a migration twice.
+type MigrationService struct {
+	dao        migrationDao
+	db         *sqlx.DB
+	migrations []models.Migration
+}
+
+func NewMigrationService(dao migrationDao, db *sqlx.DB, migrations []models.Migration) (*MigrationService, error) {
+	for i, migration := range migrations {
+		if migration.Version <= 0 || (i > 0 && migration.Version <= migrations[i-1].Version) {
+			return nil, fmt.Errorf("%v: '%v'", models.ErrorInvalidMigrations, migration.Version)
+		}
+	}
+
+	return &MigrationService{dao: dao, db: db, migrations: migrations}, nil
+}
+
+// Run a migrate command, up, down or status
+func (s *MigrationService) Migrate(command string, request_id string) ([]models.MigrationStatus, error) {
+	switch command {
+	case models.MigrateUp:
+		if _, err := s.Up(request_id); err != nil {
+			return nil, err
+		}
+	case models.MigrateDown:
+		if _, err := s.Down(request_id); err != nil {
+			return nil, err
+		}
+	case models.MigrateStatus:
+	default:
+		return nil, errors.New(models.ErrorUnknownMigrateCommand)
+	}
+	return s.Status(request_id)
+}
+
+// Apply every migration not yet applied, oldest first, returning those
+// applied. Refuses to migrate a database migrated by a newer binary.
+func (s *MigrationService) Up(request_id string) ([]models.Migration, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "migrate_up", "request": request_id})
+
+	conn, err := s.dao.LockMigrations(s.db, request_id)
+	if err != nil {
+		return nil, err
+	}
+	defer s.dao.UnlockMigrations(conn, request_id)
+
+	applied, err := s.appliedVersions(request_id)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := []models.Migration{}
+	for _, migration := range s.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := s.dao.ApplyMigration(s.db, migration, request_id)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to apply migration %v '%v': %v", migration.Version, migration.Name, err))
+			return migrated, err
+		}
+		migrated = append(migrated, migration)
+
+		logger.Info(fmt.Sprintf("applied migration %v '%v'", migration.Version, migration.Name))
+	}
+
+	return migrated, nil
+}
+
+// Revert the latest applied migration, returning it
+func (s *MigrationService) Down(request_id string) (*models.Migration, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "migrate_down", "request": request_id})
+
+	conn, err := s.dao.LockMigrations(s.db, request_id)
+	if err != nil {
+		return nil, err
+	}
+	defer s.dao.UnlockMigrations(conn, request_id)
+
+	applied, err := s.appliedVersions(request_id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(s.migrations) - 1; i >= 0; i-- {
+		migration := s.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := s.dao.RevertMigration(s.db, migration, request_id)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to revert migration %v '%v': %v", migration.Version, migration.Name, err))
+			return nil, err
+		}
+
+		logger.Info(fmt.Sprintf("reverted migration %v '%v'", migration.Version, migration.Name))
+
+		return &migration, nil
+	}
+
+	err = errors.New(models.ErrorNoMigrationToRevert)
+	logger.Error(err)
+	return nil, err
+}
+
+// Every migration known to the binary and whether it has been applied
+func (s *MigrationService) Status(request_id string) ([]models.MigrationStatus, error) {
+	applied, err := s.dao.GetAppliedMigrations(s.db, request_id)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make(map[int]models.AppliedMigration)
+	for _, migration := range applied {
+		times[migration.Version] = migration
+	}
+
+	statuses := []models.MigrationStatus{}
+	for _, migration := range s.migrations {
+		status := models.MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if applied, ok := times[migration.Version]; ok {
+			status.Applied = true
+			status.Time = applied.Applied
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Versions applied to the database, erroring when any of them is unknown to
+// the binary. A database with a version beyond the latest known was
+// migrated by a newer binary.
+func (s *MigrationService) appliedVersions(request_id string) (map[int]models.AppliedMigration, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "applied_migrations", "request": request_id})
+
+	applied, err := s.dao.GetAppliedMigrations(s.db, request_id)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool)
+	latest := 0
+	for _, migration := range s.migrations {
+		known[migration.Version] = true
+		latest = migration.Version
+	}
+
+	versions := make(map[int]models.AppliedMigration)
+	for _, migration := range applied {
+		if !known[migration.Version] {
+			err := errors.New(models.ErrorUnknownAppliedMigration)
+			if migration.Version > latest {
+				err = errors.New(models.ErrorDatabaseNewer)
+			}
+			logger.Error(fmt.Sprintf("migration %v '%v' is applied but unknown to this binary", migration.Version, migration.Name))
+			return nil, err
+		}
+		versions[migration.Version] = migration
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,219 @@
+package services_test
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Migration", func() {
+
+	var (
+		ms             *MigrationService
+		migrationDao   *MemoryMigrationDao
+		migrations     []models.Migration
+		validRequestId string
+		statuses       []models.MigrationStatus
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "ff459ef4-514b-11e8-9c2d-fa7ae01bbebc"
+		migrationDao = NewMemoryMigrationDao()
+		migrations = []models.Migration{
+			{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+			{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+			{Version: 3, Name: "third", Up: "up 3", Down: "down 3"},
+		}
+		ms, err = NewMigrationService(migrationDao, nil, migrations)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Creating a migration service", func() {
+		Context("When versions are not increasing", func() {
+			It("Should error", func() {
+				_, err = NewMigrationService(migrationDao, nil, []models.Migration{{Version: 2}, {Version: 1}})
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("When a version is not positive", func() {
+			It("Should error", func() {
+				_, err = NewMigrationService(migrationDao, nil, []models.Migration{{Version: 0}})
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Migrating up", func() {
+		It("Should apply every migration in order while holding the lock", func() {
+			applied, err := ms.Up(validRequestId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(HaveLen(3))
+			Expect(migrationDao.ran).To(Equal([]string{"up 1", "up 2", "up 3"}))
+			Expect(migrationDao.locks).To(Equal(1))
+			Expect(migrationDao.locked).To(BeFalse())
+		})
+
+		Context("When some migrations are already applied", func() {
+			It("Should only apply the pending migrations", func() {
+				migrationDao.applied[1] = models.AppliedMigration{Version: 1, Name: "first"}
+
+				applied, err := ms.Up(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(applied).To(HaveLen(2))
+				Expect(migrationDao.ran).To(Equal([]string{"up 2", "up 3"}))
+			})
+		})
+
+		Context("When run twice", func() {
+			It("Should apply nothing the second time", func() {
+				_, err = ms.Up(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+
+				applied, err := ms.Up(validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(applied).To(BeEmpty())
+				Expect(migrationDao.ran).To(HaveLen(3))
+			})
+		})
+
+		Context("When the database was migrated by a newer binary", func() {
+			It("Should refuse to migrate", func() {
+				migrationDao.applied[4] = models.AppliedMigration{Version: 4, Name: "fourth"}
+
+				_, err = ms.Up(validRequestId)
+				Expect(err).To(MatchError(models.ErrorDatabaseNewer))
+				Expect(migrationDao.ran).To(BeEmpty())
+				Expect(migrationDao.locked).To(BeFalse())
+			})
+		})
+
+		Context("When an applied migration is unknown to the binary", func() {
+			It("Should refuse to migrate", func() {
+				migrationDao.applied[0] = models.AppliedMigration{Version: 0, Name: "unknown"}
+
+				_, err = ms.Up(validRequestId)
+				Expect(err).To(MatchError(models.ErrorUnknownAppliedMigration))
+			})
+		})
+
+		Context("When a migration fails", func() {
+			It("Should stop before the migrations after it", func() {
+				migrationDao.failing = "up 2"
+
+				applied, err := ms.Up(validRequestId)
+				Expect(err).To(HaveOccurred())
+				Expect(applied).To(HaveLen(1))
+				Expect(migrationDao.ran).To(Equal([]string{"up 1"}))
+			})
+		})
+	})
+
+	Describe("Migrating down", func() {
+		It("Should revert the latest applied migration", func() {
+			migrationDao.applied[1] = models.AppliedMigration{Version: 1, Name: "first"}
+			migrationDao.applied[2] = models.AppliedMigration{Version: 2, Name: "second"}
+
+			reverted, err := ms.Down(validRequestId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reverted.Version).To(Equal(2))
+			Expect(migrationDao.ran).To(Equal([]string{"down 2"}))
+			Expect(migrationDao.applied).NotTo(HaveKey(2))
+		})
+
+		Context("When nothing is applied", func() {
+			It("Should error", func() {
+				_, err = ms.Down(validRequestId)
+				Expect(err).To(MatchError(models.ErrorNoMigrationToRevert))
+			})
+		})
+	})
+
+	Describe("Running a migrate command", func() {
+		It("Should report the status of every migration", func() {
+			migrationDao.applied[1] = models.AppliedMigration{Version: 1, Name: "first", Applied: time.Now()}
+
+			statuses, err = ms.Migrate(models.MigrateStatus, validRequestId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(statuses).To(HaveLen(3))
+			Expect(statuses[0].Applied).To(BeTrue())
+			Expect(statuses[0].Time.IsZero()).To(BeFalse())
+			Expect(statuses[1].Applied).To(BeFalse())
+			Expect(migrationDao.ran).To(BeEmpty())
+		})
+
+		It("Should report the status after migrating up", func() {
+			statuses, err = ms.Migrate(models.MigrateUp, validRequestId)
+			Expect(err).NotTo(HaveOccurred())
+			for _, status := range statuses {
+				Expect(status.Applied).To(BeTrue())
+			}
+		})
+
+		Context("When the command is unknown", func() {
+			It("Should error", func() {
+				_, err = ms.Migrate("sideways", validRequestId)
+				Expect(err).To(MatchError(models.ErrorUnknownMigrateCommand))
+			})
+		})
+	})
+})
+
+type MemoryMigrationDao struct {
+	applied map[int]models.AppliedMigration
+	ran     []string
+	failing string
+	locks   int
+	locked  bool
+}
+
+func NewMemoryMigrationDao() *MemoryMigrationDao {
+	return &MemoryMigrationDao{applied: make(map[int]models.AppliedMigration), ran: []string{}}
+}
+
+func (dao *MemoryMigrationDao) LockMigrations(db *sqlx.DB, requestId string) (*sql.Conn, error) {
+	dao.locks++
+	dao.locked = true
+	return nil, nil
+}
+
+func (dao *MemoryMigrationDao) UnlockMigrations(conn *sql.Conn, requestId string) error {
+	dao.locked = false
+	return nil
+}
+
+func (dao *MemoryMigrationDao) GetAppliedMigrations(db *sqlx.DB, requestId string) ([]models.AppliedMigration, error) {
+	applied := []models.AppliedMigration{}
+	for _, migration := range dao.applied {
+		applied = append(applied, migration)
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i].Version < applied[j].Version })
+	return applied, nil
+}
+
+func (dao *MemoryMigrationDao) ApplyMigration(db *sqlx.DB, migration models.Migration, requestId string) error {
+	if migration.Up == dao.failing {
+		return errors.New("migration failed")
+	}
+	dao.ran = append(dao.ran, migration.Up)
+	dao.applied[migration.Version] = models.AppliedMigration{Version: migration.Version, Name: migration.Name, Applied: time.Now()}
+	return nil
+}
+
+func (dao *MemoryMigrationDao) RevertMigration(db *sqlx.DB, migration models.Migration, requestId string) error {
+	dao.ran = append(dao.ran, migration.Down)
+	delete(dao.applied, migration.Version)
+	return nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type quotaDao interface {
+	GetQuotaUsage(quota models.Quota, requestId string) (*models.QuotaUsage, error)
+}
+
+// Reports the usage of the configured quotas
+type QuotaService struct {
+	dao quotaDao
+}
+
+func NewQuotaService(dao quotaDao) *QuotaService {
+	return &QuotaService{dao}
+}
+
+// Usage of the quota of the owner, followed by the quota of every
+// configured project
+func (s *QuotaService) GetQuotas(request_id string, owner string) ([]models.QuotaUsage, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_quotas", "request": request_id})
+	logger.Info("servicing request to get quotas")
+
+	projects := []string{}
+	for project := range app.GlobalServerConfig.Clouds {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	quotas := []models.Quota{ownerQuota(owner)}
+	for _, project := range projects {
+		quotas = append(quotas, projectQuota(project))
+	}
+
+	usages := []models.QuotaUsage{}
+	for _, quota := range quotas {
+		usage, err := s.dao.GetQuotaUsage(quota, request_id)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		usages = append(usages, *usage)
+	}
+
+	return usages, nil
+}
+
+// Quotas a cluster is created within. The project quota is always first,
+// which is the order their usage is locked in.
+func clusterQuotas(spec *models.ClusterSpec) []models.Quota {
+	return []models.Quota{projectQuota(spec.Project), ownerQuota(spec.Owner)}
+}
+
+func projectQuota(project string) models.Quota {
+	return newQuota(models.QuotaScopeProject, project, app.GlobalServerConfig.ProjectQuota(project))
+}
+
+func ownerQuota(owner string) models.Quota {
+	return newQuota(models.QuotaScopeOwner, owner, app.GlobalServerConfig.OwnerQuota(owner))
+}
+
+func newQuota(scope string, name string, config app.QuotaConfig) models.Quota {
+	return models.Quota{
+		Scope:           scope,
+		Name:            name,
+		MaxClusters:     config.MaxClusters,
+		MaxClusterHours: config.MaxClusterHours,
+		MaxTimeout:      config.MaxTimeout,
+	}
+}
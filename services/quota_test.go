@@ -0,0 +1,92 @@
+package services_test
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Quota", func() {
+
+	var (
+		qs             *QuotaService
+		validRequestId string
+		usages         []models.QuotaUsage
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		qs = NewQuotaService(&MemoryQuotaDao{live: 1})
+
+		app.GlobalServerConfig.Clouds = map[string]app.CloudProjectConfig{
+			"project-b": {Project: "project-b"},
+			"project-a": {Project: "project-a", QuotaConfig: app.QuotaConfig{MaxClusters: 5}},
+		}
+		app.GlobalServerConfig.OwnerQuotas = app.OwnerQuotaConfig{Default: app.QuotaConfig{MaxClusters: 2}}
+	})
+
+	AfterEach(func() {
+		app.GlobalServerConfig.Clouds = nil
+		app.GlobalServerConfig.OwnerQuotas = app.OwnerQuotaConfig{}
+	})
+
+	Describe("Getting quotas", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				usages, err = qs.GetQuotas(validRequestId, "alice")
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return the quota of the owner first", func() {
+				Expect(usages[0].Scope).To(Equal(models.QuotaScopeOwner))
+				Expect(usages[0].Name).To(Equal("alice"))
+				Expect(usages[0].MaxClusters).To(Equal(2))
+				Expect(usages[0].LiveClusters).To(Equal(1))
+			})
+			It("Should return the quota of every project by name", func() {
+				Expect(usages).To(HaveLen(3))
+				Expect(usages[1].Name).To(Equal("project-a"))
+				Expect(usages[1].MaxClusters).To(Equal(5))
+				Expect(usages[2].Name).To(Equal("project-b"))
+				Expect(usages[2].MaxClusters).To(Equal(0))
+			})
+		})
+
+		Context("When the usage cannot be counted", func() {
+			It("Should error", func() {
+				qs = NewQuotaService(&MemoryQuotaDao{err: errors.New("dao error")})
+				_, err = qs.GetQuotas(validRequestId, "alice")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
+
+type MemoryQuotaDao struct {
+	live int
+	err  error
+}
+
+func (dao *MemoryQuotaDao) GetQuotaUsage(quota models.Quota, requestId string) (*models.QuotaUsage, error) {
+	if dao.err != nil {
+		return nil, dao.err
+	}
+	return &models.QuotaUsage{
+		Scope:           quota.Scope,
+		Name:            quota.Name,
+		LiveClusters:    dao.live,
+		MaxClusters:     quota.MaxClusters,
+		MaxClusterHours: quota.MaxClusterHours,
+		MaxTimeout:      quota.MaxTimeout,
+	}, nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type reaperDao interface {
+	GetReapRuns(db *sqlx.DB, limit int, requestId string) ([]models.ReapRun, error)
+}
+
+// Reports the runs of the reaper
+type ReaperService struct {
+	dao reaperDao
+	db  *sqlx.DB
+}
+
+func NewReaperService(dao reaperDao, db *sqlx.DB) *ReaperService {
+	return &ReaperService{dao, db}
+}
+
+// The most recent reap runs, newest first
+func (s *ReaperService) GetReapRuns(request_id string, limit int) ([]models.ReapRun, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_reap_runs", "request": request_id})
+	logger.Info("servicing request to get reap runs")
+
+	if limit <= 0 || limit > models.MaxReapRunsLimit {
+		logger.Error(models.ErrorInvalidReapRunsLimit)
+		return nil, errors.New(models.ErrorInvalidReapRunsLimit)
+	}
+
+	runs, err := s.dao.GetReapRuns(s.db, limit, request_id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return runs, nil
+}
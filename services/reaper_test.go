@@ -0,0 +1,69 @@
+package services_test
+
+import (
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Reaper", func() {
+
+	var (
+		rs             *ReaperService
+		dao            *MemoryReaperDao
+		validRequestId string
+		runs           []models.ReapRun
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		dao = &MemoryReaperDao{runs: []models.ReapRun{{Id: 2}, {Id: 1}}}
+		rs = NewReaperService(dao, &sqlx.DB{})
+	})
+
+	Describe("Getting reap runs", func() {
+		Context("When everything goes ok", func() {
+			BeforeEach(func() {
+				runs, err = rs.GetReapRuns(validRequestId, 1)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return at most the limit of runs", func() {
+				Expect(runs).To(HaveLen(1))
+				Expect(runs[0].Id).To(Equal(int64(2)))
+			})
+		})
+
+		Context("When the limit is out of range", func() {
+			It("Should error", func() {
+				_, err = rs.GetReapRuns(validRequestId, 0)
+				Expect(err).To(MatchError(models.ErrorInvalidReapRunsLimit))
+
+				_, err = rs.GetReapRuns(validRequestId, models.MaxReapRunsLimit+1)
+				Expect(err).To(MatchError(models.ErrorInvalidReapRunsLimit))
+			})
+		})
+	})
+})
+
+/*
+ * Memory Reaper Dao keeps its runs newest first
+ */
+type MemoryReaperDao struct {
+	runs []models.ReapRun
+}
+
+func (dao *MemoryReaperDao) GetReapRuns(db *sqlx.DB, limit int, requestId string) ([]models.ReapRun, error) {
+	if limit > len(dao.runs) {
+		limit = len(dao.runs)
+	}
+	return dao.runs[:limit], nil
+}
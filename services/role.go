@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type roleDao interface {
+	SetRoleBinding(db *sqlx.DB, binding *models.RoleBinding, requestId string) (*models.RoleBinding, error)
+	GetRoleBindings(db *sqlx.DB, project string, requestId string) ([]models.RoleBinding, error)
+	DeleteRoleBinding(db *sqlx.DB, project string, subject_type string, subject string, requestId string) (int64, error)
+}
+
+// Grants, revokes and checks the roles of users and tokens within the
+// configured projects
+type RoleService struct {
+	dao roleDao
+	db  *sqlx.DB
+}
+
+func NewRoleService(dao roleDao, db *sqlx.DB) *RoleService {
+	return &RoleService{dao, db}
+}
+
+func (s *RoleService) SetRoleBinding(request_id string, binding *models.RoleBinding) (*models.RoleBinding, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "set_role_binding", "request": request_id})
+	logger.Info("servicing request to set role binding")
+
+	if _, exists := app.GlobalServerConfig.Clouds[binding.Project]; !exists {
+		err := errors.New(models.ErrorUnknownProject)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if !models.ValidSubjectType(binding.SubjectType) {
+		err := errors.New(models.ErrorInvalidSubjectType)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(binding.Subject) == 0 {
+		err := errors.New(models.ErrorMissingRoleSubject)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if !models.ValidRole(binding.Role) {
+		err := errors.New(models.ErrorInvalidRole)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return s.dao.SetRoleBinding(s.db, binding, request_id)
+}
+
+func (s *RoleService) GetRoleBindings(request_id string, project string) ([]models.RoleBinding, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_role_bindings", "request": request_id})
+	logger.Info("servicing request to get role bindings")
+
+	return s.dao.GetRoleBindings(s.db, project, request_id)
+}
+
+func (s *RoleService) DeleteRoleBinding(request_id string, project string, subject_type string, subject string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "delete_role_binding", "request": request_id})
+	logger.Info("servicing request to delete role binding")
+
+	deleted, err := s.dao.DeleteRoleBinding(s.db, project, subject_type, subject, request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if deleted == 0 {
+		err := errors.New(models.ErrorRoleBindingNotFound)
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Check that the roles granted within the project to the owner, or to the
+// token, grant the permission. The error names the missing permission.
+func (s *RoleService) Authorize(request_id string, owner string, token_id string, project string, permission string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "authorize", "request": request_id})
+
+	bindings, err := s.dao.GetRoleBindings(s.db, project, request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	for _, binding := range bindings {
+		granted := (binding.SubjectType == models.SubjectTypeUser && binding.Subject == owner) ||
+			(binding.SubjectType == models.SubjectTypeToken && len(token_id) > 0 && binding.Subject == token_id)
+		if granted && models.RoleGrants(binding.Role, permission) {
+			return nil
+		}
+	}
+
+	err = fmt.Errorf("%v '%v' on project '%v'", models.ErrorPermissionDenied, permission, project)
+	logger.Error(err)
+	return err
+}
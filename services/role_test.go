@@ -0,0 +1,137 @@
+package services_test
+
+import (
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Role", func() {
+
+	var (
+		rs             *RoleService
+		roleDao        *MemoryRoleDao
+		validRequestId string
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		roleDao = &MemoryRoleDao{}
+		rs = NewRoleService(roleDao, &sqlx.DB{})
+
+		app.GlobalServerConfig.Clouds = map[string]app.CloudProjectConfig{
+			"project": {Project: "project"},
+		}
+
+		_, err = rs.SetRoleBinding(validRequestId, &models.RoleBinding{Project: "project", SubjectType: models.SubjectTypeUser, Subject: "alice", Role: models.RoleViewer})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = rs.SetRoleBinding(validRequestId, &models.RoleBinding{Project: "project", SubjectType: models.SubjectTypeToken, Subject: "b7e3e0a2", Role: models.RoleOperator})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		app.GlobalServerConfig.Clouds = nil
+	})
+
+	Describe("Setting a role binding", func() {
+		Context("When the project is not configured", func() {
+			It("Should error", func() {
+				_, err = rs.SetRoleBinding(validRequestId, &models.RoleBinding{Project: "unknown", SubjectType: models.SubjectTypeUser, Subject: "alice", Role: models.RoleViewer})
+				Expect(err).To(MatchError(models.ErrorUnknownProject))
+			})
+		})
+
+		Context("When the role is unknown", func() {
+			It("Should error", func() {
+				_, err = rs.SetRoleBinding(validRequestId, &models.RoleBinding{Project: "project", SubjectType: models.SubjectTypeUser, Subject: "alice", Role: "owner"})
+				Expect(err).To(MatchError(models.ErrorInvalidRole))
+			})
+		})
+
+		Context("When the subject type is unknown", func() {
+			It("Should error", func() {
+				_, err = rs.SetRoleBinding(validRequestId, &models.RoleBinding{Project: "project", SubjectType: "group", Subject: "alice", Role: models.RoleViewer})
+				Expect(err).To(MatchError(models.ErrorInvalidSubjectType))
+			})
+		})
+	})
+
+	Describe("Authorizing a permission", func() {
+		Context("When the role of the user grants the permission", func() {
+			It("Should authorize", func() {
+				err = rs.Authorize(validRequestId, "alice", "", "project", models.PermissionReadClusters)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("When the role of the token grants the permission", func() {
+			It("Should authorize", func() {
+				err = rs.Authorize(validRequestId, "alice", "b7e3e0a2", "project", models.PermissionCreateClusters)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("When no role grants the permission", func() {
+			It("Should name the missing permission", func() {
+				err = rs.Authorize(validRequestId, "alice", "", "project", models.PermissionCreateClusters)
+				Expect(err).To(MatchError("missing permission 'clusters:create' on project 'project'"))
+			})
+		})
+
+		Context("When the role is granted within another project", func() {
+			It("Should not authorize", func() {
+				err = rs.Authorize(validRequestId, "alice", "", "other", models.PermissionReadClusters)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Deleting a role binding", func() {
+		Context("When the role binding does not exist", func() {
+			It("Should error", func() {
+				err = rs.DeleteRoleBinding(validRequestId, "project", models.SubjectTypeUser, "bob")
+				Expect(err).To(MatchError(models.ErrorRoleBindingNotFound))
+			})
+		})
+	})
+})
+
+type MemoryRoleDao struct {
+	bindings []models.RoleBinding
+}
+
+func (dao *MemoryRoleDao) SetRoleBinding(db *sqlx.DB, binding *models.RoleBinding, requestId string) (*models.RoleBinding, error) {
+	dao.DeleteRoleBinding(db, binding.Project, binding.SubjectType, binding.Subject, requestId)
+	dao.bindings = append(dao.bindings, *binding)
+	return binding, nil
+}
+
+func (dao *MemoryRoleDao) GetRoleBindings(db *sqlx.DB, project string, requestId string) ([]models.RoleBinding, error) {
+	bindings := []models.RoleBinding{}
+	for _, b := range dao.bindings {
+		if b.Project == project {
+			bindings = append(bindings, b)
+		}
+	}
+	return bindings, nil
+}
+
+func (dao *MemoryRoleDao) DeleteRoleBinding(db *sqlx.DB, project string, subject_type string, subject string, requestId string) (int64, error) {
+	kept := []models.RoleBinding{}
+	for _, b := range dao.bindings {
+		if b.Project != project || b.SubjectType != subject_type || b.Subject != subject {
+			kept = append(kept, b)
+		}
+	}
+	deleted := int64(len(dao.bindings) - len(kept))
+	dao.bindings = kept
+	return deleted, nil
+}
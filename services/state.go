@@ -0,0 +1,165 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	"github.com/kmacoskey/taos/terraform"
+	log "github.com/sirupsen/logrus"
+)
+
+type stateDao interface {
+	GetCluster(id string, requestId string) (*models.Cluster, error)
+	LockClusterState(id string, lock *models.StateLock, requestId string) (*models.StateLock, error)
+	UnlockClusterState(id string, lockId string, requestId string) (*models.StateLock, error)
+	UpdateClusterState(id string, lockId string, state []byte, requestId string) error
+}
+
+// Serves the terraform state of clusters to the terraform http backend of
+// the operations run against them
+type StateService struct {
+	dao    stateDao
+	secret string
+}
+
+func NewStateService(dao stateDao, config app.StateBackendConfig) (*StateService, error) {
+	if len(config.Secret) == 0 {
+		return nil, errors.New(models.ErrorMissingStateBackendSecret)
+	}
+
+	return &StateService{dao: dao, secret: config.Secret}, nil
+}
+
+// Basic auth credentials of the terraform state of the cluster. The
+// password is the hex encoded HMAC-SHA256 of the cluster id, granting
+// access to the state of that cluster alone.
+func ClusterStateCredentials(secret string, id string) (string, string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return id, hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backend terraform stores the state of the cluster in while running the
+// job, nil when taos is not configured to serve the state. The state is
+// locked on behalf of the job, so a lock it leaves behind is known to be
+// its own.
+func ClusterStateBackend(config app.StateBackendConfig, id string, job int64) *terraform.HttpBackend {
+	if len(config.Address) == 0 {
+		return nil
+	}
+
+	address := fmt.Sprintf("%s/cluster/%s/state", strings.TrimRight(config.Address, "/"), url.PathEscape(id))
+	username, password := ClusterStateCredentials(config.Secret, id)
+
+	return &terraform.HttpBackend{
+		Address:       address,
+		LockAddress:   fmt.Sprintf("%s?job=%d", address, job),
+		UnlockAddress: address,
+		Username:      username,
+		Password:      password,
+	}
+}
+
+func (s *StateService) AuthenticateClusterState(request_id string, id string, username string, password string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "authenticate_cluster_state", "request": request_id})
+
+	expected_username, expected_password := ClusterStateCredentials(s.secret, id)
+	if username != expected_username || !hmac.Equal([]byte(password), []byte(expected_password)) {
+		err := errors.New(models.ErrorInvalidStateCredentials)
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// The terraform state of the cluster, nil when it has none yet
+func (s *StateService) GetClusterState(request_id string, id string) ([]byte, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_cluster_state", "request": request_id})
+
+	cluster, err := s.dao.GetCluster(id, request_id)
+	if err == sql.ErrNoRows || (err == nil && cluster == nil) {
+		err = errors.New(models.ErrorClusterNotFound)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	return cluster.TerraformState, nil
+}
+
+// Replace the terraform state of the cluster, with the id of the lock
+// terraform holds on it while running an operation
+func (s *StateService) UpdateClusterState(request_id string, id string, lock_id string, state []byte) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "update_cluster_state", "request": request_id})
+
+	if !json.Valid(state) {
+		err := errors.New(models.ErrorInvalidClusterState)
+		logger.Error(err)
+		return err
+	}
+
+	err := s.dao.UpdateClusterState(id, lock_id, state, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("updated terraform state of cluster '%v'", id))
+
+	return nil
+}
+
+// Lock the terraform state of the cluster, returning the lock holding it
+// when it is already locked
+func (s *StateService) LockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "lock_cluster_state", "request": request_id})
+
+	if lock == nil || len(lock.ID) == 0 {
+		err := errors.New(models.ErrorInvalidStateLock)
+		logger.Error(err)
+		return nil, err
+	}
+
+	current, err := s.dao.LockClusterState(id, lock, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return current, err
+	}
+
+	logger.Info(fmt.Sprintf("locked terraform state of cluster '%v' for %v", id, lock.Operation))
+
+	return current, nil
+}
+
+// Unlock the terraform state of the cluster, returning the lock holding it
+// when it is locked by another lock
+func (s *StateService) UnlockClusterState(request_id string, id string, lock *models.StateLock) (*models.StateLock, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "unlock_cluster_state", "request": request_id})
+
+	if lock == nil || len(lock.ID) == 0 {
+		err := errors.New(models.ErrorInvalidStateLock)
+		logger.Error(err)
+		return nil, err
+	}
+
+	current, err := s.dao.UnlockClusterState(id, lock.ID, request_id)
+	if err != nil {
+		logger.Error(err.Error())
+		return current, err
+	}
+
+	logger.Info(fmt.Sprintf("unlocked terraform state of cluster '%v'", id))
+
+	return nil, nil
+}
@@ -0,0 +1,141 @@
+package services_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("State", func() {
+
+	var (
+		ss             *StateService
+		dao            *ValidClusterDao
+		cluster        *models.Cluster
+		config         app.StateBackendConfig
+		validRequestId string
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		config = app.StateBackendConfig{Address: "http://taos.example.com/", Secret: "secret"}
+
+		cluster = &models.Cluster{
+			Id:             "a19e2758-0ec5-11e8-ba89-0ed5f89f718b",
+			Status:         models.ClusterStatusProvisionStart,
+			TerraformState: []byte(`{"serial":1}`),
+		}
+		dao = NewValidClusterDao(map[string]*models.Cluster{cluster.Id: cluster})
+
+		ss, err = NewStateService(dao, config)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Creating a state service", func() {
+		Context("When there is no secret", func() {
+			It("Should error", func() {
+				_, err = NewStateService(dao, app.StateBackendConfig{Address: config.Address})
+				Expect(err).To(MatchError(models.ErrorMissingStateBackendSecret))
+			})
+		})
+	})
+
+	Describe("Configuring the state backend of a cluster", func() {
+		It("Should store the state of the cluster in taos", func() {
+			backend := ClusterStateBackend(config, cluster.Id, 3)
+			Expect(backend.Address).To(Equal("http://taos.example.com/cluster/a19e2758-0ec5-11e8-ba89-0ed5f89f718b/state"))
+			Expect(backend.LockAddress).To(Equal(backend.Address + "?job=3"))
+			Expect(backend.UnlockAddress).To(Equal(backend.Address))
+		})
+
+		It("Should authenticate with the credentials of the cluster", func() {
+			backend := ClusterStateBackend(config, cluster.Id, 3)
+			Expect(ss.AuthenticateClusterState(validRequestId, cluster.Id, backend.Username, backend.Password)).To(Succeed())
+		})
+
+		Context("When taos does not serve the state", func() {
+			It("Should not configure a backend", func() {
+				Expect(ClusterStateBackend(app.StateBackendConfig{}, cluster.Id, 3)).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Authenticating", func() {
+		Context("When the credentials are of another cluster", func() {
+			It("Should error", func() {
+				username, password := ClusterStateCredentials(config.Secret, "other")
+				err = ss.AuthenticateClusterState(validRequestId, cluster.Id, username, password)
+				Expect(err).To(MatchError(models.ErrorInvalidStateCredentials))
+
+				err = ss.AuthenticateClusterState(validRequestId, cluster.Id, cluster.Id, password)
+				Expect(err).To(MatchError(models.ErrorInvalidStateCredentials))
+			})
+		})
+
+		Context("When the credentials are derived from another secret", func() {
+			It("Should error", func() {
+				username, password := ClusterStateCredentials("other", cluster.Id)
+				err = ss.AuthenticateClusterState(validRequestId, cluster.Id, username, password)
+				Expect(err).To(MatchError(models.ErrorInvalidStateCredentials))
+			})
+		})
+	})
+
+	Describe("Getting the state", func() {
+		It("Should return the state of the cluster", func() {
+			state, err := ss.GetClusterState(validRequestId, cluster.Id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state).To(MatchJSON(`{"serial":1}`))
+		})
+
+		Context("When the cluster does not exist", func() {
+			It("Should error", func() {
+				_, err = ss.GetClusterState(validRequestId, "other")
+				Expect(err).To(MatchError(models.ErrorClusterNotFound))
+			})
+		})
+	})
+
+	Describe("Updating the state", func() {
+		It("Should store the state of the cluster", func() {
+			Expect(ss.UpdateClusterState(validRequestId, cluster.Id, "", []byte(`{"serial":2}`))).To(Succeed())
+			Expect(cluster.TerraformState).To(MatchJSON(`{"serial":2}`))
+		})
+
+		Context("When the state is not json", func() {
+			It("Should error", func() {
+				err = ss.UpdateClusterState(validRequestId, cluster.Id, "", []byte(`state`))
+				Expect(err).To(MatchError(models.ErrorInvalidClusterState))
+				Expect(cluster.TerraformState).To(MatchJSON(`{"serial":1}`))
+			})
+		})
+	})
+
+	Describe("Locking the state", func() {
+		It("Should refuse another lock with the lock holding the state", func() {
+			_, err = ss.LockClusterState(validRequestId, cluster.Id, &models.StateLock{ID: "lock-1"})
+			Expect(err).NotTo(HaveOccurred())
+
+			current, err := ss.LockClusterState(validRequestId, cluster.Id, &models.StateLock{ID: "lock-2"})
+			Expect(err).To(MatchError(models.ErrorClusterStateLocked))
+			Expect(current.ID).To(Equal("lock-1"))
+		})
+
+		Context("When the lock has no id", func() {
+			It("Should error", func() {
+				_, err = ss.LockClusterState(validRequestId, cluster.Id, &models.StateLock{})
+				Expect(err).To(MatchError(models.ErrorInvalidStateLock))
+
+				_, err = ss.UnlockClusterState(validRequestId, cluster.Id, &models.StateLock{})
+				Expect(err).To(MatchError(models.ErrorInvalidStateLock))
+			})
+		})
+	})
+})
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type templateDao interface {
+	CreateTemplate(db *sqlx.DB, template *models.Template, requestId string) (*models.Template, error)
+	GetTemplate(db *sqlx.DB, name string, version int, requestId string) (*models.Template, error)
+	GetTemplates(db *sqlx.DB, requestId string) ([]models.Template, error)
+	GetTemplateVersions(db *sqlx.DB, name string, requestId string) ([]models.Template, error)
+	DeleteTemplate(db *sqlx.DB, name string, version int, requestId string) (int64, error)
+}
+
+var templateNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Registers versioned terraform config templates and renders them with
+// variables into the config of a cluster
+type TemplateService struct {
+	dao templateDao
+	db  *sqlx.DB
+}
+
+func NewTemplateService(dao templateDao, db *sqlx.DB) *TemplateService {
+	return &TemplateService{dao, db}
+}
+
+// Register the template as the next version of its name. The config must
+// parse as a template and its variables must be uniquely named.
+func (s *TemplateService) CreateTemplate(request_id string, t *models.Template) (*models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "create_template", "request": request_id})
+	logger.Info("servicing request to create template")
+
+	if len(t.Name) == 0 {
+		err := errors.New(models.ErrorMissingTemplateName)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if !templateNameRegexp.MatchString(t.Name) {
+		err := errors.New(models.ErrorInvalidTemplateName)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(t.Config) == 0 {
+		err := errors.New(models.ErrorMissingTemplateConfig)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if _, err := parseTemplate(t); err != nil {
+		logger.Error(err)
+		return nil, errors.New(models.ErrorInvalidTemplate)
+	}
+
+	names := make(map[string]bool)
+	for _, variable := range t.Variables {
+		if len(variable.Name) == 0 || names[variable.Name] {
+			err := errors.New(models.ErrorInvalidTemplate)
+			logger.Error(fmt.Sprintf("%v: variable '%v' is unnamed or duplicated", err, variable.Name))
+			return nil, err
+		}
+		names[variable.Name] = true
+	}
+
+	return s.dao.CreateTemplate(s.db, t, request_id)
+}
+
+// A version of a template, the latest when version is 0
+func (s *TemplateService) GetTemplate(request_id string, name string, version int) (*models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_template", "request": request_id})
+	logger.Info("servicing request to get template")
+
+	if version < 0 {
+		err := errors.New(models.ErrorInvalidTemplateVersion)
+		logger.Error(err)
+		return nil, err
+	}
+
+	t, err := s.dao.GetTemplate(s.db, name, version, request_id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if t == nil {
+		err := errors.New(models.ErrorTemplateNotFound)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// The latest version of every template
+func (s *TemplateService) GetTemplates(request_id string) ([]models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_templates", "request": request_id})
+	logger.Info("servicing request to get templates")
+
+	return s.dao.GetTemplates(s.db, request_id)
+}
+
+// Every version of a template, latest first
+func (s *TemplateService) GetTemplateVersions(request_id string, name string) ([]models.Template, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_template_versions", "request": request_id})
+	logger.Info("servicing request to get template versions")
+
+	templates, err := s.dao.GetTemplateVersions(s.db, name, request_id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(templates) == 0 {
+		err := errors.New(models.ErrorTemplateNotFound)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// Delete a version of a template, or every version when version is 0.
+// Clusters created from the template keep their rendered config.
+func (s *TemplateService) DeleteTemplate(request_id string, name string, version int) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "delete_template", "request": request_id})
+	logger.Info("servicing request to delete template")
+
+	if version < 0 {
+		err := errors.New(models.ErrorInvalidTemplateVersion)
+		logger.Error(err)
+		return err
+	}
+
+	deleted, err := s.dao.DeleteTemplate(s.db, name, version, request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if deleted == 0 {
+		err := errors.New(models.ErrorTemplateNotFound)
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Render a version of a template, the latest when version is 0, into a
+// terraform config. Every declared variable without a default must be
+// given, variables the template does not declare are ignored. Strings are
+// rendered escaped for use within a json string, any other value is
+// rendered as json.
+func (s *TemplateService) RenderTemplate(request_id string, name string, version int, variables map[string]interface{}) (*models.Template, []byte, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "render_template", "request": request_id})
+	logger.Info("servicing request to render template")
+
+	t, err := s.GetTemplate(request_id, name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]interface{})
+	for _, variable := range t.Variables {
+		value, ok := variables[variable.Name]
+		if !ok {
+			value = variable.Default
+		}
+		if value == nil {
+			err := fmt.Errorf("%v '%v'", models.ErrorMissingTemplateVariable, variable.Name)
+			logger.Error(err)
+			return nil, nil, err
+		}
+
+		rendered, err := renderTemplateValue(value)
+		if err != nil {
+			logger.Error(err)
+			return nil, nil, err
+		}
+		values[variable.Name] = rendered
+	}
+
+	parsed, err := parseTemplate(t)
+	if err != nil {
+		logger.Error(err)
+		return nil, nil, errors.New(models.ErrorInvalidTemplate)
+	}
+
+	var config bytes.Buffer
+	if err := parsed.Execute(&config, values); err != nil {
+		logger.Error(err)
+		return nil, nil, errors.New(models.ErrorInvalidTemplate)
+	}
+
+	if !json.Valid(config.Bytes()) {
+		err := errors.New(models.ErrorInvalidRenderedTemplate)
+		logger.Error(err)
+		return nil, nil, err
+	}
+
+	return t, config.Bytes(), nil
+}
+
+func parseTemplate(t *models.Template) (*template.Template, error) {
+	return template.New(t.Name).Option("missingkey=error").Parse(t.Config)
+}
+
+func renderTemplateValue(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := value.(string); ok {
+		return string(encoded[1 : len(encoded)-1]), nil
+	}
+
+	return string(encoded), nil
+}
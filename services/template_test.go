@@ -0,0 +1,194 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Template", func() {
+
+	var (
+		ts             *TemplateService
+		templateDao    *MemoryTemplateDao
+		validRequestId string
+		template       *models.Template
+		config         []byte
+		rendered       map[string]interface{}
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		templateDao = &MemoryTemplateDao{}
+		ts = NewTemplateService(templateDao, &sqlx.DB{})
+
+		_, err = ts.CreateTemplate(validRequestId, &models.Template{
+			Name:   "gpdb-3node",
+			Config: `{"name":"{{.name}}","nodes":{{.nodes}},"tags":{{.tags}}}`,
+			Variables: models.TemplateVariables{
+				{Name: "name"},
+				{Name: "nodes", Default: 3},
+				{Name: "tags", Default: []string{}},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Creating a template", func() {
+		Context("When the name is invalid", func() {
+			It("Should error", func() {
+				_, err = ts.CreateTemplate(validRequestId, &models.Template{Name: "gpdb/3", Config: `{}`})
+				Expect(err).To(MatchError(models.ErrorInvalidTemplateName))
+			})
+		})
+
+		Context("When the config does not parse", func() {
+			It("Should error", func() {
+				_, err = ts.CreateTemplate(validRequestId, &models.Template{Name: "gpdb", Config: `{{.name`})
+				Expect(err).To(MatchError(models.ErrorInvalidTemplate))
+			})
+		})
+
+		Context("When a variable is declared twice", func() {
+			It("Should error", func() {
+				_, err = ts.CreateTemplate(validRequestId, &models.Template{
+					Name:      "gpdb",
+					Config:    `{}`,
+					Variables: models.TemplateVariables{{Name: "name"}, {Name: "name"}},
+				})
+				Expect(err).To(MatchError(models.ErrorInvalidTemplate))
+			})
+		})
+	})
+
+	Describe("Rendering a template", func() {
+		Context("When the required variables are given", func() {
+			BeforeEach(func() {
+				template, config, err = ts.RenderTemplate(validRequestId, "gpdb-3node", 0, map[string]interface{}{
+					"name": `a "quoted" name`,
+					"tags": []string{"foo"},
+				})
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return the template rendered", func() {
+				Expect(template.Version).To(Equal(1))
+				Expect(json.Unmarshal(config, &rendered)).To(Succeed())
+				Expect(rendered["name"]).To(Equal(`a "quoted" name`))
+				Expect(rendered["tags"]).To(Equal([]interface{}{"foo"}))
+			})
+			It("Should use the default of variables not given", func() {
+				Expect(json.Unmarshal(config, &rendered)).To(Succeed())
+				Expect(rendered["nodes"]).To(Equal(float64(3)))
+			})
+		})
+
+		Context("When a required variable is missing", func() {
+			It("Should error", func() {
+				_, _, err = ts.RenderTemplate(validRequestId, "gpdb-3node", 0, map[string]interface{}{})
+				Expect(err).To(MatchError(models.ErrorMissingTemplateVariable + " 'name'"))
+			})
+		})
+
+		Context("When a variable the template does not declare is given", func() {
+			It("Should ignore the variable", func() {
+				_, config, err = ts.RenderTemplate(validRequestId, "gpdb-3node", 0, map[string]interface{}{"name": "a", "foo": "b"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(config, &rendered)).To(Succeed())
+				Expect(rendered).NotTo(HaveKey("foo"))
+			})
+		})
+
+		Context("When the template does not render json", func() {
+			It("Should error", func() {
+				_, err = ts.CreateTemplate(validRequestId, &models.Template{
+					Name:      "broken",
+					Config:    `{"nodes":{{.nodes}}`,
+					Variables: models.TemplateVariables{{Name: "nodes"}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, _, err = ts.RenderTemplate(validRequestId, "broken", 0, map[string]interface{}{"nodes": 1})
+				Expect(err).To(MatchError(models.ErrorInvalidRenderedTemplate))
+			})
+		})
+
+		Context("When the template does not exist", func() {
+			It("Should error", func() {
+				_, _, err = ts.RenderTemplate(validRequestId, "gpdb-3node", 2, map[string]interface{}{"name": "a"})
+				Expect(err).To(MatchError(models.ErrorTemplateNotFound))
+			})
+		})
+	})
+
+	Describe("Deleting a template", func() {
+		Context("When the template does not exist", func() {
+			It("Should error", func() {
+				err = ts.DeleteTemplate(validRequestId, "foo", 0)
+				Expect(err).To(MatchError(models.ErrorTemplateNotFound))
+			})
+		})
+	})
+})
+
+type MemoryTemplateDao struct {
+	templates []models.Template
+}
+
+func (dao *MemoryTemplateDao) CreateTemplate(db *sqlx.DB, template *models.Template, requestId string) (*models.Template, error) {
+	created := *template
+	created.Version = len(dao.versions(template.Name)) + 1
+	dao.templates = append(dao.templates, created)
+	return &created, nil
+}
+
+func (dao *MemoryTemplateDao) GetTemplate(db *sqlx.DB, name string, version int, requestId string) (*models.Template, error) {
+	for _, t := range dao.versions(name) {
+		if version == 0 || t.Version == version {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dao *MemoryTemplateDao) GetTemplates(db *sqlx.DB, requestId string) ([]models.Template, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (dao *MemoryTemplateDao) GetTemplateVersions(db *sqlx.DB, name string, requestId string) ([]models.Template, error) {
+	return dao.versions(name), nil
+}
+
+func (dao *MemoryTemplateDao) DeleteTemplate(db *sqlx.DB, name string, version int, requestId string) (int64, error) {
+	kept := []models.Template{}
+	for _, t := range dao.templates {
+		if t.Name != name || (version != 0 && t.Version != version) {
+			kept = append(kept, t)
+		}
+	}
+	deleted := int64(len(dao.templates) - len(kept))
+	dao.templates = kept
+	return deleted, nil
+}
+
+// Versions of a template, latest first
+func (dao *MemoryTemplateDao) versions(name string) []models.Template {
+	versions := []models.Template{}
+	for i := len(dao.templates) - 1; i >= 0; i-- {
+		if dao.templates[i].Name == name {
+			versions = append(versions, dao.templates[i])
+		}
+	}
+	return versions
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type tokenDao interface {
+	CreateToken(db *sqlx.DB, token *models.Token, requestId string) error
+	GetTokenByHash(db *sqlx.DB, hash string, requestId string) (*models.Token, error)
+	GetTokens(db *sqlx.DB, requestId string) ([]models.Token, error)
+	DeleteToken(db *sqlx.DB, id string, requestId string) (int64, error)
+}
+
+// Random bytes in a token, which is high enough entropy for an unsalted
+// hash to be safe to store
+const tokenBytes = 32
+
+// Issues, authenticates and revokes the bearer tokens of API requests
+type TokenService struct {
+	dao        tokenDao
+	db         *sqlx.DB
+	adminToken string
+}
+
+func NewTokenService(dao tokenDao, db *sqlx.DB, admin_token string) *TokenService {
+	return &TokenService{dao, db, admin_token}
+}
+
+// Issue a new token for the owner. The token is only ever returned here,
+// only its hash is stored.
+func (s *TokenService) CreateToken(request_id string, owner string, description string, admin bool) (*models.Token, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "create_token", "request": request_id})
+	logger.Info("servicing request to create token")
+
+	if len(owner) == 0 {
+		err := errors.New(models.ErrorMissingTokenOwner)
+		logger.Error(err)
+		return nil, err
+	}
+
+	secret := make([]byte, tokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	token := &models.Token{
+		Id:          uuid.Must(uuid.NewRandom()).String(),
+		Owner:       owner,
+		Description: description,
+		Admin:       admin,
+		Timestamp:   time.Now(),
+		Secret:      hex.EncodeToString(secret),
+	}
+	token.Hash = hashToken(token.Secret)
+
+	err := s.dao.CreateToken(s.db, token, request_id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// The token presented by a request. The configured admin token
+// authenticates as an admin owned by models.AdminTokenOwner.
+func (s *TokenService) Authenticate(request_id string, secret string) (*models.Token, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "authenticate", "request": request_id})
+
+	if len(secret) == 0 {
+		err := errors.New(models.ErrorMissingToken)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if len(s.adminToken) > 0 && subtle.ConstantTimeCompare([]byte(secret), []byte(s.adminToken)) == 1 {
+		return &models.Token{Owner: models.AdminTokenOwner, Admin: true}, nil
+	}
+
+	token, err := s.dao.GetTokenByHash(s.db, hashToken(secret), request_id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+
+	if token == nil {
+		err := errors.New(models.ErrorInvalidToken)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *TokenService) GetTokens(request_id string) ([]models.Token, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_tokens", "request": request_id})
+	logger.Info("servicing request to get tokens")
+
+	return s.dao.GetTokens(s.db, request_id)
+}
+
+func (s *TokenService) DeleteToken(request_id string, id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "delete_token", "request": request_id})
+	logger.Info("servicing request to delete token")
+
+	deleted, err := s.dao.DeleteToken(s.db, id, request_id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	if deleted == 0 {
+		err := errors.New(models.ErrorTokenNotFound)
+		logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+func hashToken(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(digest[:])
+}
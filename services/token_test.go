@@ -0,0 +1,146 @@
+package services_test
+
+import (
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Token", func() {
+
+	var (
+		ts             *TokenService
+		tokenDao       *MemoryTokenDao
+		validRequestId string
+		adminToken     string
+		token          *models.Token
+		authenticated  *models.Token
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		validRequestId = "c12c2d58-2af0-11e8-b467-0ed5f89f718b"
+		adminToken = "configured-admin-token"
+		tokenDao = &MemoryTokenDao{}
+		ts = NewTokenService(tokenDao, &sqlx.DB{}, adminToken)
+
+		token, err = ts.CreateToken(validRequestId, "alice", "laptop", false)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Creating a token", func() {
+		It("Should return the token only once", func() {
+			Expect(token.Secret).NotTo(BeEmpty())
+			Expect(token.Owner).To(Equal("alice"))
+			Expect(tokenDao.tokens).To(HaveLen(1))
+			Expect(tokenDao.tokens[0].Secret).To(BeEmpty())
+		})
+
+		It("Should store only the hash of the token", func() {
+			Expect(tokenDao.tokens[0].Hash).NotTo(BeEmpty())
+			Expect(tokenDao.tokens[0].Hash).NotTo(Equal(token.Secret))
+		})
+
+		Context("When the owner is missing", func() {
+			It("Should error", func() {
+				_, err = ts.CreateToken(validRequestId, "", "", false)
+				Expect(err).To(MatchError(models.ErrorMissingTokenOwner))
+			})
+		})
+	})
+
+	Describe("Authenticating a token", func() {
+		Context("When the token was issued", func() {
+			It("Should return the owner of the token", func() {
+				authenticated, err = ts.Authenticate(validRequestId, token.Secret)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(authenticated.Owner).To(Equal("alice"))
+				Expect(authenticated.Admin).To(BeFalse())
+			})
+		})
+
+		Context("When the token is the configured admin token", func() {
+			It("Should authenticate as an admin", func() {
+				authenticated, err = ts.Authenticate(validRequestId, adminToken)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(authenticated.Owner).To(Equal(models.AdminTokenOwner))
+				Expect(authenticated.Admin).To(BeTrue())
+			})
+		})
+
+		Context("When the token is missing", func() {
+			It("Should error", func() {
+				_, err = ts.Authenticate(validRequestId, "")
+				Expect(err).To(MatchError(models.ErrorMissingToken))
+			})
+		})
+
+		Context("When the token was never issued", func() {
+			It("Should error", func() {
+				_, err = ts.Authenticate(validRequestId, "not-a-token")
+				Expect(err).To(MatchError(models.ErrorInvalidToken))
+			})
+		})
+
+		Context("When the token was revoked", func() {
+			It("Should error", func() {
+				err = ts.DeleteToken(validRequestId, token.Id)
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = ts.Authenticate(validRequestId, token.Secret)
+				Expect(err).To(MatchError(models.ErrorInvalidToken))
+			})
+		})
+	})
+
+	Describe("Deleting a token", func() {
+		Context("When the token does not exist", func() {
+			It("Should error", func() {
+				err = ts.DeleteToken(validRequestId, "unknown")
+				Expect(err).To(MatchError(models.ErrorTokenNotFound))
+			})
+		})
+	})
+})
+
+type MemoryTokenDao struct {
+	tokens []models.Token
+}
+
+func (dao *MemoryTokenDao) CreateToken(db *sqlx.DB, token *models.Token, requestId string) error {
+	stored := *token
+	stored.Secret = ""
+	dao.tokens = append(dao.tokens, stored)
+	return nil
+}
+
+func (dao *MemoryTokenDao) GetTokenByHash(db *sqlx.DB, hash string, requestId string) (*models.Token, error) {
+	for _, t := range dao.tokens {
+		if t.Hash == hash {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dao *MemoryTokenDao) GetTokens(db *sqlx.DB, requestId string) ([]models.Token, error) {
+	return dao.tokens, nil
+}
+
+func (dao *MemoryTokenDao) DeleteToken(db *sqlx.DB, id string, requestId string) (int64, error) {
+	kept := []models.Token{}
+	for _, t := range dao.tokens {
+		if t.Id != id {
+			kept = append(kept, t)
+		}
+	}
+	deleted := int64(len(dao.tokens) - len(kept))
+	dao.tokens = kept
+	return deleted, nil
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type webhookDao interface {
+	GetCluster(id string, requestId string) (*models.Cluster, error)
+	GetClusterWebhooks(id string, requestId string) ([]string, error)
+	CreateWebhookDeliveries(deliveries []models.WebhookDelivery, requestId string) error
+	ClaimWebhookDelivery(due time.Time, until time.Time, requestId string) (*models.WebhookDelivery, error)
+	RecordWebhookAttempt(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, requestId string) error
+	GetWebhookDeliveries(clusterId string, limit int, requestId string) ([]models.WebhookDelivery, error)
+}
+
+// Upper bound of the backoff between attempts of a delivery
+const maxWebhookBackoff = time.Hour
+
+// Time allowed for a webhook URL to respond to a single attempt
+const webhookRequestTimeout = 10 * time.Second
+
+// Time a claimed delivery is left to the instance attempting it, before
+// any instance may attempt it again
+const webhookClaimTimeout = 6 * webhookRequestTimeout
+
+// Delivers a signed payload to webhook URLs whenever a cluster moves to a
+// new status. Deliveries are persisted by the cluster store along with the
+// status change, before they are attempted, so that pending deliveries
+// survive a restart.
+type WebhookService struct {
+	dao         webhookDao
+	client      *http.Client
+	urls        []string
+	secret      string
+	maxAttempts int
+	interval    time.Duration
+}
+
+func NewWebhookService(dao webhookDao, config app.WebhookConfig) (*WebhookService, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "new_webhook_service", "request": nil})
+
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil || interval <= 0 {
+		err := fmt.Errorf("invalid webhook interval '%v'", config.Interval)
+		logger.Error(err)
+		return nil, err
+	}
+
+	if config.MaxAttempts <= 0 {
+		err := fmt.Errorf("invalid webhook max attempts '%v'", config.MaxAttempts)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return &WebhookService{
+		dao:         dao,
+		client:      &http.Client{Timeout: webhookRequestTimeout},
+		urls:        config.URLs,
+		secret:      config.Secret,
+		maxAttempts: config.MaxAttempts,
+		interval:    interval,
+	}, nil
+}
+
+// Dispatch pending deliveries every interval
+func (s *WebhookService) StartDelivering() {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "webhook_delivering", "request": nil})
+
+	go func() {
+		for _ = range time.NewTicker(s.interval).C {
+			request_id := uuid.Must(uuid.NewRandom()).String()
+			if err := s.DeliverPending(request_id); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+}
+
+// Persist a delivery of the expiration warning, as the event
+// expiration_warning, to every configured URL and to the URLs registered
+// with the cluster
+func (s *WebhookService) Notify(warning *models.ExpirationWarning, request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "enqueue_expiration_warning_webhooks", "request": request_id})
+
+	err := s.enqueue(warning.ClusterId, models.ExpirationWarningEvent, request_id, func(cluster *models.Cluster) models.WebhookPayload {
+		return models.WebhookPayload{
+			Event:     models.ExpirationWarningEvent,
+			Timestamp: time.Now(),
+			Cluster:   models.NewWebhookCluster(cluster, cluster.Status, cluster.Message),
+			Warning:   warning,
+		}
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+
+	return err
+}
+
+// Persist a delivery of the payload built for the cluster to every
+// configured URL and to the URLs registered with the cluster
+func (s *WebhookService) enqueue(cluster_id string, event string, request_id string, payload func(*models.Cluster) models.WebhookPayload) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "enqueue_webhooks", "request": request_id})
+
+	cluster_urls, err := s.dao.GetClusterWebhooks(cluster_id, request_id)
+	if err != nil {
+		return err
+	}
+
+	urls := append(append([]string{}, s.urls...), cluster_urls...)
+	if len(urls) == 0 {
+		return nil
+	}
+
+	cluster, err := s.dao.GetCluster(cluster_id, request_id)
+	if err != nil {
+		return err
+	}
+	if cluster == nil {
+		return errors.New(models.ErrorClusterNotFound)
+	}
+
+	body, err := json.Marshal(payload(cluster))
+	if err != nil {
+		return err
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	for _, url := range urls {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ClusterId: cluster_id,
+			Url:       url,
+			Event:     event,
+			Payload:   body,
+		})
+	}
+
+	logger.Info(fmt.Sprintf("enqueueing %v webhook deliveries of '%v' for cluster '%v'", len(deliveries), event, cluster_id))
+
+	return s.dao.CreateWebhookDeliveries(deliveries, request_id)
+}
+
+// Attempt every delivery that is due, claiming each in turn so instances
+// sharing the store never attempt the same delivery at once. A delivery
+// whose attempt cannot be recorded is left until its claim has passed,
+// without holding up the others.
+func (s *WebhookService) DeliverPending(request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "deliver_webhooks", "request": request_id})
+
+	// Deliveries retried meanwhile are left for the next interval
+	due := time.Now()
+	for {
+		delivery, err := s.dao.ClaimWebhookDelivery(due, time.Now().Add(webhookClaimTimeout), request_id)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		if delivery == nil {
+			return nil
+		}
+
+		if err := s.deliver(delivery, request_id); err != nil {
+			logger.Error(fmt.Sprintf("failed to record webhook delivery '%v': %v", delivery.Id, err))
+		}
+	}
+}
+
+// Make a single attempt at a delivery and record the outcome. The delivery
+// is retried with exponential backoff until it is accepted with a 2xx or
+// runs out of attempts.
+func (s *WebhookService) deliver(delivery *models.WebhookDelivery, request_id string) error {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "deliver_webhook", "request": request_id})
+
+	delivery.Attempts++
+	attempt := &models.WebhookAttempt{
+		DeliveryId: delivery.Id,
+		Attempt:    delivery.Attempts,
+		Timestamp:  time.Now(),
+	}
+
+	code, err := s.post(delivery)
+	attempt.ResponseCode = code
+	if err == nil && (code < 200 || code > 299) {
+		err = fmt.Errorf("webhook responded with status %v", code)
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = models.WebhookDeliveryDelivered
+	case delivery.Attempts >= s.maxAttempts:
+		attempt.Error = err.Error()
+		delivery.Status = models.WebhookDeliveryFailed
+		logger.Warn(fmt.Sprintf("giving up on webhook delivery '%v' to '%v' after %v attempts", delivery.Id, delivery.Url, delivery.Attempts))
+	default:
+		attempt.Error = err.Error()
+		delivery.NextAttempt = attempt.Timestamp.Add(s.backoff(delivery.Attempts))
+	}
+
+	return s.dao.RecordWebhookAttempt(delivery, attempt, request_id)
+}
+
+func (s *WebhookService) post(delivery *models.WebhookDelivery) (int, error) {
+	request, err := http.NewRequest("POST", delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Taos-Event", delivery.Event)
+	request.Header.Set("X-Taos-Delivery", strconv.FormatInt(delivery.Id, 10))
+	if len(s.secret) > 0 {
+		request.Header.Set("X-Taos-Signature", "sha256="+SignWebhookPayload(s.secret, delivery.Payload))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+
+	return response.StatusCode, nil
+}
+
+// Delay before the attempt following the given number of attempts
+func (s *WebhookService) backoff(attempts int) time.Duration {
+	backoff := s.interval
+	for i := 1; i < attempts && backoff < maxWebhookBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxWebhookBackoff {
+		backoff = maxWebhookBackoff
+	}
+	return backoff
+}
+
+// Refuse webhooks which are not absolute http or https urls, which could
+// never be delivered
+func checkWebhookUrls(urls []string) error {
+	for _, webhook := range urls {
+		parsed, err := url.Parse(webhook)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+			return fmt.Errorf("%v: '%v'", models.ErrorInvalidWebhookUrl, webhook)
+		}
+	}
+	return nil
+}
+
+// Hex encoded HMAC-SHA256 of the payload, receivers verify the
+// X-Taos-Signature header by computing the same with the shared secret
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) GetCluster(request_id string, id string) (*models.Cluster, error) {
+	return s.dao.GetCluster(id, request_id)
+}
+
+func (s *WebhookService) GetWebhookDeliveries(request_id string, cluster_id string, limit int) ([]models.WebhookDelivery, error) {
+	logger := log.WithFields(log.Fields{"package": "services", "event": "get_webhook_deliveries", "request": request_id})
+	logger.Info("servicing request to get webhook deliveries")
+
+	if limit < 0 || limit > models.MaxWebhookDeliveryLimit {
+		err := errors.New(models.ErrorInvalidWebhookLimit)
+		logger.Error(err)
+		return nil, err
+	}
+
+	return s.dao.GetWebhookDeliveries(cluster_id, limit, request_id)
+}
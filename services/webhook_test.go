@@ -0,0 +1,314 @@
+package services_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kmacoskey/taos/app"
+	"github.com/kmacoskey/taos/models"
+	. "github.com/kmacoskey/taos/services"
+)
+
+var _ = Describe("Webhook", func() {
+
+	var (
+		ws             *WebhookService
+		webhookDao     *MemoryWebhookDao
+		clusterDao     *ValidClusterDao
+		clustersMap    map[string]*models.Cluster
+		server         *httptest.Server
+		received       []*http.Request
+		receivedBodies [][]byte
+		responseCode   int
+		webhookConfig  app.WebhookConfig
+		validRequestId string
+		validClusterId string
+		validDelivery  models.WebhookDelivery
+		mutex          sync.Mutex
+		err            error
+	)
+
+	BeforeEach(func() {
+		log.SetLevel(log.FatalLevel)
+
+		received = []*http.Request{}
+		receivedBodies = [][]byte{}
+		responseCode = http.StatusOK
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			received = append(received, r)
+			receivedBodies = append(receivedBodies, body)
+			mutex.Unlock()
+			w.WriteHeader(responseCode)
+		}))
+
+		clustersMap = map[string]*models.Cluster{
+			"a19e2758-0ec5-11e8-ba89-0ed5f89f718b": &models.Cluster{
+				Id:     "a19e2758-0ec5-11e8-ba89-0ed5f89f718b",
+				Name:   "cluster",
+				Status: models.ClusterStatusProvisionSuccess,
+			},
+		}
+
+		webhookConfig = app.WebhookConfig{
+			URLs:        []string{server.URL},
+			Secret:      "secret",
+			MaxAttempts: 2,
+			Interval:    "1ms",
+		}
+
+		clusterDao = NewValidClusterDao(clustersMap)
+		webhookDao = NewMemoryWebhookDao(clusterDao)
+		validRequestId = "ff459ef4-514b-11e8-9c2d-fa7ae01bbebc"
+		validClusterId = "a19e2758-0ec5-11e8-ba89-0ed5f89f718b"
+		validDelivery = models.WebhookDelivery{
+			ClusterId: validClusterId,
+			Url:       server.URL,
+			Event:     models.ClusterStatusProvisionSuccess,
+			Payload:   []byte(`{"event":"provision_success"}`),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// ======================================================================
+	//
+	//  _ __   _____      __
+	// | '_ \ / _ \ \ /\ / /
+	// | | | |  __/\ V  V /
+	// |_| |_|\___| \_/\_/
+	//
+	// ======================================================================
+
+	Describe("Creating a webhook service", func() {
+		Context("When the interval is invalid", func() {
+			It("Should error", func() {
+				webhookConfig.Interval = "notaduration"
+				_, err = NewWebhookService(webhookDao, webhookConfig)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("When max attempts is not positive", func() {
+			It("Should error", func() {
+				webhookConfig.MaxAttempts = 0
+				_, err = NewWebhookService(webhookDao, webhookConfig)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	// ======================================================================
+	//
+	//   ___ _ __   __ _ _   _  ___ _   _  ___
+	//  / _ \ '_ \ / _` | | | |/ _ \ | | |/ _ \
+	// |  __/ | | | (_| | |_| |  __/ |_| |  __/
+	//  \___|_| |_|\__, |\__,_|\___|\__,_|\___|
+	//                |_|
+	//
+	// ======================================================================
+
+	Describe("Notifying an expiration warning", func() {
+		BeforeEach(func() {
+			clusterDao.webhooks = map[string][]string{validClusterId: []string{"http://cluster.example.com"}}
+			ws, err = NewWebhookService(webhookDao, webhookConfig)
+			Expect(err).NotTo(HaveOccurred())
+			err = ws.Notify(&models.ExpirationWarning{ClusterId: validClusterId, Threshold: "10m0s", ExtendUrl: "/cluster/a19e2758-0ec5-11e8-ba89-0ed5f89f718b/expiration"}, validRequestId)
+		})
+		It("Should enqueue a delivery of the warning to the configured and the cluster webhooks", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhookDao.deliveries).To(HaveLen(2))
+			Expect(webhookDao.deliveries[0].Url).To(Equal(server.URL))
+			Expect(webhookDao.deliveries[1].Url).To(Equal("http://cluster.example.com"))
+			Expect(webhookDao.deliveries[0].Event).To(Equal(models.ExpirationWarningEvent))
+			Expect(string(webhookDao.deliveries[0].Payload)).To(ContainSubstring(`"threshold":"10m0s"`))
+			Expect(string(webhookDao.deliveries[0].Payload)).To(ContainSubstring(`"extend_url":"/cluster/a19e2758-0ec5-11e8-ba89-0ed5f89f718b/expiration"`))
+		})
+	})
+
+	// ======================================================================
+	//
+	//      _      _ _
+	//   __| | ___| (_)_   _____ _ __
+	//  / _` |/ _ \ | \ \ / / _ \ '__|
+	// | (_| |  __/ | |\ V /  __/ |
+	//  \__,_|\___|_|_| \_/ \___|_|
+	//
+	// ======================================================================
+
+	Describe("Delivering pending webhooks", func() {
+		BeforeEach(func() {
+			ws, err = NewWebhookService(webhookDao, webhookConfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhookDao.CreateWebhookDeliveries([]models.WebhookDelivery{validDelivery}, validRequestId)).To(Succeed())
+		})
+
+		Context("When the webhook accepts the delivery", func() {
+			BeforeEach(func() {
+				err = ws.DeliverPending(validRequestId)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should POST the payload", func() {
+				Expect(received).To(HaveLen(1))
+				Expect(received[0].Method).To(Equal("POST"))
+				Expect(received[0].Header.Get("X-Taos-Event")).To(Equal(models.ClusterStatusProvisionSuccess))
+			})
+			It("Should sign the payload", func() {
+				signature := "sha256=" + SignWebhookPayload("secret", receivedBodies[0])
+				Expect(received[0].Header.Get("X-Taos-Signature")).To(Equal(signature))
+			})
+			It("Should mark the delivery delivered", func() {
+				Expect(webhookDao.deliveries[0].Status).To(Equal(models.WebhookDeliveryDelivered))
+				Expect(webhookDao.attempts).To(HaveLen(1))
+				Expect(webhookDao.attempts[0].ResponseCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("When the webhook keeps failing", func() {
+			BeforeEach(func() {
+				responseCode = http.StatusInternalServerError
+				Expect(ws.DeliverPending(validRequestId)).To(Succeed())
+			})
+			It("Should back off before the next attempt", func() {
+				Expect(webhookDao.deliveries[0].Status).To(Equal(models.WebhookDeliveryPending))
+				Expect(webhookDao.deliveries[0].NextAttempt).To(BeTemporally(">", webhookDao.attempts[0].Timestamp))
+				Expect(webhookDao.attempts[0].Error).NotTo(BeEmpty())
+			})
+			It("Should give up after the maximum attempts", func() {
+				time.Sleep(5 * time.Millisecond)
+				Expect(ws.DeliverPending(validRequestId)).To(Succeed())
+				Expect(webhookDao.deliveries[0].Status).To(Equal(models.WebhookDeliveryFailed))
+				Expect(webhookDao.attempts).To(HaveLen(2))
+
+				time.Sleep(5 * time.Millisecond)
+				Expect(ws.DeliverPending(validRequestId)).To(Succeed())
+				Expect(received).To(HaveLen(2))
+			})
+		})
+
+		Context("When the attempt at a delivery cannot be recorded", func() {
+			BeforeEach(func() {
+				Expect(webhookDao.CreateWebhookDeliveries([]models.WebhookDelivery{validDelivery}, validRequestId)).To(Succeed())
+				webhookDao.unrecorded = 1
+				err = ws.DeliverPending(validRequestId)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should attempt the deliveries after it", func() {
+				Expect(received).To(HaveLen(2))
+				Expect(webhookDao.deliveries[1].Status).To(Equal(models.WebhookDeliveryDelivered))
+			})
+		})
+
+		Context("When the delivery is claimed by another instance", func() {
+			BeforeEach(func() {
+				_, err = webhookDao.ClaimWebhookDelivery(time.Now(), time.Now().Add(time.Minute), validRequestId)
+				Expect(err).NotTo(HaveOccurred())
+				err = ws.DeliverPending(validRequestId)
+			})
+			It("Should not attempt the delivery", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(received).To(BeEmpty())
+				Expect(webhookDao.attempts).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Getting webhook deliveries", func() {
+		BeforeEach(func() {
+			ws, err = NewWebhookService(webhookDao, webhookConfig)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		Context("When the limit is too large", func() {
+			It("Should error", func() {
+				_, err = ws.GetWebhookDeliveries(validRequestId, "", 100000)
+				Expect(err).To(MatchError(models.ErrorInvalidWebhookLimit))
+			})
+		})
+		Context("When there are deliveries", func() {
+			It("Should return them", func() {
+				Expect(webhookDao.CreateWebhookDeliveries([]models.WebhookDelivery{validDelivery}, validRequestId)).To(Succeed())
+				deliveries, err := ws.GetWebhookDeliveries(validRequestId, validClusterId, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(deliveries).To(HaveLen(1))
+			})
+		})
+	})
+})
+
+// ======================================================================
+//                       _
+//  _ __ ___   ___   ___| | _____
+// | '_ ` _ \ / _ \ / __| |/ / __|
+// | | | | | | (_) | (__|   <\__ \
+// |_| |_| |_|\___/ \___|_|\_\___/
+//
+// ======================================================================
+
+type MemoryWebhookDao struct {
+	*ValidClusterDao
+	deliveries []*models.WebhookDelivery
+	attempts   []models.WebhookAttempt
+
+	// Id of a delivery whose attempts fail to be recorded
+	unrecorded int64
+}
+
+func NewMemoryWebhookDao(clusterDao *ValidClusterDao) *MemoryWebhookDao {
+	return &MemoryWebhookDao{ValidClusterDao: clusterDao}
+}
+
+func (dao *MemoryWebhookDao) CreateWebhookDeliveries(deliveries []models.WebhookDelivery, requestId string) error {
+	for _, delivery := range deliveries {
+		delivery := delivery
+		delivery.Id = int64(len(dao.deliveries) + 1)
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.NextAttempt = time.Now()
+		dao.deliveries = append(dao.deliveries, &delivery)
+	}
+	return nil
+}
+
+func (dao *MemoryWebhookDao) ClaimWebhookDelivery(due time.Time, until time.Time, requestId string) (*models.WebhookDelivery, error) {
+	for _, delivery := range dao.deliveries {
+		if delivery.Status == models.WebhookDeliveryPending && !delivery.NextAttempt.After(due) {
+			delivery.NextAttempt = until
+			claimed := *delivery
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dao *MemoryWebhookDao) RecordWebhookAttempt(delivery *models.WebhookDelivery, attempt *models.WebhookAttempt, requestId string) error {
+	if delivery.Id == dao.unrecorded {
+		return errors.New("foo")
+	}
+	dao.attempts = append(dao.attempts, *attempt)
+	*dao.deliveries[delivery.Id-1] = *delivery
+	return nil
+}
+
+func (dao *MemoryWebhookDao) GetWebhookDeliveries(clusterId string, limit int, requestId string) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range dao.deliveries {
+		if len(clusterId) == 0 || delivery.ClusterId == clusterId {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	return deliveries, nil
+}
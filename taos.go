@@ -3,14 +3,18 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
 	"github.com/kmacoskey/taos/app"
 	"github.com/kmacoskey/taos/daos"
 	"github.com/kmacoskey/taos/handlers"
 	"github.com/kmacoskey/taos/reaper"
 	"github.com/kmacoskey/taos/services"
+	"github.com/kmacoskey/taos/terraform"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -30,10 +34,97 @@ func main() {
 
 	defer db.Close()
 
+	// taos migrate up|down|status runs a single migrate command and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		command := ""
+		if len(os.Args) > 2 {
+			command = os.Args[2]
+		}
+		if err := RunMigrateCommand(db, command); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Refuses to start against a database migrated by a newer binary
+	if err := MigrateDatabase(db); err != nil {
+		panic(fmt.Errorf("Database Migration Failed: %s", err))
+	}
+
+	// Clusters and the webhook deliveries of their status changes are
+	//  stored in the configured backend, every other resource in postgres
+	var keyring *daos.Keyring
+	if len(app.GlobalServerConfig.Encryption.Keys) > 0 {
+		keyring, err = daos.NewKeyring(app.GlobalServerConfig.Encryption.Key, app.GlobalServerConfig.Encryption.Keys)
+		if err != nil {
+			panic(fmt.Errorf("Invalid encryption configuration: %s", err))
+		}
+	}
+
+	store, err := daos.NewClusterStore(app.GlobalServerConfig.ClusterStore.Backend, app.GlobalServerConfig.ClusterStore.Path, db, keyring, app.GlobalServerConfig.Webhooks.URLs)
+	if err != nil {
+		panic(fmt.Errorf("Invalid cluster store configuration: %s", err))
+	}
+
+	// taos rekey encrypts every cluster with the active key and exits
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		if err := RunRekeyCommand(store); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	webhooks, err := services.NewWebhookService(store, app.GlobalServerConfig.Webhooks)
+	if err != nil {
+		panic(fmt.Errorf("Invalid webhook configuration: %s", err))
+	}
+	webhooks.StartDelivering()
+
+	sinks, err := services.NewExpirationWarningSinks(app.GlobalServerConfig.ExpirationWarnings, webhooks)
+	if err != nil {
+		panic(fmt.Errorf("Invalid expiration warning configuration: %s", err))
+	}
+	warnings, err := services.NewExpirationWarningService(store, app.GlobalServerConfig.ExpirationWarnings, sinks, daos.NewLeaderLock(db, "expiration_warnings"))
+	if err != nil {
+		panic(fmt.Errorf("Invalid expiration warning configuration: %s", err))
+	}
+	warnings.StartWarning()
+
+	// Terraform run by the jobs stores the state of clusters through taos
+	if len(app.GlobalServerConfig.StateBackend.Address) > 0 {
+		if _, err := services.NewStateService(store, app.GlobalServerConfig.StateBackend); err != nil {
+			panic(fmt.Errorf("Invalid state backend configuration: %s", err))
+		}
+	}
+
+	jobs, err := services.NewJobService(store, services.NewClusterService(store), app.GlobalServerConfig.Jobs, func() services.TerraformClient {
+		return terraform.NewTerraformClient()
+	})
+	if err != nil {
+		panic(fmt.Errorf("Invalid job configuration: %s", err))
+	}
+	if err := jobs.StartWorking(); err != nil {
+		panic(fmt.Errorf("Failed to start job workers: %s", err))
+	}
+
 	router := mux.NewRouter()
-	handlers.ServeClusterResources(router, db)
+	handlers.ServeClusterResources(router, db, store)
+	handlers.ServeWebhookResources(router, db, store)
+	handlers.ServeTemplateResources(router, db)
+	handlers.ServeTokenResources(router, db)
+	handlers.ServeRoleResources(router, db)
+	handlers.ServeQuotaResources(router, db, store)
+	handlers.ServeReaperResources(router, db)
+	handlers.ServeStateResources(router, store)
 
-	reaper, _ := reaper.NewClusterReaper(app.GlobalServerConfig.ReapInterval, services.NewClusterService(daos.NewClusterDao(), db), db)
+	reaper, err := reaper.NewClusterReaper(app.GlobalServerConfig.ReapInterval, app.GlobalServerConfig.ReapConcurrency, services.NewClusterService(store), daos.NewReaperDao(), daos.NewLeaderLock(db, "reaper"), db)
+	if err != nil {
+		panic(fmt.Errorf("Invalid reaper configuration: %s", err))
+	}
 	reaper.StartReaping()
 
 	_ = StartHttpServer(router)
@@ -42,14 +133,62 @@ func main() {
 	//  Shutdown()
 }
 
+// Apply every migration the database is missing
+func MigrateDatabase(db *sqlx.DB) error {
+	migrations, err := services.NewMigrationService(daos.NewMigrationDao(), db, daos.Migrations)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrations.Up(uuid.Must(uuid.NewRandom()).String())
+	return err
+}
+
+// Run a migrate command and print the status of every migration
+func RunMigrateCommand(db *sqlx.DB, command string) error {
+	migrations, err := services.NewMigrationService(daos.NewMigrationDao(), db, daos.Migrations)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrations.Migrate(command, uuid.Must(uuid.NewRandom()).String())
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		applied := "pending"
+		if status.Applied {
+			applied = status.Time.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-30s  %s\n", status.Version, status.Name, applied)
+	}
+
+	return nil
+}
+
+// Encrypt every cluster not yet encrypted with the active key, such as
+// after the key is rotated
+func RunRekeyCommand(store daos.ClusterStore) error {
+	rekeyed, err := services.NewClusterService(store).RekeyClusters(uuid.Must(uuid.NewRandom()).String())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("rekeyed %d clusters\n", rekeyed)
+
+	return nil
+}
+
 func StartHttpServer(router *mux.Router) *http.Server {
 	logger := log.WithFields(log.Fields{"package": "taos", "event": "start_http", "request": ""})
 
+	// There is intentionally no WriteTimeout, it would end long-lived
+	//  event streams. Other routes are bounded by app.WithTimeout()
 	server := &http.Server{
 		Addr:           fmt.Sprintf(":%s", app.GlobalServerConfig.ServerPort),
 		Handler:        router,
 		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
 
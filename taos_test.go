@@ -57,17 +57,32 @@ var _ = Describe("Taos", func() {
 		server_port = app.GlobalServerConfig.ServerPort
 		// Run the server ListenAndServer in a go thread to allow for testing
 		app.GlobalServerConfig.BackgroundForTesting = true
+		// Requests authenticate with the admin token
+		if len(app.GlobalServerConfig.AdminToken) == 0 {
+			app.GlobalServerConfig.AdminToken = "taos-acceptance-admin-token"
+		}
 
 		db, err = app.DatabaseConnect(app.GlobalServerConfig.ConnStr)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(db).NotTo(BeNil())
 
+		Expect(MigrateDatabase(db)).To(Succeed())
+
 		// Ensure a clean table of clusters before testing
-		truncate_clusters := `TRUNCATE TABLE clusters`
+		truncate_clusters := `TRUNCATE TABLE clusters, cluster_jobs`
 		db.MustExec(truncate_clusters)
 
+		// Provisioning and destroying is run by the job workers
+		clusterDao := daos.NewClusterDao(db)
+		var jobs *services.JobService
+		jobs, err = services.NewJobService(clusterDao, services.NewClusterService(clusterDao), app.GlobalServerConfig.Jobs, func() services.TerraformClient {
+			return terraform.NewTerraformClient()
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(jobs.StartWorking()).To(Succeed())
+
 		router := mux.NewRouter()
-		handlers.ServeClusterResources(router, db)
+		handlers.ServeClusterResources(router, db, clusterDao)
 		server = StartHttpServer(router)
 
 		err = nil
@@ -203,7 +218,7 @@ var _ = Describe("Taos", func() {
 	Describe("Automatic reaping", func() {
 		Context("When everything goes ok", func() {
 			BeforeEach(func() {
-				reaper, _ := reaper.NewClusterReaper("5s", services.NewClusterService(daos.NewClusterDao(), db), db)
+				reaper, _ := reaper.NewClusterReaper("5s", 4, services.NewClusterService(daos.NewClusterDao(db)), daos.NewReaperDao(), daos.NewLeaderLock(db, "reaper"), db)
 				reaper.StartReaping()
 
 				response, body = httpClusterRequest("PUT", fmt.Sprintf("http://localhost:%s/cluster", server_port), valid_terraform_config)
@@ -236,6 +251,7 @@ func httpClusterRequest(request_type string, url string, body []byte) (*http.Res
 	req, err := http.NewRequest(request_type, url, bytes.NewBuffer(body))
 	Expect(err).NotTo(HaveOccurred())
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+app.GlobalServerConfig.AdminToken)
 	req.Close = true
 
 	client := &http.Client{}
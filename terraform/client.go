@@ -1,14 +1,18 @@
 package terraform
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -29,6 +33,13 @@ type Client struct {
 	Terraform     TerraformInfra
 	Command       TerraformCommandRunner
 	CommandConfig TerraformCommandConfig
+
+	// Optional - Receives the output of every terraform command as it runs
+	Log io.Writer
+
+	cancelMutex sync.Mutex
+	cancelled   chan struct{}
+	aborted     chan struct{}
 }
 
 type TerraformCommandConfig struct {
@@ -60,6 +71,14 @@ func (client *Client) SetState(state []byte) {
 	client.Terraform.State = state
 }
 
+func (client *Client) Variables() map[string]interface{} {
+	return client.Terraform.Variables
+}
+
+func (client *Client) SetVariables(variables map[string]interface{}) {
+	client.Terraform.Variables = variables
+}
+
 func (client *Client) SetProject(project string) {
 	client.CommandConfig.Project = project
 }
@@ -84,11 +103,102 @@ func (client *Client) Credentials() string {
 	return client.CommandConfig.Credentials
 }
 
+// Store the state in the backend instead of the state file
+func (client *Client) SetBackend(backend *HttpBackend) {
+	client.Terraform.Backend = backend
+}
+
+func (client *Client) Backend() *HttpBackend {
+	return client.Terraform.Backend
+}
+
+func (client *Client) SetLog(output io.Writer) {
+	client.Log = output
+}
+
+// Closed once the client is cancelled
+func (client *Client) cancel() chan struct{} {
+	client.cancelMutex.Lock()
+	defer client.cancelMutex.Unlock()
+
+	if client.cancelled == nil {
+		client.cancelled = make(chan struct{})
+	}
+
+	return client.cancelled
+}
+
+// Cancel an apply, or a plan for one, which has not finished. A running
+// apply is interrupted, and an apply which has not started yet is never
+// run. Destroying is never cancelled, so the changes an apply made can
+// still be destroyed with the client, only aborted.
+func (client *Client) Cancel() {
+	cancelled := client.cancel()
+
+	client.cancelMutex.Lock()
+	defer client.cancelMutex.Unlock()
+
+	// Cancelling more than once has no further effect
+	select {
+	case <-cancelled:
+	default:
+		close(cancelled)
+	}
+}
+
+func (client *Client) Cancelled() bool {
+	select {
+	case <-client.cancel():
+		return true
+	default:
+		return false
+	}
+}
+
+// Closed once the client is aborted
+func (client *Client) abort() chan struct{} {
+	client.cancelMutex.Lock()
+	defer client.cancelMutex.Unlock()
+
+	if client.aborted == nil {
+		client.aborted = make(chan struct{})
+	}
+
+	return client.aborted
+}
+
+// Abort every terraform command which has not finished, destroying
+// included, for an operation which may no longer run, such as one taken
+// over by another worker. The client is cancelled as well, and no later
+// command of the client is run.
+func (client *Client) Abort() {
+	client.Cancel()
+	aborted := client.abort()
+
+	client.cancelMutex.Lock()
+	defer client.cancelMutex.Unlock()
+
+	select {
+	case <-aborted:
+	default:
+		close(aborted)
+	}
+}
+
+func (client *Client) Aborted() bool {
+	select {
+	case <-client.abort():
+		return true
+	default:
+		return false
+	}
+}
+
 func (client *Client) Version() (string, error) {
 	err, stdout, stderr := client.Command.Run("",
 		[]string{
 			"-v",
-		}, client.Project(), client.Region(), client.Credentials())
+		}, client.Project(), client.Region(), client.Credentials(), client.Log, nil)
 
 	if err != nil {
 		return "", fmt.Errorf("Failed to retrieve version.\nError: %s\nOutput: %s", err, stderr)
@@ -132,13 +242,16 @@ func (client *Client) ClientInit() error {
 		return err
 	}
 
-	// Create temporary working directory
-	wd, err := ioutil.TempDir("", "terraform_client_workingdir")
-	if err != nil {
-		logger.Error(err.Error())
-		return err
+	// Create the temporary working directory once, every command of the
+	//  client runs in it until the client is destroyed
+	if len(client.Terraform.WorkingDir) == 0 {
+		wd, err := ioutil.TempDir("", "terraform_client_workingdir")
+		if err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+		client.Terraform.WorkingDir = wd
 	}
-	client.Terraform.WorkingDir = wd
 
 	// Set a name for the plan file
 	client.Terraform.PlanFileName = "terraform.plan"
@@ -149,20 +262,53 @@ func (client *Client) ClientInit() error {
 	// Set a name for the state file
 	client.Terraform.StateFileName = "terraform.tfstate"
 
+	// Set a name for the variables file
+	client.Terraform.VariablesFileName = "terraform.tfvars.json"
+
+	// Set names for the backend block and the backend config files
+	client.Terraform.BackendFileName = "backend.tf"
+	client.Terraform.BackendConfigFileName = "backend.hcl"
+
 	// Write Config content to config file only if there is content to write
 	if len(client.Terraform.Config) > 0 {
 		configfile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.ConfigFileName)
-		err = ioutil.WriteFile(configfile, client.Terraform.Config, 0666)
+		err := ioutil.WriteFile(configfile, client.Terraform.Config, 0666)
 		if err != nil {
 			logger.Error(err.Error())
 			return err
 		}
 	}
 
-	// Write State content to state file only if there is content to write
-	if len(client.Terraform.State) > 0 {
+	// Write State content to state file only if there is content to write,
+	//  a backend already holds the state
+	if len(client.Terraform.State) > 0 && client.Terraform.Backend == nil {
 		statefile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.StateFileName)
-		err = ioutil.WriteFile(statefile, client.Terraform.State, 0666)
+		err := ioutil.WriteFile(statefile, client.Terraform.State, 0666)
+		if err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	// Write Variables to the variables file only if there are variables to
+	//  write. The file may hold sensitive values and is readable only by
+	//  the owner.
+	if len(client.Terraform.Variables) > 0 {
+		variables, err := json.Marshal(client.Terraform.Variables)
+		if err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+
+		err = ioutil.WriteFile(client.variablesFile(), variables, 0600)
+		if err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+	}
+
+	if client.Terraform.Backend != nil {
+		err := client.writeBackend()
 		if err != nil {
 			logger.Error(err.Error())
 			return err
@@ -172,13 +318,95 @@ func (client *Client) ClientInit() error {
 	return nil
 }
 
+// Declare the http backend in the working directory. Its addresses and
+// credentials are written to a separate file passed to init, readable only
+// by the owner.
+func (client *Client) writeBackend() error {
+	backendfile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.BackendFileName)
+	err := ioutil.WriteFile(backendfile, []byte("terraform {\n  backend \"http\" {}\n}\n"), 0666)
+	if err != nil {
+		return err
+	}
+
+	backend := client.Terraform.Backend
+	config := ""
+	for _, setting := range [][]string{
+		{"address", backend.Address},
+		{"lock_address", backend.LockAddress},
+		{"unlock_address", backend.UnlockAddress},
+		{"username", backend.Username},
+		{"password", backend.Password},
+	} {
+		if len(setting[1]) > 0 {
+			config += fmt.Sprintf("%s = %s\n", setting[0], strconv.Quote(setting[1]))
+		}
+	}
+
+	return ioutil.WriteFile(client.backendConfigFile(), []byte(config), 0600)
+}
+
+func (client *Client) backendConfigFile() string {
+	return filepath.Join(client.Terraform.WorkingDir, client.Terraform.BackendConfigFileName)
+}
+
+func (client *Client) stateFile() string {
+	return filepath.Join(client.Terraform.WorkingDir, client.Terraform.StateFileName)
+}
+
+// Arguments passing the state file to commands which do not read the state
+// through the backend, none when the state is stored in a backend
+func (client *Client) stateArgs() []string {
+	if client.Terraform.Backend != nil {
+		return []string{}
+	}
+
+	return []string{fmt.Sprintf("-state=%s", client.stateFile())}
+}
+
+// The state terraform wrote to the state file, nil when terraform wrote
+// the state to the backend instead
+func (client *Client) readState() ([]byte, error) {
+	if client.Terraform.Backend != nil {
+		return nil, nil
+	}
+
+	return ioutil.ReadFile(client.stateFile())
+}
+
+func (client *Client) variablesFile() string {
+	return filepath.Join(client.Terraform.WorkingDir, client.Terraform.VariablesFileName)
+}
+
+// Arguments passing the variables file to commands which evaluate the
+// config, the plan file carries the variables on to apply
+func (client *Client) variablesArgs() []string {
+	if len(client.Terraform.Variables) == 0 {
+		return []string{}
+	}
+
+	return []string{fmt.Sprintf("-var-file=%s", client.variablesFile())}
+}
+
+// Remove the working directory of the client, which holds the variables
+// and backend credentials of the cluster. Nothing is done for a client
+// which has not created one, a later command creates a new one.
 func (client *Client) ClientDestroy() error {
+	if len(client.Terraform.WorkingDir) == 0 {
+		return nil
+	}
+
 	_, err := os.Stat(client.Terraform.WorkingDir)
 	if os.IsNotExist(err) {
 		return errors.New(ErrorClientDestroyNoDir)
 	}
 
-	return os.RemoveAll(client.Terraform.WorkingDir)
+	err = os.RemoveAll(client.Terraform.WorkingDir)
+	if err != nil {
+		return err
+	}
+
+	client.Terraform.WorkingDir = ""
+	return nil
 }
 
 func (client *Client) Init() (string, error) {
@@ -191,7 +419,12 @@ func (client *Client) Init() (string, error) {
 		"init",
 		"-input=false",
 		"-get=true",
-		"-backend=false",
+	}
+
+	if client.Terraform.Backend != nil {
+		initArgs = append(initArgs, fmt.Sprintf("-backend-config=%s", client.backendConfigFile()))
+	} else {
+		initArgs = append(initArgs, "-backend=false")
 	}
 
 	initArgs = append(initArgs, client.Terraform.WorkingDir)
@@ -200,7 +433,8 @@ func (client *Client) Init() (string, error) {
 		initArgs,
 		client.Project(),
 		client.Region(),
-		client.Credentials())
+		client.Credentials(),
+		client.Log, nil)
 
 	if err != nil {
 		return "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
@@ -235,17 +469,33 @@ func (client *Client) Plan(destroy bool) (string, error) {
 		planArgs = append(planArgs, "-destroy")
 	}
 
+	planArgs = append(planArgs, client.variablesArgs()...)
+
 	client.Terraform.PlanFile = filepath.Join(client.Terraform.WorkingDir, client.Terraform.PlanFileName)
 
 	planArgs = append(planArgs, fmt.Sprintf("-out=%s", client.Terraform.PlanFile))
 	planArgs = append(planArgs, client.Terraform.WorkingDir)
 
+	// Only a plan to apply is interrupted when the client is cancelled, a
+	//  plan to destroy only once it is aborted
+	interrupt := client.abort()
+	if !destroy {
+		interrupt = client.cancel()
+	}
+
 	err, stdout, stderr := client.Command.Run(client.Terraform.WorkingDir, planArgs,
 		client.Project(),
 		client.Region(),
-		client.Credentials())
+		client.Credentials(),
+		client.Log, interrupt)
 
 	if err != nil {
+		if !destroy && client.Cancelled() {
+			return "", errors.New(ErrorPlanCancelled)
+		}
+		if destroy && client.Aborted() {
+			return "", errors.New(ErrorDestroyAborted)
+		}
 		return "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
 	}
 
@@ -255,6 +505,9 @@ func (client *Client) Plan(destroy bool) (string, error) {
 func (client *Client) Apply() ([]byte, string, error) {
 	_, err := client.Plan(false)
 	if err != nil {
+		if client.Cancelled() {
+			return nil, "", errors.New(ErrorApplyCancelled)
+		}
 		return nil, "", err
 	}
 
@@ -266,18 +519,28 @@ func (client *Client) Apply() ([]byte, string, error) {
 
 	applyArgs = append(applyArgs, client.Terraform.PlanFile)
 
+	if client.Cancelled() {
+		return nil, "", errors.New(ErrorApplyCancelled)
+	}
+
 	err, stdout, stderr := client.Command.Run(client.Terraform.WorkingDir, applyArgs,
 		client.Project(),
 		client.Region(),
-		client.Credentials())
+		client.Credentials(),
+		client.Log, client.cancel())
 
 	if err != nil {
-		return nil, "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
+		// An interrupted apply may already have changed resources, return
+		//  whatever state terraform wrote so they can still be destroyed
+		state, _ := client.readState()
+		if client.Cancelled() {
+			return state, "", errors.New(ErrorApplyCancelled)
+		}
+		return state, "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
 	}
 
 	// Read the state file in order to return its contents
-	statefile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.StateFileName)
-	state, err := ioutil.ReadFile(statefile)
+	state, err := client.readState()
 	if err != nil {
 		return nil, "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
 	}
@@ -296,22 +559,32 @@ func (client *Client) Destroy() ([]byte, string, error) {
 		"-force",
 	}
 
-	statefile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.StateFileName)
-
-	destroyArgs = append(destroyArgs, fmt.Sprintf("-state=%s", statefile))
+	destroyArgs = append(destroyArgs, client.stateArgs()...)
+	destroyArgs = append(destroyArgs, client.variablesArgs()...)
 	destroyArgs = append(destroyArgs, client.Terraform.WorkingDir)
 
+	if client.Aborted() {
+		return nil, "", errors.New(ErrorDestroyAborted)
+	}
+
 	err, stdout, stderr := client.Command.Run(client.Terraform.WorkingDir, destroyArgs,
 		client.Project(),
 		client.Region(),
-		client.Credentials())
+		client.Credentials(),
+		client.Log, client.abort())
 
 	if err != nil {
+		// An interrupted destroy may already have destroyed resources,
+		//  return whatever state terraform wrote
+		if client.Aborted() {
+			state, _ := client.readState()
+			return state, "", errors.New(ErrorDestroyAborted)
+		}
 		return nil, "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
 	}
 
 	// Read the state file in order to return its contents
-	state, err := ioutil.ReadFile(statefile)
+	state, err := client.readState()
 	if err != nil {
 		return nil, "", errors.New(fmt.Sprint(fmt.Sprint(err) + ": " + stderr))
 	}
@@ -332,14 +605,14 @@ func (client *Client) Outputs() (string, error) {
 		"-json",
 	}
 
-	statefile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.StateFileName)
-
-	outputsArgs = append(outputsArgs, fmt.Sprintf("-state=%s", statefile))
+	outputsArgs = append(outputsArgs, client.stateArgs()...)
 
+	// Outputs include sensitive values, they are never written to the log
 	err, stdout, stderr := client.Command.Run(client.Terraform.WorkingDir, outputsArgs,
 		client.Project(),
 		client.Region(),
-		client.Credentials())
+		client.Credentials(),
+		nil, nil)
 
 	if err != nil {
 
@@ -2,8 +2,11 @@ package terraform_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 
@@ -126,6 +129,93 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("When the client was already initialized", func() {
+			It("Should reuse the working directory", func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				Expect(client.ClientInit()).To(Succeed())
+				wd := client.Terraform.WorkingDir
+
+				client.SetState(validTerraformState)
+				Expect(client.ClientInit()).To(Succeed())
+				Expect(client.Terraform.WorkingDir).To(Equal(wd))
+				Expect(filepath.Join(wd, client.Terraform.StateFileName)).To(BeARegularFile())
+				Expect(client.ClientDestroy()).To(Succeed())
+			})
+		})
+
+		Context("With Variables", func() {
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.SetVariables(map[string]interface{}{"nodes": 3, "password": "secret"})
+				err = client.ClientInit()
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should write the Variables to a variables file in the working directory", func() {
+				variablesfile := filepath.Join(client.Terraform.WorkingDir, "terraform.tfvars.json")
+				variables, readerr := ioutil.ReadFile(variablesfile)
+				Expect(readerr).NotTo(HaveOccurred())
+				Expect(variables).To(MatchJSON(`{"nodes":3,"password":"secret"}`))
+			})
+		})
+
+		Context("With a state backend", func() {
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.SetState(validTerraformState)
+				client.SetBackend(&HttpBackend{Address: "http://taos/cluster/foo/state", Username: "foo", Password: "secret"})
+				err = client.ClientInit()
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should declare the http backend in the working directory", func() {
+				backendfile := filepath.Join(client.Terraform.WorkingDir, "backend.tf")
+				backend, readerr := ioutil.ReadFile(backendfile)
+				Expect(readerr).NotTo(HaveOccurred())
+				Expect(string(backend)).To(ContainSubstring(`backend "http"`))
+			})
+			It("Should write the backend config readable only by the owner", func() {
+				configfile := filepath.Join(client.Terraform.WorkingDir, "backend.hcl")
+				config, readerr := ioutil.ReadFile(configfile)
+				Expect(readerr).NotTo(HaveOccurred())
+				Expect(string(config)).To(ContainSubstring(`address = "http://taos/cluster/foo/state"`))
+				Expect(string(config)).To(ContainSubstring(`password = "secret"`))
+
+				info, staterr := os.Stat(configfile)
+				Expect(staterr).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			})
+			It("Should not create a state file in the working directory", func() {
+				statefile := filepath.Join(client.Terraform.WorkingDir, client.Terraform.StateFileName)
+				Expect(statefile).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("With no Variables", func() {
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				err = client.ClientInit()
+			})
+			It("Should not create a variables file", func() {
+				variablesfile := filepath.Join(client.Terraform.WorkingDir, "terraform.tfvars.json")
+				Expect(variablesfile).NotTo(BeAnExistingFile())
+			})
+		})
+
 		Context("With no Terraform config", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -232,6 +322,29 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("When the client has not been initialized", func() {
+			It("Should do nothing", func() {
+				Expect(client.ClientDestroy()).To(Succeed())
+			})
+		})
+
+		Context("When the client is initialized again", func() {
+			It("Should create a new working directory", func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				Expect(client.ClientInit()).To(Succeed())
+				wd := client.Terraform.WorkingDir
+				Expect(client.ClientDestroy()).To(Succeed())
+
+				Expect(client.ClientInit()).To(Succeed())
+				Expect(client.Terraform.WorkingDir).NotTo(Equal(wd))
+				Expect(client.Terraform.WorkingDir).To(BeADirectory())
+				Expect(client.ClientDestroy()).To(Succeed())
+			})
+		})
+
 		Context("When there is no working directory", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -285,6 +398,46 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("With a state backend", func() {
+			var command *SuccessfulTerraformCommand
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.SetBackend(&HttpBackend{Address: "http://taos/cluster/foo/state"})
+				command = new(SuccessfulTerraformCommand)
+				client.Command = command
+				stdout, err = client.Init()
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should initialize the backend with the backend config", func() {
+				configfile := filepath.Join(client.Terraform.WorkingDir, "backend.hcl")
+				Expect(command.Args[0]).To(ContainElement("-backend-config=" + configfile))
+				Expect(command.Args[0]).NotTo(ContainElement("-backend=false"))
+			})
+		})
+
+		Context("When a log is set", func() {
+			var output bytes.Buffer
+			BeforeEach(func() {
+				output.Reset()
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(invalidTerraformConfig)
+				client.SetLog(&output)
+				client.Command = new(FailingTerraformCommand)
+				stdout, err = client.Init()
+			})
+			It("Should write the output of the failed command to the log", func() {
+				Expect(err).To(HaveOccurred())
+				Expect(output.String()).To(ContainSubstring(ErrorInvalidConfig))
+			})
+		})
+
 		Context("With invalid Terraform config", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -370,6 +523,27 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("With Variables", func() {
+			var command *SuccessfulTerraformCommand
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.SetVariables(map[string]interface{}{"nodes": 3})
+				command = new(SuccessfulTerraformCommand)
+				client.Command = command
+				stdout, err = client.Plan(false)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should plan with the variables file", func() {
+				variablesfile := filepath.Join(client.Terraform.WorkingDir, "terraform.tfvars.json")
+				Expect(command.Args[len(command.Args)-1]).To(ContainElement("-var-file=" + variablesfile))
+			})
+		})
+
 		Context("With invalid Terraform config", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -495,6 +669,28 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("When the client is cancelled while planning", func() {
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				command := &InterruptedTerraformCommand{Started: make(chan struct{}), Plan: true}
+				client.Command = command
+				go func() {
+					<-command.Started
+					client.Cancel()
+				}()
+				stdout, err = client.Plan(false)
+			})
+			It("Should return the expected error message", func() {
+				Expect(err).To(MatchError(ErrorPlanCancelled))
+			})
+			It("Should not return command stdout", func() {
+				Expect(stdout).To(BeEmpty())
+			})
+		})
+
 		Context("With valid Terraform config and no Terraform state", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -569,6 +765,70 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("With a state backend", func() {
+			var command *SuccessfulTerraformCommand
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.SetBackend(&HttpBackend{Address: "http://taos/cluster/foo/state"})
+				command = new(SuccessfulTerraformCommand)
+				client.Command = command
+				state, stdout, err = client.Apply()
+			})
+			It("Should not error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("Should not return the state terraform wrote to the backend", func() {
+				Expect(state).To(BeNil())
+			})
+		})
+
+		Context("When the client is cancelled before applying", func() {
+			var command *SuccessfulTerraformCommand
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				command = new(SuccessfulTerraformCommand)
+				client.Command = command
+				client.Cancel()
+				state, stdout, err = client.Apply()
+			})
+			It("Should return the expected error message", func() {
+				Expect(err).To(MatchError(ErrorApplyCancelled))
+			})
+			It("Should not apply", func() {
+				for _, args := range command.Args {
+					Expect(args[0]).NotTo(Equal("apply"))
+				}
+			})
+		})
+
+		Context("When the client is cancelled while applying", func() {
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				command := &InterruptedTerraformCommand{Started: make(chan struct{})}
+				client.Command = command
+				go func() {
+					<-command.Started
+					client.Cancel()
+				}()
+				state, stdout, err = client.Apply()
+			})
+			It("Should return the expected error message", func() {
+				Expect(err).To(MatchError(ErrorApplyCancelled))
+			})
+			It("Should return the state written before the interruption", func() {
+				Expect(state).To(Equal([]byte(`{"partial":true}`)))
+			})
+		})
+
 		Context("With no Terraform config", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -750,6 +1010,29 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("With Variables", func() {
+			var command *SuccessfulTerraformCommand
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.SetState(validTerraformState)
+				client.SetVariables(map[string]interface{}{"nodes": 3})
+				command = new(SuccessfulTerraformCommand)
+				client.Command = command
+				state, stdout, err = client.Destroy()
+			})
+			It("Should not error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+			It("Should destroy with the same variables file", func() {
+				variablesfile := filepath.Join(client.Terraform.WorkingDir, "terraform.tfvars.json")
+				Expect(command.Args[len(command.Args)-1][0]).To(Equal("destroy"))
+				Expect(command.Args[len(command.Args)-1]).To(ContainElement("-var-file=" + variablesfile))
+			})
+		})
+
 		Context("With no Terraform config", func() {
 			BeforeEach(func() {
 				client.SetProject(validProject)
@@ -893,6 +1176,55 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("When the client is cancelled while destroying", func() {
+			It("Should not interrupt the destroy", func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				client.Command = new(SuccessfulTerraformCommand)
+				client.Cancel()
+				state, stdout, err = client.Destroy()
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("When the client is aborted while destroying", func() {
+			BeforeEach(func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				command := &InterruptedTerraformCommand{Started: make(chan struct{}), Destroy: true}
+				client.Command = command
+				go func() {
+					<-command.Started
+					client.Abort()
+				}()
+				state, stdout, err = client.Destroy()
+			})
+			It("Should return the expected error message", func() {
+				Expect(err).To(MatchError(ErrorDestroyAborted))
+			})
+		})
+
+		Context("When the client is aborted before destroying", func() {
+			It("Should not destroy", func() {
+				client.SetProject(validProject)
+				client.SetRegion(validRegion)
+				client.SetCredentials(validCredentials)
+				client.SetConfig(validTerraformConfig)
+				command := new(SuccessfulTerraformCommand)
+				client.Command = command
+				client.Abort()
+				state, stdout, err = client.Destroy()
+				Expect(err).To(MatchError(ErrorDestroyAborted))
+				for _, args := range command.Args {
+					Expect(args[0]).NotTo(Equal("destroy"))
+				}
+			})
+		})
+
 	})
 
 	// ======================================================================
@@ -980,9 +1312,13 @@ type SuccessfulTerraformCommand struct {
 	Project     string
 	Region      string
 	Credentials string
+
+	// Arguments of every command run
+	Args [][]string
 }
 
-func (tc *SuccessfulTerraformCommand) Run(directory string, args []string, project string, region string, credentials string) (error, string, string) {
+func (tc *SuccessfulTerraformCommand) Run(directory string, args []string, project string, region string, credentials string, output io.Writer, interrupt <-chan struct{}) (error, string, string) {
+	tc.Args = append(tc.Args, args)
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -1023,6 +1359,11 @@ func (tc *SuccessfulTerraformCommand) Run(directory string, args []string, proje
 		stderr.WriteString("Unknown Subcommand")
 	}
 
+	if output != nil {
+		output.Write(stdout.Bytes())
+		output.Write(stderr.Bytes())
+	}
+
 	return nil, stdout.String(), stderr.String()
 }
 
@@ -1032,7 +1373,7 @@ type FailingTerraformCommand struct {
 	Credentials string
 }
 
-func (tc *FailingTerraformCommand) Run(directory string, args []string, project string, region string, credentials string) (error, string, string) {
+func (tc *FailingTerraformCommand) Run(directory string, args []string, project string, region string, credentials string, output io.Writer, interrupt <-chan struct{}) (error, string, string) {
 
 	err := new(exec.ExitError)
 	var stdout bytes.Buffer
@@ -1052,5 +1393,52 @@ func (tc *FailingTerraformCommand) Run(directory string, args []string, project
 		stderr.WriteString("foo")
 	}
 
+	if output != nil {
+		output.Write(stderr.Bytes())
+	}
+
 	return err, stdout.String(), stderr.String()
 }
+
+// Applies until interrupted, leaving the state of a partial apply
+type InterruptedTerraformCommand struct {
+	SuccessfulTerraformCommand
+
+	// Closed once the apply has started
+	Started chan struct{}
+
+	// Interrupt the plan rather than the apply
+	Plan bool
+
+	// Interrupt the destroy rather than the apply
+	Destroy bool
+}
+
+func (tc *InterruptedTerraformCommand) Run(directory string, args []string, project string, region string, credentials string, output io.Writer, interrupt <-chan struct{}) (error, string, string) {
+	if tc.Plan && args[0] == "plan" {
+		close(tc.Started)
+		<-interrupt
+		return errors.New(ErrorCommandInterrupted), "", ""
+	}
+
+	if tc.Destroy && args[0] == "destroy" {
+		close(tc.Started)
+		<-interrupt
+		return errors.New(ErrorCommandInterrupted), "", ""
+	}
+
+	if args[0] != "apply" {
+		return tc.SuccessfulTerraformCommand.Run(directory, args, project, region, credentials, output, interrupt)
+	}
+
+	state_file := filepath.Join(directory, "terraform.tfstate")
+	err := ioutil.WriteFile(state_file, []byte(`{"partial":true}`), 0666)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to write to '%s'", state_file))
+	}
+
+	close(tc.Started)
+	<-interrupt
+
+	return errors.New(ErrorCommandInterrupted), "", ""
+}
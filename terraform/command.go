@@ -4,21 +4,32 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type TerraformCommandRunner interface {
-	Run(string, []string, string, string, string) (error, string, string)
+	Run(string, []string, string, string, string, io.Writer, <-chan struct{}) (error, string, string)
 }
 
 type TerraformCommand struct{}
 
-func (tc TerraformCommand) Run(directory string, args []string, project string, region string, credentials string) (error, string, string) {
+// How long an interrupted terraform command is given to stop gracefully
+// before it is killed
+const InterruptGracePeriod = 30 * time.Second
+
+// Run terraform with the given arguments. When output is not nil the
+// command line and the combined stdout and stderr are also written to it
+// as the command runs. When interrupt is closed while the command runs,
+// terraform is sent an interrupt so it can stop gracefully and write its
+// state, and is killed if it has not stopped after the grace period.
+func (tc TerraformCommand) Run(directory string, args []string, project string, region string, credentials string, output io.Writer, interrupt <-chan struct{}) (error, string, string) {
 	logger := log.WithFields(log.Fields{"package": "terraform", "event": "run_command"})
 
 	var stdout bytes.Buffer
@@ -43,7 +54,7 @@ func (tc TerraformCommand) Run(directory string, args []string, project string,
 		"-no-color",
 	}
 
-	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("terraform %s %s", strings.Join(args, " "), strings.Join(defaultArgs, " ")))
+	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("exec terraform %s %s", strings.Join(args, " "), strings.Join(defaultArgs, " ")))
 
 	logger.Debug(cmd)
 
@@ -68,9 +79,40 @@ func (tc TerraformCommand) Run(directory string, args []string, project string,
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	if output != nil {
+		fmt.Fprintf(output, "$ terraform %s\n", strings.Join(args, " "))
+		cmd.Stdout = io.MultiWriter(&stdout, output)
+		cmd.Stderr = io.MultiWriter(&stderr, output)
+	}
+
+	err := cmd.Start()
+	if err != nil {
+		return err, "", ""
+	}
+
+	// The shell execs terraform, so signals are delivered to terraform itself
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-interrupt:
+		logger.Info("interrupting terraform command")
+		cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-done:
+		case <-time.After(InterruptGracePeriod):
+			logger.Warn("killing terraform command after it failed to stop gracefully")
+			cmd.Process.Kill()
+			<-done
+		}
+		err = errors.New(ErrorCommandInterrupted)
+	}
 
-	logger.Debug(stdout.String())
+	// Output may hold secrets, such as the outputs of a cluster
+	logger.Debug(fmt.Sprintf("terraform wrote %d bytes of output", stdout.Len()))
 
 	return err, stdout.String(), stderr.String()
 }
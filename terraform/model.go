@@ -8,6 +8,25 @@ type TerraformInfra struct {
 	ConfigFileName string
 	StateFileName  string
 	PlanFile       string
+
+	// Input variables, written to the variables file when there are any
+	Variables         map[string]interface{}
+	VariablesFileName string
+
+	// Backend the state is stored in instead of the state file, when set
+	Backend               *HttpBackend
+	BackendFileName       string
+	BackendConfigFileName string
+}
+
+// Terraform http backend, which reads, writes and locks the state through
+// the addresses while terraform runs
+type HttpBackend struct {
+	Address       string
+	LockAddress   string
+	UnlockAddress string
+	Username      string
+	Password      string
 }
 
 const (
@@ -19,6 +38,11 @@ const (
 	ErrorInvalidConfig      = "The Terraform configuration must be valid before initialization"
 	ErrorMissingOutputs     = "The state file either has no outputs defined, or all the defined\noutputs are empty."
 	ErrorBadState           = "Error refreshing state:"
+	ErrorUnparseablePlan    = "Unable to find a summary of changes in the terraform plan output"
+	ErrorCommandInterrupted = "The terraform command was interrupted"
+	ErrorApplyCancelled     = "The terraform apply was cancelled"
+	ErrorPlanCancelled      = "The terraform plan was cancelled"
+	ErrorDestroyAborted     = "The terraform destroy was aborted"
 	// Expected substrings in stdout from Terraform execution
 	InitBegin            = "Initializing provider plugins"
 	InitSuccess          = "Terraform has been successfully initialized!"
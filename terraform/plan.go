@@ -0,0 +1,92 @@
+package terraform
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Summary of the changes terraform plans to make
+type PlanSummary struct {
+	Add       int               `json:"add"`
+	Change    int               `json:"change"`
+	Destroy   int               `json:"destroy"`
+	Resources []PlannedResource `json:"resources"`
+}
+
+// A resource terraform plans to act upon
+type PlannedResource struct {
+	Address string `json:"address"`
+	Action  string `json:"action"`
+}
+
+const (
+	PlanActionCreate  = "create"
+	PlanActionUpdate  = "update"
+	PlanActionDelete  = "delete"
+	PlanActionReplace = "replace"
+	PlanActionRead    = "read"
+)
+
+var (
+	planCountsRegexp    = regexp.MustCompile(`Plan: (\d+) to add, (\d+) to change, (\d+) to destroy\.`)
+	planNoChangesRegexp = regexp.MustCompile(`No changes\.`)
+
+	// Terraform 0.11 lists resources prefixed by a symbol of the action
+	planSymbolRegexp = regexp.MustCompile(`(?m)^\s*(-/\+|<=|[-+~])\s+([^\s:]+)(\s+\(.*\))?\s*$`)
+
+	// Later versions describe each resource in a comment
+	planCommentRegexp = regexp.MustCompile(`(?m)^\s*# (\S+) (will be created|will be updated in-place|will be destroyed|must be replaced|will be read during apply)`)
+
+	planSymbolActions = map[string]string{
+		"+":   PlanActionCreate,
+		"~":   PlanActionUpdate,
+		"-":   PlanActionDelete,
+		"-/+": PlanActionReplace,
+		"<=":  PlanActionRead,
+	}
+
+	planCommentActions = map[string]string{
+		"will be created":           PlanActionCreate,
+		"will be updated in-place":  PlanActionUpdate,
+		"will be destroyed":         PlanActionDelete,
+		"must be replaced":          PlanActionReplace,
+		"will be read during apply": PlanActionRead,
+	}
+)
+
+// Parse the stdout of terraform plan into a summary of its changes
+func ParsePlanSummary(stdout string) (*PlanSummary, error) {
+	summary := &PlanSummary{Resources: []PlannedResource{}}
+
+	counts := planCountsRegexp.FindStringSubmatch(stdout)
+	if counts == nil {
+		if planNoChangesRegexp.MatchString(stdout) {
+			return summary, nil
+		}
+		return nil, errors.New(ErrorUnparseablePlan)
+	}
+
+	summary.Add, _ = strconv.Atoi(counts[1])
+	summary.Change, _ = strconv.Atoi(counts[2])
+	summary.Destroy, _ = strconv.Atoi(counts[3])
+
+	// Skip the legend of action symbols preceding the resources
+	actions := stdout
+	if i := strings.Index(stdout, PlanSuccess); i >= 0 {
+		actions = stdout[i:]
+	}
+
+	for _, match := range planCommentRegexp.FindAllStringSubmatch(actions, -1) {
+		summary.Resources = append(summary.Resources, PlannedResource{Address: match[1], Action: planCommentActions[match[2]]})
+	}
+
+	if len(summary.Resources) == 0 {
+		for _, match := range planSymbolRegexp.FindAllStringSubmatch(actions, -1) {
+			summary.Resources = append(summary.Resources, PlannedResource{Address: match[2], Action: planSymbolActions[match[1]]})
+		}
+	}
+
+	return summary, nil
+}
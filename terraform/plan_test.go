@@ -0,0 +1,112 @@
+package terraform_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/kmacoskey/taos/terraform"
+)
+
+var _ = Describe("Plan", func() {
+
+	var (
+		summary *PlanSummary
+		err     error
+	)
+
+	Describe("Parsing the summary of a plan", func() {
+
+		Context("When terraform 0.11 plans changes", func() {
+			BeforeEach(func() {
+				summary, err = ParsePlanSummary(`
+Resource actions are indicated with the following symbols:
+  + create
+  ~ update in-place
+  - destroy
+-/+ destroy and then create replacement
+
+Terraform will perform the following actions:
+
+  + google_compute_instance.default
+      id:                       <computed>
+      machine_type:             "n1-standard-1"
+
+  ~ google_compute_network.default
+      description:              "" => "network"
+
+-/+ google_compute_disk.default (new resource required)
+      id:                       "disk" => <computed> (forces new resource)
+
+  - google_compute_firewall.default
+
+
+Plan: 2 to add, 1 to change, 2 to destroy.
+`)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should count the changes", func() {
+				Expect(summary.Add).To(Equal(2))
+				Expect(summary.Change).To(Equal(1))
+				Expect(summary.Destroy).To(Equal(2))
+			})
+			It("Should list the resources and their actions", func() {
+				Expect(summary.Resources).To(Equal([]PlannedResource{
+					{Address: "google_compute_instance.default", Action: PlanActionCreate},
+					{Address: "google_compute_network.default", Action: PlanActionUpdate},
+					{Address: "google_compute_disk.default", Action: PlanActionReplace},
+					{Address: "google_compute_firewall.default", Action: PlanActionDelete},
+				}))
+			})
+		})
+
+		Context("When a later terraform plans changes", func() {
+			BeforeEach(func() {
+				summary, err = ParsePlanSummary(`
+Terraform will perform the following actions:
+
+  # google_compute_instance.default will be created
+  + resource "google_compute_instance" "default" {
+      + machine_type = "n1-standard-1"
+    }
+
+  # google_compute_disk.default must be replaced
+-/+ resource "google_compute_disk" "default" {
+    }
+
+Plan: 2 to add, 0 to change, 1 to destroy.
+`)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should list the resources and their actions", func() {
+				Expect(summary.Resources).To(Equal([]PlannedResource{
+					{Address: "google_compute_instance.default", Action: PlanActionCreate},
+					{Address: "google_compute_disk.default", Action: PlanActionReplace},
+				}))
+			})
+		})
+
+		Context("When there are no changes", func() {
+			BeforeEach(func() {
+				summary, err = ParsePlanSummary(PlanNoChangesSuccess)
+			})
+			It("Should not error", func() {
+				Expect(err).NotTo(HaveOccurred())
+			})
+			It("Should return an empty summary", func() {
+				Expect(summary.Add + summary.Change + summary.Destroy).To(Equal(0))
+				Expect(summary.Resources).To(BeEmpty())
+			})
+		})
+
+		Context("When the output is not a plan", func() {
+			It("Should error", func() {
+				_, err = ParsePlanSummary("foo")
+				Expect(err).To(MatchError(ErrorUnparseablePlan))
+			})
+		})
+	})
+})